@@ -0,0 +1,519 @@
+// Package arq runs muxado over an unreliable packet transport like UDP, for
+// paths where TCP is blocked or performs poorly. It adds a minimal automatic
+// repeat request layer to the transport: packets are numbered, acknowledged
+// by the receiver and retransmitted by the sender until they are, and
+// reassembled in order, so that a Conn reads and writes a reliable stream of
+// bytes like a TCP connection:
+//
+//	// client
+//	conn, err := arq.Dial("udp", addr, nil)
+//	sess := muxado.Client(conn, nil)
+//
+//	// server
+//	l, err := arq.Listen("udp", addr, nil)
+//	for {
+//		conn, err := l.Accept()
+//		if err != nil {
+//			break
+//		}
+//		go handle(muxado.Server(conn, nil))
+//	}
+//
+// There is no congestion control beyond a fixed window of packets in flight
+// and an exponential backoff of retransmissions, so Config.Window should be
+// sized for the path.
+package arq
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// flags, seq and ack. A packet without data acknowledges all packets
+	// before ack, and the one with sequence number seq on its own.
+	headerSize = 9
+
+	// set on packets which carry a sequence number, i.e. data and FIN
+	flagData = 0x1
+	// set on the last packet sent
+	flagFin = 0x2
+
+	// longest a retransmission timeout backs off to
+	maxRTO = 10 * time.Second
+
+	// how long a closed Conn waits for the remote end's FIN after everything
+	// it sent was acknowledged, so that it can acknowledge the FIN
+	linger = 10 * time.Second
+)
+
+// ErrTimeout is returned once a packet was retransmitted
+// Config.MaxRetransmits times without being acknowledged
+var ErrTimeout = errors.New("arq: remote end stopped acknowledging packets")
+
+// Config configures the reliability layer.
+type Config struct {
+	// Largest packet written to the transport, including the 9 byte header.
+	// Default 1200, which fits the payload of a UDP datagram on practically
+	// every path.
+	MTU int
+
+	// Maximum number of packets sent but not acknowledged. Writes block once
+	// it is reached. Default 256.
+	Window int
+
+	// Retransmission timeout before a round trip time has been measured.
+	// Default 200 milliseconds.
+	RTO time.Duration
+
+	// Minimum retransmission timeout. Default 20 milliseconds.
+	MinRTO time.Duration
+
+	// Number of times a packet is retransmitted before the Conn fails with
+	// ErrTimeout. Default 12.
+	MaxRetransmits int
+}
+
+func (c *Config) initDefaults() {
+	if c.MTU == 0 {
+		c.MTU = 1200
+	}
+	if c.Window == 0 {
+		c.Window = 256
+	}
+	if c.RTO == 0 {
+		c.RTO = 200 * time.Millisecond
+	}
+	if c.MinRTO == 0 {
+		c.MinRTO = 20 * time.Millisecond
+	}
+	if c.MaxRetransmits == 0 {
+		c.MaxRetransmits = 12
+	}
+}
+
+// packet is a sequenced packet waiting to be acknowledged
+type packet struct {
+	seq     uint32
+	flags   byte
+	data    []byte
+	sent    time.Time
+	rto     time.Duration
+	retries int
+	sacked  bool // received, but not all packets before it were
+}
+
+// segment is a sequenced packet received ahead of the next expected one
+type segment struct {
+	data []byte
+	fin  bool
+}
+
+// seqLess compares sequence numbers, which wrap around
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// Conn is a reliable, ordered io.ReadWriteCloser over an unreliable packet
+// transport.
+type Conn struct {
+	pc     net.PacketConn
+	raddr  net.Addr
+	config Config
+
+	wmu sync.Mutex // serializes calls to Write
+
+	mu       sync.Mutex
+	cond     sync.Cond
+	sendSeq  uint32             // sequence number of the next packet sent
+	unacked  []*packet          // sent packets not acknowledged, in order
+	srtt     time.Duration      // smoothed round trip time, 0 until measured
+	rto      time.Duration      // retransmission timeout of new packets
+	recvNext uint32             // sequence number of the next packet expected
+	ahead    map[uint32]segment // packets received out of order
+	buf      []byte             // received data not yet read
+	eof      bool               // the remote end sent FIN
+	closed   bool               // Close was called
+	closedAt time.Time          // when Close was called
+	err      error              // set once the Conn can no longer be used
+	released bool               // release was called
+	done     chan struct{}      // closed once the Conn is released
+
+	// called once the Conn no longer uses pc
+	release func()
+}
+
+func newConn(pc net.PacketConn, raddr net.Addr, config *Config, release func()) *Conn {
+	c := &Conn{
+		pc:      pc,
+		raddr:   raddr,
+		ahead:   make(map[uint32]segment),
+		done:    make(chan struct{}),
+		release: release,
+	}
+	if config != nil {
+		c.config = *config
+	}
+	c.config.initDefaults()
+	c.rto = c.config.RTO
+	c.cond.L = &c.mu
+	go c.retransmitter()
+	return c
+}
+
+// NewConn returns a Conn which exchanges packets with raddr over pc. Packets
+// pc reads from raddr must be passed to the Conn with Input; NewConn does not
+// read from pc itself, so that one pc can be shared with other Conns. Use
+// Dial or a Listener unless you need to manage pc yourself.
+func NewConn(pc net.PacketConn, raddr net.Addr, config *Config) *Conn {
+	return newConn(pc, raddr, config, func() {})
+}
+
+// Dial returns a Conn to the given address of a packet network like "udp".
+// The Conn uses its own socket, which is closed along with it.
+func Dial(network, address string, config *Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	setReadBuffer(pc, config)
+	c := newConn(pc, raddr, config, func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				c.finish(err)
+				return
+			}
+			if from.String() == raddr.String() {
+				c.Input(buf[:n])
+			}
+		}
+	}()
+	return c, nil
+}
+
+// setReadBuffer makes the socket buffer received packets generously: those
+// it drops are only recovered once they time out
+func setReadBuffer(pc net.PacketConn, config *Config) {
+	var cfg Config
+	if config != nil {
+		cfg = *config
+	}
+	cfg.initDefaults()
+	if u, ok := pc.(*net.UDPConn); ok {
+		u.SetReadBuffer(4 * cfg.Window * cfg.MTU)
+	}
+}
+
+// Input handles a packet the Conn's transport read from the remote address.
+// It does not retain b.
+func (c *Conn) Input(b []byte) {
+	if len(b) < headerSize {
+		return
+	}
+	flags := b[0]
+	seq := binary.BigEndian.Uint32(b[1:])
+	ack := binary.BigEndian.Uint32(b[5:])
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.acked(ack)
+	if flags&flagData != 0 {
+		c.receive(seq, flags&flagFin != 0, b[headerSize:])
+	} else {
+		c.sacked(seq)
+	}
+	next := c.recvNext
+	finished := c.finished()
+	c.mu.Unlock()
+
+	if flags&flagData != 0 {
+		c.send(0, seq, next, nil)
+	}
+	if finished {
+		c.finish(nil)
+	}
+}
+
+// acked releases the sent packets before ack and measures the round trip
+// time. It must be called with c.mu held.
+func (c *Conn) acked(ack uint32) {
+	if !seqLess(ack, c.sendSeq+1) {
+		return
+	}
+	n := 0
+	for n < len(c.unacked) && seqLess(c.unacked[n].seq, ack) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	if p := c.unacked[n-1]; !p.sacked {
+		c.measure(p)
+	}
+	c.unacked = c.unacked[n:]
+	c.cond.Broadcast()
+}
+
+// sacked marks the sent packet with sequence number seq as received, so that
+// it is not retransmitted. It must be called with c.mu held.
+func (c *Conn) sacked(seq uint32) {
+	if len(c.unacked) == 0 || seqLess(seq, c.unacked[0].seq) {
+		return
+	}
+	if i := seq - c.unacked[0].seq; i < uint32(len(c.unacked)) && !c.unacked[i].sacked {
+		c.unacked[i].sacked = true
+		c.measure(c.unacked[i])
+	}
+}
+
+// measure updates the round trip time with the acknowledgement of p. It must
+// be called with c.mu held.
+func (c *Conn) measure(p *packet) {
+	// only packets which were not retransmitted give an unambiguous sample
+	if p.retries > 0 {
+		return
+	}
+	rtt := time.Since(p.sent)
+	if c.srtt == 0 {
+		c.srtt = rtt
+	} else {
+		c.srtt += (rtt - c.srtt) / 8
+	}
+	c.rto = 2 * c.srtt
+	if c.rto < c.config.MinRTO {
+		c.rto = c.config.MinRTO
+	}
+}
+
+// receive adds a sequenced packet to the read buffer, or keeps it until the
+// packets before it arrive. It must be called with c.mu held.
+func (c *Conn) receive(seq uint32, fin bool, data []byte) {
+	if seqLess(seq, c.recvNext) || seq-c.recvNext >= uint32(c.config.Window) || c.eof {
+		return
+	}
+	if _, ok := c.ahead[seq]; !ok {
+		c.ahead[seq] = segment{data: append([]byte(nil), data...), fin: fin}
+	}
+	for {
+		s, ok := c.ahead[c.recvNext]
+		if !ok {
+			break
+		}
+		delete(c.ahead, c.recvNext)
+		c.recvNext++
+		if !c.closed {
+			c.buf = append(c.buf, s.data...)
+		}
+		if s.fin {
+			c.eof = true
+			break
+		}
+	}
+	c.cond.Broadcast()
+}
+
+// send writes a packet to the transport
+func (c *Conn) send(flags byte, seq, ack uint32, data []byte) error {
+	b := make([]byte, headerSize+len(data))
+	b[0] = flags
+	binary.BigEndian.PutUint32(b[1:], seq)
+	binary.BigEndian.PutUint32(b[5:], ack)
+	copy(b[headerSize:], data)
+	_, err := c.pc.WriteTo(b, c.raddr)
+	return err
+}
+
+// queue assigns the next sequence number to a packet and returns it. It must
+// be called with c.mu held.
+func (c *Conn) queue(flags byte, data []byte) *packet {
+	p := &packet{seq: c.sendSeq, flags: flags | flagData, data: data, sent: time.Now(), rto: c.rto}
+	c.sendSeq++
+	c.unacked = append(c.unacked, p)
+	return p
+}
+
+// Write sends p in packets of at most Config.MTU bytes. It blocks while
+// Config.Window packets are waiting to be acknowledged.
+func (c *Conn) Write(p []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	maxPayload := c.config.MTU - headerSize
+	for n < len(p) {
+		size := len(p) - n
+		if size > maxPayload {
+			size = maxPayload
+		}
+
+		c.mu.Lock()
+		for c.err == nil && !c.closed && len(c.unacked) >= c.config.Window {
+			c.cond.Wait()
+		}
+		if err = c.writeErr(); err != nil {
+			c.mu.Unlock()
+			return
+		}
+		pkt := c.queue(0, append([]byte(nil), p[n:n+size]...))
+		ack := c.recvNext
+		c.mu.Unlock()
+
+		// a packet lost to a write error is retransmitted like any other
+		c.send(pkt.flags, pkt.seq, ack, pkt.data)
+		n += size
+	}
+	return
+}
+
+// writeErr must be called with c.mu held
+func (c *Conn) writeErr() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.closed {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// Read reads the data written by the remote end. It returns io.EOF once the
+// remote end has closed the Conn and all of its data has been read.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 {
+		switch {
+		case c.eof:
+			return 0, io.EOF
+		case c.closed:
+			return 0, net.ErrClosed
+		case c.err != nil:
+			return 0, c.err
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Close sends FIN to the remote end after the data written so far. Reads and
+// writes fail once Close returns, but the Conn keeps retransmitting in the
+// background until the remote end has acknowledged all of it, or until it
+// times out.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed || c.err != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.closedAt = time.Now()
+	c.cond.Broadcast()
+	pkt := c.queue(flagFin, nil)
+	ack := c.recvNext
+	c.mu.Unlock()
+	c.send(pkt.flags, pkt.seq, ack, nil)
+	return nil
+}
+
+// Done returns a channel that is closed once the Conn has stopped sending
+// packets, because it failed or because the remote end acknowledged
+// everything sent after Close.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// LocalAddr returns the local address of the transport
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address packets are sent to
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// finished reports whether the Conn is closed, the remote end has
+// acknowledged everything and either sent FIN or had time to. It must be
+// called with c.mu held.
+func (c *Conn) finished() bool {
+	return c.closed && len(c.unacked) == 0 && (c.eof || time.Since(c.closedAt) >= linger)
+}
+
+// finish stops the Conn, failing it with err if not nil, and releases its
+// transport
+func (c *Conn) finish(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		if err == nil {
+			err = net.ErrClosed
+		}
+		c.err = err
+		c.cond.Broadcast()
+	}
+	released := c.released
+	c.released = true
+	c.mu.Unlock()
+	if !released {
+		close(c.done)
+		c.release()
+	}
+}
+
+// retransmitter resends the packets which were not acknowledged in time
+func (c *Conn) retransmitter() {
+	tick := time.NewTicker(c.config.MinRTO / 2)
+	defer tick.Stop()
+	var resend []*packet
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick.C:
+		}
+		now := time.Now()
+		resend = resend[:0]
+		c.mu.Lock()
+		var err error
+		for _, p := range c.unacked {
+			if p.sacked || now.Sub(p.sent) < p.rto {
+				continue
+			}
+			if p.retries >= c.config.MaxRetransmits {
+				err = ErrTimeout
+				break
+			}
+			p.retries++
+			p.sent = now
+			if p.rto *= 2; p.rto > maxRTO {
+				p.rto = maxRTO
+			}
+			resend = append(resend, p)
+		}
+		ack := c.recvNext
+		if err == nil && c.finished() {
+			err = net.ErrClosed
+		}
+		c.mu.Unlock()
+		if err != nil {
+			c.finish(err)
+			return
+		}
+		for _, p := range resend {
+			c.send(p.flags, p.seq, ack, p.data)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package arq
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// lossyConn drops and reorders the packets read from and written to a
+// packet transport
+type lossyConn struct {
+	net.PacketConn
+	mu   sync.Mutex
+	rand *rand.Rand
+	loss float64 // fraction of packets dropped each way
+}
+
+func (c *lossyConn) roll() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64()
+}
+
+func (c *lossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || c.roll() >= c.loss {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	switch r := c.roll(); {
+	case r < c.loss:
+		return len(p), nil
+	case r < 2*c.loss:
+		// delivered after the packets written next
+		b := append([]byte(nil), p...)
+		time.AfterFunc(5*time.Millisecond, func() { c.PacketConn.WriteTo(b, addr) })
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func newLossyListener(t *testing.T, loss float64) *Listener {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	config := &Config{MinRTO: 5 * time.Millisecond}
+	setReadBuffer(pc, config)
+	l := NewListener(&lossyConn{PacketConn: pc, rand: rand.New(rand.NewSource(1)), loss: loss}, config)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// Test that a session's streams carry their data intact over a transport
+// which drops and reorders packets
+func TestSessionOverLossyTransport(t *testing.T) {
+	t.Parallel()
+	l := newLossyListener(t, 0.05)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		sess := muxado.Server(conn, nil)
+		defer sess.Close()
+		str, err := sess.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.Close()
+	}()
+
+	conn, err := Dial("udp", l.Addr().String(), &Config{MinRTO: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	sess := muxado.Client(conn, nil)
+	defer sess.Close()
+	str, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	msg := make([]byte, 256*1024)
+	rand.New(rand.NewSource(2)).Read(msg)
+	go func() {
+		str.Write(msg)
+		str.CloseWrite()
+	}()
+	got, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Echo of %d bytes differs, read %d bytes", len(msg), len(got))
+	}
+}
+
+// Test that closing a Conn delivers everything written before it and then
+// io.EOF to the remote end
+func TestCloseEOF(t *testing.T) {
+	t.Parallel()
+	l := newLossyListener(t, 0.2)
+	conn, err := Dial("udp", l.Addr().String(), &Config{MinRTO: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Write([]byte("hello"))
+	conn.Close()
+	if _, err := conn.Write([]byte("x")); err != net.ErrClosed {
+		t.Fatalf("Wrote after close, got %v", err)
+	}
+
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	if got, err := io.ReadAll(accepted); err != nil || string(got) != "hello" {
+		t.Fatalf("Read %q, %v, expected %q", got, err, "hello")
+	}
+	accepted.Close()
+	for _, c := range []*Conn{conn, accepted} {
+		select {
+		case <-c.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Conn was not released after both ends closed")
+		}
+	}
+}
+
+// Test that a Conn fails once the remote end stops acknowledging packets
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer pc.Close()
+	conn, err := Dial("udp", pc.LocalAddr().String(), &Config{RTO: time.Millisecond, MinRTO: time.Millisecond, MaxRetransmits: 3})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Write([]byte("hello"))
+	if _, err := conn.Read(make([]byte, 1)); err != ErrTimeout {
+		t.Fatalf("Read got %v, expected %v", err, ErrTimeout)
+	}
+	<-conn.Done()
+}
+
+func TestSeqLess(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		a, b uint32
+		want bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{1, 1, false},
+		{0xffffffff, 0, true},
+		{0, 0xffffffff, false},
+	} {
+		if got := seqLess(tc.a, tc.b); got != tc.want {
+			t.Errorf("seqLess(%d, %d) = %v, expected %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
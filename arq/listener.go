@@ -0,0 +1,130 @@
+package arq
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Listener accepts Conns from the remote addresses sending packets to one
+// packet transport, e.g. a UDP socket, and dispatches the packets it reads
+// to them.
+type Listener struct {
+	pc     net.PacketConn
+	config *Config
+	accept chan *Conn
+	closed chan struct{}
+
+	mu    sync.Mutex
+	conns map[string]*Conn // by remote address
+	err   error            // set once the transport fails
+}
+
+// Listen returns a Listener on the given address of a packet network like
+// "udp".
+func Listen(network, address string, config *Config) (*Listener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	setReadBuffer(pc, config)
+	return NewListener(pc, config), nil
+}
+
+// NewListener returns a Listener of the packets read from pc. It owns pc,
+// which it closes along with the Conns it accepted once Close is called or
+// reading from pc fails.
+func NewListener(pc net.PacketConn, config *Config) *Listener {
+	l := &Listener{
+		pc:     pc,
+		config: config,
+		accept: make(chan *Conn, 16),
+		closed: make(chan struct{}),
+		conns:  make(map[string]*Conn),
+	}
+	go l.reader()
+	return l
+}
+
+// Accept waits for a remote address to send the first packet of a new Conn
+// and returns it.
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return nil, l.err
+	}
+}
+
+// Close closes the transport, which fails the Conns the Listener accepted.
+func (l *Listener) Close() error {
+	return l.pc.Close()
+}
+
+// Addr returns the local address of the transport
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// reader dispatches the packets read from the transport until it fails
+func (l *Listener) reader() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.fail(err)
+			return
+		}
+		if c := l.conn(from, buf[:n]); c != nil {
+			c.Input(buf[:n])
+		}
+	}
+}
+
+// conn returns the Conn of the remote address a packet was read from. A
+// packet from an unknown address starts a new Conn only if it is the first
+// one sent over it, so that packets a finished Conn's remote end is still
+// retransmitting are dropped.
+func (l *Listener) conn(from net.Addr, b []byte) *Conn {
+	key := from.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.conns[key]; ok {
+		return c
+	}
+	if len(b) < headerSize || b[0]&flagData == 0 || binary.BigEndian.Uint32(b[1:]) != 0 {
+		return nil
+	}
+	// if nobody is accepting, the remote end retransmits. Only the reader
+	// sends on l.accept, so it cannot fill up in between.
+	if len(l.accept) == cap(l.accept) {
+		return nil
+	}
+	var c *Conn
+	c = newConn(l.pc, from, l.config, func() {
+		l.mu.Lock()
+		if l.conns[key] == c {
+			delete(l.conns, key)
+		}
+		l.mu.Unlock()
+	})
+	l.conns[key] = c
+	l.accept <- c
+	return c
+}
+
+// fail fails the Listener and its Conns with the transport's error
+func (l *Listener) fail(err error) {
+	l.mu.Lock()
+	l.err = err
+	conns := l.conns
+	l.conns = nil
+	l.mu.Unlock()
+	close(l.closed)
+	for _, c := range conns {
+		c.finish(err)
+	}
+}
@@ -0,0 +1,273 @@
+package muxado
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+const (
+	// id of the extension that authenticates sessions, reserved for it
+	authExtensionId = frame.MaxExtensionId
+
+	// how long a server waits for a client to authenticate by default
+	defaultAuthTimeout = 10 * time.Second
+
+	// size of the challenges of HMACVerifier
+	hmacChallengeSize = 32
+)
+
+// Verifier authenticates the client of a server session, see
+// Config.Verifier. Its methods are called by a goroutine of the session and
+// may block.
+type Verifier interface {
+	// Challenge returns the challenge sent to the client, which may be empty
+	// if the client's response does not depend on it, e.g. a token.
+	Challenge() ([]byte, error)
+
+	// Verify checks the client's response to the challenge and returns the
+	// identity the client authenticated as.
+	Verify(challenge, response []byte) (identity string, err error)
+}
+
+// Credentials answer the challenge of the server's Verifier on behalf of a
+// client session, see Config.Credentials
+type Credentials interface {
+	// Respond returns the response to the server's challenge. It is called by
+	// a goroutine of the session and may block.
+	Respond(challenge []byte) ([]byte, error)
+}
+
+// TokenCredentials respond to any challenge with token
+func TokenCredentials(token []byte) Credentials {
+	return tokenCredentials(token)
+}
+
+type tokenCredentials []byte
+
+func (c tokenCredentials) Respond(challenge []byte) ([]byte, error) {
+	return c, nil
+}
+
+// TokenVerifier sends no challenge and authenticates clients by passing the
+// token they send, see TokenCredentials, to verify
+func TokenVerifier(verify func(token []byte) (identity string, err error)) Verifier {
+	return tokenVerifier(verify)
+}
+
+type tokenVerifier func(token []byte) (string, error)
+
+func (v tokenVerifier) Challenge() ([]byte, error) {
+	return nil, nil
+}
+
+func (v tokenVerifier) Verify(challenge, response []byte) (string, error) {
+	return v(response)
+}
+
+// HMACCredentials respond to the challenge of an HMACVerifier with the
+// HMAC-SHA256 of the challenge keyed by key, and the id of the key
+func HMACCredentials(id string, key []byte) Credentials {
+	return &hmacCredentials{id: id, key: key}
+}
+
+type hmacCredentials struct {
+	id  string
+	key []byte
+}
+
+func (c *hmacCredentials) Respond(challenge []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(challenge)
+	return append(mac.Sum(nil), c.id...), nil
+}
+
+// HMACVerifier sends random challenges and authenticates clients that
+// answer them with HMACCredentials whose key matches the one returned by
+// key for their id. Clients authenticate as the id of their key. key
+// returns an error for unknown ids.
+func HMACVerifier(key func(id string) ([]byte, error)) Verifier {
+	return hmacVerifier(key)
+}
+
+type hmacVerifier func(id string) ([]byte, error)
+
+func (v hmacVerifier) Challenge() ([]byte, error) {
+	challenge := make([]byte, hmacChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (v hmacVerifier) Verify(challenge, response []byte) (string, error) {
+	if len(response) < sha256.Size {
+		return "", errors.New("short response")
+	}
+	id := string(response[sha256.Size:])
+	key, err := v(id)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	if !hmac.Equal(mac.Sum(nil), response[:sha256.Size]) {
+		return "", fmt.Errorf("invalid response for key %q", id)
+	}
+	return id, nil
+}
+
+// authExtension runs the authentication exchange of a session as an
+// extension: the server sends the challenge of its Verifier as soon as the
+// client advertises the extension and the client answers it with its
+// Credentials. The server queues the streams the client opens in the
+// meantime but does not hand them to AcceptStream until the client
+// authenticated, and closes the session if it fails to.
+type authExtension struct {
+	sess        *session
+	host        ExtensionHost
+	verifier    Verifier
+	credentials Credentials
+
+	mu            sync.Mutex
+	challenge     []byte
+	identity      string
+	authenticated chan struct{} // closed once the client authenticated (server only)
+	timer         *time.Timer
+	handled       bool // true once a frame of the remote side was handled
+}
+
+func (s *session) initAuth(config *Config, isClient bool) {
+	if config.Verifier == nil && config.Credentials == nil {
+		return
+	}
+	a := &authExtension{
+		sess:          s,
+		authenticated: make(chan struct{}),
+	}
+	if isClient {
+		a.credentials = config.Credentials
+	} else {
+		a.verifier = config.Verifier
+	}
+	if s.extensions == nil {
+		s.extensions = make(map[uint16]*extensionState, 1)
+	}
+	s.extensions[authExtensionId] = &extensionState{Extension: a}
+	s.auth = a
+	if a.verifier != nil {
+		a.timer = time.AfterFunc(config.AuthTimeout, func() {
+			s.die(newErr(Unauthenticated, errors.New("client did not authenticate in time")))
+		})
+	}
+}
+
+// requireAuth closes a server session whose client does not advertise the
+// authentication extension in its first frame
+func (s *session) requireAuth(f frame.Frame) error {
+	if s.auth == nil || s.auth.verifier == nil {
+		return nil
+	}
+	if settings, ok := f.(*frame.Settings); ok {
+		for _, setting := range settings.Settings() {
+			if setting.Id == frame.SettingExtensionBase|authExtensionId && setting.Value != 0 {
+				return nil
+			}
+		}
+	}
+	return newErr(Unauthenticated, errors.New("client does not authenticate"))
+}
+
+// awaitAuth blocks until the client of a server session authenticated
+func (s *session) awaitAuth() error {
+	if s.auth == nil || s.auth.verifier == nil {
+		return nil
+	}
+	select {
+	case <-s.auth.authenticated:
+		return nil
+	case <-s.acceptDeadline.wait():
+		return ErrAcceptTimeout
+	case <-s.dead:
+		return s.Err()
+	}
+}
+
+func (a *authExtension) ExtensionId() uint16 {
+	return authExtensionId
+}
+
+func (a *authExtension) Start(host ExtensionHost) {
+	a.mu.Lock()
+	a.host = host
+	a.mu.Unlock()
+	if a.verifier == nil {
+		return
+	}
+	go func() {
+		challenge, err := a.verifier.Challenge()
+		if err != nil {
+			a.sess.die(newErr(InternalError, fmt.Errorf("failed to create authentication challenge: %v", err)))
+			return
+		}
+		a.mu.Lock()
+		a.challenge = challenge
+		a.mu.Unlock()
+		host.WriteExtensionFrame(0, challenge)
+	}()
+}
+
+func (a *authExtension) HandleFrame(streamId uint32, data []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.handled {
+		return errors.New("unexpected authentication frame")
+	}
+	a.handled = true
+	data = append([]byte(nil), data...)
+	if a.verifier != nil {
+		go a.verify(a.challenge, data)
+	} else if a.credentials != nil {
+		go a.respond(a.host, data)
+	}
+	return nil
+}
+
+func (a *authExtension) respond(host ExtensionHost, challenge []byte) {
+	response, err := a.credentials.Respond(challenge)
+	if err != nil {
+		a.sess.die(newErr(Unauthenticated, fmt.Errorf("failed to respond to authentication challenge: %v", err)))
+		return
+	}
+	host.WriteExtensionFrame(0, response)
+}
+
+func (a *authExtension) verify(challenge, response []byte) {
+	identity, err := a.verifier.Verify(challenge, response)
+	if err != nil {
+		a.sess.die(newErr(Unauthenticated, fmt.Errorf("authentication failed: %v", err)))
+		return
+	}
+	a.timer.Stop()
+	a.mu.Lock()
+	a.identity = identity
+	a.mu.Unlock()
+	close(a.authenticated)
+}
+
+// AuthIdentity returns the identity the client of a server session
+// authenticated as with Config.Verifier, or an empty string until it did
+func (s *session) AuthIdentity() string {
+	if s.auth == nil {
+		return ""
+	}
+	s.auth.mu.Lock()
+	defer s.auth.mu.Unlock()
+	return s.auth.identity
+}
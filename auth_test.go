@@ -0,0 +1,115 @@
+package muxado
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Test that streams of an authenticated client are accepted and that the
+// server learns its identity
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+	keys := map[string][]byte{"alice": []byte("secret")}
+	for _, tc := range []struct {
+		name        string
+		verifier    Verifier
+		credentials Credentials
+	}{
+		{"token", TokenVerifier(func(token []byte) (string, error) {
+			if string(token) != "letmein" {
+				return "", errors.New("bad token")
+			}
+			return "alice", nil
+		}), TokenCredentials([]byte("letmein"))},
+		{"hmac", HMACVerifier(func(id string) ([]byte, error) {
+			if key, ok := keys[id]; ok {
+				return key, nil
+			}
+			return nil, errors.New("unknown key")
+		}), HMACCredentials("alice", keys["alice"])},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := newSessionPair(&Config{Credentials: tc.credentials}, &Config{Verifier: tc.verifier})
+			defer client.Close()
+			defer server.Close()
+			str, err := client.OpenStream()
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			str.Write([]byte("a"))
+			accepted, err := server.AcceptStream()
+			if err != nil {
+				t.Fatalf("Failed to accept stream: %v", err)
+			}
+			readString(t, accepted, "a")
+			if id := server.AuthIdentity(); id != "alice" {
+				t.Fatalf("Client authenticated as %q, expected %q", id, "alice")
+			}
+		})
+	}
+}
+
+// Test that a server closes the sessions of clients that fail to
+// authenticate without accepting their streams
+func TestAuthenticateFails(t *testing.T) {
+	t.Parallel()
+	verifier := TokenVerifier(func(token []byte) (string, error) {
+		return "", errors.New("bad token")
+	})
+	for _, tc := range []struct {
+		name   string
+		client *Config
+	}{
+		{"wrong credentials", &Config{Credentials: TokenCredentials([]byte("guess"))}},
+		{"no credentials", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := newSessionPair(tc.client, &Config{Verifier: verifier, AuthTimeout: time.Minute})
+			defer client.Close()
+			defer server.Close()
+			str, err := client.OpenStream()
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			str.Write([]byte("a"))
+			if _, err := server.AcceptStream(); !errors.Is(err, Unauthenticated) {
+				t.Fatalf("Accepted stream of an unauthenticated client, got %v", err)
+			}
+			select {
+			case <-client.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Client session was not closed")
+			}
+			if _, remoteErr, _ := client.Wait(); !errors.Is(remoteErr, Unauthenticated) {
+				t.Fatalf("Client was disconnected with %v, expected %v", remoteErr, Unauthenticated)
+			}
+		})
+	}
+}
+
+// Test that a server closes the session of a client that does not answer
+// its challenge in time
+func TestAuthTimeout(t *testing.T) {
+	t.Parallel()
+	// the client answers the challenge, but too late
+	slow := credentialsFunc(func(challenge []byte) ([]byte, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	})
+	client, server := newSessionPair(&Config{Credentials: slow}, &Config{
+		Verifier:    TokenVerifier(func([]byte) (string, error) { return "", nil }),
+		AuthTimeout: 50 * time.Millisecond,
+	})
+	defer client.Close()
+	defer server.Close()
+	if _, err := server.AcceptStream(); !errors.Is(err, Unauthenticated) {
+		t.Fatalf("Accept got %v, expected %v", err, Unauthenticated)
+	}
+}
+
+type credentialsFunc func(challenge []byte) ([]byte, error)
+
+func (f credentialsFunc) Respond(challenge []byte) ([]byte, error) {
+	return f(challenge)
+}
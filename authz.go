@@ -0,0 +1,68 @@
+package muxado
+
+import (
+	"crypto/x509"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// longest debug data sent to the remote side in an RST
+const maxRstDebug = 0x400
+
+// PeerIdentity describes who the remote side of a session is, see
+// Config.Authorize
+type PeerIdentity struct {
+	// Identity the remote side authenticated as, see Session.AuthIdentity
+	AuthIdentity string
+	// Certificates the remote side presented in the TLS handshake, see
+	// Session.PeerCertificates
+	Certificates []*x509.Certificate
+}
+
+// truncateDebug shortens debug data to fit in an RST
+func truncateDebug(debug []byte) []byte {
+	if len(debug) > maxRstDebug {
+		return debug[:maxRstDebug]
+	}
+	return debug
+}
+
+// peerIdentity returns the identity of the remote side that Config.Authorize
+// is called with
+func (s *session) peerIdentity() PeerIdentity {
+	return PeerIdentity{
+		AuthIdentity: s.AuthIdentity(),
+		Certificates: s.PeerCertificates(),
+	}
+}
+
+// synStreamType returns the type of a typed stream from the data its SYN
+// carries, or zero if it carries too little data to tell
+func synStreamType(f *frame.Data) (StreamType, error) {
+	if f.Compressed() {
+		return 0, nil
+	}
+	b, err := frame.PeekPayload(f, 4)
+	if err != nil || len(b) < 4 {
+		return 0, err
+	}
+	return StreamType(order.Uint32(b)), nil
+}
+
+// authorize asks Config.Authorize whether the remote side may open the
+// stream with the given id. If not, the stream is refused with
+// PermissionDenied and the reason sent along, and authorize returns false.
+func (s *session) authorize(id frame.StreamId, stype StreamType, md map[string]string) bool {
+	if s.config.Authorize == nil {
+		return true
+	}
+	err := s.config.Authorize(stype, md, s.peerIdentity())
+	if err == nil {
+		return true
+	}
+	rstF := new(frame.Rst)
+	if err := rstF.PackWithDebug(id, frame.ErrorCode(PermissionDenied), truncateDebug([]byte(err.Error()))); err == nil {
+		s.writeFrameAsync(rstF)
+	}
+	return false
+}
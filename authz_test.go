@@ -0,0 +1,91 @@
+package muxado
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Test that streams the server does not authorize are reset with the
+// reason and that the others are accepted
+func TestAuthorize(t *testing.T) {
+	t.Parallel()
+	authorize := func(stype StreamType, md map[string]string, peer PeerIdentity) error {
+		if md["path"] != "/public" && peer.AuthIdentity != "admin" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}
+	client, server := newSessionPair(&Config{Credentials: TokenCredentials([]byte("x"))}, &Config{
+		Verifier:  TokenVerifier(func([]byte) (string, error) { return "guest", nil }),
+		Authorize: authorize,
+	})
+	defer client.Close()
+	defer server.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.AuthIdentity() == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Client did not authenticate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	denied, err := client.OpenStream(WithMetadata(map[string]string{"path": "/admin"}))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	denied.Write([]byte("a"))
+	var resetErr *StreamResetError
+	if _, err := io.ReadAll(denied); !errors.As(err, &resetErr) || resetErr.Code != PermissionDenied || string(resetErr.Debug) != "not allowed" {
+		t.Fatalf("Read from unauthorized stream got %v, expected a reset with %v", err, PermissionDenied)
+	}
+
+	allowed, err := client.OpenStream(WithMetadata(map[string]string{"path": "/public"}))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	allowed.Write([]byte("b"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if accepted.Metadata()["path"] != "/public" {
+		t.Fatalf("Accepted stream with metadata %v", accepted.Metadata())
+	}
+	readString(t, accepted, "b")
+}
+
+// Test that Authorize sees the type of typed streams
+func TestAuthorizeStreamType(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{
+		Authorize: func(stype StreamType, md map[string]string, peer PeerIdentity) error {
+			if stype != 7 {
+				return errors.New("wrong type")
+			}
+			return nil
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+
+	denied, err := typedClient.OpenTypedStream(8)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := denied.Read(make([]byte, 1)); !errors.Is(err, PermissionDenied) {
+		t.Fatalf("Read from unauthorized stream got %v, expected %v", err, PermissionDenied)
+	}
+	if _, err := typedClient.OpenTypedStream(7); err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	accepted, err := typedServer.AcceptTypedStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if accepted.StreamType() != 7 {
+		t.Fatalf("Accepted stream of type %d, expected 7", accepted.StreamType())
+	}
+}
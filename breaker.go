@@ -0,0 +1,294 @@
+package muxado
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned instead of opening a stream while a Breaker is
+// open
+var ErrBreakerOpen = newErr(CircuitOpen, errors.New("circuit breaker open"))
+
+// BreakerState is the state of a Breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets streams be opened
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails stream opens with ErrBreakerOpen
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe streams be opened to find out whether
+	// the remote side recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures a Breaker
+type BreakerConfig struct {
+	// Number of consecutive refused or timed out streams after which the
+	// breaker opens. Default 5.
+	MaxFailures int
+	// Time the breaker stays open before it lets probe streams through.
+	// Default 5 seconds.
+	Cooldown time.Duration
+	// Number of probe streams let through at once while the breaker is half
+	// open. Default 1.
+	Probes int
+	// Called when the breaker changes state. Default nil.
+	OnStateChange func(from, to BreakerState)
+}
+
+func (c *BreakerConfig) initDefaults() {
+	if c.MaxFailures == 0 {
+		c.MaxFailures = 5
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = 5 * time.Second
+	}
+	if c.Probes == 0 {
+		c.Probes = 1
+	}
+}
+
+// Breaker is a circuit breaker around opening streams, so that applications
+// fail fast with ErrBreakerOpen while the remote side is overloaded instead
+// of piling more streams on it. Streams are opened through a Breaker with
+// its OpenStream or Dialer methods, or by a SessionGroup with
+// GroupConfig.Breaker.
+//
+// A stream fails if opening it times out or is refused because of
+// Config.MaxStreams, or if the remote side resets it with StreamRefused,
+// AcceptQueueFull, RefusedLimit or EnhanceYourCalm before any data was
+// read from it. It succeeds once data or EOF is read from it. After
+// MaxFailures consecutive failures the breaker opens. Once its Cooldown
+// passed, it is half open and lets Probes streams through: the breaker
+// closes when one of them succeeds and opens again when one fails.
+type Breaker struct {
+	config BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // consecutive failures while closed
+	openedAt time.Time // when the breaker last opened
+	probes   int       // probes in flight while half open
+	gen      int       // incremented on every change of state
+}
+
+// NewBreaker returns a closed Breaker
+func NewBreaker(config *BreakerConfig) *Breaker {
+	b := new(Breaker)
+	if config != nil {
+		b.config = *config
+	}
+	b.config.initDefaults()
+	return b
+}
+
+// State returns the state of the breaker
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	cooled := b.cooledLocked()
+	state := b.state
+	b.mu.Unlock()
+	if cooled {
+		b.changed(BreakerOpen, BreakerHalfOpen)
+	}
+	return state
+}
+
+// OpenStream opens a stream on sess unless the breaker is open
+func (b *Breaker) OpenStream(sess Session, opts ...StreamOption) (Stream, error) {
+	return b.open(func() (Stream, error) { return sess.OpenStream(opts...) })
+}
+
+// Dialer is like the package-level Dialer, but opens the streams through
+// the breaker
+func (b *Breaker) Dialer(sess Session) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return b.open(func() (Stream, error) { return openStreamContext(ctx, sess) })
+	}
+}
+
+// ticket is a stream let through by the breaker
+type ticket struct {
+	gen   int
+	probe bool
+}
+
+// open calls openFn unless the breaker is open and wraps the stream it
+// opens to report whether it succeeds
+func (b *Breaker) open(openFn func() (Stream, error)) (Stream, error) {
+	t, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	str, err := openFn()
+	if err != nil {
+		b.report(t, err)
+		return nil, err
+	}
+	return &breakerStream{StreamWrapper: StreamWrapper{str}, b: b, t: t}, nil
+}
+
+func (b *Breaker) allow() (ticket, error) {
+	b.mu.Lock()
+	cooled := b.cooledLocked()
+	t, err := b.allowLocked()
+	b.mu.Unlock()
+	if cooled {
+		b.changed(BreakerOpen, BreakerHalfOpen)
+	}
+	return t, err
+}
+
+func (b *Breaker) allowLocked() (ticket, error) {
+	switch b.state {
+	case BreakerOpen:
+		return ticket{}, ErrBreakerOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.config.Probes {
+			return ticket{}, ErrBreakerOpen
+		}
+		b.probes++
+		return ticket{gen: b.gen, probe: true}, nil
+	}
+	return ticket{gen: b.gen}, nil
+}
+
+// report records the outcome of a stream: err is nil if it succeeded.
+// Errors that are not refusals or timeouts say nothing about the remote
+// side's load and only free the stream's probe slot.
+func (b *Breaker) report(t ticket, err error) {
+	b.mu.Lock()
+	var from, to BreakerState
+	changed := false
+	if t.gen == b.gen {
+		from = b.state
+		switch {
+		case t.probe && err == nil:
+			b.setStateLocked(BreakerClosed)
+			changed = true
+		case t.probe && isRefusal(err):
+			b.setStateLocked(BreakerOpen)
+			changed = true
+		case t.probe:
+			b.probes--
+		case err == nil:
+			b.failures = 0
+		case isRefusal(err):
+			b.failures++
+			if b.failures >= b.config.MaxFailures {
+				b.setStateLocked(BreakerOpen)
+				changed = true
+			}
+		}
+		to = b.state
+	}
+	b.mu.Unlock()
+	if changed {
+		b.changed(from, to)
+	}
+}
+
+// cooledLocked moves an open breaker whose cooldown passed to half open and
+// reports whether it did. It must be called with b.mu held.
+func (b *Breaker) cooledLocked() bool {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.config.Cooldown {
+		b.setStateLocked(BreakerHalfOpen)
+		return true
+	}
+	return false
+}
+
+func (b *Breaker) setStateLocked(state BreakerState) {
+	b.state = state
+	b.gen++
+	b.failures = 0
+	b.probes = 0
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *Breaker) changed(from, to BreakerState) {
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
+
+// isRefusal reports whether err means that the remote side refused a stream
+// or was too slow to take it
+func isRefusal(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	for _, code := range []ErrorCode{OpenTimeout, RefusedLimit, StreamRefused, AcceptQueueFull, EnhanceYourCalm} {
+		if errors.Is(err, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// breakerStream reports the outcome of a stream opened through a Breaker
+type breakerStream struct {
+	StreamWrapper
+	b    *Breaker
+	t    ticket
+	once sync.Once
+}
+
+func (s *breakerStream) outcome(err error) {
+	s.once.Do(func() { s.b.report(s.t, err) })
+}
+
+func (s *breakerStream) Read(p []byte) (int, error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+func (s *breakerStream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	n, err := s.Stream.ReadContext(ctx, p)
+	if n > 0 || err == io.EOF {
+		s.outcome(nil)
+	} else if err != nil {
+		s.outcome(err)
+	}
+	return n, err
+}
+
+func (s *breakerStream) Write(p []byte) (int, error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+func (s *breakerStream) WriteContext(ctx context.Context, p []byte) (int, error) {
+	n, err := s.Stream.WriteContext(ctx, p)
+	if err != nil {
+		s.outcome(err)
+	}
+	return n, err
+}
+
+func (s *breakerStream) Close() error {
+	s.outcome(ErrStreamClosed)
+	return s.Stream.Close()
+}
+
+func (s *breakerStream) CloseWithError(errCode ErrorCode, debug []byte) error {
+	s.outcome(ErrStreamClosed)
+	return s.Stream.CloseWithError(errCode, debug)
+}
@@ -0,0 +1,100 @@
+package muxado
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that a breaker opens after consecutive timeouts and closes again once
+// a probe stream succeeds
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	var mu sync.Mutex
+	var changes []BreakerState
+	b := NewBreaker(&BreakerConfig{
+		MaxFailures: 2,
+		Cooldown:    50 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			mu.Lock()
+			changes = append(changes, to)
+			mu.Unlock()
+		},
+	})
+
+	client.SetOpenDeadline(time.Now())
+	for i := 0; i < 2; i++ {
+		if _, err := b.OpenStream(client); err != ErrOpenTimeout {
+			t.Fatalf("Opened stream past the open deadline, got %v", err)
+		}
+	}
+	if _, err := b.OpenStream(client); err != ErrBreakerOpen {
+		t.Fatalf("Opened stream through an open breaker, got %v", err)
+	}
+	if state := b.State(); state != BreakerOpen {
+		t.Fatalf("Breaker is %v, expected %v", state, BreakerOpen)
+	}
+
+	client.SetOpenDeadline(time.Time{})
+	time.Sleep(50 * time.Millisecond)
+	probe, err := b.OpenStream(client)
+	if err != nil {
+		t.Fatalf("Failed to open probe stream: %v", err)
+	}
+	if _, err := b.OpenStream(client); err != ErrBreakerOpen {
+		t.Fatalf("Opened a second probe stream, got %v", err)
+	}
+	probe.Write([]byte("a"))
+	go func() {
+		str, err := server.AcceptStream()
+		if err == nil {
+			str.Write([]byte("x"))
+		}
+	}()
+	readString(t, probe, "x")
+	if state := b.State(); state != BreakerClosed {
+		t.Fatalf("Breaker is %v after a successful probe, expected %v", state, BreakerClosed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("Breaker went through %v, expected %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("Breaker went through %v, expected %v", changes, want)
+		}
+	}
+}
+
+// Test that a group's breaker opens when the peer refuses its streams
+func TestSessionGroupBreaker(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{AcceptBacklog: 1})
+	defer client.Close()
+	defer server.Close()
+	g := NewSessionGroup(&GroupConfig{Breaker: NewBreaker(&BreakerConfig{MaxFailures: 2})}, client)
+	defer g.Close()
+
+	// the first stream fills the server's accept queue
+	for i := 0; i < 3; i++ {
+		str, err := g.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream %d: %v", i, err)
+		}
+		str.Write([]byte("a"))
+		if i == 0 {
+			continue
+		}
+		if _, err := str.Read(make([]byte, 1)); err == nil {
+			t.Fatalf("Read from a refused stream")
+		}
+	}
+	if _, err := g.OpenStream(); err != ErrBreakerOpen {
+		t.Fatalf("Opened stream through an open breaker, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package muxado
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -16,17 +17,23 @@ var (
 
 type buffer interface {
 	Read([]byte) (int, error)
+	ReadContext(context.Context, []byte) (int, error)
 	ReadFrom(io.Reader) (int, error)
 	SetError(error)
 	SetDeadline(time.Time)
+	Buffered() int
+	Discard() int
+	SetMaxSize(int)
 }
 
 type inboundBuffer struct {
 	cond sync.Cond
 	mu   sync.Mutex
 	bytes.Buffer
-	err     error
-	maxSize int
+	err      error
+	maxSize  int
+	deadline time.Time   // time after which reads fail with ErrReadTimeout
+	timer    *time.Timer // wakes blocked readers at the deadline
 }
 
 func (b *inboundBuffer) Init(maxSize int) {
@@ -58,6 +65,21 @@ DONE:
 }
 
 func (b *inboundBuffer) Read(p []byte) (n int, err error) {
+	return b.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but gives up waiting for data with ctx.Err()
+// once ctx is done
+func (b *inboundBuffer) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	if ctx.Done() != nil {
+		// wake up blocked readers once ctx is done
+		stop := context.AfterFunc(ctx, func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+		defer stop()
+	}
 	b.mu.Lock()
 	for {
 		if b.Len() != 0 {
@@ -68,12 +90,43 @@ func (b *inboundBuffer) Read(p []byte) (n int, err error) {
 			err = b.err
 			break
 		}
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			err = ErrReadTimeout
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			break
+		}
 		b.cond.Wait()
 	}
 	b.mu.Unlock()
 	return
 }
 
+// Buffered returns the number of unread bytes in the buffer
+func (b *inboundBuffer) Buffered() (n int) {
+	b.mu.Lock()
+	n = b.Buffer.Len()
+	b.mu.Unlock()
+	return
+}
+
+// Discard drops all unread data and returns the number of bytes dropped
+func (b *inboundBuffer) Discard() (n int) {
+	b.mu.Lock()
+	n = b.Buffer.Len()
+	b.Buffer.Reset()
+	b.mu.Unlock()
+	return
+}
+
+// SetMaxSize changes the number of bytes the buffer holds before it is full
+func (b *inboundBuffer) SetMaxSize(maxSize int) {
+	b.mu.Lock()
+	b.maxSize = maxSize
+	b.mu.Unlock()
+}
+
 func (b *inboundBuffer) SetError(err error) {
 	b.mu.Lock()
 	b.err = err
@@ -82,25 +135,20 @@ func (b *inboundBuffer) SetError(err error) {
 }
 
 func (b *inboundBuffer) SetDeadline(t time.Time) {
-	// XXX: implement
-	/*
-		b.L.Lock()
-
-		// set the deadline
-		b.deadline = t
-
-		// how long until the deadline
-		delay := t.Sub(time.Now())
-
-		if b.timer != nil {
-			b.timer.Stop()
-		}
-
-		// after the delay, wake up waiters
-		b.timer = time.AfterFunc(delay, func() {
-			b.Broadcast()
+	b.mu.Lock()
+	b.deadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if !t.IsZero() {
+		// wake up blocked readers once the deadline passes
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
 		})
-
-		b.L.Unlock()
-	*/
+	}
+	b.mu.Unlock()
+	b.cond.Broadcast()
 }
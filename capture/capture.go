@@ -0,0 +1,289 @@
+// Package capture records the frames that muxado sessions send and receive to
+// pcapng files for offline analysis of protocol bugs, e.g. between two
+// production endpoints.
+//
+// A Writer wraps the framers of the sessions it captures:
+//
+//	f, _ := os.Create("session.pcapng")
+//	w, _ := capture.NewWriter(f)
+//	sess := muxado.Client(conn, &muxado.Config{NewFramer: w.NewFramer(nil)})
+//
+// Each session's frames appear on an interface of their own with the
+// LINKTYPE_USER0 link type. Every packet holds one frame as the framer read
+// or wrote it, the time it was read or written and whether it was received
+// or sent. A Reader reads them back, and a Replayer feeds the frames a
+// session received to a new session to reproduce what happened to it.
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// LinkType is the pcapng link type of the captured frames, LINKTYPE_USER0
+const LinkType = 147
+
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterface      = 0x1
+	blockEnhancedPacket = 0x6
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEndOfOpt   = 0
+	optUserAppl   = 4 // section header: the application that wrote the capture
+	optIfName     = 2 // interface: its name
+	optIfTsresol  = 9 // interface: the resolution of its timestamps
+	optEpbFlags   = 2 // enhanced packet: flags including the direction
+	directionMask = 0x3
+
+	nanoseconds = 9 // timestamps are written in units of 10^-9s
+)
+
+// Direction is whether a captured frame was received or sent by the session
+// that captured it. Its values are those of the direction bits of the pcapng
+// epb_flags option.
+type Direction int
+
+const (
+	Inbound  Direction = 1 // the frame was read by the session
+	Outbound Direction = 2 // the frame was written by the session
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "INBOUND"
+	case Outbound:
+		return "OUTBOUND"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// Record is a captured frame
+type Record struct {
+	// Index of the session the frame belongs to, in the order in which the
+	// Writer created their framers.
+	Session int
+
+	Time      time.Time
+	Direction Direction
+
+	// The frame as the framer read or wrote it
+	Data []byte
+}
+
+// Frame parses the captured frame. It fails for frames that carry checksums,
+// see muxado.Config.Checksums, and for frames captured from framers that
+// encrypt them, like those returned by frame.NewCipherFramer.
+func (r Record) Frame() (frame.Frame, error) {
+	return frame.Parse(r.Data)
+}
+
+// Writer writes a pcapng capture. It is safe for concurrent use.
+type Writer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	sessions int
+	buf      []byte
+	err      error // first error writing to w
+}
+
+// NewWriter returns a Writer that writes a capture to w, starting with its
+// section header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &Writer{w: w}
+	var body []byte
+	body = binary.LittleEndian.AppendUint32(body, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // major version
+	body = binary.LittleEndian.AppendUint16(body, 0) // minor version
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0))
+	body = appendOption(body, optUserAppl, []byte("muxado"))
+	body = appendOption(body, optEndOfOpt, nil)
+	return cw, cw.writeBlock(blockSectionHeader, body)
+}
+
+// AddSession adds an interface for the frames of a session named name and
+// returns its index. NewFramer calls it for every framer it creates. An
+// empty name is replaced by one made from the index.
+func (w *Writer) AddSession(name string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if name == "" {
+		name = fmt.Sprintf("muxado session %d", w.sessions)
+	}
+	var body []byte
+	body = binary.LittleEndian.AppendUint16(body, LinkType)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0) // no snapshot length limit
+	body = appendOption(body, optIfName, []byte(name))
+	body = appendOption(body, optIfTsresol, []byte{nanoseconds})
+	body = appendOption(body, optEndOfOpt, nil)
+	if err := w.writeBlockLocked(blockInterface, body); err != nil {
+		return 0, err
+	}
+	w.sessions++
+	return w.sessions - 1, nil
+}
+
+// WriteRecord writes a captured frame
+func (w *Writer) WriteRecord(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if r.Session < 0 || r.Session >= w.sessions {
+		return fmt.Errorf("record of unknown session %d", r.Session)
+	}
+	ts := uint64(r.Time.UnixNano())
+	body := w.buf[:0]
+	body = binary.LittleEndian.AppendUint32(body, uint32(r.Session))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(r.Data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(r.Data)))
+	body = append(body, r.Data...)
+	body = pad(body)
+	var flags [4]byte
+	binary.LittleEndian.PutUint32(flags[:], uint32(r.Direction)&directionMask)
+	body = appendOption(body, optEpbFlags, flags[:])
+	body = appendOption(body, optEndOfOpt, nil)
+	w.buf = body
+	return w.writeBlockLocked(blockEnhancedPacket, body)
+}
+
+// Err returns the first error encountered writing the capture. Framers
+// returned by NewFramer don't fail the session when the capture can't be
+// written; they stop capturing instead.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Writer) writeBlock(typ uint32, body []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeBlockLocked(typ, body)
+}
+
+func (w *Writer) writeBlockLocked(typ uint32, body []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	var hdr [8]byte
+	size := uint32(len(hdr) + len(body) + 4)
+	binary.LittleEndian.PutUint32(hdr[:], typ)
+	binary.LittleEndian.PutUint32(hdr[4:], size)
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], size)
+	for _, b := range [][]byte{hdr[:], body, trailer[:]} {
+		if _, err := w.w.Write(b); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// appendOption appends a pcapng option, padding its value to 32 bits
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return pad(append(b, value...))
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// NewFramer returns a function suitable for muxado.Config.NewFramer that
+// captures the frames passing through the framers returned by newFramer,
+// each framer on an interface of its own. If newFramer is nil,
+// frame.NewFramer is used.
+//
+// The frames are captured as the framer reads them from and writes them to
+// the session's transport, so the frames of framers that encrypt them can't
+// be decoded.
+func (w *Writer) NewFramer(newFramer func(io.Reader, io.Writer) frame.Framer) func(io.Reader, io.Writer) frame.Framer {
+	if newFramer == nil {
+		newFramer = frame.NewFramer
+	}
+	return func(rd io.Reader, wr io.Writer) frame.Framer {
+		id, err := w.AddSession("")
+		if err != nil {
+			return newFramer(rd, wr)
+		}
+		fr := &captureFramer{w: w, session: id}
+		fr.rd.Reader, fr.wr.Writer = rd, wr
+		fr.Framer = newFramer(&fr.rd, &fr.wr)
+		return fr
+	}
+}
+
+// teeReader keeps a copy of the bytes read through it
+type teeReader struct {
+	io.Reader
+	buf []byte
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	t.buf = append(t.buf, p[:n]...)
+	return n, err
+}
+
+// teeWriter keeps a copy of the bytes written through it
+type teeWriter struct {
+	io.Writer
+	buf []byte
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.Writer.Write(p)
+	t.buf = append(t.buf, p[:n]...)
+	return n, err
+}
+
+// captureFramer records the bytes its framer reads and writes for each frame
+type captureFramer struct {
+	frame.Framer
+	w       *Writer
+	session int
+	rd      teeReader
+	wr      teeWriter
+}
+
+func (fr *captureFramer) WriteFrame(f frame.Frame) error {
+	err := fr.Framer.WriteFrame(f)
+	fr.wr.buf = fr.record(Outbound, fr.wr.buf)
+	return err
+}
+
+func (fr *captureFramer) ReadFrame() (frame.Frame, error) {
+	f, err := fr.Framer.ReadFrame()
+	if err == nil {
+		// the payload is read now so that the record holds the whole frame
+		err = frame.ReadPayload(f)
+	}
+	fr.rd.buf = fr.record(Inbound, fr.rd.buf)
+	return f, err
+}
+
+// record writes the bytes of a frame and returns the buffer to reuse
+func (fr *captureFramer) record(dir Direction, b []byte) []byte {
+	if len(b) > 0 {
+		fr.w.WriteRecord(Record{Session: fr.session, Time: time.Now(), Direction: dir, Data: b})
+	}
+	return b[:0]
+}
+
+func (fr *captureFramer) Unwrap() frame.Framer {
+	return fr.Framer
+}
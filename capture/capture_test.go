@@ -0,0 +1,181 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+	"github.com/inconshreveable/muxado/frame"
+	"github.com/inconshreveable/muxado/muxtest"
+)
+
+// syncBuffer is a bytes.Buffer that the session's goroutines may write to
+// while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func readAll(t *testing.T, b []byte) []Record {
+	t.Helper()
+	recs, err := ReadAll(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+	return recs
+}
+
+func TestWriteRead(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write section header: %v", err)
+	}
+	if err := w.WriteRecord(Record{Data: []byte{1}}); err == nil {
+		t.Fatalf("Wrote record of a session that was not added")
+	}
+	for i := 0; i < 2; i++ {
+		if id, err := w.AddSession(""); err != nil || id != i {
+			t.Fatalf("Added session %d, %v, expected %d", id, err, i)
+		}
+	}
+	now := time.Unix(1700000000, 123456789)
+	want := []Record{
+		{Session: 0, Time: now, Direction: Outbound, Data: []byte("abcde")},
+		{Session: 1, Time: now.Add(time.Millisecond), Direction: Inbound, Data: []byte("abcdefgh")},
+	}
+	for _, rec := range want {
+		if err := w.WriteRecord(rec); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	got := readAll(t, buf.Bytes())
+	if len(got) != len(want) {
+		t.Fatalf("Read %d records, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Session != want[i].Session || !got[i].Time.Equal(want[i].Time) ||
+			got[i].Direction != want[i].Direction || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Fatalf("Read record %+v, expected %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf)
+	w.AddSession("")
+	w.WriteRecord(Record{Direction: Inbound, Data: []byte("abcde")})
+	b := buf.Bytes()
+
+	if _, err := NewReader(bytes.NewReader(b[8:])); err != ErrFormat {
+		t.Fatalf("Read capture without section header, got %v", err)
+	}
+	r, err := NewReader(bytes.NewReader(b[:len(b)-1]))
+	if err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Got %v reading truncated capture, expected %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestToNanoseconds(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		ts   uint64
+		res  uint8
+		want uint64
+	}{
+		{1500000, 6, 1500000000},
+		{15, 1, 1500000000},
+		{1500000000, 9, 1500000000},
+		{1500000000000, 12, 1500000000},
+		{3 << 9, 0x80 | 10, 1500000000},
+	} {
+		if got := toNanoseconds(tc.ts, tc.res); got != tc.want {
+			t.Errorf("toNanoseconds(%d, 0x%x) = %d, expected %d", tc.ts, tc.res, got, tc.want)
+		}
+	}
+}
+
+func TestCaptureSession(t *testing.T) {
+	t.Parallel()
+	var buf syncBuffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write section header: %v", err)
+	}
+	client, server := muxtest.NewSessionPair(t, &muxado.Config{NewFramer: w.NewFramer(nil)}, nil)
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.Close()
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	msg := []byte("hello capture")
+	if _, err := str.Write(msg); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	str.CloseWrite()
+	if got, err := io.ReadAll(str); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("Read echo %q, %v, expected %q", got, err, msg)
+	}
+	client.Close()
+	<-client.Done()
+	if err := w.Err(); err != nil {
+		t.Fatalf("Failed to write capture: %v", err)
+	}
+
+	recs := readAll(t, buf.bytes())
+	var sent, received []byte
+	first := true
+	for _, rec := range recs {
+		f, err := rec.Frame()
+		if err != nil {
+			t.Fatalf("Failed to parse captured frame %x: %v", rec.Data, err)
+		}
+		if first && rec.Direction == Outbound {
+			if f.Type() != frame.TypeSettings {
+				t.Fatalf("First frame sent is %s, expected SETTINGS", f.Type())
+			}
+			first = false
+		}
+		if d, ok := f.(*frame.Data); ok {
+			payload, _ := io.ReadAll(d.Reader())
+			if rec.Direction == Outbound {
+				sent = append(sent, payload...)
+			} else {
+				received = append(received, payload...)
+			}
+		}
+	}
+	if !bytes.Equal(sent, msg) || !bytes.Equal(received, msg) {
+		t.Fatalf("Captured %q sent and %q received, expected %q", sent, received, msg)
+	}
+}
@@ -0,0 +1,193 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+const (
+	// largest block a Reader accepts
+	maxBlockSize = 1 << 26
+
+	// default resolution of pcapng timestamps, 10^-6s
+	microseconds = 6
+)
+
+// ErrFormat is returned when reading a capture that is not valid pcapng
+var ErrFormat = errors.New("capture: invalid pcapng data")
+
+// Reader reads the frames of a pcapng capture written by a Writer. It skips
+// the blocks of other types that tools may have added to it and reads
+// captures of either byte order.
+type Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	res   []uint8 // timestamp resolution of each interface of the section
+	buf   []byte
+}
+
+// NewReader returns a Reader of the capture in r, reading its section header.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: r}
+	typ, _, err := cr.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if typ != blockSectionHeader {
+		return nil, ErrFormat
+	}
+	return cr, nil
+}
+
+// Next returns the next captured frame. It returns io.EOF at the end of the
+// capture.
+func (r *Reader) Next() (Record, error) {
+	for {
+		typ, body, err := r.readBlock()
+		if err != nil {
+			return Record{}, err
+		}
+		switch typ {
+		case blockInterface:
+			if len(body) < 8 {
+				return Record{}, ErrFormat
+			}
+			res := uint8(microseconds)
+			r.options(body[8:], func(code uint16, value []byte) {
+				if code == optIfTsresol && len(value) == 1 {
+					res = value[0]
+				}
+			})
+			r.res = append(r.res, res)
+
+		case blockEnhancedPacket:
+			return r.packet(body)
+		}
+	}
+}
+
+// packet decodes the body of an enhanced packet block
+func (r *Reader) packet(body []byte) (Record, error) {
+	if len(body) < 20 {
+		return Record{}, ErrFormat
+	}
+	iface := r.order.Uint32(body)
+	if iface >= uint32(len(r.res)) {
+		return Record{}, fmt.Errorf("capture: packet on undefined interface %d", iface)
+	}
+	ts := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+	capLen := uint64(r.order.Uint32(body[12:]))
+	opts := 20 + (capLen+3)&^3
+	if opts > uint64(len(body)) {
+		return Record{}, ErrFormat
+	}
+	rec := Record{
+		Session: int(iface),
+		Time:    time.Unix(0, int64(toNanoseconds(ts, r.res[iface]))),
+		Data:    append([]byte(nil), body[20:20+capLen]...),
+	}
+	r.options(body[opts:], func(code uint16, value []byte) {
+		if code == optEpbFlags && len(value) == 4 {
+			rec.Direction = Direction(r.order.Uint32(value) & directionMask)
+		}
+	})
+	return rec, nil
+}
+
+// toNanoseconds converts a timestamp of the given pcapng resolution
+func toNanoseconds(ts uint64, res uint8) uint64 {
+	exp := uint(res &^ 0x80)
+	if res&0x80 != 0 {
+		// units of 2^-exp seconds
+		if exp >= 64 {
+			return 0
+		}
+		hi, lo := bits.Mul64(ts>>exp, 1e9)
+		frac, _ := bits.Mul64(ts&(1<<exp-1)<<(64-exp), 1e9)
+		if hi != 0 {
+			return 0
+		}
+		return lo + frac
+	}
+	for ; exp < nanoseconds; exp++ {
+		ts *= 10
+	}
+	for ; exp > nanoseconds; exp-- {
+		ts /= 10
+	}
+	return ts
+}
+
+// options calls fn with each option in b
+func (r *Reader) options(b []byte, fn func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code, n := r.order.Uint16(b), int(r.order.Uint16(b[2:]))
+		if code == optEndOfOpt || 4+n > len(b) {
+			return
+		}
+		fn(code, b[4:4+n])
+		if n = 4 + (n+3)&^3; n > len(b) {
+			return
+		}
+		b = b[n:]
+	}
+}
+
+// readBlock reads the next block and returns its type and body. The body is
+// only valid until the next block is read.
+func (r *Reader) readBlock() (typ uint32, body []byte, err error) {
+	var hdr [12]byte
+	if _, err = io.ReadFull(r.r, hdr[:8]); err != nil {
+		return
+	}
+	// the section header block's type reads the same in either byte order and
+	// the byte order magic after its length sets the order of the section
+	if binary.LittleEndian.Uint32(hdr[:]) == blockSectionHeader {
+		if _, err = io.ReadFull(r.r, hdr[8:]); err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(hdr[8:]) == byteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(hdr[8:]) == byteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, ErrFormat
+		}
+		r.res = r.res[:0]
+	} else if r.order == nil {
+		return 0, nil, ErrFormat
+	}
+
+	typ, size := r.order.Uint32(hdr[:]), r.order.Uint32(hdr[4:])
+	read := uint32(8)
+	if typ == blockSectionHeader {
+		read = 12
+	}
+	if size < read+4 || size%4 != 0 || size > maxBlockSize {
+		return 0, nil, ErrFormat
+	}
+	if cap(r.buf) < int(size) {
+		r.buf = make([]byte, size)
+	}
+	b := r.buf[:size]
+	copy(b, hdr[:read])
+	if _, err = io.ReadFull(r.r, b[read:]); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if r.order.Uint32(b[size-4:]) != size {
+		return 0, nil, ErrFormat
+	}
+	return typ, b[8 : size-4], nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
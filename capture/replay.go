@@ -0,0 +1,98 @@
+package capture
+
+import (
+	"io"
+	"sync"
+)
+
+// ReadAll reads every frame of the capture in r
+func ReadAll(r io.Reader) ([]Record, error) {
+	cr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// Replayer is an in-memory transport that replays the frames a captured
+// session received, so that a session run over it handles them exactly as
+// the captured session did, e.g. to turn an incident captured in production
+// into a deterministic regression test:
+//
+//	recs, _ := capture.ReadAll(f)
+//	rp := capture.NewReplayer(recs, 0)
+//	sess := muxado.Server(rp, nil)
+//	<-rp.Done()
+//	// assert on the state of sess
+//
+// The session must be configured with a framer that reads frames the way
+// the captured session's framer did. The frames it writes are discarded.
+type Replayer struct {
+	data      [][]byte // the frames left to read
+	done      chan struct{}
+	closed    chan struct{}
+	doneOnce  sync.Once
+	closeOnce sync.Once
+}
+
+// NewReplayer returns a Replayer of the inbound frames of the given session
+// of a capture.
+func NewReplayer(recs []Record, session int) *Replayer {
+	rp := &Replayer{done: make(chan struct{}), closed: make(chan struct{})}
+	for _, rec := range recs {
+		if rec.Session == session && rec.Direction == Inbound {
+			rp.data = append(rp.data, rec.Data)
+		}
+	}
+	return rp
+}
+
+// Done returns a channel that is closed once the session reading from the
+// Replayer finished handling the last frame, i.e. when it reads past it.
+func (rp *Replayer) Done() <-chan struct{} {
+	return rp.done
+}
+
+// Read returns the bytes of the replayed frames in order. After the last
+// frame, it blocks until the Replayer is closed and then returns io.EOF, as
+// if the remote side closed the transport.
+func (rp *Replayer) Read(p []byte) (int, error) {
+	for len(rp.data) > 0 {
+		if len(rp.data[0]) == 0 {
+			rp.data = rp.data[1:]
+			continue
+		}
+		select {
+		case <-rp.closed:
+			return 0, io.EOF
+		default:
+		}
+		n := copy(p, rp.data[0])
+		rp.data[0] = rp.data[0][n:]
+		return n, nil
+	}
+	rp.doneOnce.Do(func() { close(rp.done) })
+	<-rp.closed
+	return 0, io.EOF
+}
+
+// Write discards the frames written by the session
+func (rp *Replayer) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Close ends the replay: reads return io.EOF.
+func (rp *Replayer) Close() error {
+	rp.closeOnce.Do(func() { close(rp.closed) })
+	return nil
+}
@@ -0,0 +1,69 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+	"github.com/inconshreveable/muxado/muxtest"
+)
+
+// captureServer captures the frames of a server session echoing a message
+func captureServer(t *testing.T, msg []byte) []Record {
+	var buf syncBuffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write section header: %v", err)
+	}
+	client, server := muxtest.NewSessionPair(t, nil, &muxado.Config{NewFramer: w.NewFramer(nil)})
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.Close()
+	}()
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write(msg)
+	str.CloseWrite()
+	if got, err := io.ReadAll(str); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("Read echo %q, %v, expected %q", got, err, msg)
+	}
+	client.Close()
+	<-server.Done()
+	return readAll(t, buf.bytes())
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+	msg := []byte("hello replay")
+	recs := captureServer(t, msg)
+
+	rp := NewReplayer(recs, 0)
+	sess := muxado.Server(rp, nil)
+	defer sess.Close()
+	select {
+	case <-rp.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session did not read the replayed frames")
+	}
+	str, err := sess.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept replayed stream: %v", err)
+	}
+	if got, err := io.ReadAll(str); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("Read %q, %v from replayed stream, expected %q", got, err, msg)
+	}
+
+	rp.Close()
+	<-sess.Done()
+	if code, _ := muxado.GetError(sess.Err()); code != muxado.PeerEOF {
+		t.Fatalf("Session died with %v after the replay, expected %v", code, muxado.PeerEOF)
+	}
+}
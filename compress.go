@@ -0,0 +1,226 @@
+package muxado
+
+import (
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// CodecId identifies a compression codec on the wire. Sessions advertise the
+// ids of the codecs they accept in their SETTINGS, see Config.Codecs.
+type CodecId uint8
+
+const (
+	// CodecDeflate compresses with DEFLATE, see compress/flate. Sessions that
+	// enable Config.Compression always accept it.
+	CodecDeflate CodecId = 0
+
+	// highest id that can be advertised, see codecMask
+	maxCodecId = 31
+
+	// metadata key carrying the id of the codec of streams not compressed
+	// with DEFLATE
+	codecKey = "muxado.codec"
+)
+
+// Codec compresses the data of streams opened WithCompression, e.g. with
+// snappy or zstd. Both sides of a session must register it under the same
+// id in Config.Codecs.
+type Codec interface {
+	// NewReader returns a reader decompressing the data read from r
+	NewReader(r io.Reader) io.ReadCloser
+	// NewWriter returns a writer compressing the data written to w
+	NewWriter(w io.Writer) CodecWriter
+}
+
+// CodecWriter compresses the data written to a stream. It is flushed after
+// each Write so that the remote side can read the data right away, and
+// closed to end the compressed data when the stream is half-closed.
+type CodecWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type deflateCodec struct{}
+
+func (deflateCodec) NewReader(r io.Reader) io.ReadCloser {
+	return flate.NewReader(r)
+}
+
+func (deflateCodec) NewWriter(w io.Writer) CodecWriter {
+	// flate.NewWriter only fails for an invalid compression level
+	fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return fw
+}
+
+// codecMask returns the value of the SettingCompression a session advertises,
+// with the bit of each codec id it accepts set. Peers that only know DEFLATE
+// advertise 1.
+func codecMask(config *Config) uint32 {
+	mask := uint32(1) << CodecDeflate
+	for id := range config.Codecs {
+		if id <= maxCodecId {
+			mask |= 1 << id
+		}
+	}
+	return mask
+}
+
+// codec returns the codec registered under id, or nil if there is none
+func (s *session) codec(id CodecId) Codec {
+	if id == CodecDeflate {
+		return deflateCodec{}
+	}
+	return s.config.Codecs[id]
+}
+
+// chooseCodec waits for the remote side's SETTINGS and returns the first of
+// ids that both sides support, DEFLATE if ids is empty. It returns a nil
+// Codec if the remote side doesn't accept any of them.
+func (s *session) chooseCodec(ids []CodecId) (CodecId, Codec, error) {
+	select {
+	case <-s.settled:
+	case <-s.dead:
+		return 0, nil, s.dieErr
+	case <-s.openDeadline.wait():
+		return 0, nil, ErrOpenTimeout
+	}
+	if len(ids) == 0 {
+		ids = []CodecId{CodecDeflate}
+	}
+	remote := atomic.LoadUint32(&s.remote.compression)
+	for _, id := range ids {
+		if id > maxCodecId || remote&(1<<id) == 0 {
+			continue
+		}
+		if codec := s.codec(id); codec != nil {
+			return id, codec, nil
+		}
+	}
+	return 0, nil, nil
+}
+
+// acceptedCodec returns the codec of a compressed stream opened by the
+// remote side, named in its metadata unless it is DEFLATE
+func (s *session) acceptedCodec(str streamPrivate) (Codec, error) {
+	v, ok := str.Metadata()[codecKey]
+	if !ok {
+		return deflateCodec{}, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid codec id %q", v)
+	}
+	codec := s.config.Codecs[CodecId(id)]
+	if codec == nil || id > maxCodecId || !s.config.Compression {
+		return nil, fmt.Errorf("stream compressed with codec %d, which is not accepted", id)
+	}
+	return codec, nil
+}
+
+// compressedStream wraps a stream whose data is compressed by a Codec. Each
+// Write is flushed so that the remote side can read it immediately.
+type compressedStream struct {
+	Stream
+
+	rmu  sync.Mutex
+	r    io.ReadCloser
+	rctx context.Context // context of the read in progress, guarded by rmu
+
+	wmu  sync.Mutex
+	w    CodecWriter
+	wctx context.Context // context of the write in progress, guarded by wmu
+}
+
+func newCompressedStream(str Stream, codec Codec) *compressedStream {
+	s := &compressedStream{
+		Stream: str,
+		rctx:   context.Background(),
+		wctx:   context.Background(),
+	}
+	s.r = codec.NewReader(compressedReader{s})
+	s.w = codec.NewWriter(compressedWriter{s})
+	return s
+}
+
+// compressedReader reads the compressed data of a stream with the context of
+// the read in progress
+type compressedReader struct{ s *compressedStream }
+
+func (r compressedReader) Read(p []byte) (int, error) {
+	return r.s.Stream.ReadContext(r.s.rctx, p)
+}
+
+// compressedWriter writes the compressed data of a stream with the context
+// of the write in progress
+type compressedWriter struct{ s *compressedStream }
+
+func (w compressedWriter) Write(p []byte) (int, error) {
+	return w.s.Stream.WriteContext(w.s.wctx, p)
+}
+
+func (s *compressedStream) Read(p []byte) (int, error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+func (s *compressedStream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	s.rctx = ctx
+	defer func() { s.rctx = context.Background() }()
+	return s.r.Read(p)
+}
+
+func (s *compressedStream) Write(p []byte) (int, error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+// WriteContext compresses p and flushes it. If ctx is done before the
+// compressed data is written, the stream's compressed data is left
+// incomplete and the stream should be closed.
+func (s *compressedStream) WriteContext(ctx context.Context, p []byte) (n int, err error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.wctx = ctx
+	defer func() { s.wctx = context.Background() }()
+	if n, err = s.w.Write(p); err != nil {
+		return
+	}
+	err = s.w.Flush()
+	return
+}
+
+// CloseWrite ends the compressed data before half-closing the stream
+func (s *compressedStream) CloseWrite() error {
+	s.wmu.Lock()
+	err := s.w.Close()
+	s.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.Stream.CloseWrite()
+}
+
+// CloseWriteWithTrailers ends the compressed data before half-closing the
+// stream with the trailers, which are not compressed
+func (s *compressedStream) CloseWriteWithTrailers(trailers map[string]string) error {
+	s.wmu.Lock()
+	err := s.w.Close()
+	s.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.Stream.CloseWriteWithTrailers(trailers)
+}
+
+func (s *compressedStream) Close() error {
+	// best effort to end the compressed data cleanly
+	s.wmu.Lock()
+	s.w.Close()
+	s.wmu.Unlock()
+	return s.Stream.Close()
+}
@@ -3,19 +3,295 @@ package muxado
 import (
 	"io"
 	"sync"
+	"time"
 
 	"github.com/inconshreveable/muxado/frame"
 )
 
 var zeroConfig Config
 
+// AcceptQueuePolicy determines what a session does with a new stream opened by
+// the remote side when its accept queue is full. Streams waiting for room in
+// the queue don't hold up the frames of other streams. Up to AcceptBacklog
+// streams wait at a time; new streams beyond them are reset with
+// AcceptQueueFull.
+type AcceptQueuePolicy int
+
+const (
+	// Reset the new stream with AcceptQueueFull if no room frees up within AcceptQueueTimeout
+	AcceptQueueReset AcceptQueuePolicy = iota
+	// Hold the new stream until there is room in the queue, withholding its
+	// window updates and acknowledgment until then to apply backpressure
+	AcceptQueueBlock
+	// Reset the oldest stream in the queue with AcceptQueueFull to make room for the new one
+	AcceptQueueDropOldest
+)
+
+// WriteQueuePolicy determines what a stream's write does when the queue of
+// frames waiting for the session's writer goroutine is full, see
+// Config.WriteQueueDepth
+type WriteQueuePolicy int
+
+const (
+	// Wait for room in the queue until the stream's write deadline passes
+	WriteQueueBlock WriteQueuePolicy = iota
+	// Fail the write with ErrWriteQueueFull right away, so that a stream is
+	// not held up behind the frames of others. The data of the frame that did
+	// not fit is not sent and its flow control window is given back.
+	WriteQueueFailFast
+)
+
+// ValidationMode determines how a session handles frames from the remote side
+// that deviate from the protocol in ways it could tolerate. Malformed frames,
+// like frames with illegal lengths or stream ids with the wrong parity, close
+// the session with a ProtocolError in every mode.
+type ValidationMode int
+
+const (
+	// Tolerate frames of unknown types, undefined flags and new streams whose
+	// ids are not greater than those opened before, for forward compatibility
+	// with newer peers. Each deviation is reported to OnProtocolDeviation.
+	ValidationLenient ValidationMode = iota
+	// Close the session with a ProtocolError on any deviation, e.g. to test
+	// the conformance of a peer
+	ValidationStrict
+)
+
+// Config configures a Session. The zero value of each field selects its
+// default.
+//
+// Flow control: streams receive up to MaxWindowSize bytes, or their type's
+// size from WindowProfiles, before the remote side must wait for the
+// application to read. Smaller windows are reached by withholding window
+// updates as data is read and are no smaller than 4KB. WindowUpdateThreshold
+// and WindowUpdateDelay coalesce window increments into fewer WNDINC frames,
+// like delayed acknowledgements, at the cost of burstier writes by the remote
+// side; delayed increments are sent early once they reach half of the window
+// so that the remote side doesn't stall. IdleWindowTimeout shrinks the windows
+// of idle streams so that thousands of them don't each commit the session to
+// buffering a full window; windows grow back once data arrives and are only
+// shrunk if the remote side advertises IdleWindowTimeout as well.
+//
+// Writes: WriteCoalesceDelay holds back small writes of chatty protocols until
+// 16KB are held back or the stream is half-closed. MaxEgressRate and Pacing
+// pace the session's frames; Pacing spreads a window's worth of data over
+// about a round trip at slightly more than the measured delivery rate instead
+// of bursting it into the transport's buffers, where it delays the frames of
+// competing interactive streams. Deeper write queues absorb bursts of writes
+// from many streams, shallower ones bound the latency a frame waits behind
+// those of other streams. Control frames (RST, WNDINC, GOAWAY, PING and
+// SETTINGS) wait in a queue of their own and are written ahead of the others,
+// except for RSTs of streams whose opening frame may still be queued.
+// DirectWrites saves the channel operations of handing each frame to the
+// writer goroutine, lowering the latency of writes under contention, but
+// frames written concurrently are not ordered by stream priority. Frames the
+// session writes on its own, like window updates, always go through the
+// writer goroutine or the WriteLoop.
+//
+// Framing: smaller DATA frames reduce head-of-line blocking between streams,
+// larger ones reduce framing overhead. MaxFrameSize is advertised via SETTINGS
+// and larger DATA frames from the remote side fail the session with
+// FrameSizeError. Sizes above 16MB need extended lengths, which are only read
+// and advertised with a framer that implements frame.LimitedFramer, and only
+// apply to remote sides which support them. Checksums need a framer that
+// implements frame.ChecksumFramer. Framers returned by NewFramer that wrap
+// another should implement frame.WrappingFramer so that the session still
+// finds the optional interfaces of the framer they wrap. Checksums, padding
+// and compression are negotiated via SETTINGS and only used if the remote
+// side enables them as well. Padding hides the size of the data from
+// observers of the transport: frames larger than the largest of
+// PaddingBuckets are padded to a multiple of it and sizes above
+// frame.MaxLength are ignored.
+//
+// Timeouts: ReadIdleTimeout detects dead peers and half-open transports; the
+// session sends a keepalive PING whenever it has not received a frame for half
+// of it, so the remote side must support PING. HandshakeTimeout keeps port
+// scanners and stalled peers from holding on to a server's resources, and
+// StreamIdleTimeout reaps streams leaked by buggy peers or applications.
+// Streams are checked for StreamIdleTimeout, IdleWindowTimeout and
+// SlowConsumerTimeout periodically, so they may outlive them by up to half
+// as long again.
+//
+// Opening and accepting streams: SyncOpen lets callers of OpenStream learn
+// right away when a stream is refused, e.g. because the remote accept queue is
+// full or its stream limit is reached. Remote sides that speak protocol
+// versions before 3 don't acknowledge streams; their streams are returned once
+// the first frame from the remote side reveals its version. Streams opened by
+// the remote side pass OnIncomingStream, which sees stream types as Authorize
+// does, then Authorize and PreAuthLimits before they are queued for Accept.
+// Authorize reads the type of a stream opened by
+// TypedStreamSession.OpenTypedStream from the data of its SYN, or zero if the
+// SYN carries too little data, authorizes streams opened before the remote
+// side authenticated with an empty AuthIdentity, and sends the text of its
+// error along with the reset, which the remote side reads as the Debug of a
+// *StreamResetError. WrapStream wrappers see data written by
+// OpenStreamWithData and should implement WrappingStream, e.g. by embedding
+// StreamWrapper, so that features like TypedStreamSession find the streams
+// they wrap; ChainStreamMiddleware installs several of them.
+//
+// Callbacks: OnProtocolDeviation, OnIncomingStream, Authorize and OnUserFrame
+// are called by the session's reader, the frame hooks by the goroutines that
+// read and write the session's frames, and OnFlowEvent and the lifecycle
+// callbacks synchronously by the session; none of them may block. OnPush is
+// called from a goroutine of its own. The data passed to OnUserFrame is only
+// valid until it returns.
+//
+// Tags are reported by DebugHandler and label the session's goroutines in
+// profiles as "muxado.tag.<key>"; more can be added with Session.SetTag.
+// Streams opened by a session with a Migrator carry an id for it in their
+// metadata.
 type Config struct {
 	// Maximum size of unread data to receive and buffer (per-stream). Default 256KB.
 	MaxWindowSize uint32
+	// Receive window sizes of streams by their type, see TypedStreamSession,
+	// in place of MaxWindowSize. Default nil (MaxWindowSize for every type).
+	WindowProfiles map[StreamType]uint32
+	// Fraction of a stream's receive window read before it is replenished
+	// with a single WNDINC. Default 0 (a WNDINC for every read).
+	WindowUpdateThreshold float64
+	// Maximum time window increments are held back to be sent in a single
+	// WNDINC. Default 0 (no delay).
+	WindowUpdateDelay time.Duration
+	// Maximum time small writes are held back to be sent in a single DATA
+	// frame, see Stream.SetNoDelay. Default 0 (no delay).
+	WriteCoalesceDelay time.Duration
 	// Maximum number of inbound streams to queue for Accept(). Default 128.
 	AcceptBacklog uint32
-	// Function creating the Session's framer. Deafult frame.NewFramer()
+	// What to do with new inbound streams when the accept queue is full. Default AcceptQueueReset.
+	AcceptQueuePolicy AcceptQueuePolicy
+	// How long AcceptQueueReset waits for room in the accept queue. Default 1ms.
+	AcceptQueueTimeout time.Duration
+	// Maximum size of unread data buffered across all streams, beyond which
+	// the fullest stream is reset with EnhanceYourCalm. Default 0 (unlimited).
+	MaxSessionBuffer uint32
+	// Maximum rate in bytes per second at which frames are written to the
+	// transport. Default 0 (unlimited).
+	MaxEgressRate uint32
+	// Whether to pace DATA frames at the delivery rate measured by PathStats.
+	// Default false.
+	Pacing bool
+	// Maximum number of concurrent streams opened by each side of the
+	// session. Default 0 (unlimited).
+	MaxStreams uint32
+	// Whether opening a stream waits, up to the open deadline, until the
+	// remote side acknowledges or refuses it. Default false.
+	SyncOpen bool
+	// Maximum payload size of DATA frames sent and received. Default 16MB.
+	MaxFrameSize uint32
+	// Maximum time to wait for a frame from the remote side before closing
+	// the session with ErrReadIdleTimeout. Default 0 (disabled).
+	ReadIdleTimeout time.Duration
+	// Maximum time to wait for the first valid frame from the remote side
+	// before closing the session with ErrHandshakeTimeout. Default 0 (disabled).
+	HandshakeTimeout time.Duration
+	// Maximum time a stream may go without reads or writes before it is
+	// reset with StreamIdleTimeout. Default 0 (disabled).
+	StreamIdleTimeout time.Duration
+	// Lowest protocol version the session speaks. Default ProtocolVersion1 (any version).
+	MinProtocolVersion uint16
+	// Highest protocol version the session speaks. Default ProtocolVersion.
+	MaxProtocolVersion uint16
+	// How strictly frames from the remote side are validated. Default
+	// ValidationLenient.
+	Validation ValidationMode
+	// Called with each deviation from the protocol tolerated under
+	// ValidationLenient. Default nil.
+	OnProtocolDeviation func(err error)
+	// Whether to accept streams whose data is compressed. Default false.
+	Compression bool
+	// Codecs besides DEFLATE that streams can be compressed with, keyed by
+	// ids from 1 to 31. Default nil (DEFLATE only).
+	Codecs map[CodecId]Codec
+	// Whether to protect every frame with a CRC32C checksum. Default false.
+	Checksums bool
+	// Sizes that DATA frames are padded up to on the wire with PADDING
+	// frames, e.g. 512, 4096 and 16384. Default nil (no padding).
+	PaddingBuckets []int
+	// Called with each stream opened by the remote side before it is queued
+	// for Accept; returning false resets it with code. Default nil (accept all streams).
+	OnIncomingStream func(id uint32, stype StreamType, metadata map[string]string) (accept bool, code ErrorCode)
+	// Called with each stream the remote side pushes, see WithPush; returning
+	// false resets it with StreamRefused. Default nil (pushes are declined).
+	OnPush func(associated uint32, pushed Stream) bool
+	// Called to authorize each stream opened by the remote side by who it is;
+	// an error resets it with PermissionDenied. Default nil (authorize all streams).
+	Authorize func(stype StreamType, metadata map[string]string, peer PeerIdentity) error
+	// Called with each change in the flow control of the session's streams.
+	// Default nil.
+	OnFlowEvent func(FlowEvent)
+	// Time the receive window of a stream may stay full before
+	// SlowConsumerPolicy is applied to it. Default 0 (disabled).
+	SlowConsumerTimeout time.Duration
+	// What to do with slow consumers, see SlowConsumerTimeout. Default
+	// SlowConsumerNotify.
+	SlowConsumerPolicy SlowConsumerPolicy
+	// Time a stream may go without reads or writes before its receive window
+	// is shrunk to IdleWindowSize. Default 0 (disabled).
+	IdleWindowTimeout time.Duration
+	// Size the windows of idle streams are shrunk to, see
+	// IdleWindowTimeout. Default 4KB.
+	IdleWindowSize uint32
+
+	// Called when a stream is opened by either side of the session, before
+	// it is returned from OpenStream or queued for Accept. Default nil.
+	OnStreamOpen func(str Stream)
+	// Called when a stream is removed from the session with the error that
+	// terminated it, or nil if it was closed normally. Default nil.
+	OnStreamClose func(str Stream, err error)
+	// Called when a stream is reset by either side of the session with the
+	// error code of the reset. Default nil.
+	OnStreamReset func(str Stream, code ErrorCode)
+
+	// Read a PROXY protocol version 2 header from the start of each accepted
+	// stream, see WithProxyHeader. Default false.
+	ProxyProtocol bool
+	// Extensions to run on the session, see Extension. Default none.
+	Extensions []Extension
+	// Authenticates the client of a server session before its streams are
+	// accepted. Default nil (clients are not authenticated).
+	Verifier Verifier
+	// Authenticate a client session to a server that requires it, see
+	// Verifier. Default nil.
+	Credentials Credentials
+	// Maximum amount of time a server with a Verifier waits for its client
+	// to authenticate. Default 10 seconds.
+	AuthTimeout time.Duration
+	// Limits on the work the client of a server session can cause before it
+	// authenticated. Default nil (no limits).
+	PreAuthLimits *PreAuthLimits
+	// Called with each stream opened by either side of the session; the
+	// stream it returns is handed to the application instead. Default nil.
+	WrapStream func(str Stream, local bool) Stream
+	// Size of the buffer that frames are read through from the transport,
+	// or negative to read them directly. Default 32KB.
+	ReadBufferSize int
+	// Write frames from the goroutines writing them, taking turns under a
+	// lock, instead of through the session's writer goroutine. Default false.
+	DirectWrites bool
+	// Write loop shared with other sessions that writes the frames the
+	// session writes on its own, see WriteLoop. Default nil.
+	WriteLoop *WriteLoop
+	// Number of frames that may wait for the session's writer goroutine.
+	// Default 64.
+	WriteQueueDepth int
+	// What streams' writes do when the write queue is full. Default
+	// WriteQueueBlock.
+	WriteQueuePolicy WriteQueuePolicy
+	// Function creating the Session's framer. Default frame.NewFramer.
 	NewFramer func(io.Reader, io.Writer) frame.Framer
+	// Called with each frame the session reads, before it is handled, and
+	// writes, before it is written, see FrameHook. Default nil.
+	OnFrameRead  FrameHook
+	OnFrameWrite FrameHook
+	// Called with each application frame sent by the remote side with
+	// WriteUserFrame; an error closes the session. Default nil (discarded).
+	OnUserFrame func(ftype frame.Type, flags frame.Flags, streamId uint32, data []byte) error
+	// Key/value tags describing the session, see Session.Tags. Default
+	// none.
+	Tags map[string]string
+	// Migrator that moves streams between this and other sessions to the
+	// same peer. Default nil (streams cannot be migrated).
+	Migrator *Migrator
 
 	// allow safe concurrent initialization
 	initOnce sync.Once
@@ -23,8 +299,8 @@ type Config struct {
 	// Function to create new streams
 	newStream streamFactory
 
-	// Size of writeFrames channel
-	writeFrameQueueDepth int
+	// Size of the buffer that batches frame writes to the transport
+	writeBufferSize int
 }
 
 func (c *Config) initDefaults() {
@@ -35,14 +311,42 @@ func (c *Config) initDefaults() {
 		if c.AcceptBacklog == 0 {
 			c.AcceptBacklog = 128
 		}
+		if c.AcceptQueueTimeout == 0 {
+			c.AcceptQueueTimeout = time.Millisecond
+		}
+		if c.AuthTimeout == 0 {
+			c.AuthTimeout = defaultAuthTimeout
+		}
+		if c.MaxFrameSize == 0 {
+			c.MaxFrameSize = frame.MaxLength
+		}
+		if c.MaxFrameSize > frame.MaxExtendedLength {
+			c.MaxFrameSize = frame.MaxExtendedLength
+		}
+		if c.MinProtocolVersion == 0 {
+			c.MinProtocolVersion = ProtocolVersion1
+		}
+		if c.MaxProtocolVersion == 0 {
+			c.MaxProtocolVersion = ProtocolVersion
+		}
 		if c.NewFramer == nil {
 			c.NewFramer = frame.NewFramer
 		}
 		if c.newStream == nil {
 			c.newStream = newStream
 		}
-		if c.writeFrameQueueDepth == 0 {
-			c.writeFrameQueueDepth = 64
+		if c.WriteQueueDepth == 0 {
+			c.WriteQueueDepth = 64
+		}
+		if c.ReadBufferSize == 0 {
+			c.ReadBufferSize = 0x8000 // 32KB
+		}
+		if c.IdleWindowSize == 0 {
+			c.IdleWindowSize = minShrunkWindow
+		}
+		if c.writeBufferSize == 0 {
+			c.writeBufferSize = 0x8000 // 32KB
 		}
+		c.PaddingBuckets = paddingBuckets(c.PaddingBuckets)
 	})
 }
@@ -0,0 +1,453 @@
+// Package conformance tests that a Session implementation speaks the muxado
+// protocol correctly by exchanging raw frames with it.
+//
+// The suite plays the remote side of each session it asks the implementation
+// under test to create. It checks the rules that interoperating
+// implementations depend on: stream id parity, flow control window
+// accounting and GOAWAY semantics. Run it from a test:
+//
+//	func TestConformance(t *testing.T) {
+//	    conformance.Run(t, conformance.Implementation{
+//	        Client: func(c net.Conn) muxado.Session { return mymux.Client(c) },
+//	        Server: func(c net.Conn) muxado.Session { return mymux.Server(c) },
+//	    })
+//	}
+package conformance
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+	"github.com/inconshreveable/muxado/frame"
+)
+
+const (
+	defaultWindowSize = 0x40000 // 256KB
+	defaultTimeout    = 5 * time.Second
+
+	// how long the implementation is given to send a frame it must not send
+	quietPeriod = 100 * time.Millisecond
+
+	// size of the DATA frames sent by the suite
+	dataFrameSize = 0x4000
+)
+
+// Implementation describes the Session implementation under test
+type Implementation struct {
+	// Returns a client session running over trans. Required.
+	Client func(trans net.Conn) muxado.Session
+	// Returns a server session running over trans. Required.
+	Server func(trans net.Conn) muxado.Session
+	// The initial flow control window of each of the implementation's
+	// streams in both directions. Default 256KB.
+	WindowSize uint32
+	// How long to wait for the implementation to send an expected frame.
+	// Default 5s.
+	Timeout time.Duration
+}
+
+func (impl *Implementation) initDefaults() {
+	if impl.WindowSize == 0 {
+		impl.WindowSize = defaultWindowSize
+	}
+	if impl.Timeout == 0 {
+		impl.Timeout = defaultTimeout
+	}
+}
+
+// Run runs the conformance suite against impl, each test as a subtest of t
+func Run(t *testing.T, impl Implementation) {
+	impl.initDefaults()
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.run(t, &impl)
+		})
+	}
+}
+
+var tests = []struct {
+	name string
+	run  func(*testing.T, *Implementation)
+}{
+	{"ClientStreamIds", testClientStreamIds},
+	{"ServerStreamIds", testServerStreamIds},
+	{"ClientRejectsWrongParity", testClientRejectsWrongParity},
+	{"ServerRejectsWrongParity", testServerRejectsWrongParity},
+	{"SendWindow", testSendWindow},
+	{"ReceiveWindow", testReceiveWindow},
+	{"RemoteGoAway", testRemoteGoAway},
+	{"LocalGoAway", testLocalGoAway},
+}
+
+// Client streams have odd ids that increase with each new stream
+func testClientStreamIds(t *testing.T, impl *Implementation) {
+	sess, p := newPair(t, impl, true)
+	testStreamIds(t, sess, p, 1)
+}
+
+// Server streams have even ids that increase with each new stream
+func testServerStreamIds(t *testing.T, impl *Implementation) {
+	sess, p := newPair(t, impl, false)
+	testStreamIds(t, sess, p, 0)
+}
+
+func testStreamIds(t *testing.T, sess muxado.Session, p *peer, parity frame.StreamId) {
+	var last frame.StreamId
+	for i := 0; i < 3; i++ {
+		str, err := sess.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte{0}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		f, _ := p.expectData()
+		if !f.Syn() {
+			t.Fatalf("first DATA frame of stream 0x%x does not have SYN set", f.StreamId())
+		}
+		if id := f.StreamId(); id&1 != parity || id <= last || id != frame.StreamId(str.Id()) {
+			t.Fatalf("stream opened with id 0x%x after stream 0x%x, Stream.Id() 0x%x", id, last, str.Id())
+		}
+		last = f.StreamId()
+	}
+}
+
+// A client must close the session with a PROTOCOL_ERROR when the server opens
+// a stream with an odd id
+func testClientRejectsWrongParity(t *testing.T, impl *Implementation) {
+	_, p := newPair(t, impl, true)
+	p.writeSyn(3)
+	p.expectGoAway(muxado.ProtocolError)
+	p.expectClosed()
+}
+
+// A server must close the session with a PROTOCOL_ERROR when the client opens
+// a stream with an even id
+func testServerRejectsWrongParity(t *testing.T, impl *Implementation) {
+	_, p := newPair(t, impl, false)
+	p.writeSyn(2)
+	p.expectGoAway(muxado.ProtocolError)
+	p.expectClosed()
+}
+
+// A sender must not send more DATA than the receiver's window allows until it
+// receives a WNDINC
+func testSendWindow(t *testing.T, impl *Implementation) {
+	sess, p := newPair(t, impl, true)
+	str, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	const extra = 1000
+	go str.Write(make([]byte, int(impl.WindowSize)+extra))
+
+	var id frame.StreamId
+	for n := 0; n < int(impl.WindowSize); {
+		f, data := p.expectData()
+		id = f.StreamId()
+		n += len(data)
+		if n > int(impl.WindowSize) {
+			t.Fatalf("sent %d bytes with a window of %d", n, impl.WindowSize)
+		}
+	}
+	p.expectQuiet()
+
+	wndinc := new(frame.WndInc)
+	if err := wndinc.Pack(id, extra); err != nil {
+		t.Fatalf("failed to pack WNDINC: %v", err)
+	}
+	p.write(wndinc)
+	for n := 0; n < extra; {
+		_, data := p.expectData()
+		n += len(data)
+		if n > extra {
+			t.Fatalf("sent %d bytes after a window increment of %d", n, extra)
+		}
+	}
+}
+
+// A receiver must reset a stream with a FLOW_CONTROL_ERROR when the sender
+// exceeds its window
+func testReceiveWindow(t *testing.T, impl *Implementation) {
+	_, p := newPair(t, impl, false)
+	const id = 1
+	data := make([]byte, impl.WindowSize+1)
+	for flags := frame.Flags(frame.FlagDataSyn); len(data) > 0; flags = 0 {
+		n := dataFrameSize
+		if n > len(data) {
+			n = len(data)
+		}
+		p.writeData(id, data[:n], flags)
+		data = data[n:]
+	}
+	p.expectRst(id, muxado.FlowControlError)
+}
+
+// After receiving a GOAWAY, a session must fail its streams above the last
+// stream id the remote side processed and must not open new streams
+func testRemoteGoAway(t *testing.T, impl *Implementation) {
+	sess, p := newPair(t, impl, true)
+	var strs [2]muxado.Stream
+	for i := range strs {
+		str, err := sess.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte{0}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		p.expectData()
+		strs[i] = str
+	}
+
+	goAway := new(frame.GoAway)
+	if err := goAway.Pack(frame.StreamId(strs[0].Id()), frame.ErrorCode(muxado.NoError), nil); err != nil {
+		t.Fatalf("failed to pack GOAWAY: %v", err)
+	}
+	p.write(goAway)
+	p.sync()
+
+	if _, err := strs[0].Write([]byte{0}); err != nil {
+		t.Fatalf("failed to write to stream below the GOAWAY's last stream id: %v", err)
+	}
+	if _, err := strs[1].Write([]byte{0}); err == nil {
+		t.Fatalf("wrote to stream above the GOAWAY's last stream id")
+	}
+	if _, err := sess.OpenStream(); err == nil {
+		t.Fatalf("opened stream after receiving GOAWAY")
+	}
+}
+
+// After sending a GOAWAY, a session must refuse new streams
+func testLocalGoAway(t *testing.T, impl *Implementation) {
+	sess, p := newPair(t, impl, false)
+	go sess.GoAway(muxado.NoError, nil, time.Time{})
+	p.expectGoAway(muxado.NoError)
+	p.writeData(1, []byte{0}, frame.FlagDataSyn)
+	p.expectRst(1, muxado.StreamRefused)
+}
+
+// peer is the remote side of a session under test. It reads frames in the
+// background because writes to the synchronous pipe block until they are read.
+type peer struct {
+	t       *testing.T
+	conn    net.Conn
+	timeout time.Duration
+
+	wmu sync.Mutex
+	fr  frame.Framer
+
+	frames  chan frame.Frame // frames the tests are interested in
+	acks    chan uint64      // data of acknowledged PINGs
+	readErr error            // error that stopped the reader, set before frames is closed
+}
+
+// newPair returns a session created by impl and the peer it is connected to
+func newPair(t *testing.T, impl *Implementation, client bool) (muxado.Session, *peer) {
+	local, remote := net.Pipe()
+	var sess muxado.Session
+	if client {
+		sess = impl.Client(local)
+	} else {
+		sess = impl.Server(local)
+	}
+	t.Cleanup(func() {
+		sess.Close()
+		remote.Close()
+	})
+	p := &peer{
+		t:       t,
+		conn:    remote,
+		timeout: impl.Timeout,
+		fr:      frame.NewFramer(nil, remote),
+		frames:  make(chan frame.Frame, 64),
+		acks:    make(chan uint64, 1),
+	}
+	go p.reader()
+	return sess, p
+}
+
+// reader reads each frame into its own buffer so that it remains valid after
+// the next one is read. SETTINGS and WNDINC frames are ignored and PINGs are
+// answered.
+func (p *peer) reader() {
+	defer close(p.frames)
+	for {
+		hdr := make([]byte, frame.HeaderSize)
+		if _, err := io.ReadFull(p.conn, hdr); err != nil {
+			p.readErr = err
+			return
+		}
+		length := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+		b := append(hdr, make([]byte, length)...)
+		if _, err := io.ReadFull(p.conn, b[frame.HeaderSize:]); err != nil {
+			p.readErr = err
+			return
+		}
+		f, err := frame.Parse(b)
+		if err != nil {
+			p.readErr = err
+			return
+		}
+		switch f := f.(type) {
+		case *frame.Settings, *frame.WndInc:
+		case *frame.Ping:
+			if f.Ack() {
+				p.acks <- f.Data()
+			} else {
+				ack := new(frame.Ping)
+				ack.Pack(f.Data(), true)
+				p.writeFrame(ack)
+			}
+		default:
+			p.frames <- f
+		}
+	}
+}
+
+func (p *peer) writeFrame(f frame.Frame) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	return p.fr.WriteFrame(f)
+}
+
+func (p *peer) write(f frame.Frame) {
+	p.t.Helper()
+	if err := p.writeFrame(f); err != nil {
+		p.t.Fatalf("failed to write %s frame: %v", f.Type(), err)
+	}
+}
+
+func (p *peer) writeData(id frame.StreamId, data []byte, flags frame.Flags) {
+	p.t.Helper()
+	f := new(frame.Data)
+	if err := f.PackFlags(id, data, flags); err != nil {
+		p.t.Fatalf("failed to pack DATA: %v", err)
+	}
+	p.write(f)
+}
+
+// writeSyn opens a stream that the session may reject by closing the
+// transport before the whole frame is written
+func (p *peer) writeSyn(id frame.StreamId) {
+	p.t.Helper()
+	f := new(frame.Data)
+	if err := f.PackFlags(id, []byte{0}, frame.FlagDataSyn); err != nil {
+		p.t.Fatalf("failed to pack DATA: %v", err)
+	}
+	p.writeFrame(f)
+}
+
+// read returns the next frame read within timeout. A nil frame and nil error
+// mean that the timeout expired.
+func (p *peer) read(timeout time.Duration) (frame.Frame, error) {
+	select {
+	case f, ok := <-p.frames:
+		if !ok {
+			return nil, p.readErr
+		}
+		return f, nil
+	case <-time.After(timeout):
+		return nil, nil
+	}
+}
+
+func (p *peer) expect(ftype frame.Type) frame.Frame {
+	p.t.Helper()
+	f, err := p.read(p.timeout)
+	switch {
+	case err != nil:
+		p.t.Fatalf("failed to read %s frame: %v", ftype, err)
+	case f == nil:
+		p.t.Fatalf("timed out waiting for %s frame", ftype)
+	case f.Type() != ftype:
+		p.t.Fatalf("read %s frame of stream 0x%x, expected %s", f.Type(), f.StreamId(), ftype)
+	}
+	return f
+}
+
+// expectData reads a DATA frame and its payload
+func (p *peer) expectData() (*frame.Data, []byte) {
+	p.t.Helper()
+	f := p.expect(frame.TypeData).(*frame.Data)
+	data, err := ioutil.ReadAll(f.Reader())
+	if err != nil {
+		p.t.Fatalf("failed to read DATA payload: %v", err)
+	}
+	return f, data
+}
+
+func (p *peer) expectRst(id frame.StreamId, code muxado.ErrorCode) {
+	p.t.Helper()
+	f := p.expect(frame.TypeRst).(*frame.Rst)
+	if f.StreamId() != id || muxado.ErrorCode(f.ErrorCode()) != code {
+		p.t.Fatalf("read RST of stream 0x%x with code %v, expected stream 0x%x with code %v",
+			f.StreamId(), muxado.ErrorCode(f.ErrorCode()), id, code)
+	}
+}
+
+func (p *peer) expectGoAway(code muxado.ErrorCode) {
+	p.t.Helper()
+	f := p.expect(frame.TypeGoAway).(*frame.GoAway)
+	if got := muxado.ErrorCode(f.ErrorCode()); got != code {
+		p.t.Fatalf("read GOAWAY with code %v, expected %v", got, code)
+	}
+}
+
+// expectQuiet fails the test if the session sends a frame soon
+func (p *peer) expectQuiet() {
+	p.t.Helper()
+	f, err := p.read(quietPeriod)
+	switch {
+	case err != nil:
+		p.t.Fatalf("failed to read: %v", err)
+	case f != nil:
+		p.t.Fatalf("read unexpected %s frame of stream 0x%x", f.Type(), f.StreamId())
+	}
+}
+
+// expectClosed fails the test unless the session closes the transport
+func (p *peer) expectClosed() {
+	p.t.Helper()
+	f, err := p.read(p.timeout)
+	switch {
+	case err == io.EOF || err == io.ErrClosedPipe:
+	case err != nil:
+		p.t.Fatalf("failed to read: %v", err)
+	case f == nil:
+		p.t.Fatalf("timed out waiting for the session to close the transport")
+	default:
+		p.t.Fatalf("read unexpected %s frame after the session should have closed", f.Type())
+	}
+}
+
+// sync waits until the session has handled every frame written so far by
+// sending a PING and waiting for its acknowledgement
+func (p *peer) sync() {
+	p.t.Helper()
+	const data = 0x636f6e666f726d // "conform"
+	ping := new(frame.Ping)
+	if err := ping.Pack(data, false); err != nil {
+		p.t.Fatalf("failed to pack PING: %v", err)
+	}
+	p.write(ping)
+	timeout := time.After(p.timeout)
+	for {
+		select {
+		case got := <-p.acks:
+			if got == data {
+				return
+			}
+		case <-timeout:
+			p.t.Fatalf("timed out waiting for PING acknowledgement")
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package conformance
+
+import (
+	"net"
+	"testing"
+
+	"github.com/inconshreveable/muxado"
+)
+
+func TestMuxado(t *testing.T) {
+	Run(t, Implementation{
+		Client: func(c net.Conn) muxado.Session { return muxado.Client(c, nil) },
+		Server: func(c net.Conn) muxado.Session { return muxado.Server(c, nil) },
+	})
+}
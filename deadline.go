@@ -0,0 +1,88 @@
+package muxado
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is a settable point in time that blocking operations can select on
+// via the channel returned by wait, which is closed once the deadline passes.
+type deadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired chan struct{} // closed when the deadline passes
+}
+
+func newDeadline() *deadline {
+	return &deadline{expired: make(chan struct{})}
+}
+
+// set changes the deadline. The zero time means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired, wait for it to close the channel
+		<-d.expired
+	}
+	d.timer = nil
+
+	// a new deadline gets a fresh channel if the old one already expired
+	if isClosed(d.expired) {
+		d.expired = make(chan struct{})
+	}
+
+	if t.IsZero() {
+		return
+	}
+	if delay := time.Until(t); delay > 0 {
+		expired := d.expired
+		d.timer = time.AfterFunc(delay, func() {
+			close(expired)
+		})
+	} else {
+		close(d.expired)
+	}
+}
+
+// wait returns a channel that is closed when the deadline passes
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// timers reused by writes with deadlines, so that each write does not leave
+// a timer behind until its deadline passes
+var timerPool sync.Pool
+
+// getTimer returns a timer that fires at t
+func getTimer(t time.Time) *time.Timer {
+	if timer, ok := timerPool.Get().(*time.Timer); ok {
+		timer.Reset(time.Until(t))
+		return timer
+	}
+	return time.NewTimer(time.Until(t))
+}
+
+// putTimer stops timer and returns it to the pool
+func putTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		// drain a value the caller did not receive
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timerPool.Put(timer)
+}
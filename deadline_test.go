@@ -0,0 +1,60 @@
+package muxado
+
+import (
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// Test that pooled timers fire at their new deadline and not with a value
+// left over from their previous one
+func TestTimerPool(t *testing.T) {
+	t.Parallel()
+	timer := getTimer(time.Now())
+	time.Sleep(10 * time.Millisecond)
+	putTimer(timer)
+
+	timer = getTimer(time.Now().Add(time.Hour))
+	defer putTimer(timer)
+	select {
+	case <-timer.C:
+		t.Fatalf("Pooled timer fired before its deadline")
+	case <-time.After(20 * time.Millisecond):
+	}
+	timer.Reset(time.Millisecond)
+	select {
+	case <-timer.C:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Pooled timer did not fire")
+	}
+}
+
+// BenchmarkWriteFrameDeadline measures the allocations of writes with
+// deadlines
+func BenchmarkWriteFrameDeadline(b *testing.B) {
+	for _, direct := range []bool{false, true} {
+		name := "Writer"
+		if direct {
+			name = "DirectWrites"
+		}
+		b.Run(name, func(b *testing.B) {
+			client, server := newSessionPair(&Config{DirectWrites: direct}, nil)
+			defer client.Close()
+			defer server.Close()
+			sess := client.(*session)
+			// the server discards application frames
+			f := new(frame.User)
+			if err := f.Pack(frame.TypeUserMin, 0, 0, nil); err != nil {
+				b.Fatalf("Failed to pack frame: %v", err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := sess.writeFrame(f, time.Now().Add(time.Minute)); err != nil {
+					b.Fatalf("Failed to write frame: %v", err)
+				}
+			}
+		})
+	}
+}
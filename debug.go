@@ -0,0 +1,114 @@
+package muxado
+
+import (
+	"encoding/json"
+	"expvar"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// debugSession is the state of a session rendered by DebugHandler
+type debugSession struct {
+	LocalAddr  string            `json:"local_addr"`
+	RemoteAddr string            `json:"remote_addr"`
+	State      string            `json:"state"`
+	Err        string            `json:"err,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Streams    []debugStream     `json:"streams"`
+}
+
+type debugStream struct {
+	Id           uint32     `json:"id"`
+	Type         StreamType `json:"type"`
+	Label        string     `json:"label,omitempty"`
+	Local        bool       `json:"local"`
+	Age          string     `json:"age"`
+	BytesRead    uint64     `json:"bytes_read"`
+	BytesWritten uint64     `json:"bytes_written"`
+	SendWindow   int        `json:"send_window"`
+	Buffered     int        `json:"buffered"`
+}
+
+func debugState(sessions []Session) []debugSession {
+	state := make([]debugSession, 0, len(sessions))
+	for _, sess := range sessions {
+		ds := debugSession{State: sess.State().String(), Tags: sess.Tags(), Streams: []debugStream{}}
+		if a := sess.LocalAddr(); a != nil {
+			ds.LocalAddr = a.String()
+		}
+		if a := sess.RemoteAddr(); a != nil {
+			ds.RemoteAddr = a.String()
+		}
+		if err := sess.Err(); err != nil {
+			ds.Err = err.Error()
+		}
+		for _, info := range sess.Streams() {
+			ds.Streams = append(ds.Streams, debugStream{
+				Id:           info.Id,
+				Type:         info.Type,
+				Label:        info.Label,
+				Local:        info.Local,
+				Age:          info.Age.Round(time.Millisecond).String(),
+				BytesRead:    info.BytesRead,
+				BytesWritten: info.BytesWritten,
+				SendWindow:   info.SendWindow,
+				Buffered:     info.Buffered,
+			})
+		}
+		state = append(state, ds)
+	}
+	return state
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>muxado sessions</title></head>
+<body>
+{{range .}}
+<h2>{{.LocalAddr}} &harr; {{.RemoteAddr}} ({{.State}})</h2>
+{{if .Tags}}<p>{{range $k, $v := .Tags}}{{$k}}={{$v}} {{end}}</p>{{end}}
+{{if .Err}}<p>closed: {{.Err}}</p>{{end}}
+<table border="1" cellpadding="4">
+<tr><th>id</th><th>type</th><th>label</th><th>opened by</th><th>age</th><th>read</th><th>written</th><th>send window</th><th>buffered</th></tr>
+{{range .Streams}}
+<tr><td>0x{{printf "%x" .Id}}</td><td>{{.Type}}</td><td>{{.Label}}</td><td>{{if .Local}}local{{else}}remote{{end}}</td><td>{{.Age}}</td><td>{{.BytesRead}}</td><td>{{.BytesWritten}}</td><td>{{.SendWindow}}</td><td>{{.Buffered}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>no sessions</p>
+{{end}}
+</body>
+</html>
+`))
+
+// DebugHandler returns an http.Handler that renders the state of the given
+// sessions and their streams, similar to /debug/requests. It serves HTML to
+// browsers and JSON when the request's Accept header or its format query
+// parameter asks for it. Mount it on an operations port:
+//
+//	http.Handle("/debug/muxado", muxado.DebugHandler(sess))
+func DebugHandler(sessions ...Session) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := debugState(sessions)
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(state)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		debugTemplate.Execute(w, state)
+	})
+}
+
+// ExpvarFunc returns an expvar.Func reporting the state of the given sessions
+// in the same form as DebugHandler's JSON, e.g. to publish it on
+// /debug/vars:
+//
+//	expvar.Publish("muxado", muxado.ExpvarFunc(sess))
+func ExpvarFunc(sessions ...Session) expvar.Func {
+	return func() interface{} {
+		return debugState(sessions)
+	}
+}
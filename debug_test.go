@@ -0,0 +1,50 @@
+package muxado
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream(WithLabel("db-sync"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hello"))
+
+	h := DebugHandler(client)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/muxado?format=json", nil))
+	var state []debugSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("Failed to decode JSON: %v, %s", err, rec.Body)
+	}
+	if len(state) != 1 || len(state[0].Streams) != 1 {
+		t.Fatalf("Wrong debug state: %+v", state)
+	}
+	if s := state[0].Streams[0]; s.Id != str.Id() || s.Label != "db-sync" || s.BytesWritten != 5 {
+		t.Fatalf("Wrong stream state: %+v", s)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/muxado", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Wrong content type: %s", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "db-sync") {
+		t.Fatalf("HTML does not contain the stream's label: %s", rec.Body)
+	}
+
+	var v expvar.Var = ExpvarFunc(client)
+	if !strings.Contains(v.String(), `"label":"db-sync"`) {
+		t.Fatalf("expvar does not contain the stream's label: %s", v.String())
+	}
+}
@@ -0,0 +1,89 @@
+package muxado
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// writeFrameDirect writes f from the calling goroutine under the write lock,
+// see Config.DirectWrites. Writers that leave the flush to the writers
+// waiting behind them ask the writer goroutine to flush in case those give
+// up waiting.
+func (s *session) writeFrameDirect(ctx context.Context, f frame.Frame, dl time.Time) error {
+	var timeout <-chan time.Time
+	if !dl.IsZero() {
+		t := getTimer(dl)
+		defer putTimer(t)
+		timeout = t.C
+	}
+	atomic.AddInt32(&s.writers, 1)
+	select {
+	case s.writeLock <- struct{}{}:
+	case <-s.dead:
+		atomic.AddInt32(&s.writers, -1)
+		return ErrSessionClosed
+	case <-timeout:
+		atomic.AddInt32(&s.writers, -1)
+		return ErrWriteTimeout
+	case <-ctx.Done():
+		atomic.AddInt32(&s.writers, -1)
+		return ctx.Err()
+	}
+	if isClosed(s.dead) {
+		atomic.AddInt32(&s.writers, -1)
+		s.unlockWrites()
+		return ErrSessionClosed
+	}
+
+	err := s.pacedWriteFrame(f)
+	if atomic.AddInt32(&s.writers, -1) > 0 {
+		s.requestFlush()
+	} else if err == nil {
+		err = s.wbuf.Flush()
+	}
+	s.unlockWrites()
+	if err != nil {
+		// any write error kills the session
+		s.die(err)
+	}
+	return err
+}
+
+// requestFlush asks the writer goroutine, or the write loop, to flush the
+// write buffer
+func (s *session) requestFlush() {
+	if s.loop != nil {
+		s.queueFrame(nil)
+		return
+	}
+	select {
+	case s.flushes <- struct{}{}:
+	default:
+	}
+}
+
+// flushDirect flushes the frames left in the write buffer by direct writers
+func (s *session) flushDirect() {
+	s.lockWrites()
+	err := s.wbuf.Flush()
+	s.unlockWrites()
+	if err != nil {
+		s.die(err)
+	}
+}
+
+// lockWrites takes the write lock if the session writes frames directly
+func (s *session) lockWrites() {
+	if s.writeLock != nil {
+		s.writeLock <- struct{}{}
+	}
+}
+
+func (s *session) unlockWrites() {
+	if s.writeLock != nil {
+		<-s.writeLock
+	}
+}
@@ -0,0 +1,105 @@
+package muxado
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that concurrent streams of sessions writing frames directly deliver
+// all of their data
+func TestDirectWrites(t *testing.T) {
+	t.Parallel()
+	config := &Config{DirectWrites: true}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		for {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(str, str)
+				str.Close()
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			str, err := client.OpenStream()
+			if err != nil {
+				t.Errorf("Failed to open stream: %v", err)
+				return
+			}
+			msg := bytes.Repeat([]byte(fmt.Sprintf("stream %d ", i)), 0x4000)
+			go func() {
+				for p := msg; len(p) > 0; p = p[min(len(p), 1000):] {
+					str.Write(p[:min(len(p), 1000)])
+				}
+				str.CloseWrite()
+			}()
+			str.SetReadDeadline(time.Now().Add(10 * time.Second))
+			if got, err := ioutil.ReadAll(str); err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("Stream %d echoed %d bytes, %v, expected %d", i, len(got), err, len(msg))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	client.Close()
+	if _, err := client.OpenStreamWithData([]byte("late")); err != ErrSessionClosed {
+		t.Fatalf("Wrote on closed session: %v", err)
+	}
+}
+
+// BenchmarkWriteContention measures the latency of small writes by many
+// goroutines with and without DirectWrites
+func BenchmarkWriteContention(b *testing.B) {
+	for _, direct := range []bool{false, true} {
+		b.Run(fmt.Sprintf("DirectWrites=%v", direct), func(b *testing.B) {
+			config := &Config{DirectWrites: direct}
+			client, server := newSessionPair(config, config)
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				for {
+					str, err := server.AcceptStream()
+					if err != nil {
+						return
+					}
+					go io.Copy(ioutil.Discard, str)
+				}
+			}()
+
+			p := make([]byte, 64)
+			b.SetBytes(int64(len(p)))
+			b.ReportAllocs()
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				str, err := client.OpenStream()
+				if err != nil {
+					b.Errorf("Failed to open stream: %v", err)
+					return
+				}
+				defer str.Close()
+				for pb.Next() {
+					if _, err := str.Write(p); err != nil {
+						b.Errorf("Failed to write: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
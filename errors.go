@@ -1,8 +1,12 @@
 package muxado
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"os"
 
-import "github.com/inconshreveable/muxado/frame"
+	"github.com/inconshreveable/muxado/frame"
+)
 
 // ErrorCode is a 32-bit integer indicating the type of an error condition
 type ErrorCode uint32
@@ -24,54 +28,195 @@ const (
 	WriteTimeout
 	SessionClosed
 	PeerEOF
+	RefusedLimit
+	ReadIdleTimeout
+	ReadTimeout
+	AcceptTimeout
+	OpenTimeout
+	StreamIdleTimeout
+	VersionMismatch
+	CircuitOpen
+	Unauthenticated
+	PermissionDenied
+	FlowControlTimeout
+	HandshakeTimeout
+	WriteQueueFull
 
 	ErrorUnknown ErrorCode = 0xFF
 )
 
 var (
-	remoteGoneAway      = newErr(RemoteGoneAway, errors.New("remote gone away"))
-	streamsExhausted    = newErr(StreamsExhausted, errors.New("streams exhuastated"))
-	streamClosed        = newErr(StreamClosed, errors.New("stream closed"))
-	writeTimeout        = newErr(WriteTimeout, errors.New("write timed out"))
-	flowControlViolated = newErr(FlowControlError, errors.New("flow control violated"))
-	sessionClosed       = newErr(SessionClosed, errors.New("session closed"))
-	eofPeer             = newErr(PeerEOF, errors.New("read EOF from remote peer"))
+	ErrRemoteGoneAway       = newErr(RemoteGoneAway, errors.New("remote gone away"))
+	ErrStreamsExhausted     = newErr(StreamsExhausted, errors.New("streams exhuastated"))
+	ErrStreamClosed         = newErr(StreamClosed, errors.New("stream closed"))
+	ErrWriteTimeout         = newErr(WriteTimeout, deadlineError("write timed out"))
+	ErrFlowControlViolated  = newErr(FlowControlError, errors.New("flow control violated"))
+	ErrSessionClosed        = newErr(SessionClosed, errors.New("session closed"))
+	ErrPeerEOF              = newErr(PeerEOF, errors.New("read EOF from remote peer"))
+	ErrStreamsLimited       = newErr(RefusedLimit, errors.New("maximum concurrent streams reached"))
+	ErrBufferBudgetExceeded = newErr(EnhanceYourCalm, errors.New("session buffer budget exceeded"))
+	ErrAcceptQueueFull      = newErr(AcceptQueueFull, errors.New("accept queue full"))
+	ErrReadIdleTimeout      = newErr(ReadIdleTimeout, deadlineError("no frames received from remote peer within read idle timeout"))
+	ErrReadTimeout          = newErr(ReadTimeout, deadlineError("read timed out"))
+	ErrAcceptTimeout        = newErr(AcceptTimeout, deadlineError("accept timed out"))
+	ErrOpenTimeout          = newErr(OpenTimeout, deadlineError("open timed out"))
+	ErrStreamIdleTimeout    = newErr(StreamIdleTimeout, deadlineError("no data sent or received on stream within idle timeout"))
+	ErrFlowControlTimeout   = newErr(FlowControlTimeout, deadlineError("stream's receive window stayed full for longer than the slow consumer timeout"))
+	ErrHandshakeTimeout     = newErr(HandshakeTimeout, deadlineError("no valid frame received from remote peer within handshake timeout"))
+	ErrWriteQueueFull       = newErr(WriteQueueFull, errors.New("write queue full"))
+	ErrUnidirectional       = newErr(StreamClosed, errors.New("stream carries data from the remote side only"))
+
+	ErrUnidirectionalUnsupported = errors.New("remote side does not support unidirectional streams")
 )
 
+var errorCodeNames = map[ErrorCode]string{
+	NoError:            "NO_ERROR",
+	ProtocolError:      "PROTOCOL_ERROR",
+	InternalError:      "INTERNAL_ERROR",
+	FlowControlError:   "FLOW_CONTROL_ERROR",
+	StreamClosed:       "STREAM_CLOSED",
+	StreamRefused:      "STREAM_REFUSED",
+	StreamCancelled:    "STREAM_CANCELLED",
+	StreamReset:        "STREAM_RESET",
+	FrameSizeError:     "FRAME_SIZE_ERROR",
+	AcceptQueueFull:    "ACCEPT_QUEUE_FULL",
+	EnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	RemoteGoneAway:     "REMOTE_GONE_AWAY",
+	StreamsExhausted:   "STREAMS_EXHAUSTED",
+	WriteTimeout:       "WRITE_TIMEOUT",
+	SessionClosed:      "SESSION_CLOSED",
+	PeerEOF:            "PEER_EOF",
+	RefusedLimit:       "REFUSED_LIMIT",
+	ReadIdleTimeout:    "READ_IDLE_TIMEOUT",
+	ReadTimeout:        "READ_TIMEOUT",
+	AcceptTimeout:      "ACCEPT_TIMEOUT",
+	OpenTimeout:        "OPEN_TIMEOUT",
+	StreamIdleTimeout:  "STREAM_IDLE_TIMEOUT",
+	VersionMismatch:    "VERSION_MISMATCH",
+	CircuitOpen:        "CIRCUIT_OPEN",
+	Unauthenticated:    "UNAUTHENTICATED",
+	PermissionDenied:   "PERMISSION_DENIED",
+	FlowControlTimeout: "FLOW_CONTROL_TIMEOUT",
+	HandshakeTimeout:   "HANDSHAKE_TIMEOUT",
+	WriteQueueFull:     "WRITE_QUEUE_FULL",
+	ErrorUnknown:       "UNKNOWN",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ERROR_CODE(0x%x)", uint32(c))
+}
+
+// Error implements the error interface so that an ErrorCode can be used as the
+// target of errors.Is. Any muxado error carrying the same code matches:
+//
+//	if errors.Is(err, muxado.StreamRefused) { ... }
+func (c ErrorCode) Error() string {
+	return c.String()
+}
+
 func fromFrameError(err error) error {
-	if e, ok := err.(*frame.Error); ok {
+	var e *frame.Error
+	if errors.As(err, &e) {
 		switch e.Type() {
 		case frame.ErrorFrameSize:
-			return &muxadoError{FrameSizeError, err}
+			return &Error{FrameSizeError, err}
 		case frame.ErrorProtocol, frame.ErrorProtocolStream:
-			return &muxadoError{ProtocolError, err}
+			return &Error{ProtocolError, err}
 		}
 	}
 	return err
 }
 
-type muxadoError struct {
-	ErrorCode
-	error
+// Error is the type of the errors returned by muxado. It pairs an ErrorCode
+// with the underlying error, which is available via errors.Unwrap.
+type Error struct {
+	Code ErrorCode
+	Err  error
 }
 
-func (e *muxadoError) Error() string {
-	if e.error != nil {
-		return e.error.Error()
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
 	}
 	return "<nil>"
 }
 
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the error is the result of a deadline or timeout
+// expiring. Together with Temporary it implements net.Error so that muxado's
+// timeouts are handled like those of any other net.Conn.
+func (e *Error) Timeout() bool {
+	return errors.Is(e.Err, os.ErrDeadlineExceeded)
+}
+
+// Temporary reports whether the operation may succeed if retried. Only
+// timeouts are temporary.
+func (e *Error) Temporary() bool {
+	return e.Timeout()
+}
+
+// Is reports whether target is the ErrorCode of e
+func (e *Error) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.Code
+}
+
 func newErr(code ErrorCode, err error) error {
-	return &muxadoError{code, err}
+	return &Error{code, err}
+}
+
+// deadlineError is the underlying error of muxado's timeouts. It unwraps to
+// os.ErrDeadlineExceeded so that errors.Is matches it like the timeouts of
+// the standard library.
+type deadlineError string
+
+func (e deadlineError) Error() string {
+	return string(e)
+}
+
+func (e deadlineError) Unwrap() error {
+	return os.ErrDeadlineExceeded
 }
 
+// StreamResetError is returned by a Stream's Read and Write methods after the
+// remote side resets the stream.
+type StreamResetError struct {
+	Code  ErrorCode // the error code sent by the remote side
+	Debug []byte    // optional debug data sent by the remote side
+}
+
+func (e *StreamResetError) Error() string {
+	if len(e.Debug) > 0 {
+		return fmt.Sprintf("stream reset by peer with remote error code %d: %s", e.Code, e.Debug)
+	}
+	return fmt.Sprintf("stream reset by peer with remote error code %d", e.Code)
+}
+
+// Is reports whether target is the ErrorCode of e
+func (e *StreamResetError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.Code
+}
+
+// GetError returns the ErrorCode of err and the error it describes. Errors
+// that are not muxado errors, even after unwrapping, have the code ErrorUnknown.
 func GetError(err error) (ErrorCode, error) {
 	if err == nil {
 		return NoError, nil
 	}
-	if e, ok := err.(*muxadoError); ok {
-		return e.ErrorCode, e.error
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code, e.Err
+	}
+	var resetErr *StreamResetError
+	if errors.As(err, &resetErr) {
+		return resetErr.Code, resetErr
 	}
 	return ErrorUnknown, err
 }
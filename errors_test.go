@@ -0,0 +1,51 @@
+package muxado
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func TestErrorsIs(t *testing.T) {
+	t.Parallel()
+	wrapped := fmt.Errorf("opening stream: %w", ErrSessionClosed)
+	if !errors.Is(wrapped, ErrSessionClosed) {
+		t.Errorf("Wrapped sentinel does not match itself")
+	}
+	if !errors.Is(wrapped, SessionClosed) {
+		t.Errorf("Wrapped sentinel does not match its error code")
+	}
+	if errors.Is(wrapped, StreamClosed) {
+		t.Errorf("Wrapped sentinel matches the wrong error code")
+	}
+	if code, _ := GetError(wrapped); code != SessionClosed {
+		t.Errorf("Wrong code for wrapped error. Got %v, expected %v", code, SessionClosed)
+	}
+
+	resetErr := fmt.Errorf("reading: %w", &StreamResetError{Code: StreamRefused})
+	if !errors.Is(resetErr, StreamRefused) {
+		t.Errorf("Reset error does not match its error code")
+	}
+	var target *StreamResetError
+	if !errors.As(resetErr, &target) || target.Code != StreamRefused {
+		t.Errorf("Failed to extract *StreamResetError from wrapped error")
+	}
+}
+
+func TestFrameErrorsAs(t *testing.T) {
+	t.Parallel()
+	// a DATA frame with a zero stream id fails to parse with a protocol error
+	rd := bytes.NewReader([]byte{0, 0, 0, byte(frame.TypeData << 4), 0, 0, 0, 0})
+	_, err := frame.NewFramer(rd, nil).ReadFrame()
+	err = fromFrameError(err)
+	if !errors.Is(err, ProtocolError) {
+		t.Fatalf("Expected protocol error, got: %v", err)
+	}
+	var frameErr *frame.Error
+	if !errors.As(err, &frameErr) || frameErr.Type() != frame.ErrorProtocol {
+		t.Fatalf("Failed to extract *frame.Error from: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Package heartbeat measures the latency of a muxado session and closes it
+// when the remote side stops responding.
+//
+// Each side of the session that wraps it with New responds to heartbeats sent
+// over a dedicated typed stream. Calling Start sends a timestamp over that
+// stream every Interval. The remote side echoes it back so that the
+// round-trip latency can be reported to a callback. If MaxMissed consecutive
+// heartbeats go unanswered for longer than Tolerance, the session is closed.
+package heartbeat
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+const (
+	defaultInterval                     = 10 * time.Second
+	defaultTolerance                    = 15 * time.Second
+	defaultMaxMissed                    = 3
+	defaultStreamType muxado.StreamType = 0xFFFFFFFF
+)
+
+// Config configures a Heartbeat
+type Config struct {
+	// How often to send a heartbeat. Default 10s.
+	Interval time.Duration
+	// How long to wait for the response to a heartbeat before it is missed. Default 15s.
+	Tolerance time.Duration
+	// Number of consecutive missed heartbeats after which the session is closed. Default 3.
+	MaxMissed int
+	// Type of the stream heartbeats are exchanged over. Default 0xFFFFFFFF.
+	Type muxado.StreamType
+	// Called with the round-trip latency of each answered heartbeat. Default nil.
+	OnLatency func(time.Duration)
+	// Called with the number of consecutive missed heartbeats each time one is missed. Default nil.
+	OnMissed func(int)
+}
+
+func (c *Config) initDefaults() {
+	if c.Interval == 0 {
+		c.Interval = defaultInterval
+	}
+	if c.Tolerance == 0 {
+		c.Tolerance = defaultTolerance
+	}
+	if c.MaxMissed == 0 {
+		c.MaxMissed = defaultMaxMissed
+	}
+	if c.Type == 0 {
+		c.Type = defaultStreamType
+	}
+}
+
+// Heartbeat wraps a muxado.TypedStreamSession, answering the remote side's
+// heartbeats and hiding the heartbeat stream from Accept.
+type Heartbeat struct {
+	muxado.TypedStreamSession
+	config    Config
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a Heartbeat for sess configured by config, which may be nil
+func New(sess muxado.TypedStreamSession, config *Config) *Heartbeat {
+	var c Config
+	if config != nil {
+		c = *config
+	}
+	c.initDefaults()
+	return &Heartbeat{
+		TypedStreamSession: sess,
+		config:             c,
+		closed:             make(chan struct{}),
+	}
+}
+
+func (h *Heartbeat) Accept() (net.Conn, error) {
+	return h.AcceptTypedStream()
+}
+
+func (h *Heartbeat) AcceptStream() (muxado.Stream, error) {
+	return h.AcceptTypedStream()
+}
+
+// AcceptTypedStream returns the next stream opened by the remote side,
+// answering heartbeat streams itself
+func (h *Heartbeat) AcceptTypedStream() (muxado.TypedStream, error) {
+	for {
+		str, err := h.TypedStreamSession.AcceptTypedStream()
+		if err != nil {
+			return nil, err
+		}
+		if str.StreamType() != h.config.Type {
+			return str, nil
+		}
+		go respond(str)
+	}
+}
+
+func (h *Heartbeat) Close() error {
+	h.stop()
+	return h.TypedStreamSession.Close()
+}
+
+func (h *Heartbeat) stop() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+// Start sends heartbeats to the remote side until the session is closed
+func (h *Heartbeat) Start() {
+	go h.requester()
+}
+
+func (h *Heartbeat) requester() {
+	str, err := h.OpenTypedStream(h.config.Type)
+	if err != nil {
+		return
+	}
+	defer str.Close()
+
+	// read the echoed timestamps
+	echoes := make(chan int64)
+	go func() {
+		var b [8]byte
+		for {
+			if _, err := io.ReadFull(str, b[:]); err != nil {
+				return
+			}
+			select {
+			case echoes <- int64(binary.BigEndian.Uint64(b[:])):
+			case <-h.closed:
+				return
+			}
+		}
+	}()
+
+	missed := 0
+	interval := time.NewTimer(h.config.Interval)
+	defer interval.Stop()
+	for {
+		select {
+		case <-interval.C:
+		case <-h.closed:
+			return
+		case <-h.Done():
+			return
+		}
+
+		sent := time.Now().UnixNano()
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(sent))
+		if _, err := str.Write(b[:]); err != nil {
+			return
+		}
+
+		if h.await(echoes, sent) {
+			missed = 0
+		} else {
+			missed++
+			if h.config.OnMissed != nil {
+				h.config.OnMissed(missed)
+			}
+			if missed >= h.config.MaxMissed {
+				h.stop()
+				h.CloseWithError(muxado.ReadIdleTimeout, []byte("heartbeat timeout"))
+				return
+			}
+		}
+		interval.Reset(h.config.Interval)
+	}
+}
+
+// await waits for the echo of the heartbeat sent at the given time and
+// reports its latency. It returns false if the heartbeat was missed.
+func (h *Heartbeat) await(echoes chan int64, sent int64) bool {
+	timeout := time.NewTimer(h.config.Tolerance)
+	defer timeout.Stop()
+	for {
+		select {
+		case echo := <-echoes:
+			// ignore late echoes of heartbeats that were already missed
+			if echo != sent {
+				continue
+			}
+			if h.config.OnLatency != nil {
+				h.config.OnLatency(time.Since(time.Unix(0, sent)))
+			}
+			return true
+		case <-timeout.C:
+			return false
+		case <-h.closed:
+			return true
+		}
+	}
+}
+
+// respond echoes the remote side's heartbeats
+func respond(str muxado.Stream) {
+	defer str.Close()
+	var b [8]byte
+	for {
+		if _, err := io.ReadFull(str, b[:]); err != nil {
+			return
+		}
+		if _, err := str.Write(b[:]); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package heartbeat
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+func newPair() (client, server muxado.TypedStreamSession) {
+	local, remote := net.Pipe()
+	client = muxado.NewTypedStreamSession(muxado.Client(local, nil))
+	server = muxado.NewTypedStreamSession(muxado.Server(remote, nil))
+	return
+}
+
+func TestLatency(t *testing.T) {
+	t.Parallel()
+	client, server := newPair()
+	defer client.Close()
+
+	latencies := make(chan time.Duration, 10)
+	hbClient := New(client, &Config{
+		Interval:  10 * time.Millisecond,
+		OnLatency: func(d time.Duration) { latencies <- d },
+	})
+	hbServer := New(server, nil)
+	defer hbServer.Close()
+	go hbServer.Accept()
+	hbClient.Start()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case d := <-latencies:
+			if d <= 0 {
+				t.Fatalf("Invalid latency: %v", d)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for heartbeat latency")
+		}
+	}
+}
+
+func TestMissedHeartbeats(t *testing.T) {
+	t.Parallel()
+	client, server := newPair()
+	defer server.Close()
+
+	// the server accepts the heartbeat stream but never answers
+	go server.AcceptTypedStream()
+
+	missed := make(chan int, 10)
+	hb := New(client, &Config{
+		Interval:  5 * time.Millisecond,
+		Tolerance: 10 * time.Millisecond,
+		MaxMissed: 2,
+		OnMissed:  func(n int) { missed <- n },
+	})
+	hb.Start()
+
+	select {
+	case <-hb.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Session not closed after missed heartbeats")
+	}
+	if n := len(missed); n != 2 {
+		t.Fatalf("Wrong number of missed heartbeats. Got %d, expected 2", n)
+	}
+	if code, _ := muxado.GetError(hb.Err()); code != muxado.ReadIdleTimeout {
+		t.Fatalf("Wrong session error. Got %v, expected %v", hb.Err(), muxado.ReadIdleTimeout)
+	}
+}
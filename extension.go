@@ -0,0 +1,111 @@
+package muxado
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// An Extension layers a feature on top of a session, like heartbeats,
+// compression or authentication, without changing the core protocol.
+// Extensions exchange data with their counterpart on the remote side in
+// EXTENSION frames.
+//
+// Extensions are registered with Config.Extensions. Each side advertises the
+// ids of its extensions via SETTINGS and an extension is only started once the
+// remote side has advertised the same id.
+type Extension interface {
+	// ExtensionId uniquely identifies the extension. It must be less than
+	// frame.MaxExtensionId, which is reserved for authentication, see
+	// Config.Verifier.
+	ExtensionId() uint16
+
+	// Start is called once the remote side has advertised the extension. The
+	// host is used to send frames to the remote side's extension. Start is
+	// called by the session's reader and must not block.
+	Start(ExtensionHost)
+
+	// HandleFrame is called with the data of each frame sent by the remote
+	// side's extension. The data is only valid until HandleFrame returns. It
+	// is called by the session's reader and must not block. Returning an
+	// error closes the session.
+	HandleFrame(streamId uint32, data []byte) error
+}
+
+// ExtensionHost is the session an extension is running on
+type ExtensionHost interface {
+	Session
+
+	// WriteExtensionFrame sends data to the remote side's extension. The
+	// stream id is opaque to the session.
+	WriteExtensionFrame(streamId uint32, data []byte) error
+}
+
+// extensionState tracks a registered extension
+type extensionState struct {
+	Extension
+	started uint32 // true once the remote side advertised the extension
+}
+
+type extensionHost struct {
+	*session
+	id uint16
+}
+
+func (h *extensionHost) WriteExtensionFrame(streamId uint32, data []byte) error {
+	f := new(frame.Extension)
+	if err := f.Pack(h.id, frame.StreamId(streamId), data); err != nil {
+		return err
+	}
+	return h.session.writeFrame(f, zeroTime)
+}
+
+func (s *session) initExtensions(exts []Extension) {
+	if len(exts) == 0 {
+		return
+	}
+	s.extensions = make(map[uint16]*extensionState, len(exts))
+	for _, ext := range exts {
+		if ext.ExtensionId() >= authExtensionId {
+			panic(fmt.Sprintf("invalid extension id: 0x%x", ext.ExtensionId()))
+		}
+		s.extensions[ext.ExtensionId()] = &extensionState{Extension: ext}
+	}
+}
+
+// extensionSettings advertises the registered extensions
+func (s *session) extensionSettings() (settings []frame.Setting) {
+	for id := range s.extensions {
+		settings = append(settings, frame.Setting{Id: frame.SettingExtensionBase | frame.SettingId(id), Value: 1})
+	}
+	return
+}
+
+// startExtension starts the extension advertised by the remote side if it
+// is registered
+func (s *session) startExtension(id uint16) {
+	ext, ok := s.extensions[id]
+	if ok && atomic.CompareAndSwapUint32(&ext.started, 0, 1) {
+		ext.Start(&extensionHost{s, id})
+	}
+}
+
+func (s *session) handleExtension(f *frame.Extension) error {
+	ext, ok := s.extensions[f.ExtensionId()]
+	if !ok || atomic.LoadUint32(&ext.started) == 0 {
+		// frames of extensions that aren't running are ignored
+		_, err := io.CopyN(ioutil.Discard, f.Reader(), int64(f.Length()))
+		return err
+	}
+	data, err := ioutil.ReadAll(f.Reader())
+	if err != nil {
+		return err
+	}
+	if err := ext.HandleFrame(uint32(f.StreamId()), data); err != nil {
+		return newErr(ProtocolError, fmt.Errorf("extension 0x%x failed to handle frame: %v", f.ExtensionId(), err))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package muxado
+
+import (
+	"testing"
+	"time"
+)
+
+// echoExtension replies to every frame that doesn't start with "re:" and
+// reports the replies it receives
+type echoExtension struct {
+	host    ExtensionHost
+	started chan struct{} // closed once host is set
+	replies chan string
+}
+
+func newEchoExtension() *echoExtension {
+	return &echoExtension{
+		started: make(chan struct{}),
+		replies: make(chan string, 1),
+	}
+}
+
+func (e *echoExtension) ExtensionId() uint16 { return 0x42 }
+
+func (e *echoExtension) Start(h ExtensionHost) {
+	e.host = h
+	close(e.started)
+}
+
+func (e *echoExtension) HandleFrame(streamId uint32, data []byte) error {
+	msg := string(data)
+	if len(msg) > 3 && msg[:3] == "re:" {
+		e.replies <- msg
+		return nil
+	}
+	go e.host.WriteExtensionFrame(streamId, []byte("re:"+msg))
+	return nil
+}
+
+func TestExtension(t *testing.T) {
+	t.Parallel()
+	clientExt, serverExt := newEchoExtension(), newEchoExtension()
+	client, server := newSessionPair(&Config{Extensions: []Extension{clientExt}}, &Config{Extensions: []Extension{serverExt}})
+	defer client.Close()
+	defer server.Close()
+
+	select {
+	case <-clientExt.started:
+	case <-time.After(time.Second):
+		t.Fatalf("Extension not started")
+	}
+	if err := clientExt.host.WriteExtensionFrame(7, []byte("hello")); err != nil {
+		t.Fatalf("Failed to write extension frame: %v", err)
+	}
+	select {
+	case reply := <-clientExt.replies:
+		if reply != "re:hello" {
+			t.Fatalf("Wrong reply. Got %q, expected %q", reply, "re:hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("No reply from remote extension")
+	}
+}
+
+// Test that an extension isn't started if the remote side doesn't run it
+func TestExtensionNotAdvertised(t *testing.T) {
+	t.Parallel()
+	ext := newEchoExtension()
+	client, server := newSessionPair(&Config{Extensions: []Extension{ext}}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// round-trip a stream so the server's settings would have arrived
+	str, err := server.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("x"))
+	if _, err := client.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	select {
+	case <-ext.started:
+		t.Fatalf("Extension started without the remote side advertising it")
+	default:
+	}
+}
@@ -0,0 +1,69 @@
+package muxado
+
+import (
+	"time"
+)
+
+// FlowEventType is the kind of a FlowEvent
+type FlowEventType int
+
+const (
+	// WindowStalled is emitted when a write to a stream blocks because the
+	// remote side's window for it is empty
+	WindowStalled FlowEventType = iota
+	// WindowResumed is emitted when a stalled write continues, or fails,
+	// with how long the window stayed empty
+	WindowResumed
+	// WindowUpdateSent is emitted when the session returns bytes read from a
+	// stream to the remote side's window
+	WindowUpdateSent
+	// WindowUpdateReceived is emitted when the remote side returns bytes to
+	// the window of a stream
+	WindowUpdateReceived
+	// SlowConsumer is emitted when the window of a stream stayed full for
+	// longer than Config.SlowConsumerTimeout, with how long it has been
+	// full, before the session's SlowConsumerPolicy is applied to it
+	SlowConsumer
+)
+
+func (t FlowEventType) String() string {
+	switch t {
+	case WindowStalled:
+		return "WINDOW_STALLED"
+	case WindowResumed:
+		return "WINDOW_RESUMED"
+	case WindowUpdateSent:
+		return "WINDOW_UPDATE_SENT"
+	case WindowUpdateReceived:
+		return "WINDOW_UPDATE_RECEIVED"
+	case SlowConsumer:
+		return "SLOW_CONSUMER"
+	}
+	return "UNKNOWN"
+}
+
+// FlowEvent describes a change in the flow control of a stream, see
+// Config.OnFlowEvent
+type FlowEvent struct {
+	Type      FlowEventType
+	StreamId  uint32
+	Increment uint32        // bytes returned to the window by window updates
+	Duration  time.Duration // how long the window stayed empty or full, for WindowResumed and SlowConsumer
+}
+
+// flowEvent passes ev to Config.OnFlowEvent
+func (s *session) flowEvent(ev FlowEvent) {
+	if s.config.OnFlowEvent != nil {
+		s.config.OnFlowEvent(ev)
+	}
+}
+
+// stalled is called by the stream's window when a write blocks on it
+func (s *stream) stalled() {
+	s.session.flowEvent(FlowEvent{Type: WindowStalled, StreamId: uint32(s.id)})
+}
+
+// resumed is called by the stream's window when a blocked write continues
+func (s *stream) resumed(d time.Duration) {
+	s.session.flowEvent(FlowEvent{Type: WindowResumed, StreamId: uint32(s.id), Duration: d})
+}
@@ -0,0 +1,74 @@
+package muxado
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flowRecorder records the flow events of a session
+type flowRecorder struct {
+	mu     sync.Mutex
+	events []FlowEvent
+}
+
+func (r *flowRecorder) record(ev FlowEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+func (r *flowRecorder) find(t FlowEventType) (FlowEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if ev.Type == t {
+			return ev, true
+		}
+	}
+	return FlowEvent{}, false
+}
+
+// Test that a writer that fills the window of a slow reader reports the
+// stall and its duration, and that both sides report the window updates
+func TestFlowEvents(t *testing.T) {
+	t.Parallel()
+	var clientEvents, serverEvents flowRecorder
+	client, server := newSessionPair(
+		&Config{MaxWindowSize: 4096, OnFlowEvent: clientEvents.record},
+		&Config{MaxWindowSize: 4096, OnFlowEvent: serverEvents.record},
+	)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		str.Write(make([]byte, 16384))
+		str.CloseWrite()
+	}()
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, err := io.Copy(io.Discard, accepted); err != nil || n != 16384 {
+		t.Fatalf("Read %d bytes, %v", n, err)
+	}
+
+	if ev, ok := clientEvents.find(WindowStalled); !ok || ev.StreamId != str.Id() {
+		t.Fatalf("Client reported stall %v, %v", ev, ok)
+	}
+	if ev, ok := clientEvents.find(WindowResumed); !ok || ev.Duration < 40*time.Millisecond {
+		t.Fatalf("Client reported resumption %v, %v, expected a stall of at least the reader's delay", ev, ok)
+	}
+	if ev, ok := clientEvents.find(WindowUpdateReceived); !ok || ev.Increment == 0 {
+		t.Fatalf("Client reported window update %v, %v", ev, ok)
+	}
+	if ev, ok := serverEvents.find(WindowUpdateSent); !ok || ev.Increment == 0 || ev.StreamId != accepted.Id() {
+		t.Fatalf("Server reported window update %v, %v", ev, ok)
+	}
+}
@@ -0,0 +1,99 @@
+package frame
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// size of the CRC32C trailer appended to checksummed frames
+	checksumSize = 4
+
+	// values of the SettingChecksums setting
+	ChecksumsSupported = 0x1 // the sender verifies checksums once the remote side turns them on
+	ChecksumsOn        = 0x2 // every frame the sender writes after this SETTINGS frame has a checksum
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumFramer is implemented by Framers that append and verify per-frame
+// CRC32C checksums. A ChecksumFramer starts writing checksums after it writes
+// a SETTINGS frame setting SettingChecksums to ChecksumsOn and starts
+// verifying them after it reads one, so the switch happens at the same frame
+// for both sides.
+type ChecksumFramer interface {
+	Framer
+	checksums()
+}
+
+// checksumState is the state a framer keeps for checksummed frames
+type checksumState struct {
+	read  bool // verify the checksums of frames read
+	write bool // append checksums to frames written
+
+	rbuf  []byte       // holds the frame being read
+	plain bytes.Reader // reads the verified frame
+	inner *framer      // parses frames from the verified bytes
+	wbuf  bytes.Buffer // serializes the frame being written
+}
+
+func (fr *framer) checksums() {}
+
+// readChecksummedFrame reads the next frame and its checksum trailer,
+// failing with a protocol error if they do not match
+func (fr *framer) readChecksummedFrame() (Frame, error) {
+	ck := &fr.checksum
+	// DATA frames are checked against the max length before they're buffered
+	if err := fr.common.readFrom(fr.Reader, fr.maxLen()); err != nil {
+		return nil, err
+	}
+	hdrLen := fr.common.headerLen()
+	size := hdrLen + int(fr.common.length) + checksumSize
+	if cap(ck.rbuf) < size {
+		ck.rbuf = make([]byte, size)
+	}
+	ck.rbuf = ck.rbuf[:size]
+	copy(ck.rbuf, fr.common.b[:hdrLen])
+	if _, err := io.ReadFull(fr.Reader, ck.rbuf[hdrLen:]); err != nil {
+		return nil, err
+	}
+	body := ck.rbuf[:size-checksumSize]
+	if sum := order.Uint32(ck.rbuf[size-checksumSize:]); sum != crc32.Checksum(body, castagnoli) {
+		return nil, protoError("checksum mismatch on %s frame of length %d", fr.common.ftype, fr.common.length)
+	}
+	if ck.inner == nil {
+		ck.inner = &framer{Reader: &ck.plain}
+	}
+	ck.inner.maxLength = fr.maxLength
+	ck.plain.Reset(body)
+	return ck.inner.readFrame()
+}
+
+// writeChecksummedFrame writes the frame followed by its checksum trailer
+func (fr *framer) writeChecksummedFrame(f Frame) error {
+	ck := &fr.checksum
+	ck.wbuf.Reset()
+	if err := f.writeTo(&ck.wbuf); err != nil {
+		return err
+	}
+	var sum [checksumSize]byte
+	order.PutUint32(sum[:], crc32.Checksum(ck.wbuf.Bytes(), castagnoli))
+	ck.wbuf.Write(sum[:])
+	_, err := fr.Writer.Write(ck.wbuf.Bytes())
+	return err
+}
+
+// turnsOnChecksums returns true if f is a SETTINGS frame that turns on checksums
+func turnsOnChecksums(f Frame) bool {
+	settings, ok := f.(*Settings)
+	if !ok {
+		return false
+	}
+	for _, s := range settings.Settings() {
+		if s.Id == SettingChecksums && s.Value == ChecksumsOn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package frame
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func writeChecksumsOn(t *testing.T, fr Framer) {
+	f := new(Settings)
+	if err := f.Pack([]Setting{{SettingChecksums, ChecksumsOn}}); err != nil {
+		t.Fatalf("Failed to pack SETTINGS: %v", err)
+	}
+	if err := fr.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to write SETTINGS: %v", err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	wr := NewFramer(nil, buf)
+	rd := NewFramer(buf, nil)
+	if _, ok := wr.(ChecksumFramer); !ok {
+		t.Fatalf("Default framer does not implement ChecksumFramer")
+	}
+
+	writeChecksumsOn(t, wr)
+	settingsLen := buf.Len()
+
+	payload := []byte("checked payload")
+	f := new(Data)
+	f.Pack(1, payload, false, false)
+	if err := wr.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to write DATA: %v", err)
+	}
+	if n := buf.Len() - settingsLen; n != headerSize+len(payload)+checksumSize {
+		t.Fatalf("Wrong checksummed frame size. Got %d, expected %d", n, headerSize+len(payload)+checksumSize)
+	}
+
+	if _, err := rd.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read SETTINGS: %v", err)
+	}
+	fr, err := rd.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read checksummed DATA: %v", err)
+	}
+	data, _ := ioutil.ReadAll(fr.(*Data).Reader())
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("Wrong payload. Got %q, expected %q", data, payload)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	wr := NewFramer(nil, buf)
+	rd := NewFramer(buf, nil)
+
+	writeChecksumsOn(t, wr)
+	f := new(Data)
+	f.Pack(1, []byte("checked payload"), false, false)
+	wr.WriteFrame(f)
+
+	// flip a bit in the payload
+	buf.Bytes()[buf.Len()-checksumSize-1] ^= 0x1
+
+	if _, err := rd.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read SETTINGS: %v", err)
+	}
+	_, err := rd.ReadFrame()
+	if e, ok := err.(*Error); !ok || e.Type() != ErrorProtocol {
+		t.Fatalf("Expected protocol error for corrupted frame, got: %v", err)
+	}
+}
+
+func TestChecksumFrameTooLong(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	wr := NewFramer(nil, buf)
+	rd := NewFramer(buf, nil).(LimitedFramer)
+	rd.SetMaxLength(1024)
+
+	writeChecksumsOn(t, wr)
+	if _, err := rd.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read SETTINGS: %v", err)
+	}
+	// only the header is written, the frame is rejected before it's buffered
+	var f Data
+	if err := f.pack(TypeData, 1025, 1, 0); err != nil {
+		t.Fatalf("Failed to pack header: %v", err)
+	}
+	buf.Write(f.b[:headerSize])
+	_, err := rd.ReadFrame()
+	if e, ok := err.(*Error); !ok || e.Type() != ErrorFrameSize {
+		t.Fatalf("Expected frame size error for frame over the max length, got: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package frame
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// size of a sealed record's header: 4-byte length and 1-byte record type
+	recordHeaderSize = 5
+
+	// record types
+	recordFrame = 0x0 // carries a single sealed frame
+	recordRekey = 0x1 // switches the sender to the next key epoch
+
+	// length of a rekey record's plaintext: the new key epoch
+	rekeyLength = 4
+
+	// the sender rekeys automatically after sealing this many records with
+	// one key so that nonces are never reused
+	maxRecordsPerKey = 1 << 32
+)
+
+// CipherKeys returns the AEADs used by a cipher framer in the given key
+// epoch: seal authenticates and encrypts the frames it writes and open
+// authenticates and decrypts the frames it reads. The two sides of a session
+// must be given mirrored keys, the seal AEAD of one being the open AEAD of the
+// other, and the keys for each direction must differ because both sides
+// derive their nonces from the same counter.
+type CipherKeys func(epoch uint32) (seal cipher.AEAD, open cipher.AEAD, err error)
+
+// A CipherFramer is a Framer that encrypts frames for transports which are not
+// already secure, e.g. raw serial links or tunnels using pre-shared keys.
+type CipherFramer interface {
+	Framer
+
+	// Rekey switches the frames written to the keys of the next epoch. The
+	// remote side is told to do the same for the frames it reads by a rekey
+	// control record sealed with the current keys.
+	Rekey() error
+}
+
+// cipherHalf is the key state of one direction of a cipher framer
+type cipherHalf struct {
+	aead    cipher.AEAD
+	epoch   uint32
+	counter uint64 // number of records sealed/opened with the current key
+	nonce   []byte
+	ad      [recordHeaderSize]byte
+}
+
+func (h *cipherHalf) nextNonce() []byte {
+	for i := range h.nonce {
+		h.nonce[i] = 0
+	}
+	order.PutUint64(h.nonce[len(h.nonce)-8:], h.counter)
+	h.counter++
+	return h.nonce
+}
+
+func (h *cipherHalf) setKey(aead cipher.AEAD, epoch uint32) error {
+	if aead.NonceSize() < 8 {
+		return fmt.Errorf("AEAD nonce size must be at least 8 bytes, got %d", aead.NonceSize())
+	}
+	h.aead = aead
+	h.epoch = epoch
+	h.counter = 0
+	h.nonce = make([]byte, aead.NonceSize())
+	return nil
+}
+
+type cipherFramer struct {
+	keys CipherKeys
+
+	rd    io.Reader
+	rhalf cipherHalf
+	rbuf  []byte       // holds the record being read
+	plain bytes.Reader // reads the opened frame
+	inner *framer      // parses frames from the opened record
+
+	wmu   sync.Mutex
+	wr    io.Writer
+	whalf cipherHalf
+	wbuf  bytes.Buffer // serializes frames before they are sealed
+	rec   []byte       // holds the sealed record being written
+
+	initOnce sync.Once
+	initErr  error
+}
+
+// NewCipherFramer returns a Framer that seals each frame it writes into a
+// record encrypted with the AEADs returned by keys and opens the records it
+// reads. Records that fail to authenticate are reported as protocol errors.
+//
+// To run a session over it, set Config.NewFramer to a function calling
+// NewCipherFramer.
+func NewCipherFramer(r io.Reader, w io.Writer, keys CipherKeys) CipherFramer {
+	fr := &cipherFramer{
+		keys: keys,
+		rd:   r,
+		wr:   w,
+	}
+	fr.inner = &framer{Reader: &fr.plain}
+	return fr
+}
+
+func (fr *cipherFramer) SetMaxLength(maxLength uint32) {
+	fr.inner.maxLength = maxLength
+}
+
+func (fr *cipherFramer) init() error {
+	fr.initOnce.Do(func() {
+		seal, open, err := fr.keys(0)
+		if err != nil {
+			fr.initErr = err
+			return
+		}
+		if fr.initErr = fr.whalf.setKey(seal, 0); fr.initErr != nil {
+			return
+		}
+		fr.initErr = fr.rhalf.setKey(open, 0)
+	})
+	return fr.initErr
+}
+
+func (fr *cipherFramer) WriteFrame(f Frame) error {
+	if err := fr.init(); err != nil {
+		return err
+	}
+	fr.wmu.Lock()
+	defer fr.wmu.Unlock()
+
+	fr.wbuf.Reset()
+	if err := f.writeTo(&fr.wbuf); err != nil {
+		return err
+	}
+	if err := fr.writeRecord(recordFrame, fr.wbuf.Bytes()); err != nil {
+		return err
+	}
+	if fr.whalf.counter >= maxRecordsPerKey {
+		return fr.rekey()
+	}
+	return nil
+}
+
+func (fr *cipherFramer) Rekey() error {
+	if err := fr.init(); err != nil {
+		return err
+	}
+	fr.wmu.Lock()
+	defer fr.wmu.Unlock()
+	return fr.rekey()
+}
+
+func (fr *cipherFramer) rekey() error {
+	epoch := fr.whalf.epoch + 1
+	seal, _, err := fr.keys(epoch)
+	if err != nil {
+		return err
+	}
+	var b [rekeyLength]byte
+	order.PutUint32(b[:], epoch)
+	if err := fr.writeRecord(recordRekey, b[:]); err != nil {
+		return err
+	}
+	return fr.whalf.setKey(seal, epoch)
+}
+
+// writeRecord seals plaintext into a record of type rtype with the current keys
+func (fr *cipherFramer) writeRecord(rtype byte, plaintext []byte) error {
+	h := &fr.whalf
+	length := len(plaintext) + h.aead.Overhead()
+	order.PutUint32(h.ad[:], uint32(length))
+	h.ad[4] = rtype
+	fr.rec = append(fr.rec[:0], h.ad[:]...)
+	fr.rec = h.aead.Seal(fr.rec, h.nextNonce(), plaintext, h.ad[:])
+	_, err := fr.wr.Write(fr.rec)
+	return err
+}
+
+func (fr *cipherFramer) ReadFrame() (Frame, error) {
+	if err := fr.init(); err != nil {
+		return nil, err
+	}
+	for {
+		rtype, plaintext, err := fr.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch rtype {
+		case recordFrame:
+			fr.plain.Reset(plaintext)
+			f, err := fr.inner.readFrame()
+			if err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					err = protoError("sealed record does not contain a whole frame")
+				}
+				return nil, err
+			}
+			return f, nil
+		case recordRekey:
+			if len(plaintext) != rekeyLength {
+				return nil, protoError("illegal rekey record length: %d", len(plaintext))
+			}
+			epoch := order.Uint32(plaintext)
+			if epoch != fr.rhalf.epoch+1 {
+				return nil, protoError("rekey to epoch %d out of order, current epoch %d", epoch, fr.rhalf.epoch)
+			}
+			_, open, err := fr.keys(epoch)
+			if err != nil {
+				return nil, err
+			}
+			if err := fr.rhalf.setKey(open, epoch); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, protoError("unknown record type: 0x%x", rtype)
+		}
+	}
+}
+
+// readRecord reads and opens the next record
+func (fr *cipherFramer) readRecord() (byte, []byte, error) {
+	h := &fr.rhalf
+	if _, err := io.ReadFull(fr.rd, h.ad[:]); err != nil {
+		return 0, nil, err
+	}
+	// records are checked against the largest frame they may hold before
+	// they are buffered and authenticated
+	length := order.Uint32(h.ad[:])
+	maxFrame := max(fr.inner.maxLen(), MaxLength) + headerSize + extendedLengthSize
+	if length > maxFrame+uint32(h.aead.Overhead()) || length < uint32(h.aead.Overhead()) {
+		return 0, nil, frameSizeError(length, "sealed record")
+	}
+	if cap(fr.rbuf) < int(length) {
+		fr.rbuf = make([]byte, length)
+	}
+	fr.rbuf = fr.rbuf[:length]
+	if _, err := io.ReadFull(fr.rd, fr.rbuf); err != nil {
+		return 0, nil, err
+	}
+	plaintext, err := h.aead.Open(fr.rbuf[:0], h.nextNonce(), fr.rbuf, h.ad[:])
+	if err != nil {
+		return 0, nil, protoError("failed to authenticate sealed record: %v", err)
+	}
+	return h.ad[4], plaintext, nil
+}
@@ -0,0 +1,129 @@
+package frame
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// testKeys derives an AES-GCM key for each direction and epoch from a
+// pre-shared secret
+func testKeys(client bool) CipherKeys {
+	aead := func(direction byte, epoch uint32) cipher.AEAD {
+		var b [5]byte
+		b[0] = direction
+		binary.BigEndian.PutUint32(b[1:], epoch)
+		key := sha256.Sum256(append([]byte("pre-shared secret"), b[:]...))
+		block, _ := aes.NewCipher(key[:])
+		gcm, _ := cipher.NewGCM(block)
+		return gcm
+	}
+	return func(epoch uint32) (cipher.AEAD, cipher.AEAD, error) {
+		if client {
+			return aead('c', epoch), aead('s', epoch), nil
+		}
+		return aead('s', epoch), aead('c', epoch), nil
+	}
+}
+
+func TestCipherFramer(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	client := NewCipherFramer(nil, buf, testKeys(true))
+	server := NewCipherFramer(buf, nil, testKeys(false))
+
+	payload := []byte("sealed payload")
+	for i := 0; i < 3; i++ {
+		f := new(Data)
+		if err := f.Pack(1, payload, false, false); err != nil {
+			t.Fatalf("Failed to pack frame: %v", err)
+		}
+		if err := client.WriteFrame(f); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+		// switch keys in the middle of the stream
+		if i == 1 {
+			if err := client.Rekey(); err != nil {
+				t.Fatalf("Failed to rekey: %v", err)
+			}
+		}
+	}
+	if bytes.Contains(buf.Bytes(), payload) {
+		t.Fatalf("Payload written in plaintext")
+	}
+
+	for i := 0; i < 3; i++ {
+		f, err := server.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame %d: %v", i, err)
+		}
+		data, err := ioutil.ReadAll(f.(*Data).Reader())
+		if err != nil {
+			t.Fatalf("Failed to read frame payload: %v", err)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("Wrong payload. Got %q, expected %q", data, payload)
+		}
+	}
+}
+
+func TestCipherFramerTampered(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	client := NewCipherFramer(nil, buf, testKeys(true))
+	server := NewCipherFramer(buf, nil, testKeys(false))
+
+	f := new(WndInc)
+	f.Pack(1, 100)
+	if err := client.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	buf.Bytes()[buf.Len()-1] ^= 0xFF
+
+	_, err := server.ReadFrame()
+	if e, ok := err.(*Error); !ok || e.Type() != ErrorProtocol {
+		t.Fatalf("Expected protocol error for tampered record, got: %v", err)
+	}
+}
+
+func TestCipherFramerWrongKeys(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	// both sides seal with the client key, so the reader can't open the record
+	client := NewCipherFramer(nil, buf, testKeys(true))
+	other := NewCipherFramer(buf, nil, testKeys(true))
+
+	f := new(WndInc)
+	f.Pack(1, 100)
+	if err := client.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	if _, err := other.ReadFrame(); err == nil {
+		t.Fatalf("Expected error reading a record sealed with the wrong key")
+	}
+}
+
+func TestCipherFramerRecordTooLong(t *testing.T) {
+	t.Parallel()
+	// only the record header is written, the record is rejected before
+	// it's buffered
+	var hdr [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:], 1<<30)
+	server := NewCipherFramer(bytes.NewReader(hdr[:]), nil, testKeys(false))
+	_, err := server.ReadFrame()
+	if e, ok := err.(*Error); !ok || e.Type() != ErrorFrameSize {
+		t.Fatalf("Expected frame size error for record over the max length, got: %v", err)
+	}
+
+	// records of DATA frames with extended lengths are read once they're allowed
+	server = NewCipherFramer(bytes.NewReader(hdr[:]), nil, testKeys(false))
+	server.(LimitedFramer).SetMaxLength(MaxExtendedLength)
+	if _, err := server.ReadFrame(); err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Fatalf("Expected EOF reading the truncated record, got: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 )
 
 var (
@@ -37,10 +38,36 @@ type ErrorCode uint32
 type Type uint8
 
 const (
-	TypeRst    Type = 0x0
-	TypeData   Type = 0x1
-	TypeWndInc Type = 0x2
-	TypeGoAway Type = 0x3
+	TypeRst      Type = 0x0
+	TypeData     Type = 0x1
+	TypeWndInc   Type = 0x2
+	TypeGoAway   Type = 0x3
+	TypeSettings Type = 0x4
+	TypePing     Type = 0x5
+	TypeHeaders  Type = 0x6
+	TypePriority Type = 0x7
+
+	// reserved for protocol extensions, see Extension
+	TypeExtension Type = 0x8
+
+	// pads the frames before it, see Padding
+	TypePadding Type = 0x9
+
+	// reserved for applications, see User
+	TypeUserMin Type = 0xC
+	TypeUserMax Type = 0xF
+)
+
+const (
+	// MaxLength is the largest payload length a frame header can express
+	MaxLength = lengthMask
+
+	// MaxExtendedLength is the largest payload length of a DATA frame with
+	// an extended length, which is bounded by the largest flow control window
+	MaxExtendedLength = wndIncMask
+
+	// HeaderSize is the size in bytes of every frame's header
+	HeaderSize = headerSize
 )
 
 func (t Type) String() string {
@@ -53,6 +80,21 @@ func (t Type) String() string {
 		return "WNDINC"
 	case TypeGoAway:
 		return "GOAWAY"
+	case TypeSettings:
+		return "SETTINGS"
+	case TypePing:
+		return "PING"
+	case TypeHeaders:
+		return "HEADERS"
+	case TypePriority:
+		return "PRIORITY"
+	case TypeExtension:
+		return "EXTENSION"
+	case TypePadding:
+		return "PADDING"
+	}
+	if IsUserType(t) {
+		return fmt.Sprintf("USER(0x%x)", uint8(t))
 	}
 	return "UNKNOWN"
 }
@@ -61,8 +103,12 @@ func (t Type) String() string {
 type Flags uint8
 
 const (
-	FlagDataFin = 0x1
-	FlagDataSyn = 0x2
+	FlagDataFin        = 0x1
+	FlagDataSyn        = 0x2
+	FlagDataCompressed = 0x4
+	// the payload length follows the header as a 64-bit integer, allowing
+	// payloads larger than MaxLength. The header's length field is zero.
+	FlagDataExtended = 0x8
 )
 
 func (f Flags) IsSet(g Flags) bool {
@@ -78,9 +124,10 @@ func (f *Flags) Unset(g Flags) {
 }
 
 const (
-	headerSize       = 8
-	maxFixedBodySize = 8 // goaway frame has streamid + errorcode
-	maxBufferSize    = headerSize + maxFixedBodySize
+	headerSize         = 8
+	extendedLengthSize = 8
+	maxFixedBodySize   = 8 // goaway frame has streamid + errorcode
+	maxBufferSize      = headerSize + maxFixedBodySize
 )
 
 type common struct {
@@ -107,7 +154,10 @@ func (f *common) Flags() Flags {
 	return f.flags
 }
 
-func (f *common) readFrom(r io.Reader) error {
+// readFrom reads the frame's header, failing if it is a DATA frame whose
+// payload is longer than maxLength. DATA frames with extended lengths are
+// only read if maxLength is larger than MaxLength.
+func (f *common) readFrom(r io.Reader, maxLength uint32) error {
 	b := f.b[:headerSize]
 	if _, err := io.ReadFull(r, b); err != nil {
 		return err
@@ -116,6 +166,49 @@ func (f *common) readFrom(r io.Reader) error {
 	f.ftype = Type(b[3] >> 4)
 	f.flags = Flags(b[3] & flagsMask)
 	f.streamId = StreamId(order.Uint32(b[4:]))
+	if f.extended() {
+		if maxLength <= MaxLength {
+			return protoError("DATA frame with extended length, which was not negotiated")
+		}
+		if err := f.readExtendedLength(r); err != nil {
+			return err
+		}
+	}
+	if f.ftype == TypeData && f.length > maxLength {
+		return &Error{ErrorFrameSize, fmt.Errorf("DATA frame length %d exceeds the maximum of %d", f.length, maxLength)}
+	}
+	return nil
+}
+
+// extended returns true if the frame's length follows its header
+func (f *common) extended() bool {
+	return f.ftype == TypeData && f.flags.IsSet(FlagDataExtended)
+}
+
+// headerLen returns the size of the frame's header including any extended length
+func (f *common) headerLen() int {
+	if f.extended() {
+		return headerSize + extendedLengthSize
+	}
+	return headerSize
+}
+
+func (f *common) readExtendedLength(r io.Reader) error {
+	if f.length != 0 {
+		return protoError("DATA frame with extended length has non-zero length field: %d", f.length)
+	}
+	b := f.b[headerSize : headerSize+extendedLengthSize]
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	length := order.Uint64(b)
+	switch {
+	case length > MaxExtendedLength:
+		return &Error{ErrorFrameSize, fmt.Errorf("illegal extended DATA frame length: 0x%x", length)}
+	case length <= MaxLength:
+		return protoError("DATA frame with extended length of %d fits in the frame header", length)
+	}
+	f.length = uint32(length)
 	return nil
 }
 
@@ -124,27 +217,71 @@ func (f *common) writeTo(w io.Writer, fixedSize int) error {
 	return err
 }
 
+// BuffersWriter is implemented by writers that buffer the frames written to
+// a transport, e.g. with a bufio.Writer, so that the header and payload of
+// large frames can still reach the transport in a single net.Buffers.WriteTo
+// call instead of being copied into the buffer.
+type BuffersWriter interface {
+	WriteBuffers(bufs *net.Buffers) (int64, error)
+}
+
+// vectored holds the scratch space used to write a frame's header and its
+// variable-length payload with a single net.Buffers.WriteTo call. On
+// transports that support it (e.g. *net.TCPConn) this becomes one writev(2)
+// instead of two write(2) syscalls.
+type vectored struct {
+	vec  [2][]byte
+	bufs net.Buffers
+}
+
+func (v *vectored) writeVec(w io.Writer, hdr, payload []byte) error {
+	v.vec[0], v.vec[1] = hdr, payload
+	v.bufs = v.vec[:]
+	var err error
+	if bw, ok := w.(BuffersWriter); ok {
+		_, err = bw.WriteBuffers(&v.bufs)
+	} else {
+		_, err = v.bufs.WriteTo(w)
+	}
+	// don't hold on to the caller's payload
+	v.vec[0], v.vec[1] = nil, nil
+	return err
+}
+
 func (f *common) pack(ftype Type, length int, streamId StreamId, flags Flags) error {
 	if err := streamId.valid(); err != nil {
 		return err
 	}
-	if !isValidLength(length) {
+	// DATA frames packed with FlagDataExtended may have payloads too large
+	// for the length field. The flag is only kept when it is needed.
+	hdrLength := length
+	if ftype == TypeData && flags.IsSet(FlagDataExtended) {
+		flags.Unset(FlagDataExtended)
+		if length > MaxLength && length <= MaxExtendedLength {
+			flags.Set(FlagDataExtended)
+			hdrLength = 0
+		}
+	}
+	if !isValidLength(hdrLength) {
 		return fmt.Errorf("invalid length: %d", length)
 	}
 	f.ftype = ftype
 	f.streamId = streamId
 	f.length = uint32(length)
 	f.flags = flags
-	_ = append(f.b[:0],
-		byte(f.length>>16),
-		byte(f.length>>8),
-		byte(f.length),
+	b := append(f.b[:0],
+		byte(hdrLength>>16),
+		byte(hdrLength>>8),
+		byte(hdrLength),
 		byte(uint8(f.ftype<<4)|uint8(f.flags&flagsMask)),
 		byte(f.streamId>>24),
 		byte(f.streamId>>16),
 		byte(f.streamId>>8),
 		byte(f.streamId),
 	)
+	if f.extended() {
+		order.PutUint64(b[headerSize:headerSize+extendedLengthSize], uint64(length))
+	}
 	return nil
 }
 
@@ -156,7 +293,7 @@ func (f *common) String() string {
 	s := fmt.Sprintf(
 		"FRAME [TYPE: %s | LENGTH: %d | STREAMID: %x | FLAGS: %d",
 		f.Type(), f.Length(), f.StreamId(), f.Flags())
-	if f.Type() != TypeData && f.Type() != TypeGoAway {
+	if f.Type() != TypeData && f.Type() != TypeGoAway && f.Type() != TypeSettings && f.Type() != TypeHeaders && f.Type() != TypeExtension && f.Type() != TypePadding && !IsUserType(f.Type()) {
 		s += fmt.Sprintf(" | BODY: %x", f.body()[:f.Length()])
 	}
 	s += "]"
@@ -127,7 +127,7 @@ func TestPack(t *testing.T) {
 	// test deserialization
 	for _, pt := range tests {
 		var c common
-		err := c.readFrom(bytes.NewReader(pt.serialized))
+		err := c.readFrom(bytes.NewReader(pt.serialized), MaxLength)
 		if err != nil {
 			t.Errorf("Header readFrom should never return an error, but failed with: %v, %+v", err, pt)
 			continue
@@ -152,7 +152,7 @@ func TestPack(t *testing.T) {
 			t.Errorf("Failed to round-trip serialize: %v, %+v", err, pt)
 			continue
 		}
-		err = c.readFrom(&b)
+		err = c.readFrom(&b, MaxLength)
 		if err != nil {
 			t.Errorf("Failed to round-trip deserialize: %v, %+v", err, pt)
 			continue
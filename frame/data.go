@@ -9,6 +9,7 @@ type Data struct {
 
 	toRead  io.LimitedReader // when reading, the underlying io.Reader is handed up
 	toWrite []byte           // when writing, these are the bytes to write
+	vectored
 }
 
 func (f *Data) Fin() bool {
@@ -19,6 +20,12 @@ func (f *Data) Syn() bool {
 	return f.flags.IsSet(FlagDataSyn)
 }
 
+// Compressed returns true if the stream's data is compressed. It is only
+// meaningful on the frame that opens a stream.
+func (f *Data) Compressed() bool {
+	return f.flags.IsSet(FlagDataCompressed)
+}
+
 func (f *Data) Reader() io.Reader {
 	return &f.toRead
 }
@@ -38,14 +45,8 @@ func (f *Data) readFrom(rd io.Reader) error {
 	return nil
 }
 
-func (f *Data) writeTo(wr io.Writer) (err error) {
-	if err = f.common.writeTo(wr, 0); err != nil {
-		return err
-	}
-	if _, err = wr.Write(f.toWrite); err != nil {
-		return err
-	}
-	return
+func (f *Data) writeTo(wr io.Writer) error {
+	return f.writeVec(wr, f.b[:f.headerLen()], f.toWrite)
 }
 
 func (f *Data) Pack(streamId StreamId, data []byte, fin bool, syn bool) (err error) {
@@ -56,6 +57,13 @@ func (f *Data) Pack(streamId StreamId, data []byte, fin bool, syn bool) (err err
 	if syn {
 		flags.Set(FlagDataSyn)
 	}
+	return f.PackFlags(streamId, data, flags)
+}
+
+// PackFlags is like Pack but sets the frame's flags directly. Setting
+// FlagDataExtended allows payloads up to MaxExtendedLength, which the remote
+// side must have agreed to receive.
+func (f *Data) PackFlags(streamId StreamId, data []byte, flags Flags) (err error) {
 	if err = f.common.pack(TypeData, len(data), streamId, flags); err != nil {
 		return
 	}
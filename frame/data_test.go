@@ -99,7 +99,7 @@ func TestDataFrameReadLengthLimited(t *testing.T) {
 	buf := bytes.NewBuffer(dt.serialized)
 	buf.Write([]byte("extra data that shouldn't be read"))
 	var f *Data = new(Data)
-	if err := f.common.readFrom(buf); err != nil {
+	if err := f.common.readFrom(buf, MaxLength); err != nil {
 		t.Fatalf("failed read frame header: %v, %+v", err, dt)
 	}
 	err := f.readFrom(buf)
@@ -110,3 +110,118 @@ func TestDataFrameReadLengthLimited(t *testing.T) {
 		t.Fatalf(err.Error())
 	}
 }
+
+func TestDataFrameExtendedLength(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte{0xAB}, MaxLength+1)
+	var f Data
+	if err := f.PackFlags(0x1, data, FlagDataFin|FlagDataExtended); err != nil {
+		t.Fatalf("Failed to pack frame: %v", err)
+	}
+	if !f.Flags().IsSet(FlagDataExtended) || f.Length() != MaxLength+1 {
+		t.Fatalf("Wrong flags or length: %v, %d", f.Flags(), f.Length())
+	}
+
+	var buf bytes.Buffer
+	if err := NewFramer(nil, &buf).WriteFrame(&f); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	b := buf.Bytes()
+	if !bytes.Equal(b[:3], []byte{0, 0, 0}) || order.Uint64(b[headerSize:]) != MaxLength+1 {
+		t.Fatalf("Wrong extended header: %x", b[:headerSize+extendedLengthSize])
+	}
+
+	rd := NewFramer(&buf, nil).(LimitedFramer)
+	rd.SetMaxLength(MaxExtendedLength)
+	fr, err := rd.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	read := fr.(*Data)
+	if !read.Fin() || read.Length() != MaxLength+1 {
+		t.Fatalf("Wrong flags or length read: %v, %d", read.Flags(), read.Length())
+	}
+	got, err := ioutil.ReadAll(read.Reader())
+	if err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Data read does not match")
+	}
+}
+
+func TestDataFrameExtendedLengthInvalid(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		length uint64
+		code   ErrorType
+	}{
+		{4, ErrorProtocol},
+		{MaxLength, ErrorProtocol},
+		{MaxExtendedLength + 1, ErrorFrameSize},
+	} {
+		b := []byte{0, 0, 0, byte(TypeData<<4) | FlagDataExtended, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+		order.PutUint64(b[headerSize:], tc.length)
+		fr := NewFramer(bytes.NewReader(b), nil).(LimitedFramer)
+		fr.SetMaxLength(MaxExtendedLength)
+		_, err := fr.ReadFrame()
+		if e, ok := err.(*Error); !ok || e.errorType != tc.code {
+			t.Errorf("Extended length %d: got error %v, expected code %v", tc.length, err, tc.code)
+		}
+	}
+}
+
+func TestDataFrameExtendedLengthNotNeeded(t *testing.T) {
+	t.Parallel()
+	var f Data
+	if err := f.PackFlags(0x1, []byte{0x1}, FlagDataExtended); err != nil {
+		t.Fatalf("Failed to pack frame: %v", err)
+	}
+	if f.Flags().IsSet(FlagDataExtended) {
+		t.Fatalf("Small frame was packed with an extended length")
+	}
+}
+
+func TestDataFrameExtendedLengthNotNegotiated(t *testing.T) {
+	t.Parallel()
+	var f Data
+	if err := f.PackFlags(0x1, make([]byte, MaxLength+1), FlagDataExtended); err != nil {
+		t.Fatalf("Failed to pack frame: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := NewFramer(nil, &buf).WriteFrame(&f); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	_, err := NewFramer(&buf, nil).ReadFrame()
+	if e, ok := err.(*Error); !ok || e.errorType != ErrorProtocol {
+		t.Fatalf("Expected protocol error for extended length that wasn't negotiated, got: %v", err)
+	}
+}
+
+func TestDataFrameMaxLength(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	wr := NewFramer(nil, &buf)
+	for _, n := range []int{1024, 1025} {
+		var f Data
+		if err := f.Pack(0x1, make([]byte, n), false, false); err != nil {
+			t.Fatalf("Failed to pack frame: %v", err)
+		}
+		if err := wr.WriteFrame(&f); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+	rd := NewFramer(&buf, nil).(LimitedFramer)
+	rd.SetMaxLength(1024)
+	f, err := rd.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if err := DiscardPayload(f); err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	_, err = rd.ReadFrame()
+	if e, ok := err.(*Error); !ok || e.errorType != ErrorFrameSize {
+		t.Fatalf("Expected frame size error for frame over the max length, got: %v", err)
+	}
+}
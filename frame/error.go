@@ -25,6 +25,10 @@ func (e Error) Err() error {
 	return e.error
 }
 
+func (e Error) Unwrap() error {
+	return e.error
+}
+
 func frameSizeError(length uint32, frameName string) error {
 	return &Error{ErrorFrameSize, fmt.Errorf("illegal %s frame length: 0x%x", frameName, length)}
 }
@@ -0,0 +1,61 @@
+package frame
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	extensionIdLength = 2
+
+	// largest extension id, the top bit is reserved
+	MaxExtensionId = 0x7FFF
+)
+
+// Extension is a frame reserved for protocol extensions that are layered on
+// top of the core protocol. Its payload starts with the 16-bit id of the
+// extension it belongs to, followed by data only that extension understands.
+type Extension struct {
+	common
+	toRead  io.LimitedReader
+	toWrite []byte
+	vectored
+}
+
+// ExtensionId returns the id of the extension the frame belongs to
+func (f *Extension) ExtensionId() uint16 {
+	return order.Uint16(f.body())
+}
+
+// Reader returns the extension's data
+func (f *Extension) Reader() io.Reader {
+	return &f.toRead
+}
+
+func (f *Extension) readFrom(rd io.Reader) error {
+	if f.length < extensionIdLength {
+		return frameSizeError(f.length, "EXTENSION")
+	}
+	if _, err := io.ReadFull(rd, f.body()[:extensionIdLength]); err != nil {
+		return err
+	}
+	f.toRead.R = rd
+	f.toRead.N = int64(f.length - extensionIdLength)
+	return nil
+}
+
+func (f *Extension) writeTo(wr io.Writer) error {
+	return f.writeVec(wr, f.b[:headerSize+extensionIdLength], f.toWrite)
+}
+
+func (f *Extension) Pack(extId uint16, streamId StreamId, data []byte) (err error) {
+	if extId > MaxExtensionId {
+		return fmt.Errorf("invalid extension id: %d", extId)
+	}
+	if err = f.common.pack(TypeExtension, extensionIdLength+len(data), streamId, 0); err != nil {
+		return
+	}
+	order.PutUint16(f.body(), extId)
+	f.toWrite = data
+	return
+}
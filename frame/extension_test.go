@@ -0,0 +1,73 @@
+package frame
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+type extensionTest struct {
+	extId            uint16
+	streamId         StreamId
+	data             []byte
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *extensionTest) FrameName() string         { return "EXTENSION" }
+func (t *extensionTest) SerializeError() bool      { return t.serializeError }
+func (t *extensionTest) DeserializeError() bool    { return t.deserializeError }
+func (t *extensionTest) Serialized() []byte        { return t.serialized }
+func (t *extensionTest) WithHeader(c common) Frame { return &Extension{common: c} }
+func (t *extensionTest) Pack() (Frame, error) {
+	var f Extension
+	return &f, f.Pack(t.extId, t.streamId, t.data)
+}
+func (t *extensionTest) Eq(fr Frame) error {
+	f := fr.(*Extension)
+	if f.ExtensionId() != t.extId {
+		return fmt.Errorf("wrong extension id. expected %x, got %x", t.extId, f.ExtensionId())
+	}
+	data, err := ioutil.ReadAll(f.Reader())
+	if err != nil {
+		return fmt.Errorf("failed to read extension data: %v", err)
+	}
+	if !bytes.Equal(data, t.data) {
+		return fmt.Errorf("wrong extension data. expected %x, got %x", t.data, data)
+	}
+	return nil
+}
+
+func TestExtensionFrameValid(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &extensionTest{
+		extId:      0x1234,
+		streamId:   0x5,
+		data:       []byte{0xA, 0xB},
+		serialized: []byte{0x0, 0x0, 0x4, byte(TypeExtension << 4), 0, 0, 0, 0x5, 0x12, 0x34, 0xA, 0xB},
+	})
+	RunFrameTest(t, &extensionTest{
+		extId:      MaxExtensionId,
+		data:       []byte{},
+		serialized: []byte{0x0, 0x0, 0x2, byte(TypeExtension << 4), 0, 0, 0, 0, 0x7F, 0xFF},
+	})
+}
+
+func TestExtensionBadId(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &extensionTest{
+		extId:          MaxExtensionId + 1,
+		serialized:     []byte{},
+		serializeError: true,
+	})
+}
+
+func TestExtensionTooShort(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &extensionTest{
+		serialized:       []byte{0x0, 0x0, 0x1, byte(TypeExtension << 4), 0, 0, 0, 0, 0x1},
+		deserializeError: true,
+	})
+}
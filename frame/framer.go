@@ -25,6 +25,41 @@ type Framer interface {
 	ReadFrame() (Frame, error)
 }
 
+// A WrappingFramer is a Framer that passes frames through to another Framer,
+// e.g. to instrument or record them. Sessions look through wrapping Framers
+// for the optional interfaces of the Framers they wrap, like ChecksumFramer.
+type WrappingFramer interface {
+	Framer
+
+	// Unwrap returns the wrapped Framer
+	Unwrap() Framer
+}
+
+// A LimitedFramer is a Framer that fails with a frame size error on DATA
+// frames whose payload is longer than a limit before it reads or buffers
+// them, so that the remote side can't make it allocate more than was agreed
+// on. Other frames are limited to MaxLength.
+type LimitedFramer interface {
+	Framer
+
+	// SetMaxLength sets the largest payload length of the DATA frames read.
+	// DATA frames with extended lengths are only read if it is larger than
+	// MaxLength. Default MaxLength.
+	SetMaxLength(uint32)
+}
+
+// Unwrap returns fr followed by the Framers it wraps, outermost first
+func Unwrap(fr Framer) []Framer {
+	frs := []Framer{fr}
+	for {
+		w, ok := frs[len(frs)-1].(WrappingFramer)
+		if !ok {
+			return frs
+		}
+		frs = append(frs, w.Unwrap())
+	}
+}
+
 type framer struct {
 	io.Reader
 	io.Writer
@@ -35,15 +70,57 @@ type framer struct {
 	Data
 	WndInc
 	GoAway
+	Settings
+	Ping
+	Headers
+	Priority
+	Extension
+	Padding
+	User
 	Unknown
+
+	checksum  checksumState
+	maxLength uint32 // largest DATA payload length read, see SetMaxLength
 }
 
-func (fr *framer) WriteFrame(f Frame) error {
-	return f.writeTo(fr.Writer)
+func (fr *framer) SetMaxLength(maxLength uint32) {
+	fr.maxLength = maxLength
+}
+
+// maxLen returns the largest payload length of the DATA frames read
+func (fr *framer) maxLen() uint32 {
+	if fr.maxLength == 0 {
+		return MaxLength
+	}
+	return fr.maxLength
+}
+
+func (fr *framer) WriteFrame(f Frame) (err error) {
+	if fr.checksum.write {
+		err = fr.writeChecksummedFrame(f)
+	} else {
+		err = f.writeTo(fr.Writer)
+	}
+	if err == nil && turnsOnChecksums(f) {
+		fr.checksum.write = true
+	}
+	return
 }
 
 func (fr *framer) ReadFrame() (f Frame, err error) {
-	if err := fr.common.readFrom(fr.Reader); err != nil {
+	if fr.checksum.read {
+		f, err = fr.readChecksummedFrame()
+	} else {
+		f, err = fr.readFrame()
+	}
+	if err == nil && turnsOnChecksums(f) {
+		fr.checksum.read = true
+	}
+	return
+}
+
+func (fr *framer) readFrame() (f Frame, err error) {
+	if err := fr.common.readFrom(fr.Reader, fr.maxLen()); err != nil {
 		return nil, err
 	}
 	switch fr.common.ftype {
@@ -59,6 +136,27 @@ func (fr *framer) ReadFrame() (f Frame, err error) {
 	case TypeGoAway:
 		f = &fr.GoAway
 		fr.GoAway.common = fr.common
+	case TypeSettings:
+		f = &fr.Settings
+		fr.Settings.common = fr.common
+	case TypePing:
+		f = &fr.Ping
+		fr.Ping.common = fr.common
+	case TypeHeaders:
+		f = &fr.Headers
+		fr.Headers.common = fr.common
+	case TypePriority:
+		f = &fr.Priority
+		fr.Priority.common = fr.common
+	case TypeExtension:
+		f = &fr.Extension
+		fr.Extension.common = fr.common
+	case TypePadding:
+		f = &fr.Padding
+		fr.Padding.common = fr.common
+	case TypeUserMin, TypeUserMin + 1, TypeUserMin + 2, TypeUserMax:
+		f = &fr.User
+		fr.User.common = fr.common
 	default:
 		f = &fr.Unknown
 		fr.Unknown.common = fr.common
@@ -105,6 +203,10 @@ func (fr *debugFramer) ReadFrame() (Frame, error) {
 	return f, err
 }
 
+func (fr *debugFramer) Unwrap() Framer {
+	return fr.Framer
+}
+
 func (fr *debugFramer) printHeader() {
 	fr.once.Do(func() {
 		fmt.Fprintf(fr.debugWr, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "NAME", "OP", "TYPE", "STREAMID", "LENGTH", "FLAGS", "ERROR")
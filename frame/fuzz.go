@@ -0,0 +1,31 @@
+//go:build gofuzz
+// +build gofuzz
+
+package frame
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+// Fuzz is the entry point for go-fuzz. It feeds data to a Framer as a stream
+// of frames and checks that every frame which parses on its own can be
+// serialized back to the same bytes.
+func Fuzz(data []byte) int {
+	if f, err := Parse(data); err == nil {
+		if b, err := Marshal(f); err == nil && !bytes.Equal(b, data) {
+			panic("frame did not round-trip")
+		}
+		return 1
+	}
+	fr := NewFramer(bytes.NewReader(data), ioutil.Discard)
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return 0
+		}
+		if err := DiscardPayload(f); err != nil {
+			return 0
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package frame
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// fuzzSeeds returns a serialized frame of every type
+func fuzzSeeds(t testing.TB) [][]byte {
+	var (
+		rst      Rst
+		data     Data
+		wndinc   WndInc
+		goaway   GoAway
+		settings Settings
+		ping     Ping
+		headers  Headers
+		ext      Extension
+		user     User
+	)
+	for _, err := range []error{
+		rst.PackWithDebug(1, 2, []byte("debug")),
+		data.Pack(3, []byte("hello"), true, true),
+		wndinc.Pack(5, 0x1000),
+		goaway.Pack(7, 1, []byte("bye")),
+		settings.Pack([]Setting{{SettingMaxFrameSize, 0x4000}, {SettingCompression, 1}}),
+		ping.Pack(0xdeadbeef, true),
+		headers.Pack(9, []Header{{"key", "value"}}, FlagHeadersSyn),
+		ext.Pack(0x10, 11, []byte("ext")),
+		user.Pack(TypeUserMin, 13, 0x5, []byte("user")),
+	} {
+		if err != nil {
+			t.Fatalf("Failed to pack seed frame: %v", err)
+		}
+	}
+
+	var seeds [][]byte
+	for _, f := range []Frame{&rst, &data, &wndinc, &goaway, &settings, &ping, &headers, &ext, &user} {
+		b, err := Marshal(f)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s frame: %v", f.Type(), err)
+		}
+		seeds = append(seeds, b)
+	}
+	return seeds
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, b := range fuzzSeeds(t) {
+		f, err := Parse(b)
+		if err != nil {
+			t.Fatalf("Failed to parse %x: %v", b, err)
+		}
+		out, err := Marshal(f)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s frame: %v", f.Type(), err)
+		}
+		if !bytes.Equal(out, b) {
+			t.Fatalf("%s frame did not round-trip. Got %x, expected %x", f.Type(), out, b)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	t.Parallel()
+	b := fuzzSeeds(t)[1]
+	if _, err := Parse(b[:len(b)-1]); err == nil {
+		t.Fatalf("Parsed truncated frame")
+	}
+	if _, err := Parse(append(b, 0)); err == nil {
+		t.Fatalf("Parsed frame with trailing bytes")
+	}
+}
+
+func TestReadPayload(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	fr := NewFramer(&buf, &buf)
+	for _, payload := range []string{"first", "second"} {
+		f := new(Data)
+		if err := f.Pack(1, []byte(payload), false, false); err != nil {
+			t.Fatalf("Failed to pack frame: %v", err)
+		}
+		if err := fr.WriteFrame(f); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+
+	rd := NewFramer(&buf, &buf)
+	f, err := rd.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if err := ReadPayload(f); err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	if buf.Len() != headerSize+len("second") {
+		t.Fatalf("Payload left %d bytes on the transport", buf.Len()-headerSize-len("second"))
+	}
+	got, err := ioutil.ReadAll(f.(*Data).Reader())
+	if err != nil || string(got) != "first" {
+		t.Fatalf("Read payload %q, %v, expected %q", got, err, "first")
+	}
+}
+
+func TestPeekPayload(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	fr := NewFramer(&buf, &buf)
+	f := new(Data)
+	if err := f.Pack(1, []byte("payload"), false, true); err != nil {
+		t.Fatalf("Failed to pack frame: %v", err)
+	}
+	if err := fr.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	read, err := NewFramer(&buf, &buf).ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{{3, "pay"}, {3, "pay"}, {100, "payload"}} {
+		if got, err := PeekPayload(read, tc.n); err != nil || string(got) != tc.want {
+			t.Fatalf("Peeked %q, %v, expected %q", got, err, tc.want)
+		}
+	}
+	got, err := ioutil.ReadAll(read.(*Data).Reader())
+	if err != nil || string(got) != "payload" {
+		t.Fatalf("Read payload %q, %v, expected %q", got, err, "payload")
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, b := range fuzzSeeds(f) {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr, err := Parse(b)
+		if err != nil {
+			return
+		}
+		out, err := Marshal(fr)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(out, b) {
+			t.Fatalf("%s frame did not round-trip. Got %x, expected %x", fr.Type(), out, b)
+		}
+	})
+}
+
+func FuzzFramer(f *testing.F) {
+	seeds := fuzzSeeds(f)
+	for _, b := range seeds {
+		f.Add(b)
+	}
+	f.Add(bytes.Join(seeds, nil))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr := NewFramer(bytes.NewReader(b), ioutil.Discard)
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if err := DiscardPayload(f); err != nil {
+				return
+			}
+		}
+	})
+}
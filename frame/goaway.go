@@ -8,6 +8,7 @@ type GoAway struct {
 	common
 	debugToWrite []byte
 	debugToRead  io.LimitedReader
+	vectored
 }
 
 func (f *GoAway) LastStreamId() StreamId {
@@ -33,18 +34,12 @@ func (f *GoAway) readFrom(rd io.Reader) error {
 		return protoError("GOAWAY stream id must be zero, not: %d", f.StreamId())
 	}
 	f.debugToRead.R = rd
-	f.debugToRead.N = int64(f.Length())
+	f.debugToRead.N = int64(f.Length() - goAwayFrameLength)
 	return nil
 }
 
-func (f *GoAway) writeTo(wr io.Writer) (err error) {
-	if err = f.common.writeTo(wr, goAwayFrameLength); err != nil {
-		return
-	}
-	if _, err = wr.Write(f.debugToWrite); err != nil {
-		return err
-	}
-	return
+func (f *GoAway) writeTo(wr io.Writer) error {
+	return f.writeVec(wr, f.b[:headerSize+goAwayFrameLength], f.debugToWrite)
 }
 
 func (f *GoAway) Pack(lastStreamId StreamId, errCode ErrorCode, debug []byte) (err error) {
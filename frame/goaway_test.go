@@ -1 +1,109 @@
 package frame
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+type goAwayTest struct {
+	lastStreamId     StreamId
+	errorCode        ErrorCode
+	debug            []byte
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *goAwayTest) FrameName() string         { return "GOAWAY" }
+func (t *goAwayTest) SerializeError() bool      { return t.serializeError }
+func (t *goAwayTest) DeserializeError() bool    { return t.deserializeError }
+func (t *goAwayTest) Serialized() []byte        { return t.serialized }
+func (t *goAwayTest) WithHeader(c common) Frame { return &GoAway{common: c} }
+func (t *goAwayTest) Pack() (Frame, error) {
+	var f GoAway
+	return &f, f.Pack(t.lastStreamId, t.errorCode, t.debug)
+}
+func (t *goAwayTest) Eq(fr Frame) error {
+	f, ok := fr.(*GoAway)
+	if !ok {
+		return fmt.Errorf("wrong frame type, expected GOAWAY!")
+	}
+	if f.LastStreamId() != t.lastStreamId {
+		return fmt.Errorf("expected last stream id %v but got %v", t.lastStreamId, f.LastStreamId())
+	}
+	if f.ErrorCode() != t.errorCode {
+		return fmt.Errorf("expected error code %v but got %v", t.errorCode, f.ErrorCode())
+	}
+	debug, err := ioutil.ReadAll(f.Debug())
+	if err != nil {
+		return fmt.Errorf("failed to read debug data: %v", err)
+	}
+	if !bytes.Equal(debug, t.debug) {
+		return fmt.Errorf("expected debug data %x but got %x", t.debug, debug)
+	}
+	return nil
+}
+
+func TestValidGoAwayFrames(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &goAwayTest{
+		lastStreamId: 0x49a1bb00,
+		errorCode:    0x5,
+		debug:        []byte("bye"),
+		serialized:   []byte{0x0, 0x0, 0xB, byte(TypeGoAway << 4), 0, 0, 0, 0, 0x49, 0xa1, 0xbb, 0x00, 0x0, 0x0, 0x0, 0x5, 'b', 'y', 'e'},
+	})
+	RunFrameTest(t, &goAwayTest{
+		lastStreamId: 0x0,
+		errorCode:    0x0,
+		debug:        []byte{},
+		serialized:   []byte{0x0, 0x0, 0x8, byte(TypeGoAway << 4), 0, 0, 0, 0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+	})
+}
+
+func TestGoAwayShortLength(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &goAwayTest{
+		serialized:       []byte{0x0, 0x0, 0x7, byte(TypeGoAway << 4), 0, 0, 0, 0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+		deserializeError: true,
+	})
+}
+
+func TestGoAwayNonZeroStream(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &goAwayTest{
+		serialized:       []byte{0x0, 0x0, 0x8, byte(TypeGoAway << 4), 0, 0, 0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+		deserializeError: true,
+	})
+}
+
+func TestGoAwayInvalidLastStreamId(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &goAwayTest{
+		lastStreamId:   streamMask + 1,
+		serializeError: true,
+	})
+}
+
+// the debug data must not extend into the next frame
+func TestGoAwayDebugLengthLimited(t *testing.T) {
+	t.Parallel()
+	gt := &goAwayTest{
+		lastStreamId: 0x3,
+		debug:        []byte("bye"),
+		serialized:   []byte{0x0, 0x0, 0xB, byte(TypeGoAway << 4), 0, 0, 0, 0, 0x0, 0x0, 0x0, 0x3, 0x0, 0x0, 0x0, 0x0, 'b', 'y', 'e'},
+	}
+	buf := bytes.NewBuffer(gt.serialized)
+	buf.Write([]byte("extra data that shouldn't be read"))
+	f := new(GoAway)
+	if err := f.common.readFrom(buf, MaxLength); err != nil {
+		t.Fatalf("failed read frame header: %v", err)
+	}
+	if err := f.readFrom(buf); err != nil {
+		t.Fatalf("failed to read goaway frame: %v", err)
+	}
+	if err := gt.Eq(f); err != nil {
+		t.Fatal(err)
+	}
+}
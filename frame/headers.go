@@ -0,0 +1,142 @@
+package frame
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// maximum length of a HEADERS frame's payload
+	maxHeadersLength = 0x4000 // 16KB
+
+	// size of the length prefixes of a header's key and value
+	headerFieldLengthSize = 2
+)
+
+const (
+	FlagHeadersFin        = 0x1
+	FlagHeadersSyn        = 0x2
+	FlagHeadersCompressed = 0x4
+	// FlagHeadersUnidirectional marks a stream opened by the frame as
+	// carrying data from its opener only
+	FlagHeadersUnidirectional = 0x8
+)
+
+// Header is a single key/value pair carried in a HEADERS frame
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Headers is a frame carrying a small block of key/value metadata for a
+// stream. A HEADERS frame with the SYN flag opens a new stream in place of a
+// DATA frame with the SYN flag. A HEADERS frame with the FIN flag carries the
+// stream's trailers and half-closes it in place of a DATA frame with the FIN
+// flag. Each header is serialized as its key and
+// value, each prefixed with its 16-bit length.
+type Headers struct {
+	common
+	headers []Header
+	toWrite []byte
+}
+
+func (f *Headers) Fin() bool {
+	return f.flags.IsSet(FlagHeadersFin)
+}
+
+func (f *Headers) Syn() bool {
+	return f.flags.IsSet(FlagHeadersSyn)
+}
+
+// Compressed returns true if the stream opened by the frame is compressed
+func (f *Headers) Compressed() bool {
+	return f.flags.IsSet(FlagHeadersCompressed)
+}
+
+// Unidirectional returns true if the stream opened by the frame carries data
+// from its opener only
+func (f *Headers) Unidirectional() bool {
+	return f.flags.IsSet(FlagHeadersUnidirectional)
+}
+
+// Headers returns the headers carried in the frame. The returned slice is
+// only valid until the next frame is read.
+func (f *Headers) Headers() []Header {
+	return f.headers
+}
+
+func (f *Headers) readFrom(rd io.Reader) error {
+	if f.length > maxHeadersLength {
+		return frameSizeError(f.length, "HEADERS")
+	}
+	if f.StreamId() == 0 {
+		return protoError("HEADERS stream id must not be zero")
+	}
+	var b [maxHeadersLength]byte
+	if _, err := io.ReadFull(rd, b[:f.length]); err != nil {
+		return err
+	}
+	f.headers = f.headers[:0]
+	for p := b[:f.length]; len(p) > 0; {
+		key, rest, ok := readHeaderField(p)
+		if !ok {
+			return protoError("malformed HEADERS frame")
+		}
+		value, rest, ok := readHeaderField(rest)
+		if !ok {
+			return protoError("malformed HEADERS frame")
+		}
+		f.headers = append(f.headers, Header{Key: key, Value: value})
+		p = rest
+	}
+	return nil
+}
+
+// readHeaderField reads a length-prefixed string from the front of p
+func readHeaderField(p []byte) (string, []byte, bool) {
+	if len(p) < headerFieldLengthSize {
+		return "", nil, false
+	}
+	n := int(order.Uint16(p))
+	p = p[headerFieldLengthSize:]
+	if len(p) < n {
+		return "", nil, false
+	}
+	return string(p[:n]), p[n:], true
+}
+
+func (f *Headers) writeTo(wr io.Writer) error {
+	if err := f.common.writeTo(wr, 0); err != nil {
+		return err
+	}
+	_, err := wr.Write(f.toWrite)
+	return err
+}
+
+func (f *Headers) Pack(streamId StreamId, headers []Header, flags Flags) (err error) {
+	length := 0
+	for _, h := range headers {
+		length += 2*headerFieldLengthSize + len(h.Key) + len(h.Value)
+		if len(h.Key) > 0xFFFF || len(h.Value) > 0xFFFF {
+			return fmt.Errorf("header too long: %q", h.Key)
+		}
+	}
+	if length > maxHeadersLength {
+		return fmt.Errorf("headers too long: %d bytes", length)
+	}
+	if err = f.common.pack(TypeHeaders, length, streamId, flags); err != nil {
+		return
+	}
+	f.toWrite = make([]byte, 0, length)
+	for _, h := range headers {
+		f.toWrite = appendHeaderField(f.toWrite, h.Key)
+		f.toWrite = appendHeaderField(f.toWrite, h.Value)
+	}
+	f.headers = append(f.headers[:0], headers...)
+	return
+}
+
+func appendHeaderField(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
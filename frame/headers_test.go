@@ -0,0 +1,87 @@
+package frame
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type headersTest struct {
+	streamId         StreamId
+	headers          []Header
+	flags            Flags
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *headersTest) FrameName() string         { return "HEADERS" }
+func (t *headersTest) SerializeError() bool      { return t.serializeError }
+func (t *headersTest) DeserializeError() bool    { return t.deserializeError }
+func (t *headersTest) Serialized() []byte        { return t.serialized }
+func (t *headersTest) WithHeader(c common) Frame { return &Headers{common: c} }
+func (t *headersTest) Pack() (Frame, error) {
+	var f Headers
+	return &f, f.Pack(t.streamId, t.headers, t.flags)
+}
+func (t *headersTest) Eq(fr Frame) error {
+	f := fr.(*Headers)
+	if f.Flags() != t.flags {
+		return fmt.Errorf("wrong flags. expected %x, got %x", t.flags, f.Flags())
+	}
+	if len(t.headers) == 0 && len(f.Headers()) == 0 {
+		return nil
+	}
+	if !reflect.DeepEqual(t.headers, f.Headers()) {
+		return fmt.Errorf("expected headers %v but got %v", t.headers, f.Headers())
+	}
+	return nil
+}
+
+func TestHeadersFrameValid(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &headersTest{
+		streamId:   0x3,
+		headers:    []Header{{"k", "v1"}, {"", ""}},
+		flags:      FlagHeadersSyn,
+		serialized: []byte{0x0, 0x0, 0xB, byte(TypeHeaders<<4) | FlagHeadersSyn, 0, 0, 0, 0x3, 0x0, 0x1, 'k', 0x0, 0x2, 'v', '1', 0x0, 0x0, 0x0, 0x0},
+	})
+	RunFrameTest(t, &headersTest{
+		streamId:   0x1,
+		serialized: []byte{0x0, 0x0, 0x0, byte(TypeHeaders << 4), 0, 0, 0, 0x1},
+	})
+	RunFrameTest(t, &headersTest{
+		streamId:   0x2,
+		headers:    []Header{{"status", "ok"}},
+		flags:      FlagHeadersFin,
+		serialized: []byte{0x0, 0x0, 0xC, byte(TypeHeaders<<4) | FlagHeadersFin, 0, 0, 0, 0x2, 0x0, 0x6, 's', 't', 'a', 't', 'u', 's', 0x0, 0x2, 'o', 'k'},
+	})
+}
+
+func TestHeadersMalformed(t *testing.T) {
+	t.Parallel()
+	// the value's length prefix claims more bytes than remain
+	RunFrameTest(t, &headersTest{
+		streamId:         0x1,
+		serialized:       []byte{0x0, 0x0, 0x5, byte(TypeHeaders << 4), 0, 0, 0, 0x1, 0x0, 0x1, 'k', 0x0, 0x2},
+		deserializeError: true,
+	})
+}
+
+func TestHeadersZeroStreamId(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &headersTest{
+		serialized:       []byte{0x0, 0x0, 0x0, byte(TypeHeaders << 4), 0, 0, 0, 0},
+		deserializeError: true,
+	})
+}
+
+func TestHeadersTooLong(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &headersTest{
+		streamId:       0x1,
+		headers:        []Header{{"k", strings.Repeat("v", maxHeadersLength)}},
+		serializeError: true,
+	})
+}
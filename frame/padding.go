@@ -0,0 +1,42 @@
+package frame
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// zeros is written as the payload of PADDING frames
+var zeros [0x1000]byte
+
+// Padding is a frame whose payload of zeros carries no information. It pads
+// the frames before it up to a size that hides their real one from observers
+// of the transport. Receivers discard it; it is only sent to peers that
+// advertised SettingPadding.
+type Padding struct {
+	common
+}
+
+func (f *Padding) readFrom(rd io.Reader) error {
+	if f.StreamId() != 0 {
+		return protoError("PADDING stream id must be zero, not: %d", f.StreamId())
+	}
+	_, err := io.CopyN(ioutil.Discard, rd, int64(f.length))
+	return err
+}
+
+func (f *Padding) writeTo(wr io.Writer) error {
+	if err := f.common.writeTo(wr, 0); err != nil {
+		return err
+	}
+	for n := int(f.length); n > 0; n -= len(zeros) {
+		if _, err := wr.Write(zeros[:min(n, len(zeros))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pack packs a PADDING frame with a payload of length zeros
+func (f *Padding) Pack(length int) error {
+	return f.common.pack(TypePadding, length, 0, 0)
+}
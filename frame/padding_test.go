@@ -0,0 +1,62 @@
+package frame
+
+import (
+	"fmt"
+	"testing"
+)
+
+type paddingTest struct {
+	length           int
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *paddingTest) FrameName() string         { return "PADDING" }
+func (t *paddingTest) SerializeError() bool      { return t.serializeError }
+func (t *paddingTest) DeserializeError() bool    { return t.deserializeError }
+func (t *paddingTest) Serialized() []byte        { return t.serialized }
+func (t *paddingTest) WithHeader(c common) Frame { return &Padding{common: c} }
+func (t *paddingTest) Pack() (Frame, error) {
+	var f Padding
+	return &f, f.Pack(t.length)
+}
+func (t *paddingTest) Eq(fr Frame) error {
+	f := fr.(*Padding)
+	if int(f.Length()) != t.length {
+		return fmt.Errorf("wrong length. expected %d, got %d", t.length, f.Length())
+	}
+	return nil
+}
+
+func TestPaddingFrameValid(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &paddingTest{
+		length:     4,
+		serialized: []byte{0x0, 0x0, 0x4, byte(TypePadding << 4), 0, 0, 0, 0, 0, 0, 0, 0},
+	})
+	RunFrameTest(t, &paddingTest{
+		length:     0,
+		serialized: []byte{0x0, 0x0, 0x0, byte(TypePadding << 4), 0, 0, 0, 0},
+	})
+	// longer than the buffer of zeros it is written from
+	RunFrameTest(t, &paddingTest{
+		length:     len(zeros) + 1,
+		serialized: append([]byte{0x0, 0x10, 0x1, byte(TypePadding << 4), 0, 0, 0, 0}, make([]byte, len(zeros)+1)...),
+	})
+}
+
+func TestPaddingFrameInvalid(t *testing.T) {
+	t.Parallel()
+	// stream id must be zero
+	RunFrameTest(t, &paddingTest{
+		length:           0,
+		serialized:       []byte{0x0, 0x0, 0x0, byte(TypePadding << 4), 0, 0, 0, 1},
+		deserializeError: true,
+	})
+	// too long to express
+	RunFrameTest(t, &paddingTest{
+		length:         MaxLength + 1,
+		serializeError: true,
+	})
+}
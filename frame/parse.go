@@ -0,0 +1,167 @@
+package frame
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Parse parses b, which must hold exactly one serialized frame, and returns
+// the frame. Unlike the frames returned by a Framer, the returned frame is
+// not reused and can be serialized again with Marshal. It refers to b, which
+// must not be modified while the frame is in use.
+//
+// Parse is intended for tools and fuzzing; sessions read frames from a Framer.
+func Parse(b []byte) (Frame, error) {
+	if len(b) < headerSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	hdrLen, length := headerSize, int(uint32(b[0])<<16|uint32(b[1])<<8|uint32(b[2]))
+	if hdr := (common{ftype: Type(b[3] >> 4), flags: Flags(b[3] & flagsMask)}); hdr.extended() {
+		hdrLen += extendedLengthSize
+		if len(b) < hdrLen {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if n := order.Uint64(b[headerSize:]); n <= MaxExtendedLength {
+			length = int(n)
+		}
+	}
+	switch {
+	case len(b) < hdrLen+length:
+		return nil, io.ErrUnexpectedEOF
+	case len(b) > hdrLen+length:
+		return nil, fmt.Errorf("%d trailing bytes after frame", len(b)-hdrLen-length)
+	}
+	payload := b[hdrLen:]
+
+	rd := bytes.NewReader(b)
+	f, err := (&framer{Reader: rd, maxLength: MaxExtendedLength}).readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	// payloads that a framer hands up as readers are read here so that the
+	// frame can be written again
+	switch f := f.(type) {
+	case *Data:
+		f.toWrite = payload
+		f.toRead = io.LimitedReader{R: bytes.NewReader(payload), N: int64(len(payload))}
+	case *Rst:
+		if len(payload) > rstFrameLength {
+			f.debugToWrite = payload[rstFrameLength:]
+		}
+	case *GoAway:
+		debug := payload[goAwayFrameLength:]
+		f.debugToWrite = debug
+		f.debugToRead = io.LimitedReader{R: bytes.NewReader(debug), N: int64(len(debug))}
+	case *Settings:
+		f.toWrite = payload
+	case *Headers:
+		f.toWrite = payload
+	case *Extension:
+		data := payload[extensionIdLength:]
+		f.toWrite = data
+		f.toRead = io.LimitedReader{R: bytes.NewReader(data), N: int64(len(data))}
+	case *User:
+		f.toWrite = payload
+		f.toRead = io.LimitedReader{R: bytes.NewReader(payload), N: int64(len(payload))}
+	case *Unknown:
+		f.toRead = io.LimitedReader{R: bytes.NewReader(payload), N: int64(len(payload))}
+	}
+	return f, nil
+}
+
+// Marshal returns the serialization of f. Frames of unknown type cannot be
+// serialized.
+func Marshal(f Frame) ([]byte, error) {
+	if _, ok := f.(*Unknown); ok {
+		return nil, fmt.Errorf("cannot marshal frame of unknown type 0x%x", uint8(f.Type()))
+	}
+	var buf bytes.Buffer
+	if err := f.writeTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadPayload reads the part of a frame's payload that a framer hands up as a
+// reader, e.g. the data of a DATA frame, into memory and hands it up from
+// there instead. The framer can then read the next frame while f is still in
+// use. It is intended for tools that need every frame's bytes at the time it
+// is read, like captures.
+func ReadPayload(f Frame) error {
+	r := payloadReader(f)
+	if r == nil {
+		return nil
+	}
+	n := r.N
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) < n {
+		return io.ErrUnexpectedEOF
+	}
+	*r = io.LimitedReader{R: bytes.NewReader(b), N: int64(len(b))}
+	return nil
+}
+
+// PeekPayload returns up to the first n bytes of the part of a frame's
+// payload that a framer hands up as a reader without consuming them. Like
+// ReadPayload, it reads that part into memory.
+func PeekPayload(f Frame, n int) ([]byte, error) {
+	if err := ReadPayload(f); err != nil {
+		return nil, err
+	}
+	r := payloadReader(f)
+	if r == nil {
+		return nil, nil
+	}
+	if int64(n) > r.N {
+		n = int(r.N)
+	}
+	b := make([]byte, n)
+	// ReadPayload left a reader positioned at the start of the payload
+	if _, err := r.R.(*bytes.Reader).ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+// payloadReader returns the reader of the part of f's payload that a framer
+// hands up, or nil if it has none
+func payloadReader(f Frame) *io.LimitedReader {
+	switch f := f.(type) {
+	case *Data:
+		return &f.toRead
+	case *GoAway:
+		return &f.debugToRead
+	case *Extension:
+		return &f.toRead
+	case *User:
+		return &f.toRead
+	case *Unknown:
+		return &f.toRead
+	}
+	return nil
+}
+
+// DiscardPayload reads and discards the part of a frame's payload that a
+// framer hands up as a reader, e.g. the data of a DATA frame, so that the
+// next frame can be read
+func DiscardPayload(f Frame) (err error) {
+	switch f := f.(type) {
+	case *Data:
+		_, err = io.Copy(ioutil.Discard, f.Reader())
+	case *GoAway:
+		_, err = io.Copy(ioutil.Discard, f.Debug())
+	case *Extension:
+		_, err = io.Copy(ioutil.Discard, f.Reader())
+	case *User:
+		_, err = io.Copy(ioutil.Discard, f.Reader())
+	case *Unknown:
+		_, err = io.Copy(ioutil.Discard, f.PayloadReader())
+	}
+	return
+}
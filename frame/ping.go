@@ -0,0 +1,57 @@
+package frame
+
+import "io"
+
+const (
+	pingFrameLength = 8
+)
+
+const (
+	FlagPingAck = 0x1
+)
+
+// Ping is a frame used to check that the remote side of a session is still
+// alive. The receiver of a PING replies with a PING carrying the same opaque
+// data and the ACK flag set.
+type Ping struct {
+	common
+}
+
+// Data returns the frame's 8 bytes of opaque data
+func (f *Ping) Data() uint64 {
+	return order.Uint64(f.body())
+}
+
+// Ack returns true if this frame is a reply to a PING
+func (f *Ping) Ack() bool {
+	return f.Flags().IsSet(FlagPingAck)
+}
+
+func (f *Ping) readFrom(rd io.Reader) error {
+	if f.length != pingFrameLength {
+		return frameSizeError(f.length, "PING")
+	}
+	if _, err := io.ReadFull(rd, f.body()[:pingFrameLength]); err != nil {
+		return err
+	}
+	if f.StreamId() != 0 {
+		return protoError("PING stream id must be zero, not: %d", f.StreamId())
+	}
+	return nil
+}
+
+func (f *Ping) writeTo(wr io.Writer) error {
+	return f.common.writeTo(wr, pingFrameLength)
+}
+
+func (f *Ping) Pack(data uint64, ack bool) (err error) {
+	var flags Flags
+	if ack {
+		flags.Set(FlagPingAck)
+	}
+	if err = f.common.pack(TypePing, pingFrameLength, 0, flags); err != nil {
+		return
+	}
+	order.PutUint64(f.body(), data)
+	return
+}
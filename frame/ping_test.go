@@ -0,0 +1,82 @@
+package frame
+
+import (
+	"fmt"
+	"testing"
+)
+
+type pingTest struct {
+	data             uint64
+	ack              bool
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *pingTest) FrameName() string         { return "PING" }
+func (t *pingTest) SerializeError() bool      { return t.serializeError }
+func (t *pingTest) DeserializeError() bool    { return t.deserializeError }
+func (t *pingTest) Serialized() []byte        { return t.serialized }
+func (t *pingTest) WithHeader(c common) Frame { return &Ping{common: c} }
+func (t *pingTest) Pack() (Frame, error) {
+	var f Ping
+	return &f, f.Pack(t.data, t.ack)
+}
+func (t *pingTest) Eq(fr Frame) error {
+	f := fr.(*Ping)
+	if f.Data() != t.data {
+		return fmt.Errorf("wrong data. expected %x, got %x", t.data, f.Data())
+	}
+	if f.Ack() != t.ack {
+		return fmt.Errorf("wrong ack flag. expected %v, got %v", t.ack, f.Ack())
+	}
+	return nil
+}
+
+func TestPingFrameValid(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &pingTest{
+		data:             0x0102030405060708,
+		ack:              false,
+		serialized:       []byte{0x0, 0x0, 0x8, byte(TypePing << 4), 0, 0, 0, 0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8},
+		serializeError:   false,
+		deserializeError: false,
+	})
+	RunFrameTest(t, &pingTest{
+		data:             0xFFFFFFFFFFFFFFFF,
+		ack:              true,
+		serialized:       []byte{0x0, 0x0, 0x8, byte(TypePing<<4) | FlagPingAck, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		serializeError:   false,
+		deserializeError: false,
+	})
+}
+
+func TestPingNonZeroStreamId(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &pingTest{
+		data:             0x1,
+		serialized:       []byte{0x0, 0x0, 0x8, byte(TypePing << 4), 0, 0, 0, 0x1, 0, 0, 0, 0, 0, 0, 0, 0x1},
+		serializeError:   false,
+		deserializeError: true,
+	})
+}
+
+func TestBadLengthPing(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &pingTest{
+		data:             0x1,
+		serialized:       []byte{0x0, 0x0, 0x4, byte(TypePing << 4), 0, 0, 0, 0, 0, 0, 0, 0x1},
+		serializeError:   false,
+		deserializeError: true,
+	})
+}
+
+func TestShortReadPing(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &pingTest{
+		data:             0x1,
+		serialized:       []byte{0x0, 0x0, 0x8, byte(TypePing << 4), 0, 0, 0, 0, 0, 0, 0x1},
+		serializeError:   false,
+		deserializeError: true,
+	})
+}
@@ -0,0 +1,81 @@
+package frame
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	priorityFrameLength = 5 // dependency stream id and weight
+)
+
+const (
+	FlagPriorityExclusive = 0x1
+)
+
+// Priority is a frame that asks the remote side to change how it prioritizes
+// sending the data of a stream. Like in HTTP/2, every stream depends on
+// another stream, or on stream zero, and is sent data only once the streams
+// it depends on cannot make progress. Streams depending on the same stream
+// share the bandwidth in proportion to their weights.
+type Priority struct {
+	common
+}
+
+// Dependency returns the id of the stream that the frame's stream depends on
+func (f *Priority) Dependency() StreamId {
+	return StreamId(order.Uint32(f.body()) & streamMask)
+}
+
+// Weight returns the stream's weight, between 1 and 256
+func (f *Priority) Weight() int {
+	return int(f.body()[4]) + 1
+}
+
+// Exclusive returns true if the stream becomes the only dependent of its
+// dependency, taking its other dependents as its own
+func (f *Priority) Exclusive() bool {
+	return f.Flags().IsSet(FlagPriorityExclusive)
+}
+
+func (f *Priority) readFrom(rd io.Reader) error {
+	if f.length != priorityFrameLength {
+		return frameSizeError(f.length, "PRIORITY")
+	}
+	if _, err := io.ReadFull(rd, f.body()[:priorityFrameLength]); err != nil {
+		return err
+	}
+	if f.StreamId() == 0 {
+		return protoError("PRIORITY stream id must not be zero")
+	}
+	if f.Dependency() == f.StreamId() {
+		return protoStreamError("PRIORITY stream %d must not depend on itself", f.StreamId())
+	}
+	return nil
+}
+
+func (f *Priority) writeTo(wr io.Writer) error {
+	return f.common.writeTo(wr, priorityFrameLength)
+}
+
+func (f *Priority) Pack(streamId, dependency StreamId, weight int, exclusive bool) (err error) {
+	if weight < 1 || weight > 256 {
+		return fmt.Errorf("invalid priority weight: %d", weight)
+	}
+	if dependency > streamMask {
+		return fmt.Errorf("invalid priority dependency: %d", dependency)
+	}
+	if dependency == streamId {
+		return fmt.Errorf("stream %d cannot depend on itself", streamId)
+	}
+	var flags Flags
+	if exclusive {
+		flags.Set(FlagPriorityExclusive)
+	}
+	if err = f.common.pack(TypePriority, priorityFrameLength, streamId, flags); err != nil {
+		return
+	}
+	order.PutUint32(f.body(), uint32(dependency))
+	f.body()[4] = byte(weight - 1)
+	return
+}
@@ -0,0 +1,105 @@
+package frame
+
+import (
+	"fmt"
+	"testing"
+)
+
+type priorityTest struct {
+	streamId         StreamId
+	dependency       StreamId
+	weight           int
+	exclusive        bool
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *priorityTest) FrameName() string         { return "PRIORITY" }
+func (t *priorityTest) SerializeError() bool      { return t.serializeError }
+func (t *priorityTest) DeserializeError() bool    { return t.deserializeError }
+func (t *priorityTest) Serialized() []byte        { return t.serialized }
+func (t *priorityTest) WithHeader(c common) Frame { return &Priority{common: c} }
+func (t *priorityTest) Pack() (Frame, error) {
+	var f Priority
+	return &f, f.Pack(t.streamId, t.dependency, t.weight, t.exclusive)
+}
+func (t *priorityTest) Eq(fr Frame) error {
+	f := fr.(*Priority)
+	if f.Dependency() != t.dependency {
+		return fmt.Errorf("wrong dependency. expected %d, got %d", t.dependency, f.Dependency())
+	}
+	if f.Weight() != t.weight {
+		return fmt.Errorf("wrong weight. expected %d, got %d", t.weight, f.Weight())
+	}
+	if f.Exclusive() != t.exclusive {
+		return fmt.Errorf("wrong exclusive flag. expected %v, got %v", t.exclusive, f.Exclusive())
+	}
+	return nil
+}
+
+func TestPriorityFrameValid(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &priorityTest{
+		streamId:   0x3,
+		dependency: 0x0,
+		weight:     16,
+		serialized: []byte{0x0, 0x0, 0x5, byte(TypePriority << 4), 0, 0, 0, 0x3, 0, 0, 0, 0, 0xF},
+	})
+	RunFrameTest(t, &priorityTest{
+		streamId:   0x5,
+		dependency: streamMask,
+		weight:     256,
+		exclusive:  true,
+		serialized: []byte{0x0, 0x0, 0x5, byte(TypePriority<<4) | FlagPriorityExclusive, 0, 0, 0, 0x5, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF},
+	})
+}
+
+func TestPriorityInvalidWeight(t *testing.T) {
+	t.Parallel()
+	for _, weight := range []int{0, 257} {
+		RunFrameTest(t, &priorityTest{
+			streamId:       0x3,
+			weight:         weight,
+			serializeError: true,
+		})
+	}
+}
+
+func TestPrioritySelfDependency(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &priorityTest{
+		streamId:         0x3,
+		dependency:       0x3,
+		weight:           1,
+		serialized:       []byte{0x0, 0x0, 0x5, byte(TypePriority << 4), 0, 0, 0, 0x3, 0, 0, 0, 0x3, 0x0},
+		deserializeError: true,
+	})
+	RunFrameTest(t, &priorityTest{
+		streamId:       0x3,
+		dependency:     0x3,
+		weight:         1,
+		serializeError: true,
+	})
+}
+
+func TestPriorityZeroStreamId(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &priorityTest{
+		streamId:         0x0,
+		dependency:       0x3,
+		weight:           1,
+		serialized:       []byte{0x0, 0x0, 0x5, byte(TypePriority << 4), 0, 0, 0, 0, 0, 0, 0, 0x3, 0x0},
+		deserializeError: true,
+	})
+}
+
+func TestBadLengthPriority(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &priorityTest{
+		streamId:         0x3,
+		weight:           1,
+		serialized:       []byte{0x0, 0x0, 0x4, byte(TypePriority << 4), 0, 0, 0, 0x3, 0, 0, 0, 0},
+		deserializeError: true,
+	})
+}
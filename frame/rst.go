@@ -4,19 +4,35 @@ import "io"
 
 const (
 	rstFrameLength = 4
+
+	// maximum length of the optional debug data following an RST's error code
+	maxRstDebugLength = 0x1000
 )
 
-// Rst is a frame sent to forcibly close a stream
+// Rst is a frame sent to forcibly close a stream. It may optionally carry
+// debug data describing why the stream was reset.
 type Rst struct {
 	common
+	debug        []byte // debug data read from the transport
+	debugToWrite []byte // debug data to write
+	vectored
 }
 
 func (f *Rst) ErrorCode() ErrorCode {
 	return ErrorCode(order.Uint32(f.body()))
 }
 
+// Debug returns the frame's debug data. When reading, the returned slice is
+// only valid until the next frame is read.
+func (f *Rst) Debug() []byte {
+	if f.debugToWrite != nil {
+		return f.debugToWrite
+	}
+	return f.debug
+}
+
 func (f *Rst) readFrom(rd io.Reader) (err error) {
-	if f.length != rstFrameLength {
+	if f.length < rstFrameLength || f.length > rstFrameLength+maxRstDebugLength {
 		return frameSizeError(f.length, "RST")
 	}
 	if _, err = io.ReadFull(rd, f.body()[:rstFrameLength]); err != nil {
@@ -25,17 +41,39 @@ func (f *Rst) readFrom(rd io.Reader) (err error) {
 	if f.StreamId() == 0 {
 		return protoError("RST stream id must not be zero")
 	}
+	n := int(f.length - rstFrameLength)
+	if cap(f.debug) < n {
+		f.debug = make([]byte, n)
+	}
+	f.debug = f.debug[:n]
+	if _, err = io.ReadFull(rd, f.debug); err != nil {
+		return err
+	}
 	return
 }
 
 func (f *Rst) writeTo(wr io.Writer) (err error) {
-	return f.common.writeTo(wr, rstFrameLength)
+	if len(f.debugToWrite) == 0 {
+		return f.common.writeTo(wr, rstFrameLength)
+	}
+	return f.writeVec(wr, f.b[:headerSize+rstFrameLength], f.debugToWrite)
 }
 
 func (f *Rst) Pack(streamId StreamId, errorCode ErrorCode) (err error) {
-	if err = f.common.pack(TypeRst, rstFrameLength, streamId, 0); err != nil {
+	return f.PackWithDebug(streamId, errorCode, nil)
+}
+
+// PackWithDebug packs an RST frame carrying debug data after the error code.
+// Peers that predate RST debug data reject RST frames that carry it, so it
+// should only be sent to peers known to support it.
+func (f *Rst) PackWithDebug(streamId StreamId, errorCode ErrorCode, debug []byte) (err error) {
+	if len(debug) > maxRstDebugLength {
+		return frameSizeError(uint32(rstFrameLength+len(debug)), "RST")
+	}
+	if err = f.common.pack(TypeRst, rstFrameLength+len(debug), streamId, 0); err != nil {
 		return
 	}
 	order.PutUint32(f.body(), uint32(errorCode))
+	f.debugToWrite = debug
 	return
 }
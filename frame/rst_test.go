@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 )
@@ -8,6 +9,7 @@ import (
 type rstTest struct {
 	streamId         StreamId
 	errorCode        ErrorCode
+	debug            []byte
 	serialized       []byte
 	serializeError   bool
 	deserializeError bool
@@ -20,7 +22,7 @@ func (r *rstTest) Serialized() []byte        { return r.serialized }
 func (r *rstTest) WithHeader(c common) Frame { return &Rst{common: c} }
 func (r *rstTest) Pack() (Frame, error) {
 	var f Rst
-	return &f, f.Pack(r.streamId, r.errorCode)
+	return &f, f.PackWithDebug(r.streamId, r.errorCode, r.debug)
 }
 func (r *rstTest) Eq(f Frame) error {
 	fr, ok := f.(*Rst)
@@ -30,6 +32,9 @@ func (r *rstTest) Eq(f Frame) error {
 	if r.errorCode != fr.ErrorCode() {
 		return fmt.Errorf("expected error code %v but got %v", r.errorCode, fr.ErrorCode())
 	}
+	if !bytes.Equal(r.debug, fr.Debug()) {
+		return fmt.Errorf("expected debug data %x but got %x", r.debug, fr.Debug())
+	}
 	return nil
 }
 
@@ -83,3 +88,33 @@ func TestRstShortPayload(t *testing.T) {
 		deserializeError: true,
 	})
 }
+
+func TestRstWithDebug(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &rstTest{
+		streamId:         0x49a1bb00,
+		errorCode:        0x5,
+		debug:            []byte("quota"),
+		serialized:       []byte{0x0, 0x0, 0x9, byte(TypeRst << 4), 0x49, 0xa1, 0xbb, 0x00, 0x0, 0x0, 0x0, 0x5, 'q', 'u', 'o', 't', 'a'},
+		serializeError:   false,
+		deserializeError: false,
+	})
+}
+
+func TestRstDebugTooLong(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &rstTest{
+		streamId:         0x49a1bb00,
+		errorCode:        0x5,
+		debug:            make([]byte, maxRstDebugLength+1),
+		serializeError:   true,
+		deserializeError: false,
+	})
+	RunFrameTest(t, &rstTest{
+		streamId:         0x49a1bb00,
+		errorCode:        0x5,
+		serialized:       []byte{0x0, 0x10, 0x05, byte(TypeRst << 4), 0x49, 0xa1, 0xbb, 0x00, 0x0, 0x0, 0x0, 0x5},
+		serializeError:   false,
+		deserializeError: true,
+	})
+}
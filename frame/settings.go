@@ -0,0 +1,113 @@
+package frame
+
+import "io"
+
+const (
+	settingLength = 6
+
+	// maximum number of settings accepted in a single SETTINGS frame
+	maxSettings = 64
+)
+
+// SettingId is a 16-bit integer identifying a parameter carried in a SETTINGS frame
+type SettingId uint16
+
+const (
+	// The maximum DATA frame payload size the sender is willing to receive
+	SettingMaxFrameSize SettingId = 0x1
+	// Non-zero if the sender accepts streams whose data is compressed, with
+	// the bit of each codec id it accepts set. Bit 0 is DEFLATE, the only
+	// codec of senders that advertise 1.
+	SettingCompression SettingId = 0x2
+	// Negotiates per-frame CRC32C checksums, see ChecksumFramer
+	SettingChecksums SettingId = 0x3
+	// The maximum DATA frame payload size the sender is willing to receive if
+	// it is larger than MaxLength, in which case the sender accepts DATA
+	// frames with extended lengths. It overrides SettingMaxFrameSize.
+	SettingExtendedLength SettingId = 0x4
+	// The range of protocol versions the sender supports, the lowest in the
+	// upper 16 bits and the highest in the lower 16 bits. It is sent in the
+	// first frame of a session; senders that don't send it only support
+	// version 1.
+	SettingVersion SettingId = 0x5
+	// Non-zero if the sender asks the remote side to acknowledge each stream
+	// the sender opens with a WNDINC frame with FlagWndIncAck once it
+	// accepted the stream. Peers that speak protocol versions before 3
+	// ignore it.
+	SettingStreamAcks SettingId = 0x6
+	// Non-zero if the sender accepts PADDING frames, see Padding. Peers that
+	// don't understand PADDING frames never advertise it.
+	SettingPadding SettingId = 0x7
+	// Non-zero if the sender accepts WNDINC frames with FlagWndIncShrink.
+	// Peers that don't understand the flag never advertise it.
+	SettingWindowShrink SettingId = 0x8
+
+	// Settings with the top bit set advertise support for the protocol
+	// extension whose id is in the remaining bits
+	SettingExtensionBase SettingId = 0x8000
+)
+
+// Setting is a single identifier/value pair carried in a SETTINGS frame
+type Setting struct {
+	Id    SettingId
+	Value uint32
+}
+
+// Settings is a frame sent to inform the remote side of the session of
+// configuration parameters. Settings the receiver does not understand are ignored.
+type Settings struct {
+	common
+	settings []Setting
+	toWrite  []byte
+}
+
+// Settings returns the settings carried in the frame. The returned slice is
+// only valid until the next frame is read.
+func (f *Settings) Settings() []Setting {
+	return f.settings
+}
+
+func (f *Settings) readFrom(rd io.Reader) error {
+	if f.length%settingLength != 0 || f.length > maxSettings*settingLength {
+		return frameSizeError(f.length, "SETTINGS")
+	}
+	if f.StreamId() != 0 {
+		return protoError("SETTINGS stream id must be zero, not: %d", f.StreamId())
+	}
+	var b [maxSettings * settingLength]byte
+	if _, err := io.ReadFull(rd, b[:f.length]); err != nil {
+		return err
+	}
+	f.settings = f.settings[:0]
+	for i := 0; i < int(f.length); i += settingLength {
+		f.settings = append(f.settings, Setting{
+			Id:    SettingId(order.Uint16(b[i:])),
+			Value: order.Uint32(b[i+2:]),
+		})
+	}
+	return nil
+}
+
+func (f *Settings) writeTo(wr io.Writer) error {
+	if err := f.common.writeTo(wr, 0); err != nil {
+		return err
+	}
+	_, err := wr.Write(f.toWrite)
+	return err
+}
+
+func (f *Settings) Pack(settings []Setting) (err error) {
+	if len(settings) > maxSettings {
+		return frameSizeError(uint32(len(settings)*settingLength), "SETTINGS")
+	}
+	if err = f.common.pack(TypeSettings, len(settings)*settingLength, 0, 0); err != nil {
+		return
+	}
+	f.toWrite = make([]byte, len(settings)*settingLength)
+	for i, s := range settings {
+		order.PutUint16(f.toWrite[i*settingLength:], uint16(s.Id))
+		order.PutUint32(f.toWrite[i*settingLength+2:], s.Value)
+	}
+	f.settings = append(f.settings[:0], settings...)
+	return
+}
@@ -0,0 +1,80 @@
+package frame
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type settingsTest struct {
+	streamId         StreamId
+	settings         []Setting
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *settingsTest) FrameName() string         { return "SETTINGS" }
+func (t *settingsTest) SerializeError() bool      { return t.serializeError }
+func (t *settingsTest) DeserializeError() bool    { return t.deserializeError }
+func (t *settingsTest) Serialized() []byte        { return t.serialized }
+func (t *settingsTest) WithHeader(c common) Frame { return &Settings{common: c} }
+func (t *settingsTest) Pack() (Frame, error) {
+	var f Settings
+	return &f, f.Pack(t.settings)
+}
+func (t *settingsTest) Eq(fr Frame) error {
+	f, ok := fr.(*Settings)
+	if !ok {
+		return fmt.Errorf("wrong frame type, expected SETTINGS!")
+	}
+	if len(t.settings) == 0 && len(f.Settings()) == 0 {
+		return nil
+	}
+	if !reflect.DeepEqual(t.settings, f.Settings()) {
+		return fmt.Errorf("expected settings %v but got %v", t.settings, f.Settings())
+	}
+	return nil
+}
+
+func TestValidSettingsFrames(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &settingsTest{
+		settings:   []Setting{{SettingMaxFrameSize, 0x4000}},
+		serialized: []byte{0x0, 0x0, 0x6, byte(TypeSettings << 4), 0, 0, 0, 0, 0x0, 0x1, 0x0, 0x0, 0x40, 0x0},
+	})
+	RunFrameTest(t, &settingsTest{
+		settings:   []Setting{{SettingMaxFrameSize, 0x1}, {0xFFFF, 0xFFFFFFFF}},
+		serialized: []byte{0x0, 0x0, 0xC, byte(TypeSettings << 4), 0, 0, 0, 0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x1, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+	})
+	RunFrameTest(t, &settingsTest{
+		settings:   []Setting{},
+		serialized: []byte{0x0, 0x0, 0x0, byte(TypeSettings << 4), 0, 0, 0, 0},
+	})
+}
+
+func TestSettingsBadLength(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &settingsTest{
+		settings:         []Setting{{SettingMaxFrameSize, 0x4000}},
+		serialized:       []byte{0x0, 0x0, 0x5, byte(TypeSettings << 4), 0, 0, 0, 0, 0x0, 0x1, 0x0, 0x0, 0x40},
+		deserializeError: true,
+	})
+}
+
+func TestSettingsNonZeroStream(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &settingsTest{
+		settings:         []Setting{{SettingMaxFrameSize, 0x4000}},
+		serialized:       []byte{0x0, 0x0, 0x6, byte(TypeSettings << 4), 0, 0, 0, 0x1, 0x0, 0x1, 0x0, 0x0, 0x40, 0x0},
+		deserializeError: true,
+	})
+}
+
+func TestSettingsTooMany(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &settingsTest{
+		settings:       make([]Setting, maxSettings+1),
+		serializeError: true,
+	})
+}
@@ -55,7 +55,7 @@ func runSerializeTest(t *testing.T, ft FrameTest, expectError bool) {
 func runDeserializeTest(t *testing.T, ft FrameTest, expectError bool) {
 	buf := bytes.NewReader(ft.Serialized())
 	var c common
-	if err := c.readFrom(buf); err != nil {
+	if err := c.readFrom(buf, MaxLength); err != nil {
 		t.Errorf("failed read %s frame header: %v, %+v", ft.FrameName(), err, ft)
 		return
 	}
@@ -0,0 +1,49 @@
+package frame
+
+import (
+	"fmt"
+	"io"
+)
+
+// User is a frame of one of the types reserved for applications, from
+// TypeUserMin to TypeUserMax. The protocol leaves its stream id, flags and
+// payload to the application.
+type User struct {
+	common
+	toRead  io.LimitedReader
+	toWrite []byte
+	vectored
+}
+
+// IsUserType returns true if t is reserved for applications
+func IsUserType(t Type) bool {
+	return t >= TypeUserMin && t <= TypeUserMax
+}
+
+// Reader returns the frame's payload
+func (f *User) Reader() io.Reader {
+	return &f.toRead
+}
+
+func (f *User) readFrom(rd io.Reader) error {
+	f.toRead.R = rd
+	f.toRead.N = int64(f.length)
+	return nil
+}
+
+func (f *User) writeTo(wr io.Writer) error {
+	return f.writeVec(wr, f.b[:headerSize], f.toWrite)
+}
+
+// Pack packs a frame of the given application type. All four flag bits are
+// the application's.
+func (f *User) Pack(ftype Type, streamId StreamId, flags Flags, data []byte) error {
+	if !IsUserType(ftype) {
+		return fmt.Errorf("frame type 0x%x is not reserved for applications", uint8(ftype))
+	}
+	if err := f.common.pack(ftype, len(data), streamId, flags&flagsMask); err != nil {
+		return err
+	}
+	f.toWrite = data
+	return nil
+}
@@ -0,0 +1,70 @@
+package frame
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+type userTest struct {
+	ftype            Type
+	streamId         StreamId
+	flags            Flags
+	data             []byte
+	serialized       []byte
+	serializeError   bool
+	deserializeError bool
+}
+
+func (t *userTest) FrameName() string         { return "USER" }
+func (t *userTest) SerializeError() bool      { return t.serializeError }
+func (t *userTest) DeserializeError() bool    { return t.deserializeError }
+func (t *userTest) Serialized() []byte        { return t.serialized }
+func (t *userTest) WithHeader(c common) Frame { return &User{common: c} }
+func (t *userTest) Pack() (Frame, error) {
+	var f User
+	return &f, f.Pack(t.ftype, t.streamId, t.flags, t.data)
+}
+func (t *userTest) Eq(fr Frame) error {
+	f := fr.(*User)
+	if f.Type() != t.ftype {
+		return fmt.Errorf("wrong type. expected %s, got %s", t.ftype, f.Type())
+	}
+	if f.Flags() != t.flags {
+		return fmt.Errorf("wrong flags. expected %x, got %x", t.flags, f.Flags())
+	}
+	data, err := ioutil.ReadAll(f.Reader())
+	if err != nil {
+		return fmt.Errorf("failed to read user data: %v", err)
+	}
+	if !bytes.Equal(data, t.data) {
+		return fmt.Errorf("wrong user data. expected %x, got %x", t.data, data)
+	}
+	return nil
+}
+
+func TestUserFrameValid(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &userTest{
+		ftype:      TypeUserMin,
+		streamId:   0x5,
+		flags:      0x3,
+		data:       []byte{0xA, 0xB},
+		serialized: []byte{0x0, 0x0, 0x2, byte(TypeUserMin<<4) | 0x3, 0, 0, 0, 0x5, 0xA, 0xB},
+	})
+	RunFrameTest(t, &userTest{
+		ftype:      TypeUserMax,
+		data:       []byte{},
+		serialized: []byte{0x0, 0x0, 0x0, byte(TypeUserMax << 4), 0, 0, 0, 0},
+	})
+}
+
+func TestUserFrameBadType(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &userTest{
+		ftype:          TypeExtension,
+		serialized:     []byte{},
+		serializeError: true,
+	})
+}
@@ -9,6 +9,16 @@ const (
 	wndIncFrameLength = 4
 )
 
+const (
+	// FlagWndIncAck acknowledges that the sender accepted the stream, see
+	// SettingStreamAcks. The increment of a frame with the flag may be zero.
+	FlagWndIncAck = 0x1
+	// FlagWndIncShrink asks the receiver to reduce the stream's window by the
+	// increment instead of growing it, see SettingWindowShrink. The window
+	// may become negative.
+	FlagWndIncShrink = 0x2
+)
+
 // Increase a stream's flow control window size
 type WndInc struct {
 	common
@@ -18,6 +28,16 @@ func (f *WndInc) WindowIncrement() uint32 {
 	return order.Uint32(f.body()) & wndIncMask
 }
 
+// Ack returns true if the frame acknowledges the stream
+func (f *WndInc) Ack() bool {
+	return f.flags.IsSet(FlagWndIncAck)
+}
+
+// Shrink returns true if the frame reduces the window by its increment
+func (f *WndInc) Shrink() bool {
+	return f.flags.IsSet(FlagWndIncShrink)
+}
+
 func (f *WndInc) readFrom(rd io.Reader) error {
 	if f.length != wndIncFrameLength {
 		return frameSizeError(f.length, "WNDINC")
@@ -28,7 +48,7 @@ func (f *WndInc) readFrom(rd io.Reader) error {
 	if f.StreamId() == 0 {
 		return protoError("WNDINC stream id must not be zero, got: %d", f.StreamId())
 	}
-	if f.WindowIncrement() == 0 {
+	if f.WindowIncrement() == 0 && !f.Ack() {
 		return protoStreamError("WNDINC increment must not be zero, got: %d", f.WindowIncrement())
 	}
 	return nil
@@ -48,3 +68,29 @@ func (f *WndInc) Pack(streamId StreamId, inc uint32) (err error) {
 	order.PutUint32(f.body(), inc)
 	return
 }
+
+// PackAck packs a frame acknowledging the stream which also increments its
+// window by inc, which may be zero
+func (f *WndInc) PackAck(streamId StreamId, inc uint32) (err error) {
+	if inc > wndIncMask {
+		return fmt.Errorf("invalid window increment: %d", inc)
+	}
+	if err = f.common.pack(TypeWndInc, wndIncFrameLength, streamId, FlagWndIncAck); err != nil {
+		return
+	}
+	order.PutUint32(f.body(), inc)
+	return
+}
+
+// PackShrink packs a frame asking the remote side to reduce the stream's
+// window by dec
+func (f *WndInc) PackShrink(streamId StreamId, dec uint32) (err error) {
+	if dec > wndIncMask || dec == 0 {
+		return fmt.Errorf("invalid window decrement: %d", dec)
+	}
+	if err = f.common.pack(TypeWndInc, wndIncFrameLength, streamId, FlagWndIncShrink); err != nil {
+		return
+	}
+	order.PutUint32(f.body(), dec)
+	return
+}
@@ -8,6 +8,8 @@ import (
 type wndIncTest struct {
 	streamId         StreamId
 	inc              uint32
+	ack              bool
+	shrink           bool
 	serialized       []byte
 	serializeError   bool
 	deserializeError bool
@@ -20,6 +22,12 @@ func (t *wndIncTest) Serialized() []byte        { return t.serialized }
 func (t *wndIncTest) WithHeader(c common) Frame { return &WndInc{common: c} }
 func (t *wndIncTest) Pack() (Frame, error) {
 	var f WndInc
+	if t.ack {
+		return &f, f.PackAck(t.streamId, t.inc)
+	}
+	if t.shrink {
+		return &f, f.PackShrink(t.streamId, t.inc)
+	}
 	return &f, f.Pack(t.streamId, t.inc)
 }
 func (t *wndIncTest) Eq(fr Frame) error {
@@ -27,6 +35,12 @@ func (t *wndIncTest) Eq(fr Frame) error {
 	if f.WindowIncrement() != t.inc {
 		return fmt.Errorf("wrong increment. expected %v, got %v", t.inc, f.WindowIncrement())
 	}
+	if f.Ack() != t.ack {
+		return fmt.Errorf("wrong ack flag. expected %v, got %v", t.ack, f.Ack())
+	}
+	if f.Shrink() != t.shrink {
+		return fmt.Errorf("wrong shrink flag. expected %v, got %v", t.shrink, f.Shrink())
+	}
 	return nil
 }
 
@@ -59,6 +73,40 @@ func TestWndIncZeroIncrement(t *testing.T) {
 	})
 }
 
+// acknowledgments may have a zero increment
+func TestWndIncAck(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &wndIncTest{
+		streamId:   0x3,
+		inc:        0x0,
+		ack:        true,
+		serialized: []byte{0x0, 0x0, 0x4, byte(TypeWndInc<<4) | FlagWndIncAck, 0, 0, 0, 0x3, 0x0, 0x0, 0x0, 0x0},
+	})
+	RunFrameTest(t, &wndIncTest{
+		streamId:   0x3,
+		inc:        0x100,
+		ack:        true,
+		serialized: []byte{0x0, 0x0, 0x4, byte(TypeWndInc<<4) | FlagWndIncAck, 0, 0, 0, 0x3, 0x0, 0x0, 0x1, 0x0},
+	})
+}
+
+// shrinking frames carry the decrement in the increment field
+func TestWndIncShrink(t *testing.T) {
+	t.Parallel()
+	RunFrameTest(t, &wndIncTest{
+		streamId:   0x3,
+		inc:        0xf000,
+		shrink:     true,
+		serialized: []byte{0x0, 0x0, 0x4, byte(TypeWndInc<<4) | FlagWndIncShrink, 0, 0, 0, 0x3, 0x0, 0x0, 0xf0, 0x0},
+	})
+	RunFrameTest(t, &wndIncTest{
+		streamId:       0x3,
+		inc:            0x0,
+		shrink:         true,
+		serializeError: true,
+	})
+}
+
 // test a bad frame length of wndIncBodySize+1
 func TestBadLengthWndInc(t *testing.T) {
 	t.Parallel()
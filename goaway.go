@@ -0,0 +1,85 @@
+package muxado
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// GoAwayDebug is the structured form of the debug data sent with GOAWAY, by
+// GoAway or CloseWithError, so that the remote side can react to why the
+// session is going away, e.g. by reconnecting to another address after a
+// delay, instead of parsing a message meant for humans.
+//
+// It is sent encoded as a JSON object by passing Bytes as the debug data:
+//
+//	sess.GoAway(muxado.NoError, muxado.GoAwayDebug{
+//		Reason:     "upgrading",
+//		RetryAfter: 5 * time.Second,
+//		NewAddress: "edge-2.example.com:443",
+//	}.Bytes(), deadline)
+//
+// The remote error that Wait returns on the other side then wraps the
+// decoded *GoAwayDebug:
+//
+//	_, remoteErr, _ := sess.Wait()
+//	var d *muxado.GoAwayDebug
+//	if errors.As(remoteErr, &d) && d.NewAddress != "" { ... }
+type GoAwayDebug struct {
+	Reason     string        // why the session is going away
+	RetryAfter time.Duration // how long to wait before reconnecting, if non-zero
+	NewAddress string        // the address to reconnect to, if any
+}
+
+// goAwayDebugJSON is the encoding of GoAwayDebug. Unknown fields are ignored
+// so that fields can be added.
+type goAwayDebugJSON struct {
+	Reason       string `json:"reason,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+	NewAddress   string `json:"new_address,omitempty"`
+}
+
+// Bytes returns the encoding of d to send as GOAWAY debug data
+func (d GoAwayDebug) Bytes() []byte {
+	b, _ := json.Marshal(goAwayDebugJSON{
+		Reason:       d.Reason,
+		RetryAfterMs: d.RetryAfter.Milliseconds(),
+		NewAddress:   d.NewAddress,
+	})
+	return b
+}
+
+// Error returns the reason, so that a *GoAwayDebug can be the underlying
+// error of the remote error returned by Wait
+func (d *GoAwayDebug) Error() string {
+	return d.Reason
+}
+
+// ParseGoAwayDebug decodes GOAWAY debug data encoded by GoAwayDebug.Bytes.
+// It returns false if debug is not structured, e.g. when the remote side sent
+// a plain message.
+func ParseGoAwayDebug(debug []byte) (*GoAwayDebug, bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(debug), []byte("{")) {
+		return nil, false
+	}
+	var enc goAwayDebugJSON
+	if err := json.Unmarshal(debug, &enc); err != nil {
+		return nil, false
+	}
+	return &GoAwayDebug{
+		Reason:     enc.Reason,
+		RetryAfter: time.Duration(enc.RetryAfterMs) * time.Millisecond,
+		NewAddress: enc.NewAddress,
+	}, true
+}
+
+// goAwayError returns the underlying error of a session closed or sent away
+// with the given debug data: the decoded *GoAwayDebug if it is structured,
+// the data as a message otherwise.
+func goAwayError(debug []byte) error {
+	if d, ok := ParseGoAwayDebug(debug); ok {
+		return d
+	}
+	return errors.New(string(debug))
+}
@@ -0,0 +1,44 @@
+package muxado
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseGoAwayDebug(t *testing.T) {
+	t.Parallel()
+	want := GoAwayDebug{Reason: "upgrading", RetryAfter: 1500 * time.Millisecond, NewAddress: "example.com:443"}
+	got, ok := ParseGoAwayDebug(want.Bytes())
+	if !ok || *got != want {
+		t.Fatalf("Parsed %+v, %v, expected %+v", got, ok, want)
+	}
+	if got, ok := ParseGoAwayDebug([]byte(`{"reason":"x","extra":1}`)); !ok || got.Reason != "x" {
+		t.Fatalf("Failed to parse debug data with unknown fields")
+	}
+	for _, debug := range []string{"", "overloaded", "{not json"} {
+		if _, ok := ParseGoAwayDebug([]byte(debug)); ok {
+			t.Fatalf("Parsed plain debug data %q", debug)
+		}
+	}
+}
+
+// Test that structured debug data is decoded into the remote error
+func TestGoAwayDebugRemoteError(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+
+	sent := GoAwayDebug{Reason: "upgrading", RetryAfter: time.Second, NewAddress: "example.com:443"}
+	if err := server.CloseWithError(NoError, sent.Bytes()); err != nil {
+		t.Fatalf("Failed to close session: %v", err)
+	}
+	_, remoteErr, _ := client.Wait()
+	var got *GoAwayDebug
+	if !errors.As(remoteErr, &got) || *got != sent {
+		t.Fatalf("Remote error %v does not wrap %+v", remoteErr, sent)
+	}
+	if remoteErr.Error() != sent.Reason {
+		t.Fatalf("Remote error is %q, expected %q", remoteErr.Error(), sent.Reason)
+	}
+}
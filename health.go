@@ -0,0 +1,192 @@
+package muxado
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// errPingTimeout is the reason a session whose health probe was not
+// answered in time is unhealthy
+var errPingTimeout = errors.New("PING was not answered in time")
+
+// pinger is implemented by sessions that can measure the round trip time of
+// a PING
+type pinger interface {
+	ping(timeout time.Duration) (time.Duration, error)
+}
+
+// ping sends a PING and waits up to timeout for its reply. It returns the
+// round trip time.
+func (s *session) ping(timeout time.Duration) (time.Duration, error) {
+	sent := time.Now()
+	reply := make(chan struct{})
+	// our PINGs carry the time they were sent, made unique among those
+	// awaiting a reply
+	data := uint64(sent.UnixNano())
+	s.pingsMu.Lock()
+	if s.pings == nil {
+		s.pings = make(map[uint64]chan struct{})
+	}
+	for s.pings[data] != nil {
+		data++
+	}
+	s.pings[data] = reply
+	s.pingsMu.Unlock()
+	defer func() {
+		s.pingsMu.Lock()
+		delete(s.pings, data)
+		s.pingsMu.Unlock()
+	}()
+
+	f := new(frame.Ping)
+	if err := f.Pack(data, false); err != nil {
+		return 0, err
+	}
+	if err := s.writeFrame(f, sent.Add(timeout)); err != nil {
+		return 0, err
+	}
+	timer := getTimer(sent.Add(timeout))
+	defer putTimer(timer)
+	select {
+	case <-reply:
+		return time.Since(sent), nil
+	case <-timer.C:
+		return 0, errPingTimeout
+	case <-s.dead:
+		return 0, s.Err()
+	}
+}
+
+// pingReplied wakes up the ping waiting for the reply with the given data
+func (s *session) pingReplied(data uint64) {
+	s.pingsMu.Lock()
+	defer s.pingsMu.Unlock()
+	if reply, ok := s.pings[data]; ok {
+		close(reply)
+		delete(s.pings, data)
+	}
+}
+
+func (s *rotatingSession) ping(timeout time.Duration) (time.Duration, error) {
+	return s.getCurrent().ping(timeout)
+}
+
+// probe measures the round trip time of sess, which is unhealthy if it
+// exceeds maxRTT. Sessions that cannot be pinged are judged by their
+// PathStats.
+func probe(sess Session, maxRTT time.Duration) error {
+	var rtt time.Duration
+	if p, ok := sess.(pinger); ok {
+		var err error
+		if rtt, err = p.ping(maxRTT); err != nil {
+			return err
+		}
+	} else {
+		rtt = sess.PathStats().RTT
+	}
+	if rtt > maxRTT {
+		return fmt.Errorf("round trip time %v exceeds %v", rtt, maxRTT)
+	}
+	return nil
+}
+
+// checkHealth probes the group's sessions every HealthCheckInterval until
+// the group dies
+func (g *SessionGroup) checkHealth() {
+	t := time.NewTicker(g.config.HealthCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-g.dead:
+			return
+		}
+		var wg sync.WaitGroup
+		for _, sess := range g.Sessions() {
+			wg.Add(1)
+			go func(sess Session) {
+				defer wg.Done()
+				if err := probe(sess, g.config.MaxRTT); err != nil {
+					g.evict(sess, err)
+				}
+			}(sess)
+		}
+		wg.Wait()
+		// retry replacements that failed to dial
+		g.replenish()
+	}
+}
+
+// opened records the result of opening a stream on sess, which is unhealthy
+// after GroupConfig.MaxOpenFailures consecutive failures
+func (g *SessionGroup) opened(sess Session, err error) {
+	if g.config.MaxOpenFailures == 0 || err == ErrOpenTimeout || err == ErrStreamsLimited {
+		return
+	}
+	g.mu.Lock()
+	if err == nil {
+		delete(g.failures, sess)
+		g.mu.Unlock()
+		return
+	}
+	g.failures[sess]++
+	n := g.failures[sess]
+	g.mu.Unlock()
+	if n >= g.config.MaxOpenFailures {
+		g.evict(sess, fmt.Errorf("%d consecutive failures to open a stream, the last: %v", n, err))
+	}
+}
+
+// evict stops opening streams on an unhealthy session, dials its
+// replacement and closes it once its remaining streams have finished
+func (g *SessionGroup) evict(sess Session, reason error) {
+	g.mu.Lock()
+	removed := g.removeLocked(sess)
+	if removed {
+		g.missing++
+	}
+	g.mu.Unlock()
+	if !removed {
+		return
+	}
+	if g.config.OnUnhealthy != nil {
+		g.config.OnUnhealthy(sess, reason)
+	}
+	go g.replenish()
+	go func() {
+		sess.GoAway(NoError, []byte("unhealthy"), time.Now().Add(time.Second))
+		select {
+		case <-sess.Drained():
+			sess.Close()
+		case <-sess.Done():
+		}
+	}()
+}
+
+// replenish dials replacements for the sessions that left the group
+func (g *SessionGroup) replenish() {
+	if g.config.Dial == nil {
+		return
+	}
+	g.mu.Lock()
+	n := g.missing
+	g.missing = 0
+	g.mu.Unlock()
+	for i := 0; i < n; i++ {
+		if isClosed(g.dead) {
+			return
+		}
+		sess, err := g.config.Dial()
+		if err != nil {
+			g.mu.Lock()
+			g.missing += n - i
+			g.mu.Unlock()
+			return
+		}
+		g.Add(sess)
+	}
+}
@@ -0,0 +1,117 @@
+package muxado
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	rtt, err := client.(*session).ping(5 * time.Second)
+	if err != nil {
+		t.Fatalf("Failed to ping: %v", err)
+	}
+	if rtt <= 0 {
+		t.Fatalf("Measured round trip time %v", rtt)
+	}
+}
+
+// Test that a session on which streams fail to open is evicted from the
+// group and replaced
+func TestSessionGroupEvictsOpenFailures(t *testing.T) {
+	t.Parallel()
+	dialed := make(chan Session, 1)
+	unhealthy := make(chan Session, 1)
+	g, servers := newGroupPair(t, &GroupConfig{
+		Balance:         RoundRobin,
+		MaxOpenFailures: 1,
+		Dial: func() (Session, error) {
+			client, server := newSessionPair(nil, nil)
+			t.Cleanup(func() { server.Close() })
+			dialed <- client
+			return client, nil
+		},
+		OnUnhealthy: func(sess Session, reason error) { unhealthy <- sess },
+	}, 2)
+	failing := g.Sessions()[0]
+	servers[0].GoAway(NoError, nil, time.Now().Add(5*time.Second))
+	// wait for the client to learn about the GOAWAY
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := failing.OpenStream(); err == ErrRemoteGoneAway {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Session can still open streams after GOAWAY")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	str, err := g.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if str.Session() == failing {
+		t.Fatalf("Stream was opened on the failing session")
+	}
+	select {
+	case sess := <-unhealthy:
+		if sess != failing {
+			t.Fatalf("Session %v was found unhealthy, expected %v", sess, failing)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failing session was not found unhealthy")
+	}
+	var replacement Session
+	select {
+	case replacement = <-dialed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failing session was not replaced")
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		sessions := g.Sessions()
+		if len(sessions) == 2 && sessions[1] == replacement {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Group has sessions %v, expected the replacement %v", sessions, replacement)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, sess := range g.Sessions() {
+		if sess == failing {
+			t.Fatalf("Failing session is still in the group")
+		}
+	}
+}
+
+// Test that sessions whose PINGs take longer than MaxRTT are evicted
+func TestSessionGroupEvictsSlowSessions(t *testing.T) {
+	t.Parallel()
+	unhealthy := make(chan error, 2)
+	g, _ := newGroupPair(t, &GroupConfig{
+		HealthCheckInterval: 10 * time.Millisecond,
+		MaxRTT:              time.Nanosecond,
+		OnUnhealthy:         func(sess Session, reason error) { unhealthy <- reason },
+	}, 2)
+	for i := 0; i < 2; i++ {
+		select {
+		case reason := <-unhealthy:
+			if reason == nil {
+				t.Fatalf("Session was found unhealthy without a reason")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Slow session was not found unhealthy")
+		}
+	}
+	if sessions := g.Sessions(); len(sessions) != 0 {
+		t.Fatalf("Group still has sessions %v", sessions)
+	}
+	if _, err := g.OpenStream(); err == nil {
+		t.Fatalf("Opened stream without healthy sessions")
+	}
+}
@@ -0,0 +1,70 @@
+package muxado
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// streams are cheap, so keep more of them idle than net/http does by default
+	httpMaxIdleConnsPerHost = 64
+	httpIdleConnTimeout     = 90 * time.Second
+
+	// bounds how long a stream can hold the server without sending a request
+	httpReadHeaderTimeout = 30 * time.Second
+)
+
+// HTTPTransport returns an *http.Transport that sends each request over a
+// stream opened on sess, whatever the URL's host. This lets an HTTP client make
+// many concurrent requests to the remote side of a single session, e.g. an
+// agent at the other end of a reverse tunnel:
+//
+//	client := &http.Client{Transport: muxado.HTTPTransport(sess)}
+//	resp, err := client.Get("http://agent/status")
+//
+// Idle streams are kept open for reuse like any other HTTP connection and are
+// closed with the transport's CloseIdleConnections.
+func HTTPTransport(sess Session) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return openStreamContext(ctx, sess)
+		},
+		MaxIdleConnsPerHost: httpMaxIdleConnsPerHost,
+		IdleConnTimeout:     httpIdleConnTimeout,
+	}
+}
+
+// ServeHTTP serves HTTP requests made over the streams opened by the remote
+// side of sess with handler. It blocks until the session dies, then closes
+// any connections still being served and returns the session's error:
+//
+//	sess := muxado.Client(conn, nil)
+//	log.Fatal(muxado.ServeHTTP(sess, mux))
+//
+// The remote side can make requests with an *http.Client whose Transport is
+// HTTPTransport.
+func ServeHTTP(sess Session, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+
+	// stop serving the session's streams once it dies
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-sess.Done():
+			srv.Close()
+		case <-stop:
+		}
+	}()
+
+	err := srv.Serve(Listener(sess))
+	if sessErr := sess.Err(); sessErr != nil {
+		return sessErr
+	}
+	return err
+}
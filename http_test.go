@@ -0,0 +1,79 @@
+package muxado
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go http.Serve(Listener(server), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path[1:])
+	}))
+
+	transport := HTTPTransport(client)
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := httpClient.Get(fmt.Sprintf("http://agent/%d", i))
+			if err != nil {
+				t.Errorf("Failed to get: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if expected := fmt.Sprintf("hello %d", i); err != nil || string(body) != expected {
+				t.Errorf("Wrong response. Got %q, %v, expected %q", body, err, expected)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer server.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeHTTP(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	}()
+
+	httpClient := &http.Client{Transport: HTTPTransport(client)}
+	resp, err := httpClient.Get("http://agent/")
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("Wrong response. Got %q, %v, expected %q", body, err, "ok")
+	}
+
+	// closing the session stops the server
+	client.Close()
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Fatalf("ServeHTTP returned nil error after the session died")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ServeHTTP did not return after the session died")
+	}
+}
@@ -0,0 +1,72 @@
+package muxado
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// idleWindows shrinks the receive windows of streams that have been idle for
+// longer than the configured IdleWindowTimeout to IdleWindowSize
+func (s *session) idleWindows() {
+	defer s.recoverPanic("idleWindows()")
+	timeout := s.config.IdleWindowTimeout
+	t := time.NewTicker(timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.dead:
+			return
+		}
+		// remote sides that can't give back window keep it
+		if atomic.LoadUint32(&s.remote.shrink) == 0 {
+			continue
+		}
+		s.streams.Each(func(id frame.StreamId, str streamPrivate) {
+			if time.Since(str.idleSince()) >= timeout {
+				str.shrinkIdleWindow(s.config.IdleWindowSize)
+			}
+		})
+	}
+}
+
+// shrinkIdleWindow asks the remote side to give back the part of the stream's
+// window beyond size. Streams whose window is being shrunk by withholding
+// window updates, see shrinkWindow, are left alone.
+func (s *stream) shrinkIdleWindow(size uint32) {
+	if atomic.LoadUint32(&s.withheld) != 0 {
+		return
+	}
+	limit := atomic.LoadUint32(&s.recvLimit)
+	if size >= limit || !atomic.CompareAndSwapUint32(&s.recvLimit, limit, size) {
+		return
+	}
+	atomic.StoreUint32(&s.idleShrunk, 1)
+	var wndinc frame.WndInc
+	if err := wndinc.PackShrink(s.id, limit-size); err != nil {
+		return
+	}
+	s.session.writeFrameAsync(&wndinc)
+}
+
+// growIdleWindow gives back the window taken from the stream by
+// shrinkIdleWindow once data arrives on it again
+func (s *stream) growIdleWindow() {
+	if !atomic.CompareAndSwapUint32(&s.idleShrunk, 1, 0) {
+		return
+	}
+	for {
+		limit := atomic.LoadUint32(&s.recvLimit)
+		windowSize := atomic.LoadUint32(&s.windowSize)
+		if limit >= windowSize {
+			return
+		}
+		if atomic.CompareAndSwapUint32(&s.recvLimit, limit, windowSize) {
+			atomic.AddUint32(&s.pendingInc, windowSize-limit)
+			s.flushWindowUpdate()
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package muxado
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Test that the remote side gives back the window of an idle stream and gets
+// it back once the stream is used again
+func TestIdleWindow(t *testing.T) {
+	t.Parallel()
+	const window, size = 0x10000, 0x40000
+	config := &Config{MaxWindowSize: window, IdleWindowTimeout: 200 * time.Millisecond}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+
+	waitWindow := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for str.(*stream).window.Available() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Window is %d bytes, expected %d", str.(*stream).window.Available(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitWindow(minShrunkWindow)
+
+	go func() {
+		str.Write(make([]byte, size))
+		str.CloseWrite()
+	}()
+	if n, err := io.Copy(ioutil.Discard, accepted); err != nil || n != size {
+		t.Fatalf("Read %d bytes, %v, expected %d", n, err, size)
+	}
+	if limit := accepted.(*stream).windowLimit(); limit != window {
+		t.Fatalf("Window limit is %d bytes after the stream was used, expected %d", limit, window)
+	}
+}
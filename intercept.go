@@ -0,0 +1,111 @@
+package muxado
+
+import (
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// FrameHook intercepts a frame read or written by a session, see
+// Config.OnFrameRead and Config.OnFrameWrite. It returns the frame the
+// session handles or writes in its place: f itself, possibly modified, or a
+// different frame, e.g. one created with frame.Parse. Returning a nil frame
+// drops f. Returning an error closes the session with it; return an *Error to
+// choose the code sent to the remote side.
+//
+// Hooks can read the payload a frame hands up as a reader, like the data of
+// a DATA frame, after calling frame.ReadPayload on it, but the session
+// handles only what they leave unread.
+type FrameHook func(f frame.Frame) (frame.Frame, error)
+
+// ChainFrameHooks returns a FrameHook that calls each of hooks in order with
+// the frame returned by the previous one. It stops at the first hook that
+// drops the frame or fails.
+func ChainFrameHooks(hooks ...FrameHook) FrameHook {
+	return func(f frame.Frame) (frame.Frame, error) {
+		var err error
+		for _, hook := range hooks {
+			if f, err = hook(f); f == nil || err != nil {
+				return nil, err
+			}
+		}
+		return f, nil
+	}
+}
+
+// hookFramer passes the frames of a framer through the session's frame hooks
+type hookFramer struct {
+	frame.Framer
+	onRead  FrameHook
+	onWrite FrameHook
+}
+
+// newHookFramer wraps fr if any frame hooks are configured
+func newHookFramer(fr frame.Framer, config *Config) frame.Framer {
+	if config.OnFrameRead == nil && config.OnFrameWrite == nil {
+		return fr
+	}
+	return &hookFramer{Framer: fr, onRead: config.OnFrameRead, onWrite: config.OnFrameWrite}
+}
+
+func (fr *hookFramer) ReadFrame() (frame.Frame, error) {
+	for {
+		f, err := fr.Framer.ReadFrame()
+		if err != nil || fr.onRead == nil {
+			return f, err
+		}
+		hooked, err := fr.onRead(f)
+		if err != nil {
+			return nil, err
+		}
+		if hooked != nil {
+			return hooked, nil
+		}
+		// the frame was dropped
+		if err := frame.DiscardPayload(f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (fr *hookFramer) WriteFrame(f frame.Frame) error {
+	if fr.onWrite != nil {
+		var err error
+		if f, err = fr.onWrite(f); f == nil || err != nil {
+			return err
+		}
+	}
+	return fr.Framer.WriteFrame(f)
+}
+
+func (fr *hookFramer) Unwrap() frame.Framer {
+	return fr.Framer
+}
+
+// StreamMiddleware wraps a stream opened or accepted by a session, e.g. to
+// collect its metrics or throttle it. Middleware can embed StreamWrapper in
+// the streams it returns to pass on the methods it does not change.
+type StreamMiddleware func(Stream) Stream
+
+// ChainStreamMiddleware returns a function for Config.WrapStream that wraps
+// every stream of a session with each of middleware in order, so that the
+// first is innermost.
+func ChainStreamMiddleware(middleware ...StreamMiddleware) func(str Stream, local bool) Stream {
+	return func(str Stream, local bool) Stream {
+		for _, wrap := range middleware {
+			str = wrap(str)
+		}
+		return str
+	}
+}
+
+// StreamWrapper passes the methods of Stream to the stream it wraps and
+// implements WrappingStream. Stream wrappers embed it and override the
+// methods they change. Wrappers that change Read or Write should also
+// override ReadContext or WriteContext.
+type StreamWrapper struct {
+	Stream
+}
+
+// Unwrap returns the wrapped Stream
+func (w StreamWrapper) Unwrap() Stream {
+	return w.Stream
+}
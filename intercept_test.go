@@ -0,0 +1,183 @@
+package muxado
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func TestChainFrameHooks(t *testing.T) {
+	t.Parallel()
+	var calls []int
+	hook := func(i int, drop bool) FrameHook {
+		return func(f frame.Frame) (frame.Frame, error) {
+			calls = append(calls, i)
+			if drop {
+				return nil, nil
+			}
+			return f, nil
+		}
+	}
+	f := new(frame.WndInc)
+	if got, err := ChainFrameHooks(hook(1, false), hook(2, false))(f); got != f || err != nil {
+		t.Fatalf("Chain returned %v, %v, expected the frame", got, err)
+	}
+	if got, _ := ChainFrameHooks(hook(3, true), hook(4, false))(f); got != nil {
+		t.Fatalf("Chain did not drop the frame")
+	}
+	if want := []int{1, 2, 3}; len(calls) != len(want) || calls[0] != 1 || calls[1] != 2 || calls[2] != 3 {
+		t.Fatalf("Hooks were called in order %v, expected %v", calls, want)
+	}
+}
+
+// Test that frame hooks see every frame and can replace and drop them
+func TestFrameHooks(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var written []frame.Type
+	record := func(f frame.Frame) (frame.Frame, error) {
+		mu.Lock()
+		written = append(written, f.Type())
+		mu.Unlock()
+		return f, nil
+	}
+	dropWndInc := func(f frame.Frame) (frame.Frame, error) {
+		if f.Type() == frame.TypeWndInc {
+			return nil, nil
+		}
+		return f, nil
+	}
+	// replaces the payload of DATA frames with its upper case
+	upper := func(f frame.Frame) (frame.Frame, error) {
+		d, ok := f.(*frame.Data)
+		if !ok || d.Length() == 0 {
+			return f, nil
+		}
+		if err := frame.ReadPayload(d); err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(d.Reader())
+		if err != nil {
+			return nil, err
+		}
+		out := new(frame.Data)
+		if err := out.PackFlags(d.StreamId(), bytes.ToUpper(b), d.Flags()); err != nil {
+			return nil, err
+		}
+		raw, err := frame.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		return frame.Parse(raw)
+	}
+
+	client, server := newSessionPair(
+		&Config{OnFrameWrite: ChainFrameHooks(record, dropWndInc)},
+		&Config{OnFrameRead: upper},
+	)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hello"))
+	str.CloseWrite()
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if got, err := io.ReadAll(accepted); err != nil || string(got) != "HELLO" {
+		t.Fatalf("Read %q, %v, expected %q", got, err, "HELLO")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(written) < 2 || written[0] != frame.TypeSettings || written[1] != frame.TypeData {
+		t.Fatalf("Client wrote %v, expected SETTINGS and DATA first", written)
+	}
+}
+
+// Test that an error returned by a read hook closes the session with it
+func TestFrameHookError(t *testing.T) {
+	t.Parallel()
+	refuseMetadata := func(f frame.Frame) (frame.Frame, error) {
+		if f.Type() == frame.TypeHeaders {
+			return nil, &Error{StreamRefused, errors.New("streams with metadata are not allowed")}
+		}
+		return f, nil
+	}
+	client, server := newSessionPair(nil, &Config{OnFrameRead: refuseMetadata})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream(WithMetadata(map[string]string{"k": "v"}))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hello"))
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session was not closed by the hook's error")
+	}
+	if code, _ := GetError(server.Err()); code != StreamRefused {
+		t.Fatalf("Session died with %v, expected %v", code, StreamRefused)
+	}
+}
+
+// countingStream counts the bytes read from a stream
+type countingStream struct {
+	StreamWrapper
+	read *int64
+}
+
+func (s *countingStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	atomic.AddInt64(s.read, int64(n))
+	return n, err
+}
+
+func TestChainStreamMiddleware(t *testing.T) {
+	t.Parallel()
+	var inner, outer int64
+	var order []*int64
+	counter := func(n *int64) StreamMiddleware {
+		return func(str Stream) Stream {
+			order = append(order, n)
+			return &countingStream{StreamWrapper{str}, n}
+		}
+	}
+	client, server := newSessionPair(nil, &Config{WrapStream: ChainStreamMiddleware(counter(&inner), counter(&outer))})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStreamWithData([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.CloseWrite()
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if len(order) != 2 || order[0] != &inner || accepted.(*countingStream).read != &outer {
+		t.Fatalf("Middleware was not applied in order")
+	}
+	if _, err := io.ReadAll(accepted); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if atomic.LoadInt64(&inner) != 5 || atomic.LoadInt64(&outer) != 5 {
+		t.Fatalf("Middleware counted %d and %d bytes, expected 5", inner, outer)
+	}
+	if unwrapStream(accepted) == nil {
+		t.Fatalf("Failed to unwrap the stream")
+	}
+}
@@ -1,8 +1,13 @@
 package muxado
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
 	"time"
+
+	"github.com/inconshreveable/muxado/frame"
 )
 
 // Stream is a full duplex stream-oriented connection that is multiplexed over
@@ -14,12 +19,39 @@ type Stream interface {
 	// Read reads the next bytes on the stream into the given buffer
 	Read([]byte) (int, error)
 
+	// ReadContext is like Read, but gives up waiting for data once ctx is
+	// done and returns ctx.Err(). The stream stays usable.
+	ReadContext(ctx context.Context, p []byte) (int, error)
+
+	// WriteContext is like Write, but gives up waiting for flow control, the
+	// rate limit or the session's writer once ctx is done and returns the
+	// number of bytes written so far and ctx.Err(). The stream stays usable.
+	WriteContext(ctx context.Context, p []byte) (int, error)
+
 	// Closes the stream.
 	Close() error
 
+	// CloseWithError closes the stream by resetting it with the given error
+	// code and debug data, e.g. to tell the remote side that the request the
+	// stream carries was cancelled or exceeded a quota. The remote side's
+	// Read and Write fail with a *StreamResetError carrying both. Debug
+	// data beyond 1KB is truncated.
+	CloseWithError(errCode ErrorCode, debug []byte) error
+
 	// Half-closes the stream. Calls to Write will fail after this is invoked.
 	CloseWrite() error
 
+	// CloseWriteWithTrailers half-closes the stream like CloseWrite, sending
+	// a small block of key/value trailers, like a status code, byte count or
+	// checksum, that the remote side reads with Trailers after EOF. The
+	// remote side must support HEADERS frames. Trailers are limited to 16KB.
+	CloseWriteWithTrailers(map[string]string) error
+
+	// Trailers returns the trailers sent by the remote side when it
+	// half-closed the stream. It is nil until Read returns io.EOF or if the
+	// remote side sent no trailers.
+	Trailers() map[string]string
+
 	// SetDeadline sets a time after which future Read and Write operations will
 	// fail.
 	//
@@ -37,10 +69,58 @@ type Stream interface {
 	// Some implementation may not support this.
 	SetWriteDeadline(time.Time) error
 
+	// SetRateLimit limits the rate at which data is written to the stream to
+	// the given number of bytes per second so that a bulk transfer cannot
+	// starve the session's other streams. A limit of 0 removes the limit.
+	SetRateLimit(bytesPerSec int)
+
+	// SetNoDelay controls whether small writes are held back so that they
+	// are sent together, see Config.WriteCoalesceDelay. Setting noDelay
+	// sends every write immediately and flushes any writes held back.
+	SetNoDelay(noDelay bool)
+
+	// Pause stops returning the data read from the stream to the remote
+	// side's window, so that the remote side stops sending once it used up
+	// the window it has, e.g. while the consumer of the stream's data is
+	// congested. Reads still return the data that arrived. Paused streams
+	// are not slow consumers, see Config.SlowConsumerTimeout.
+	Pause()
+
+	// Resume undoes Pause, returning the window of the data read while the
+	// stream was paused to the remote side.
+	Resume()
+
+	// SetPriority asks the remote side to prioritize sending the stream's
+	// data as described by p, e.g. to mirror the priorities of browser
+	// requests forwarded over the session. A priority set before the stream
+	// is opened is sent along with the frame that opens it.
+	SetPriority(p Priority) error
+
+	// Priority returns the priority the remote side set for the stream,
+	// which orders the data written to it relative to the session's other
+	// streams.
+	Priority() Priority
+
+	// Metadata returns the metadata the stream was opened with, see
+	// WithMetadata. It is nil if the stream was opened without metadata.
+	Metadata() map[string]string
+
+	// Label returns the stream's label, see WithLabel. It is empty if the
+	// stream has not been labeled.
+	Label() string
+
+	// SetLabel sets the stream's label, e.g. to label a stream accepted from
+	// the remote side.
+	SetLabel(string)
+
 	// Id returns the stream's unique identifier.
 	Id() uint32
 
-	// Session returns the session object this stream is running on.
+	// Session returns the session object this stream is running on, e.g.
+	// to open sibling streams to the same peer from a handler of an accepted
+	// stream. For streams of a SessionGroup or rotating session this is the
+	// underlying session the stream was opened or accepted on; a migrated
+	// stream returns the session it currently runs on.
 	Session() Session
 
 	// RemoteAddr returns the session transport's remote address.
@@ -50,6 +130,14 @@ type Stream interface {
 	LocalAddr() net.Addr
 }
 
+// A WrappingStream is a Stream that wraps another, see Config.WrapStream.
+type WrappingStream interface {
+	Stream
+
+	// Unwrap returns the wrapped Stream
+	Unwrap() Stream
+}
+
 // Session multiplexes many Streams over a single underlying stream transport.
 // Both sides of a muxado session can open new Streams. Sessions can also accept
 // new streams from the remote side.
@@ -63,8 +151,17 @@ type Session interface {
 
 	// OpenStream initiates a new stream on the session. A caller can specify an
 	// opaque stream type.  Setting fin to true will cause the stream to be
-	// half-closed from the local side immediately upon creation.
-	OpenStream() (Stream, error)
+	// half-closed from the local side immediately upon creation. The stream
+	// is configured by the given options.
+	OpenStream(...StreamOption) (Stream, error)
+
+	// OpenStreamWithData opens a stream like OpenStream and writes p to it
+	// in the frame that opens the stream, so that the remote side receives
+	// the stream and its first data at once. It saves a round trip through
+	// the session's writer for small request/response exchanges. Streams
+	// opened with metadata are opened by their HEADERS frame instead, which
+	// is immediately followed by p.
+	OpenStreamWithData(p []byte, opts ...StreamOption) (Stream, error)
 
 	// Accept returns the next stream initiated by the remote side
 	Accept() (net.Conn, error)
@@ -75,6 +172,35 @@ type Session interface {
 	// Attempts to close the Session cleanly. Closes the underlying stream transport.
 	Close() error
 
+	// CloseWithError closes the session like Close but sends the remote side
+	// the given error code and debug data explaining why, for example because
+	// its authentication expired or the server is overloaded or upgrading.
+	CloseWithError(ErrorCode, []byte) error
+
+	// GoAway puts the session into a draining state. The remote side is told
+	// not to open any new streams and any it opens anyway are refused, while
+	// streams that were already accepted continue to be serviced. The GOAWAY
+	// frame carries the given error code and debug data, which may be
+	// structured, see GoAwayDebug, and its write fails if it cannot be sent by
+	// the deadline.
+	GoAway(ErrorCode, []byte, time.Time) error
+
+	// Drained returns a channel that is closed once GoAway has been called
+	// and all of the session's streams have finished.
+	Drained() <-chan struct{}
+
+	// SetDeadline sets the deadline for both SetAcceptDeadline and
+	// SetOpenDeadline.
+	SetDeadline(time.Time) error
+
+	// SetAcceptDeadline sets a time after which blocked and future calls to
+	// Accept fail with a timeout error. The zero time means no deadline.
+	SetAcceptDeadline(time.Time) error
+
+	// SetOpenDeadline sets a time after which calls to Open fail with a
+	// timeout error. The zero time means no deadline.
+	SetOpenDeadline(time.Time) error
+
 	// LocalAddr returns the local address of the transport stream over which the session is running.
 	LocalAddr() net.Addr
 
@@ -85,6 +211,117 @@ type Session interface {
 	Addr() net.Addr
 
 	// Wait blocks until the session has shutdown and returns an error
-	// explaining the session termination.
+	// explaining the session termination, the error sent by the remote side
+	// with GOAWAY, if any, and its debug data. The remote error wraps a
+	// *GoAwayDebug if the debug data is structured.
 	Wait() (error, error, []byte)
+
+	// Done returns a channel that is closed when the session has shutdown. It
+	// allows the session's termination to be selected on alongside contexts
+	// and timers.
+	Done() <-chan struct{}
+
+	// Err returns the error that caused the session to shutdown, or nil if it
+	// is still running. It is set as soon as the session starts closing, see
+	// StateClosing.
+	Err() error
+
+	// IsClosed reports whether the session is closing or has shut down,
+	// without blocking like Wait.
+	IsClosed() bool
+
+	// CloseReason returns what Wait returns without blocking, or nils while
+	// the session is open. The GOAWAY of the remote side may only be
+	// reported once the session has shut down.
+	CloseReason() (localErr error, remoteErr error, debug []byte)
+
+	// Streams returns a snapshot of the state of each of the session's live
+	// streams, ordered by id.
+	Streams() []StreamInfo
+
+	// NumStreams returns the number of the session's open streams, without
+	// taking a snapshot of them like Streams. It is the sum of NumOpened and
+	// NumAccepted. Streams that were reset are counted until they are
+	// removed a few seconds later, as they are against Config.MaxStreams.
+	NumStreams() int
+
+	// NumOpened returns the number of open streams the local side opened,
+	// including those still waiting for the remote side to acknowledge them.
+	NumOpened() int
+
+	// NumAccepted returns the number of open streams the remote side opened,
+	// including those not yet returned by AcceptStream.
+	NumAccepted() int
+
+	// TransportOptions returns the options of the transport the session runs
+	// over, e.g. to turn off Nagle's algorithm on a TCP connection.
+	TransportOptions() TransportOptions
+
+	// PathStats returns the current estimates of the round trip time and
+	// delivery rate of the path to the remote side, e.g. for adaptive bitrate
+	// logic or tuning window sizes. They are updated continuously as PINGs
+	// are answered and the remote side returns window for data written to
+	// it.
+	PathStats() PathStats
+
+	// ProtocolVersion returns the version of the protocol negotiated with
+	// the remote side, see Config.MinProtocolVersion. It is zero until the
+	// first frame from the remote side has been received.
+	ProtocolVersion() uint16
+
+	// AuthIdentity returns the identity the remote side authenticated as,
+	// see Config.Verifier. It is empty until it did and on client sessions.
+	AuthIdentity() string
+
+	// ConnectionState returns the state of the TLS connection the session
+	// runs over, unwrapping transports that implement NetConn() net.Conn
+	// like TransportOptions, and false if it does not run over TLS. It is
+	// only complete once the TLS handshake has finished, which DialTLS and
+	// TLSListener do before they return a session.
+	ConnectionState() (tls.ConnectionState, bool)
+
+	// PeerCertificates returns the certificates the remote side presented
+	// in the TLS handshake, see ConnectionState, e.g. to authorize the
+	// streams of a client by its certificate through Stream.Session. It is
+	// nil if the session does not run over TLS or the remote side presented
+	// none.
+	PeerCertificates() []*x509.Certificate
+
+	// State returns the stage of its lifecycle the session is in, see
+	// SessionState.
+	State() SessionState
+
+	// WriteUserFrame sends the remote side a frame of one of the types
+	// reserved for applications, frame.TypeUserMin to frame.TypeUserMax, e.g.
+	// a control-plane message that does not warrant a stream of its own. The
+	// flags and stream id are opaque to the session. The remote side handles
+	// it with Config.OnUserFrame.
+	WriteUserFrame(ftype frame.Type, flags frame.Flags, streamId uint32, data []byte) error
+
+	// Tags returns a copy of the key/value tags describing the session, see
+	// Config.Tags.
+	Tags() map[string]string
+
+	// SetTag tags the session with the given key and value, replacing any
+	// previous value, e.g. with the tenant it serves once the remote side
+	// has authenticated. Unlike the tags of Config.Tags, it does not label
+	// goroutines that are already running.
+	SetTag(key, value string)
+}
+
+// StreamInfo describes the state of a stream at the time it was returned by
+// Session.Streams
+type StreamInfo struct {
+	Id           uint32
+	Type         StreamType    // set for streams of a TypedStreamSession
+	Label        string        // see WithLabel
+	Local        bool          // true if the stream was opened by this side of the session
+	Age          time.Duration // time since the stream was opened
+	BytesRead    uint64        // bytes read from the stream by the application
+	BytesWritten uint64        // bytes written to the remote side
+	SendWindow   int           // bytes that may be sent before the remote side grants more window
+	Buffered     int           // bytes received but not yet read
+	// true if the stream carries data from the side that opened it only,
+	// see WithUnidirectional
+	Unidirectional bool
 }
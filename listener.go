@@ -0,0 +1,117 @@
+package muxado
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Listener returns a net.Listener whose Accept returns the streams opened by
+// the remote side of sess. A Session is itself a net.Listener, but closing it
+// closes the session; closing the returned listener only stops accepting
+// streams. This suits servers like grpc.Server and http.Server which close
+// their listener when they are shut down:
+//
+//	srv := grpc.NewServer()
+//	go srv.Serve(muxado.Listener(sess))
+//
+// Streams opened by the remote side after the listener is closed are closed.
+func Listener(sess Session) net.Listener {
+	return &streamListener{
+		sess:    sess,
+		streams: make(chan Stream),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+type streamListener struct {
+	sess      Session
+	startOnce sync.Once
+	streams   chan Stream
+	done      chan struct{} // closed when the session stops accepting streams
+	err       error         // error that stopped the session accepting streams, set before done is closed
+	closed    chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+// accept forwards the session's streams to Accept until the listener is
+// closed or the session stops accepting streams
+func (l *streamListener) accept() {
+	for {
+		str, err := l.sess.AcceptStream()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		select {
+		case l.streams <- str:
+		case <-l.closed:
+			str.Close()
+		}
+	}
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.accept() })
+	select {
+	case str := <-l.streams:
+		return str, nil
+	case <-l.done:
+		return nil, l.err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *streamListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *streamListener) Addr() net.Addr {
+	return l.sess.Addr()
+}
+
+// Dialer returns a function that opens a stream on sess each time it is
+// called, ignoring the address. It suits clients that dial through a custom
+// function like grpc.ClientConn:
+//
+//	conn, err := grpc.Dial("muxado", grpc.WithContextDialer(muxado.Dialer(sess)), grpc.WithInsecure())
+//
+// The dial waits for a free slot while Config.MaxStreams streams are open and
+// fails with the context's error if it is done before the stream is opened.
+func Dialer(sess Session) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return openStreamContext(ctx, sess)
+	}
+}
+
+// openStreamContext opens a stream on sess unless ctx is done first
+func openStreamContext(ctx context.Context, sess Session) (Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		str Stream
+		err error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		str, err := sess.OpenStream(WithWaitForSlot(ctx))
+		opened <- result{str, err}
+	}()
+	select {
+	case r := <-opened:
+		return r.str, r.err
+	case <-ctx.Done():
+		// don't leak a stream opened after giving up on it
+		go func() {
+			if r := <-opened; r.str != nil {
+				r.str.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
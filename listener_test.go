@@ -0,0 +1,58 @@
+package muxado
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestListenerDialer(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	l := Listener(server)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	dial := Dialer(client)
+	conn, err := dial(context.Background(), "ignored")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	if _, err := conn.Write([]byte("hi")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hi" {
+		t.Fatalf("Failed to read echo, got %q, %v", buf, err)
+	}
+
+	// closing the listener leaves the session open
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Wrong error from closed listener. Got %v, expected %v", err, net.ErrClosed)
+	}
+	if err := server.Err(); err != nil {
+		t.Fatalf("Closing listener closed session: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dial(ctx, "ignored"); err != context.Canceled {
+		t.Fatalf("Wrong error dialing with canceled context. Got %v, expected %v", err, context.Canceled)
+	}
+}
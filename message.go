@@ -0,0 +1,122 @@
+package muxado
+
+import (
+	"io"
+	"net"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// MessageTransport is a message-oriented carrier for a session, like a
+// WebRTC data channel, an SCTP association or a message queue. It must
+// deliver messages reliably and in order. Sessions over a MessageTransport,
+// see MessageClient and MessageServer, send each frame in a message of its
+// own, so carriers that limit the size of messages need a Config.MaxFrameSize
+// that keeps frames below the limit.
+type MessageTransport interface {
+	// ReadMessage returns the next message. It is called by a single
+	// goroutine of the session.
+	ReadMessage() ([]byte, error)
+
+	// WriteMessage sends p as a single message. It is called by one
+	// goroutine of the session at a time and must not retain p.
+	WriteMessage(p []byte) error
+
+	// Close closes the transport, failing pending calls to ReadMessage.
+	Close() error
+}
+
+// MessageClient returns a new muxado client-side session using the
+// message-oriented transport t
+func MessageClient(t MessageTransport, config *Config) Session {
+	return newMessageSession(t, config, true)
+}
+
+// MessageServer returns a new muxado server-side session using the
+// message-oriented transport t
+func MessageServer(t MessageTransport, config *Config) Session {
+	return newMessageSession(t, config, false)
+}
+
+func newMessageSession(t MessageTransport, config *Config, isClient bool) Session {
+	if config == nil {
+		config = &zeroConfig
+	}
+	config.initDefaults()
+	conn := &messageConn{t: t}
+	return newSession(conn, config, isClient, func(rd io.Reader, _ io.Writer) frame.Framer {
+		// frames bypass the session's write buffer so that each one is sent
+		// in a message of its own
+		return &messageFramer{Framer: config.NewFramer(rd, conn), conn: conn}
+	})
+}
+
+// messageConn adapts a MessageTransport to the byte stream a session reads
+// frames from. Writes are held back until the frame they belong to is
+// complete, see messageFramer.
+type messageConn struct {
+	t       MessageTransport
+	msg     []byte // unread rest of the last message read (reader only)
+	pending []byte // frame being written (writer only)
+}
+
+func (c *messageConn) Read(p []byte) (int, error) {
+	for len(c.msg) == 0 {
+		msg, err := c.t.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.msg = msg
+	}
+	n := copy(p, c.msg)
+	c.msg = c.msg[n:]
+	return n, nil
+}
+
+func (c *messageConn) Write(p []byte) (int, error) {
+	c.pending = append(c.pending, p...)
+	return len(p), nil
+}
+
+// flush sends the frame written since the last flush as a message
+func (c *messageConn) flush() error {
+	err := c.t.WriteMessage(c.pending)
+	c.pending = c.pending[:0]
+	return err
+}
+
+func (c *messageConn) Close() error {
+	return c.t.Close()
+}
+
+func (c *messageConn) LocalAddr() net.Addr {
+	if a, ok := c.t.(interface{ LocalAddr() net.Addr }); ok {
+		return a.LocalAddr()
+	}
+	return &addr{"local"}
+}
+
+func (c *messageConn) RemoteAddr() net.Addr {
+	if a, ok := c.t.(interface{ RemoteAddr() net.Addr }); ok {
+		return a.RemoteAddr()
+	}
+	return &addr{"remote"}
+}
+
+// messageFramer sends each frame it writes as a message of its own
+type messageFramer struct {
+	frame.Framer
+	conn *messageConn
+}
+
+func (fr *messageFramer) WriteFrame(f frame.Frame) error {
+	if err := fr.Framer.WriteFrame(f); err != nil {
+		fr.conn.pending = fr.conn.pending[:0]
+		return err
+	}
+	return fr.conn.flush()
+}
+
+func (fr *messageFramer) Unwrap() frame.Framer {
+	return fr.Framer
+}
@@ -0,0 +1,109 @@
+package muxado
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// chanTransport is an in-memory MessageTransport that records the messages it
+// sends
+type chanTransport struct {
+	in, out chan []byte
+	once    sync.Once
+	closed  chan struct{}
+	peer    *chanTransport
+
+	mu   sync.Mutex
+	sent [][]byte
+}
+
+func newChanTransportPair() (*chanTransport, *chanTransport) {
+	a2b, b2a := make(chan []byte, 64), make(chan []byte, 64)
+	a := &chanTransport{in: b2a, out: a2b, closed: make(chan struct{})}
+	b := &chanTransport{in: a2b, out: b2a, closed: make(chan struct{})}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (t *chanTransport) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-t.in:
+		return msg, nil
+	case <-t.closed:
+		return nil, io.EOF
+	case <-t.peer.closed:
+		return nil, io.EOF
+	}
+}
+
+func (t *chanTransport) WriteMessage(p []byte) error {
+	msg := append([]byte(nil), p...)
+	t.mu.Lock()
+	t.sent = append(t.sent, msg)
+	t.mu.Unlock()
+	select {
+	case t.out <- msg:
+		return nil
+	case <-t.closed:
+		return io.ErrClosedPipe
+	case <-t.peer.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (t *chanTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+// Test that sessions run over message transports and send a frame per
+// message
+func TestMessageTransport(t *testing.T) {
+	t.Parallel()
+	clientT, serverT := newChanTransportPair()
+	client := MessageClient(clientT, &Config{MaxFrameSize: 0x1000})
+	server := MessageServer(serverT, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	data := bytes.Repeat([]byte("x"), 0x3000)
+	go str.Write(data)
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(accepted, buf); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatalf("Read wrong data")
+	}
+	accepted.Write([]byte("x"))
+	readString(t, str, "x")
+
+	clientT.mu.Lock()
+	defer clientT.mu.Unlock()
+	for i, msg := range clientT.sent {
+		r := bytes.NewReader(msg)
+		f, err := frame.NewFramer(r, ioutil.Discard).ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame of message %d: %v", i, err)
+		}
+		if f.Type() == frame.TypeData {
+			io.Copy(ioutil.Discard, f.(*frame.Data).Reader())
+		}
+		if r.Len() != 0 {
+			t.Fatalf("Message %d carries %d bytes after its %v frame", i, r.Len(), f.Type())
+		}
+	}
+}
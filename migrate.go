@@ -0,0 +1,497 @@
+package muxado
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// metadata key of the id that a stream opened by a session with a
+	// Migrator is known by across sessions
+	migrationIdKey = "muxado.migration-id"
+
+	// metadata key of a stream that continues the stream with the given id on
+	// another session. The stream being migrated is half-closed with trailers
+	// carrying the same key to mark where its data continues on the new one.
+	migratedKey = "muxado.migrated-from"
+)
+
+var (
+	// ErrNotMigratable is returned when migrating a stream that was not opened
+	// or accepted by a session with the Migrator
+	ErrNotMigratable = errors.New("stream cannot be migrated by this migrator")
+	// ErrMigrating is returned when migrating a stream that has not finished
+	// its previous migration, i.e. whose data on its previous session has not
+	// all been read
+	ErrMigrating = errors.New("stream is already migrating")
+
+	errNoMigrator = newErr(StreamRefused, errors.New("cannot continue a migrated stream without a Migrator"))
+)
+
+// Migrator moves open streams between sessions to the same peer, so that
+// long-lived streams survive the planned replacement of a session, e.g. when
+// it runs out of stream ids or its server is going down for maintenance.
+// The sessions to the peer share a Migrator through Config.Migrator, as must
+// the peer's sessions. A rotating client migrates the streams of a session
+// it rotates out to the new one.
+//
+// The application keeps using the Stream returned by OpenStream or
+// AcceptStream: its data continues on a new stream of the other session,
+// which the peer's session hands to its Migrator instead of returning it
+// from AcceptStream, so the session being migrated to must be accepting
+// streams. The old stream is half-closed in each direction once the data
+// written to it has been sent, and the data read from it is read up to the
+// point where the data written to the new one starts, so the migration is
+// invisible to both ends of the stream. Only one end of a stream should
+// migrate it at a time.
+//
+// Deadlines and labels carry over to the new stream. Rate limits,
+// priorities, SetNoDelay and Pause apply to the session the stream is on and
+// must be set again after a migration.
+type Migrator struct {
+	mu      sync.Mutex
+	streams map[string]*migratingStream
+	arrived map[string]Stream // continuations of streams not yet accepted
+}
+
+// NewMigrator returns a Migrator for Config.Migrator
+func NewMigrator() *Migrator {
+	return &Migrator{
+		streams: make(map[string]*migratingStream),
+		arrived: make(map[string]Stream),
+	}
+}
+
+// Migrate moves str to the session to. It returns once the new stream is
+// opened; the data written to str before Migrate is still delivered first.
+func (m *Migrator) Migrate(str Stream, to Session) error {
+	ms := findMigrating(str)
+	if ms == nil || ms.m != m {
+		return ErrNotMigratable
+	}
+	return ms.migrate(to)
+}
+
+// MigrateSession moves all streams on the session from that the Migrator
+// knows about to the session to. It returns the first error migrating a
+// stream, but tries to migrate all of them.
+func (m *Migrator) MigrateSession(from, to Session) (err error) {
+	m.mu.Lock()
+	var streams []*migratingStream
+	for _, ms := range m.streams {
+		if ms.Session() == from {
+			streams = append(streams, ms)
+		}
+	}
+	m.mu.Unlock()
+	for _, ms := range streams {
+		if merr := ms.migrate(to); merr != nil && err == nil {
+			err = merr
+		}
+	}
+	return
+}
+
+// newId tags the metadata of a stream being opened with a new migration id
+func (m *Migrator) newId(md map[string]string) map[string]string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	if md == nil {
+		md = make(map[string]string, 1)
+	}
+	md[migrationIdKey] = hex.EncodeToString(b[:])
+	return md
+}
+
+// track wraps a stream opened or accepted by a session so that it can be
+// migrated. An accepted continuation of a migrated stream is handed to the
+// stream it continues, in which case track returns nil.
+func (m *Migrator) track(str Stream) Stream {
+	md := str.Metadata()
+	if id, ok := md[migratedKey]; ok {
+		m.mu.Lock()
+		ms, ok := m.streams[id]
+		if !ok {
+			m.arrived[id] = str
+		}
+		m.mu.Unlock()
+		if ok {
+			go ms.migrated(str)
+		}
+		return nil
+	}
+	id, ok := md[migrationIdKey]
+	if !ok {
+		// opened by a peer without a Migrator
+		return str
+	}
+	ms := &migratingStream{m: m, id: id, w: str, r: str, md: withoutKey(md, migrationIdKey)}
+	ms.cond.L = &ms.mu
+	m.mu.Lock()
+	m.streams[id] = ms
+	next, ok := m.arrived[id]
+	delete(m.arrived, id)
+	m.mu.Unlock()
+	if ok {
+		go ms.migrated(next)
+	}
+	return ms
+}
+
+func (m *Migrator) forget(id string) {
+	m.mu.Lock()
+	delete(m.streams, id)
+	m.mu.Unlock()
+}
+
+func withoutKey(md map[string]string, key string) map[string]string {
+	if len(md) <= 1 {
+		return nil
+	}
+	ret := make(map[string]string, len(md)-1)
+	for k, v := range md {
+		if k != key {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// findMigrating returns the migratingStream beneath the wrappers of str
+func findMigrating(str Stream) *migratingStream {
+	for {
+		switch s := str.(type) {
+		case *migratingStream:
+			return s
+		case WrappingStream:
+			str = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// withContinuation opens a stream that continues the stream with the given
+// migration id. It is neither tracked nor wrapped.
+func withContinuation(id string) StreamOption {
+	return func(o *streamOptions) {
+		o.metadata = map[string]string{migratedKey: id}
+		o.continuation = true
+	}
+}
+
+// migratingStream is a stream whose data continues on streams of other
+// sessions as it is migrated
+type migratingStream struct {
+	m  *Migrator
+	id string
+	md map[string]string
+
+	rmu sync.Mutex // serializes reads
+	wmu sync.Mutex // serializes writes and migrations
+
+	mu          sync.Mutex
+	cond        sync.Cond
+	w           Stream // stream written to
+	r           Stream // stream read from
+	next        Stream // stream read from once r ends with a migration
+	writeClosed bool
+	closed      bool
+	label       string
+	readDl      time.Time
+	writeDl     time.Time
+}
+
+// migrate opens a stream continuing s on the session to and moves the
+// writes there. Reads move once the remote end did the same.
+func (s *migratingStream) migrate(to Session) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	busy, closed := s.next != nil, s.closed
+	s.mu.Unlock()
+	if closed {
+		return ErrStreamClosed
+	}
+	if busy {
+		return ErrMigrating
+	}
+	next, err := to.OpenStream(withContinuation(s.id))
+	if err != nil {
+		return err
+	}
+	if err := s.endWrites(next); err != nil {
+		next.Close()
+		return err
+	}
+	return nil
+}
+
+// migrated moves the writes to next, the continuation of s opened by the
+// remote end
+func (s *migratingStream) migrated(next Stream) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		next.Close()
+		return
+	}
+	if err := s.endWrites(next); err != nil {
+		next.Close()
+	}
+}
+
+// endWrites marks the end of the data written to the current stream and
+// makes next the stream written to and read from after it. It must be called
+// with s.wmu held.
+func (s *migratingStream) endWrites(next Stream) error {
+	s.mu.Lock()
+	writeClosed, label, readDl, writeDl := s.writeClosed, s.label, s.readDl, s.writeDl
+	s.mu.Unlock()
+	if writeClosed {
+		next.CloseWrite()
+	} else if err := s.w.CloseWriteWithTrailers(map[string]string{migratedKey: s.id}); err != nil {
+		return err
+	}
+	if label != "" {
+		next.SetLabel(label)
+	}
+	next.SetReadDeadline(readDl)
+	next.SetWriteDeadline(writeDl)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		next.Close()
+		return nil
+	}
+	s.w = next
+	s.next = next
+	s.cond.Broadcast()
+	return nil
+}
+
+// awaitNext waits for the stream that continues r if it ended with a
+// migration and makes it the stream read from
+func (s *migratingStream) awaitNext(r Stream) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := r.Trailers()[migratedKey]; !ok {
+		// the remote end closed the stream before the migration
+		s.next = nil
+		return false
+	}
+	for s.next == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return false
+	}
+	s.r, s.next = s.next, nil
+	// both ends of r are done
+	go r.Close()
+	return true
+}
+
+func (s *migratingStream) reader() Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r
+}
+
+func (s *migratingStream) writer() Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w
+}
+
+func (s *migratingStream) Read(p []byte) (int, error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+func (s *migratingStream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	for {
+		r := s.reader()
+		n, err := r.ReadContext(ctx, p)
+		if err != io.EOF || !s.awaitNext(r) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (s *migratingStream) Write(p []byte) (int, error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+func (s *migratingStream) WriteContext(ctx context.Context, p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.w.WriteContext(ctx, p)
+}
+
+func (s *migratingStream) Close() error {
+	return s.closeWith(func(str Stream) error { return str.Close() })
+}
+
+// CloseWithError resets the stream the stream is currently written to, and
+// closes the others
+func (s *migratingStream) CloseWithError(errCode ErrorCode, debug []byte) error {
+	return s.closeWith(func(str Stream) error { return str.CloseWithError(errCode, debug) })
+}
+
+// closeWith closes the stream, closing the stream it is currently written
+// to with closeW
+func (s *migratingStream) closeWith(closeW func(Stream) error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.writeClosed = true
+	s.cond.Broadcast()
+	w, r, next := s.w, s.r, s.next
+	s.mu.Unlock()
+	s.m.forget(s.id)
+	closeW(w)
+	if r != w {
+		r.Close()
+	}
+	if next != nil && next != w {
+		next.Close()
+	}
+	return nil
+}
+
+func (s *migratingStream) CloseWrite() error {
+	return s.CloseWriteWithTrailers(nil)
+}
+
+func (s *migratingStream) CloseWriteWithTrailers(trailers map[string]string) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	s.writeClosed = true
+	s.mu.Unlock()
+	if trailers == nil {
+		return s.w.CloseWrite()
+	}
+	return s.w.CloseWriteWithTrailers(trailers)
+}
+
+func (s *migratingStream) Trailers() map[string]string {
+	return s.reader().Trailers()
+}
+
+func (s *migratingStream) SetDeadline(dl time.Time) error {
+	s.SetReadDeadline(dl)
+	return s.SetWriteDeadline(dl)
+}
+
+func (s *migratingStream) SetReadDeadline(dl time.Time) error {
+	s.mu.Lock()
+	s.readDl = dl
+	r, next := s.r, s.next
+	s.mu.Unlock()
+	if next != nil {
+		next.SetReadDeadline(dl)
+	}
+	return r.SetReadDeadline(dl)
+}
+
+func (s *migratingStream) SetWriteDeadline(dl time.Time) error {
+	s.mu.Lock()
+	s.writeDl = dl
+	w := s.w
+	s.mu.Unlock()
+	return w.SetWriteDeadline(dl)
+}
+
+func (s *migratingStream) SetRateLimit(bytesPerSec int) {
+	s.writer().SetRateLimit(bytesPerSec)
+}
+
+func (s *migratingStream) SetNoDelay(noDelay bool) {
+	s.writer().SetNoDelay(noDelay)
+}
+
+func (s *migratingStream) Pause() {
+	s.reader().Pause()
+}
+
+// Resume resumes the stream the data is read from and the one it continues
+// on, which may have been paused before the migration
+func (s *migratingStream) Resume() {
+	s.mu.Lock()
+	r, next := s.r, s.next
+	s.mu.Unlock()
+	if next != nil {
+		next.Resume()
+	}
+	r.Resume()
+}
+
+func (s *migratingStream) SetPriority(p Priority) error {
+	return s.writer().SetPriority(p)
+}
+
+func (s *migratingStream) Priority() Priority {
+	return s.writer().Priority()
+}
+
+// Metadata returns the metadata the stream was opened with, which does not
+// change when it is migrated
+func (s *migratingStream) Metadata() map[string]string {
+	return s.md
+}
+
+func (s *migratingStream) Label() string {
+	return s.writer().Label()
+}
+
+func (s *migratingStream) SetLabel(label string) {
+	s.mu.Lock()
+	s.label = label
+	w, r := s.w, s.r
+	s.mu.Unlock()
+	w.SetLabel(label)
+	r.SetLabel(label)
+}
+
+// Id returns the id of the stream on the session it is currently on
+func (s *migratingStream) Id() uint32 {
+	return s.writer().Id()
+}
+
+// Session returns the session the stream is currently on
+func (s *migratingStream) Session() Session {
+	return s.writer().Session()
+}
+
+func (s *migratingStream) RemoteAddr() net.Addr {
+	return s.writer().RemoteAddr()
+}
+
+func (s *migratingStream) LocalAddr() net.Addr {
+	return s.writer().LocalAddr()
+}
+
+// Unwrap returns the stream the stream is currently written to
+func (s *migratingStream) Unwrap() Stream {
+	return s.writer()
+}
@@ -0,0 +1,185 @@
+package muxado
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// readString reads exactly len(want) bytes from str and compares them
+func readString(t *testing.T, str Stream, want string) {
+	t.Helper()
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(str, buf); err != nil || string(buf) != want {
+		t.Fatalf("Read %q, %v, expected %q", buf, err, want)
+	}
+}
+
+// Test that a stream's data continues in order in both directions after it
+// is migrated to another session, and that the old stream is closed
+func TestMigrateStream(t *testing.T) {
+	t.Parallel()
+	clientCfg, serverCfg := &Config{Migrator: NewMigrator()}, &Config{Migrator: NewMigrator()}
+	client1, server1 := newSessionPair(clientCfg, serverCfg)
+	defer client1.Close()
+	defer server1.Close()
+	client2, server2 := newSessionPair(clientCfg, serverCfg)
+	defer client2.Close()
+	defer server2.Close()
+	// continuations are handed over by AcceptStream
+	go server2.AcceptStream()
+
+	str, err := client1.OpenStream(WithMetadata(map[string]string{"k": "v"}))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server1.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if md := accepted.Metadata(); len(md) != 1 || md["k"] != "v" {
+		t.Fatalf("Accepted stream with metadata %v", md)
+	}
+	readString(t, accepted, "a")
+	accepted.Write([]byte("x"))
+
+	if err := clientCfg.Migrator.Migrate(str, client2); err != nil {
+		t.Fatalf("Failed to migrate stream: %v", err)
+	}
+	if str.Session() != client2 {
+		t.Fatalf("Stream is on %v after migration, expected %v", str.Session(), client2)
+	}
+	if err := clientCfg.Migrator.Migrate(str, client1); err != ErrMigrating {
+		t.Fatalf("Migrated stream during its migration, got %v", err)
+	}
+	str.Write([]byte("b"))
+	readString(t, accepted, "b")
+	accepted.Write([]byte("y"))
+	readString(t, str, "xy")
+	if accepted.Session() != server2 {
+		t.Fatalf("Accepted stream is on %v after migration, expected %v", accepted.Session(), server2)
+	}
+
+	str.CloseWrite()
+	accepted.CloseWriteWithTrailers(map[string]string{"status": "ok"})
+	if rest, err := io.ReadAll(accepted); err != nil || len(rest) != 0 {
+		t.Fatalf("Read %q, %v, expected EOF", rest, err)
+	}
+	if rest, err := io.ReadAll(str); err != nil || len(rest) != 0 {
+		t.Fatalf("Read %q, %v, expected EOF", rest, err)
+	}
+	if tr := str.Trailers(); tr["status"] != "ok" {
+		t.Fatalf("Read trailers %v", tr)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client1.Streams())+len(server1.Streams()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Old streams %v and %v were not closed", client1.Streams(), server1.Streams())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test that a continuation of a migrated stream is refused by a session
+// without a Migrator
+func TestMigrateWithoutMigrator(t *testing.T) {
+	t.Parallel()
+	clientCfg := &Config{Migrator: NewMigrator()}
+	client1, server1 := newSessionPair(clientCfg, nil)
+	defer client1.Close()
+	defer server1.Close()
+
+	str, err := client1.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := NewMigrator().Migrate(str, client1); err != ErrNotMigratable {
+		t.Fatalf("Migrated stream with another migrator, got %v", err)
+	}
+	if err := clientCfg.Migrator.Migrate(str, client1); err != nil {
+		t.Fatalf("Failed to migrate stream: %v", err)
+	}
+	str.Write([]byte("hello"))
+	accepted, err := server1.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	// the old stream is the only one accepted; it ends at the migration
+	if _, err := io.ReadAll(accepted); err != nil {
+		t.Fatalf("Failed to read old stream: %v", err)
+	}
+	server1.SetAcceptDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := server1.AcceptStream(); err != ErrAcceptTimeout {
+		t.Fatalf("Accepted continuation without a Migrator, got %v", err)
+	}
+}
+
+// Test that a rotating client migrates the streams of the session it
+// rotates out
+func TestRotatingSessionMigrates(t *testing.T) {
+	t.Parallel()
+	serverCfg := &Config{Migrator: NewMigrator()}
+	servers := make(chan Session, 2)
+	dial := func() (io.ReadWriteCloser, error) {
+		local, remote := newFakeConnPair()
+		servers <- Server(remote, serverCfg)
+		return local, nil
+	}
+	s, err := NewRotatingClient(dial, &Config{Migrator: NewMigrator()})
+	if err != nil {
+		t.Fatalf("Failed to create rotating session: %v", err)
+	}
+	defer s.Close()
+	first := <-servers
+	defer first.Close()
+
+	// one more id is left
+	s.(*rotatingSession).getCurrent().local.lastId = 1<<31 - 3
+	str, err := s.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := first.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+
+	if _, err := s.OpenStream(); err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	second := <-servers
+	defer second.Close()
+	// continuations are handed over by AcceptStream, which also returns the
+	// stream opened above
+	go func() {
+		for {
+			if _, err := second.AcceptStream(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the stream is migrated in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for accepted.Session() != second {
+		if time.Now().After(deadline) {
+			t.Fatalf("Stream was not migrated to the new session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	str.Write([]byte("b"))
+	readString(t, accepted, "b")
+	accepted.Write([]byte("x"))
+	readString(t, str, "x")
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for rotated session to close")
+	}
+	str.Write([]byte("c"))
+	readString(t, accepted, "c")
+}
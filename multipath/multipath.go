@@ -0,0 +1,433 @@
+// Package multipath provides a transport that carries one muxado session over
+// several underlying connections, or paths, at once. Writes are split into
+// sequenced segments which are scheduled on the path with the least
+// unacknowledged data, so the bandwidth of the paths is aggregated. The
+// receiving end puts the segments back in order and acknowledges them. When a
+// path fails, the segments that were not acknowledged on it are sent again
+// over the remaining paths, so the session survives as long as one path
+// does:
+//
+//	conn := multipath.New(tcpConn, otherConn)
+//	sess := muxado.Client(conn, nil)
+//
+// Both ends of a session must wrap their ends of the same paths, and any
+// paths added later with AddPath, in a multipath Conn. Paths are only
+// considered failed once reading from or writing to them returns an error;
+// a path that silently stops delivering data stalls the session until its
+// idle timeout expires.
+package multipath
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// largest payload carried by a single segment
+	maxSegmentSize = 1 << 16
+
+	// most segments a Conn sends ahead of the remote end's acknowledgements,
+	// which also bounds how far ahead of the next segment to read a
+	// received segment may be
+	maxUnacked = 1024
+
+	typeData = 0
+	typeAck  = 1
+
+	dataHeaderSize = 13 // type, sequence number and payload length
+	ackSize        = 9  // type and sequence number
+)
+
+// ErrNoPaths is returned by writes once every path of a Conn has failed
+var ErrNoPaths = errors.New("multipath: no paths remain")
+
+// segment is a sequenced chunk of written data that has not been acknowledged
+type segment struct {
+	seq   uint64
+	wire  []byte // the serialized segment, header included
+	path  *path  // the path the segment was last sent on
+	acked bool
+}
+
+type path struct {
+	rwc      io.ReadWriteCloser
+	wmu      sync.Mutex // serializes writes to rwc
+	inflight int        // unacknowledged bytes sent on the path (protected by Conn.mu)
+	dead     bool       // (protected by Conn.mu)
+}
+
+func (p *path) write(b []byte) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	_, err := p.rwc.Write(b)
+	return err
+}
+
+// Conn is an io.ReadWriteCloser that spreads the data written to it over
+// several paths and reassembles the data read from them in order.
+type Conn struct {
+	wmu sync.Mutex // serializes calls to Write
+	seq uint64     // sequence number of the next segment (protected by wmu, written with mu held)
+
+	mu      sync.Mutex
+	acked   sync.Cond  // signals that unacked shrank or the Conn can't send any more
+	paths   []*path    // live paths
+	unacked []*segment // sent segments in sequence order
+	closed  bool
+	failed  bool  // every path failed
+	err     error // returned by writes once no paths remain
+
+	rmu     sync.Mutex
+	rcond   sync.Cond
+	next    uint64            // sequence number of the next segment to read
+	pending map[uint64][]byte // segments received ahead of next
+	buf     []byte            // in order data not yet read
+	readErr error             // returned by reads once buf is drained
+
+	ackc    chan struct{} // signals that next has advanced
+	eof     chan struct{} // closed once Read returned io.EOF
+	eofOnce sync.Once
+	done    chan struct{} // closed by Close
+}
+
+// New returns a Conn which carries its data over the given paths.
+func New(paths ...io.ReadWriteCloser) *Conn {
+	c := &Conn{
+		err:     ErrNoPaths,
+		pending: make(map[uint64][]byte),
+		ackc:    make(chan struct{}, 1),
+		eof:     make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.acked.L = &c.mu
+	c.rcond.L = &c.rmu
+	for _, p := range paths {
+		c.AddPath(p)
+	}
+	go c.acker()
+	return c
+}
+
+// AddPath adds another path to the Conn. The remote end must add its end of
+// the same connection to its Conn.
+func (c *Conn) AddPath(rwc io.ReadWriteCloser) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		rwc.Close()
+		return io.ErrClosedPipe
+	}
+	p := &path{rwc: rwc}
+	c.paths = append(c.paths, p)
+	c.err = nil
+	c.failed = false
+	go c.reader(p)
+	return nil
+}
+
+// NumPaths returns the number of paths which have not failed
+func (c *Conn) NumPaths() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.paths)
+}
+
+// Write splits p into segments and sends each on the path with the least
+// unacknowledged data. It waits while too many segments are unacknowledged.
+// Once every path has failed, it returns ErrNoPaths only after Read returned
+// io.EOF, or the Conn was closed, so that the reading side learns that the
+// remote end went away first.
+func (c *Conn) Write(p []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for n < len(p) {
+		size := len(p) - n
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+		seg := &segment{seq: c.seq, wire: make([]byte, dataHeaderSize+size)}
+		seg.wire[0] = typeData
+		binary.BigEndian.PutUint64(seg.wire[1:], seg.seq)
+		binary.BigEndian.PutUint32(seg.wire[9:], uint32(size))
+		copy(seg.wire[dataHeaderSize:], p[n:n+size])
+
+		c.mu.Lock()
+		for len(c.unacked) >= maxUnacked && len(c.paths) > 0 {
+			c.acked.Wait()
+		}
+		c.unacked = append(c.unacked, seg)
+		c.seq++
+		c.mu.Unlock()
+
+		if err = c.transmit(seg); err != nil {
+			c.awaitEOF()
+			return
+		}
+		n += size
+	}
+	return
+}
+
+// awaitEOF waits until Read returned io.EOF or the Conn was closed if every
+// path failed
+func (c *Conn) awaitEOF() {
+	c.mu.Lock()
+	failed := c.failed
+	c.mu.Unlock()
+	if failed {
+		select {
+		case <-c.eof:
+		case <-c.done:
+		}
+	}
+}
+
+// transmit sends seg on the least loaded live path, failing over to the
+// other paths if that path fails
+func (c *Conn) transmit(seg *segment) error {
+	c.mu.Lock()
+	if seg.acked {
+		c.mu.Unlock()
+		return nil
+	}
+	var p *path
+	for _, candidate := range c.paths {
+		if p == nil || candidate.inflight < p.inflight {
+			p = candidate
+		}
+	}
+	if p == nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	seg.path = p
+	p.inflight += len(seg.wire)
+	c.mu.Unlock()
+
+	if err := p.write(seg.wire); err != nil {
+		// retransmits seg too, unless another goroutine already failed
+		// the path and is retransmitting it
+		return c.retransmit(c.fail(p))
+	}
+	return nil
+}
+
+// retransmit sends segs again on the remaining paths
+func (c *Conn) retransmit(segs []*segment) (err error) {
+	for _, seg := range segs {
+		if err = c.transmit(seg); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// fail removes p from the live paths and returns the unacknowledged segments
+// that were sent on it. It returns nil if p had already failed.
+func (c *Conn) fail(p *path) (segs []*segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p.dead {
+		return nil
+	}
+	p.dead = true
+	p.rwc.Close()
+	for i, live := range c.paths {
+		if live == p {
+			c.paths = append(c.paths[:i], c.paths[i+1:]...)
+			break
+		}
+	}
+	if c.closed {
+		return nil
+	}
+	if len(c.paths) == 0 {
+		c.err = ErrNoPaths
+		c.failed = true
+		c.setReadErr(io.EOF)
+		c.acked.Broadcast()
+		return nil
+	}
+	for _, seg := range c.unacked {
+		if seg.path == p {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// reader reads segments and acknowledgements from p until it fails
+func (c *Conn) reader(p *path) {
+	rd := bufio.NewReader(p.rwc)
+	hdr := make([]byte, dataHeaderSize)
+	for c.readSegment(rd, hdr) == nil {
+	}
+	c.retransmit(c.fail(p))
+}
+
+// readSegment reads and handles a single data segment or acknowledgement
+func (c *Conn) readSegment(rd io.Reader, hdr []byte) error {
+	if _, err := io.ReadFull(rd, hdr[:ackSize]); err != nil {
+		return err
+	}
+	seq := binary.BigEndian.Uint64(hdr[1:])
+	switch hdr[0] {
+	case typeData:
+		if _, err := io.ReadFull(rd, hdr[ackSize:]); err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(hdr[ackSize:])
+		if size > maxSegmentSize {
+			return fmt.Errorf("multipath: segment of %d bytes is larger than %d", size, maxSegmentSize)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return err
+		}
+		if err := c.receive(seq, data); err != nil {
+			return err
+		}
+	case typeAck:
+		c.ack(seq)
+	default:
+		return fmt.Errorf("multipath: unknown segment type %d", hdr[0])
+	}
+	return nil
+}
+
+// receive buffers a data segment and delivers any segments that are now in
+// order. It fails on segments further ahead than the remote end sends.
+func (c *Conn) receive(seq uint64, data []byte) error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if _, ok := c.pending[seq]; ok || seq < c.next {
+		// a retransmission of a segment that has already been received
+		return nil
+	}
+	if seq-c.next >= maxUnacked {
+		return fmt.Errorf("multipath: segment %d is too far ahead of segment %d", seq, c.next)
+	}
+	c.pending[seq] = data
+	advanced := false
+	for {
+		data, ok := c.pending[c.next]
+		if !ok {
+			break
+		}
+		delete(c.pending, c.next)
+		c.buf = append(c.buf, data...)
+		c.next++
+		advanced = true
+	}
+	if advanced {
+		c.rcond.Broadcast()
+		select {
+		case c.ackc <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// ack releases the segments the remote end acknowledged receiving. Segments
+// that weren't sent yet can't have been received, so acknowledgements of them
+// are ignored.
+func (c *Conn) ack(next uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if next > c.seq {
+		return
+	}
+	i := 0
+	for ; i < len(c.unacked) && c.unacked[i].seq < next; i++ {
+		seg := c.unacked[i]
+		seg.acked = true
+		if seg.path != nil {
+			seg.path.inflight -= len(seg.wire)
+		}
+		c.unacked[i] = nil
+	}
+	c.unacked = c.unacked[i:]
+	c.acked.Broadcast()
+}
+
+// acker acknowledges received segments. Acknowledgements are written from
+// their own goroutine so that readers never block on writes.
+func (c *Conn) acker() {
+	msg := make([]byte, ackSize)
+	msg[0] = typeAck
+	for {
+		select {
+		case <-c.ackc:
+		case <-c.done:
+			return
+		}
+		c.rmu.Lock()
+		binary.BigEndian.PutUint64(msg[1:], c.next)
+		c.rmu.Unlock()
+
+		c.mu.Lock()
+		paths := append([]*path(nil), c.paths...)
+		c.mu.Unlock()
+		for _, p := range paths {
+			if p.write(msg) == nil {
+				break
+			}
+		}
+	}
+}
+
+// setReadErr must be called with c.mu held
+func (c *Conn) setReadErr(err error) {
+	c.rmu.Lock()
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	c.rcond.Broadcast()
+	c.rmu.Unlock()
+}
+
+// Read reads the data written to the remote Conn in order. It returns io.EOF
+// once every path has failed or been closed by the remote end and all of the
+// data received in order has been read.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.buf) == 0 {
+		if c.readErr == io.EOF {
+			c.eofOnce.Do(func() { close(c.eof) })
+		}
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.rcond.Wait()
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Close closes every path.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.err = io.ErrClosedPipe
+	for _, p := range c.paths {
+		p.dead = true
+		p.rwc.Close()
+	}
+	c.paths = nil
+	c.setReadErr(io.ErrClosedPipe)
+	c.acked.Broadcast()
+	close(c.done)
+	return nil
+}
@@ -0,0 +1,183 @@
+package multipath
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// countingConn counts the bytes written to it
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// newPaths returns the client and server ends of n paths
+func newPaths(n int) (client, server []*countingConn) {
+	for i := 0; i < n; i++ {
+		c, s := net.Pipe()
+		client = append(client, &countingConn{Conn: c})
+		server = append(server, &countingConn{Conn: s})
+	}
+	return
+}
+
+func newConn(paths []*countingConn) *Conn {
+	c := New()
+	for _, p := range paths {
+		c.AddPath(p)
+	}
+	return c
+}
+
+// echo accepts a stream from server and echoes its data back
+func echo(server muxado.Session) {
+	str, err := server.AcceptStream()
+	if err != nil {
+		return
+	}
+	io.Copy(str, str)
+	str.CloseWrite()
+}
+
+// roundTrip writes msg over a new stream and returns what is echoed back,
+// calling during once part of the message has been written
+func roundTrip(t *testing.T, client muxado.Session, msg []byte, during func()) []byte {
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		half := len(msg) / 2
+		str.Write(msg[:half])
+		if during != nil {
+			during()
+		}
+		str.Write(msg[half:])
+		str.CloseWrite()
+	}()
+	buf, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	return buf
+}
+
+func TestMultipathSpreadsWrites(t *testing.T) {
+	t.Parallel()
+	clientPaths, serverPaths := newPaths(2)
+	client := muxado.Client(newConn(clientPaths), nil)
+	server := muxado.Server(newConn(serverPaths), nil)
+	defer client.Close()
+	defer server.Close()
+	go echo(server)
+
+	msg := bytes.Repeat([]byte("0123456789"), 100000)
+	if got := roundTrip(t, client, msg, nil); !bytes.Equal(got, msg) {
+		t.Fatalf("Wrong echo, read %d bytes, expected %d", len(got), len(msg))
+	}
+	for i, p := range clientPaths {
+		if atomic.LoadInt64(&p.written) == 0 {
+			t.Errorf("Nothing was written on path %d", i)
+		}
+	}
+}
+
+func TestMultipathFailover(t *testing.T) {
+	t.Parallel()
+	clientPaths, serverPaths := newPaths(3)
+	clientConn := newConn(clientPaths)
+	client := muxado.Client(clientConn, nil)
+	server := muxado.Server(newConn(serverPaths), nil)
+	defer client.Close()
+	defer server.Close()
+	go echo(server)
+
+	msg := bytes.Repeat([]byte("0123456789"), 100000)
+	got := roundTrip(t, client, msg, func() {
+		clientPaths[0].Close()
+		serverPaths[1].Close()
+	})
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Wrong echo, read %d bytes, expected %d", len(got), len(msg))
+	}
+	if n := clientConn.NumPaths(); n != 1 {
+		t.Fatalf("Conn has %d paths, expected 1", n)
+	}
+}
+
+func TestMultipathAllPathsFail(t *testing.T) {
+	t.Parallel()
+	clientPaths, serverPaths := newPaths(2)
+	client := muxado.Client(newConn(clientPaths), nil)
+	server := muxado.Server(newConn(serverPaths), nil)
+	defer client.Close()
+	defer server.Close()
+
+	for _, p := range clientPaths {
+		p.Close()
+	}
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session did not die after every path failed")
+	}
+	if code, _ := muxado.GetError(server.Err()); code != muxado.PeerEOF {
+		t.Fatalf("Session died with %v, expected %v", code, muxado.PeerEOF)
+	}
+	if _, err := newConn(nil).Write([]byte("x")); err != ErrNoPaths {
+		t.Fatalf("Write without paths returned %v, expected %v", err, ErrNoPaths)
+	}
+}
+
+// Test that paths which send oversized or out of range segments fail
+func TestMultipathBadSegments(t *testing.T) {
+	t.Parallel()
+	for name, wire := range map[string][]byte{
+		"oversized": {typeData, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1},
+		"ahead":     {typeData, 0, 0, 0, 0, 0, 0, maxUnacked >> 8, maxUnacked & 0xFF, 0, 0, 0, 1, 'x'},
+	} {
+		local, remote := net.Pipe()
+		c := New(local)
+		go remote.Write(wire)
+		for i := 0; c.NumPaths() != 0; i++ {
+			if i == 500 {
+				t.Fatalf("%s: path did not fail", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		remote.Close()
+		c.Close()
+	}
+}
+
+// Test that acknowledgements of segments that weren't sent are ignored
+func TestMultipathAckAhead(t *testing.T) {
+	t.Parallel()
+	c := New()
+	defer c.Close()
+	// a segment is queued before it is sent on a path
+	seg := &segment{seq: 0, wire: make([]byte, dataHeaderSize)}
+	c.unacked = append(c.unacked, seg)
+	c.seq = 1
+
+	c.ack(2)
+	if seg.acked || len(c.unacked) != 1 {
+		t.Fatalf("Segment acknowledged by an acknowledgement of segments that weren't sent")
+	}
+	c.ack(1)
+	if !seg.acked || len(c.unacked) != 0 {
+		t.Fatalf("Segment not acknowledged")
+	}
+}
@@ -0,0 +1,140 @@
+package muxtest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// ErrInjectedDisconnect is returned by the write on which a FaultConn disconnects
+var ErrInjectedDisconnect = errors.New("muxtest: injected disconnect")
+
+// Faults configures the faults injected by a FaultConn. The zero value
+// injects no faults.
+type Faults struct {
+	// Seeds the random choices made when injecting faults so that failures
+	// are reproducible.
+	Seed int64
+	// Each write is delayed by a random duration up to Latency.
+	Latency time.Duration
+	// Each write is delivered to the underlying connection in several random
+	// chunks so that the remote side reads frames in pieces.
+	PartialWrites bool
+	// Each read returns a random number of bytes no greater than requested.
+	ShortReads bool
+	// Bytes written after the first TruncateAfter are silently discarded,
+	// leaving the remote side waiting for the rest of a frame. Zero disables
+	// truncation.
+	TruncateAfter int64
+	// The connection is closed once DisconnectAfter bytes have been written,
+	// usually in the middle of a frame. Zero disables disconnection.
+	DisconnectAfter int64
+}
+
+// FaultConn is a net.Conn that injects faults into the connection it wraps
+type FaultConn struct {
+	net.Conn
+	faults Faults
+
+	rngMu sync.Mutex
+	rng   *rand.Rand
+
+	wmu     sync.Mutex
+	written int64 // bytes written, including truncated ones (protected by wmu)
+}
+
+// NewFaultConn returns a FaultConn which injects faults into conn
+func NewFaultConn(conn net.Conn, faults Faults) *FaultConn {
+	return &FaultConn{
+		Conn:   conn,
+		faults: faults,
+		rng:    rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+// intn returns a random int in [0,n)
+func (c *FaultConn) intn(n int) int {
+	c.rngMu.Lock()
+	defer c.rngMu.Unlock()
+	return c.rng.Intn(n)
+}
+
+func (c *FaultConn) Read(p []byte) (int, error) {
+	if c.faults.ShortReads && len(p) > 1 {
+		p = p[:1+c.intn(len(p))]
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *FaultConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.faults.Latency > 0 {
+		time.Sleep(time.Duration(c.intn(int(c.faults.Latency))))
+	}
+
+	if dc := c.faults.DisconnectAfter; dc > 0 && c.written+int64(len(p)) >= dc {
+		n, err := c.write(p[:dc-c.written])
+		c.written += int64(n)
+		c.Conn.Close()
+		if err == nil {
+			err = ErrInjectedDisconnect
+		}
+		return n, err
+	}
+
+	if tr := c.faults.TruncateAfter; tr > 0 && c.written+int64(len(p)) > tr {
+		keep := tr - c.written
+		if keep < 0 {
+			keep = 0
+		}
+		if _, err := c.write(p[:keep]); err != nil {
+			return 0, err
+		}
+		c.written += int64(len(p))
+		return len(p), nil
+	}
+
+	n, err := c.write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// write writes p to the underlying connection, in random chunks if partial
+// writes are enabled
+func (c *FaultConn) write(p []byte) (n int, err error) {
+	if !c.faults.PartialWrites {
+		return c.Conn.Write(p)
+	}
+	for n < len(p) {
+		chunk := 1 + c.intn(len(p)-n)
+		var nn int
+		nn, err = c.Conn.Write(p[n : n+chunk])
+		n += nn
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ExpectSessionError waits up to DefaultTimeout for sess to die and fails the
+// test unless it died with an error carrying code.
+func ExpectSessionError(tb testing.TB, sess muxado.Session, code muxado.ErrorCode) {
+	tb.Helper()
+	select {
+	case <-sess.Done():
+	case <-time.After(DefaultTimeout):
+		tb.Fatalf("session did not die within %v, expected error code %v", DefaultTimeout, code)
+		return
+	}
+	if got, err := muxado.GetError(sess.Err()); got != code {
+		tb.Fatalf("session died with error code %v (%v), expected %v", got, err, code)
+	}
+}
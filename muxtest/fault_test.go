@@ -0,0 +1,88 @@
+package muxtest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// newFaultySessionPair returns sessions whose client transport injects faults
+func newFaultySessionPair(t *testing.T, faults Faults, serverConfig *muxado.Config) (client, server muxado.Session) {
+	clientConn, serverConn := Pipe()
+	client = muxado.Client(NewFaultConn(clientConn, faults), nil)
+	server = muxado.Server(NewFaultConn(serverConn, Faults{Seed: faults.Seed, ShortReads: true}), serverConfig)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return
+}
+
+func TestFaultConnEcho(t *testing.T) {
+	t.Parallel()
+	client, server := newFaultySessionPair(t, Faults{
+		Seed:          1,
+		Latency:       time.Millisecond,
+		PartialWrites: true,
+		ShortReads:    true,
+	}, nil)
+
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.CloseWrite()
+	}()
+
+	msg := bytes.Repeat([]byte("0123456789"), 10000)
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		str.Write(msg)
+		str.CloseWrite()
+	}()
+	buf, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("Wrong echo, read %d bytes, expected %d", len(buf), len(msg))
+	}
+}
+
+func TestFaultConnDisconnect(t *testing.T) {
+	t.Parallel()
+	client, server := newFaultySessionPair(t, Faults{Seed: 2, DisconnectAfter: 20}, nil)
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := str.Write(make([]byte, 100)); err == nil {
+		t.Fatalf("Write succeeded on disconnected transport")
+	}
+	ExpectSessionError(t, server, muxado.PeerEOF)
+}
+
+func TestFaultConnTruncate(t *testing.T) {
+	t.Parallel()
+	client, server := newFaultySessionPair(t, Faults{Seed: 3, TruncateAfter: 20}, &muxado.Config{
+		ReadIdleTimeout: 100 * time.Millisecond,
+	})
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := str.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	ExpectSessionError(t, server, muxado.ReadIdleTimeout)
+}
@@ -0,0 +1,52 @@
+// Package muxtest provides utilities for testing protocols built on muxado
+// without real sockets.
+//
+// NewSessionPair connects a client and server session over an in-process
+// pipe:
+//
+//	client, server := muxtest.NewSessionPair(t, nil, nil)
+//	go func() {
+//	    str, _ := server.AcceptStream()
+//	    io.Copy(str, str)
+//	}()
+//	str, _ := client.OpenStream()
+//
+// A Recorder captures the frames a session writes and reads so that tests can
+// assert on the exact protocol exchange:
+//
+//	rec := muxtest.NewRecorder()
+//	client, server := muxtest.NewSessionPair(t, &muxado.Config{NewFramer: rec.NewFramer(nil)}, nil)
+//	...
+//	rec.ExpectFrames(t,
+//	    muxtest.Frame{Op: muxtest.Write, Type: frame.TypeData, StreamId: 3, Flags: frame.FlagDataSyn},
+//	    muxtest.Frame{Op: muxtest.Read, Type: frame.TypeWndInc, StreamId: 3},
+//	)
+package muxtest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// Pipe returns the two ends of a synchronous, in-memory, full-duplex
+// transport. Both ends implement net.Conn, including deadlines.
+func Pipe() (client net.Conn, server net.Conn) {
+	return net.Pipe()
+}
+
+// NewSessionPair returns a client and a server session connected over a Pipe.
+// Either config may be nil to use the defaults. Both sessions are closed when
+// the test finishes.
+func NewSessionPair(tb testing.TB, clientConfig, serverConfig *muxado.Config) (client muxado.Session, server muxado.Session) {
+	tb.Helper()
+	clientConn, serverConn := Pipe()
+	client = muxado.Client(clientConn, clientConfig)
+	server = muxado.Server(serverConn, serverConfig)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
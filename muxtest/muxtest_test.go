@@ -0,0 +1,71 @@
+package muxtest
+
+import (
+	"io"
+	"testing"
+
+	"github.com/inconshreveable/muxado"
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func TestSessionPair(t *testing.T) {
+	t.Parallel()
+	rec := NewRecorder()
+	client, server := NewSessionPair(t, &muxado.Config{NewFramer: rec.NewFramer(nil)}, nil)
+
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.CloseWrite()
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := str.Write([]byte("hello")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	str.CloseWrite()
+	buf, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("Wrong echo. Got %q, expected %q", buf, "hello")
+	}
+
+	id := frame.StreamId(str.Id())
+	rec.ExpectFrames(t,
+		Frame{Op: Write, Type: frame.TypeData, StreamId: id, Flags: frame.FlagDataSyn},
+		Frame{Op: Write, Type: frame.TypeData, StreamId: id, Flags: frame.FlagDataFin},
+		Frame{Op: Read, Type: frame.TypeData, StreamId: id, Flags: frame.FlagDataFin},
+	)
+}
+
+func TestContainsInOrder(t *testing.T) {
+	t.Parallel()
+	a := Frame{Op: Write, Type: frame.TypeData, StreamId: 1}
+	b := Frame{Op: Read, Type: frame.TypeWndInc, StreamId: 1}
+	c := Frame{Op: Write, Type: frame.TypeRst, StreamId: 3}
+	got := []Frame{a, c, b}
+
+	tcs := []struct {
+		want []Frame
+		ok   bool
+	}{
+		{nil, true},
+		{[]Frame{a, b}, true},
+		{[]Frame{a, c, b}, true},
+		{[]Frame{b, a}, false},
+		{[]Frame{a, a}, false},
+	}
+	for i, tc := range tcs {
+		if ok := containsInOrder(got, tc.want); ok != tc.ok {
+			t.Errorf("case %d: containsInOrder() = %v, expected %v", i, ok, tc.ok)
+		}
+	}
+}
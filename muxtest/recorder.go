@@ -0,0 +1,178 @@
+package muxtest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// DefaultTimeout is how long ExpectFrames waits for the expected frames
+var DefaultTimeout = 5 * time.Second
+
+// Op is the direction of a recorded frame relative to the recording session
+type Op int
+
+const (
+	Write Op = iota // the frame was written by the session
+	Read            // the frame was read by the session
+)
+
+func (op Op) String() string {
+	switch op {
+	case Write:
+		return "WRITE"
+	case Read:
+		return "READ"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Frame is the header of a recorded frame
+type Frame struct {
+	Op       Op
+	Type     frame.Type
+	StreamId frame.StreamId
+	Flags    frame.Flags
+	Length   uint32
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s %s stream=0x%x flags=0x%x length=%d", f.Op, f.Type, f.StreamId, f.Flags, f.Length)
+}
+
+// matches reports whether f matches the expected frame want. Lengths are
+// not compared because how data is split into frames depends on timing.
+func (f Frame) matches(want Frame) bool {
+	return f.Op == want.Op && f.Type == want.Type && f.StreamId == want.StreamId && f.Flags == want.Flags
+}
+
+// Recorder records the headers of the frames written and read by a session.
+// It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	frames  []Frame
+	changed chan struct{} // closed and replaced when a frame is recorded
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{changed: make(chan struct{})}
+}
+
+// NewFramer returns a function suitable for muxado.Config.NewFramer that
+// records the frames passing through the framers returned by newFramer. If
+// newFramer is nil, frame.NewFramer is used.
+func (r *Recorder) NewFramer(newFramer func(io.Reader, io.Writer) frame.Framer) func(io.Reader, io.Writer) frame.Framer {
+	if newFramer == nil {
+		newFramer = frame.NewFramer
+	}
+	return func(rd io.Reader, wr io.Writer) frame.Framer {
+		return &recordingFramer{Framer: newFramer(rd, wr), rec: r}
+	}
+}
+
+// Frames returns the frames recorded so far
+func (r *Recorder) Frames() []Frame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Frame(nil), r.frames...)
+}
+
+// Reset discards the frames recorded so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = nil
+}
+
+// ExpectFrames waits up to DefaultTimeout for want to be recorded in order and
+// fails the test if it is not. Other frames may be recorded between the
+// expected ones. Frames match if their Op, Type, StreamId and Flags are equal.
+func (r *Recorder) ExpectFrames(tb testing.TB, want ...Frame) {
+	tb.Helper()
+	timeout := time.NewTimer(DefaultTimeout)
+	defer timeout.Stop()
+	for {
+		r.mu.Lock()
+		got, changed := append([]Frame(nil), r.frames...), r.changed
+		r.mu.Unlock()
+
+		if containsInOrder(got, want) {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			tb.Fatalf("expected frames were not recorded within %v\nexpected:\n%s\ngot:\n%s", DefaultTimeout, formatFrames(want), formatFrames(got))
+			return
+		}
+	}
+}
+
+func (r *Recorder) record(f Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, f)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// containsInOrder reports whether want is a subsequence of got
+func containsInOrder(got, want []Frame) bool {
+	i := 0
+	for _, f := range got {
+		if i < len(want) && f.matches(want[i]) {
+			i++
+		}
+	}
+	return i == len(want)
+}
+
+func formatFrames(frames []Frame) string {
+	var b strings.Builder
+	for _, f := range frames {
+		fmt.Fprintf(&b, "\t%v\n", f)
+	}
+	return b.String()
+}
+
+type recordingFramer struct {
+	frame.Framer
+	rec *Recorder
+}
+
+func (fr *recordingFramer) WriteFrame(f frame.Frame) error {
+	if err := fr.Framer.WriteFrame(f); err != nil {
+		return err
+	}
+	fr.rec.record(header(Write, f))
+	return nil
+}
+
+func (fr *recordingFramer) ReadFrame() (frame.Frame, error) {
+	f, err := fr.Framer.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	fr.rec.record(header(Read, f))
+	return f, nil
+}
+
+func (fr *recordingFramer) Unwrap() frame.Framer {
+	return fr.Framer
+}
+
+func header(op Op, f frame.Frame) Frame {
+	return Frame{
+		Op:       op,
+		Type:     f.Type(),
+		StreamId: f.StreamId(),
+		Flags:    f.Flags(),
+		Length:   f.Length(),
+	}
+}
@@ -0,0 +1,101 @@
+package netsim
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// MeasureGoodput opens the given number of streams from client to server and
+// concurrently writes size bytes over each. It returns the goodput of each
+// stream in bytes per second: the application data it delivered divided by
+// the time from the start of the measurement until the server read the
+// stream's last byte.
+func MeasureGoodput(client, server muxado.Session, streams int, size int64) ([]float64, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		elapsed  = make(map[uint32]time.Duration, streams)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+
+	start := time.Now()
+
+	// the server reads every stream to EOF
+	wg.Add(streams)
+	go func() {
+		for i := 0; i < streams; i++ {
+			str, err := server.AcceptStream()
+			if err != nil {
+				fail(err)
+				for ; i < streams; i++ {
+					wg.Done()
+				}
+				return
+			}
+			go func() {
+				defer wg.Done()
+				defer str.Close()
+				n, err := io.Copy(ioutil.Discard, str)
+				if err == nil && n != size {
+					err = fmt.Errorf("stream 0x%x delivered %d bytes, expected %d", str.Id(), n, size)
+				}
+				if err != nil {
+					fail(err)
+					return
+				}
+				mu.Lock()
+				elapsed[str.Id()] = time.Since(start)
+				mu.Unlock()
+			}()
+		}
+	}()
+
+	ids := make([]uint32, streams)
+	for i := range ids {
+		str, err := client.OpenStream()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = str.Id()
+		go func() {
+			defer str.Close()
+			if _, err := io.CopyN(str, zeros{}, size); err != nil {
+				fail(err)
+				return
+			}
+			str.CloseWrite()
+		}()
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	goodput := make([]float64, streams)
+	for i, id := range ids {
+		goodput[i] = float64(size) / elapsed[id].Seconds()
+	}
+	return goodput, nil
+}
+
+// zeros is an infinite source of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
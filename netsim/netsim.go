@@ -0,0 +1,182 @@
+// Package netsim provides an in-memory transport that simulates the
+// bandwidth, latency and jitter of a network link, and helpers for measuring
+// the goodput of muxado streams over it. It is intended for evaluating
+// changes to flow control and scheduling under realistic network conditions:
+//
+//	link := netsim.Link{Bandwidth: 10 << 20, RTT: 50 * time.Millisecond}
+//	c, s := netsim.Pipe(link, link)
+//	client, server := muxado.Client(c, nil), muxado.Server(s, nil)
+//	goodput, err := netsim.MeasureGoodput(client, server, 4, 1<<20)
+package netsim
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// size of the packets writes are split into
+const mtu = 1500
+
+// Link describes the simulated conditions of one direction of a Pipe. The
+// zero value is an infinitely fast link.
+type Link struct {
+	// Bytes per second that can be written to the link. Zero means unlimited.
+	Bandwidth int64
+	// Round-trip time of the link. Each packet arrives RTT/2 after it has
+	// been written.
+	RTT time.Duration
+	// Each packet is delayed by a further random duration up to Jitter.
+	// Packets are never reordered.
+	Jitter time.Duration
+	// Seeds the jitter so that simulations are reproducible.
+	Seed int64
+}
+
+type packet struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// link is one direction of a simulated connection
+type link struct {
+	Link
+
+	mu        sync.Mutex
+	cond      sync.Cond
+	packets   []packet
+	busyUntil time.Time // when the link finishes transmitting queued packets
+	lastAt    time.Time // delivery time of the last queued packet
+	closed    bool
+	rng       *rand.Rand
+}
+
+func newLink(l Link) *link {
+	ln := &link{Link: l, rng: rand.New(rand.NewSource(l.Seed))}
+	ln.cond.L = &ln.mu
+	return ln
+}
+
+// write queues p for delivery and blocks until it has been transmitted
+func (l *link) write(p []byte) (int, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	now := time.Now()
+	if l.busyUntil.Before(now) {
+		l.busyUntil = now
+	}
+	for n := 0; n < len(p); n += mtu {
+		end := n + mtu
+		if end > len(p) {
+			end = len(p)
+		}
+		if l.Bandwidth > 0 {
+			l.busyUntil = l.busyUntil.Add(time.Duration(int64(end-n) * int64(time.Second) / l.Bandwidth))
+		}
+		at := l.busyUntil.Add(l.RTT / 2)
+		if l.Jitter > 0 {
+			at = at.Add(time.Duration(l.rng.Int63n(int64(l.Jitter))))
+		}
+		if at.Before(l.lastAt) {
+			at = l.lastAt
+		}
+		l.lastAt = at
+		l.packets = append(l.packets, packet{data: append([]byte(nil), p[n:end]...), deliverAt: at})
+	}
+	wait := time.Until(l.busyUntil)
+	l.cond.Broadcast()
+	l.mu.Unlock()
+
+	// the writer is blocked while the link transmits its data
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return len(p), nil
+}
+
+// read blocks until a packet has arrived and reads from it
+func (l *link) read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		if len(l.packets) == 0 {
+			if l.closed {
+				return 0, io.EOF
+			}
+			l.cond.Wait()
+			continue
+		}
+		pkt := &l.packets[0]
+		if wait := time.Until(pkt.deliverAt); wait > 0 {
+			l.mu.Unlock()
+			time.Sleep(wait)
+			l.mu.Lock()
+			continue
+		}
+		n := copy(p, pkt.data)
+		pkt.data = pkt.data[n:]
+		if len(pkt.data) == 0 {
+			l.packets[0] = packet{}
+			l.packets = l.packets[1:]
+		}
+		return n, nil
+	}
+}
+
+func (l *link) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// Conn is one end of a simulated connection. Data written to a Conn that
+// has already been transmitted is still delivered to the remote end after it
+// is closed.
+type Conn struct {
+	rd, wr    *link
+	local     net.Addr
+	remote    net.Addr
+	closeOnce sync.Once
+}
+
+type addr string
+
+func (a addr) Network() string { return "netsim" }
+func (a addr) String() string  { return string(a) }
+
+// Pipe returns the two ends of a simulated connection. Data written to client
+// travels over a link with the conditions of up and data written to server
+// over a link with the conditions of down.
+func Pipe(up, down Link) (client *Conn, server *Conn) {
+	upLink, downLink := newLink(up), newLink(down)
+	client = &Conn{rd: downLink, wr: upLink, local: addr("client"), remote: addr("server")}
+	server = &Conn{rd: upLink, wr: downLink, local: addr("server"), remote: addr("client")}
+	return
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.rd.read(p)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.wr.write(p)
+}
+
+// Close closes both directions of the connection. The remote end reads the
+// data that is already in flight and then io.EOF.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.wr.close()
+		c.rd.close()
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
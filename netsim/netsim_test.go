@@ -0,0 +1,121 @@
+package netsim
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+func TestLatency(t *testing.T) {
+	t.Parallel()
+	link := Link{RTT: 100 * time.Millisecond, Jitter: 10 * time.Millisecond}
+	c, s := Pipe(link, link)
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if d := time.Since(start); d < link.RTT/2 || d > link.RTT {
+		t.Fatalf("One-way delay %v outside of [%v, %v]", d, link.RTT/2, link.RTT)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	t.Parallel()
+	link := Link{Bandwidth: 1 << 20}
+	c, s := Pipe(link, link)
+	go io.Copy(ioutil.Discard, s)
+
+	start := time.Now()
+	if _, err := c.Write(make([]byte, 100<<10)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	c.Close()
+	// 100KB at 1MB/s
+	if d := time.Since(start); d < 90*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("Write of 100KB at 1MB/s took %v", d)
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+	c, s := Pipe(Link{}, Link{})
+	c.Write([]byte("bye"))
+	c.Close()
+	buf, err := ioutil.ReadAll(s)
+	if err != nil || string(buf) != "bye" {
+		t.Fatalf("Read %q, %v after close, expected %q", buf, err, "bye")
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatalf("Write succeeded after close")
+	}
+}
+
+func TestMeasureGoodput(t *testing.T) {
+	t.Parallel()
+	link := Link{Bandwidth: 4 << 20, RTT: 10 * time.Millisecond}
+	c, s := Pipe(link, link)
+	client, server := muxado.Client(c, nil), muxado.Server(s, nil)
+	defer client.Close()
+	defer server.Close()
+
+	goodput, err := MeasureGoodput(client, server, 4, 64<<10)
+	if err != nil {
+		t.Fatalf("Failed to measure goodput: %v", err)
+	}
+	if len(goodput) != 4 {
+		t.Fatalf("Got goodput for %d streams, expected 4", len(goodput))
+	}
+	var total float64
+	for _, g := range goodput {
+		total += g
+	}
+	if total > float64(link.Bandwidth)*1.1 {
+		t.Fatalf("Total goodput %.0f B/s exceeds link bandwidth %d B/s", total, link.Bandwidth)
+	}
+}
+
+func BenchmarkGoodput(b *testing.B) {
+	links := []struct {
+		name string
+		link Link
+	}{
+		{"LAN", Link{Bandwidth: 100 << 20, RTT: time.Millisecond}},
+		{"WAN", Link{Bandwidth: 10 << 20, RTT: 50 * time.Millisecond, Jitter: 5 * time.Millisecond}},
+		{"Satellite", Link{Bandwidth: 2 << 20, RTT: 600 * time.Millisecond, Jitter: 20 * time.Millisecond}},
+	}
+	for _, l := range links {
+		b.Run(l.name, func(b *testing.B) {
+			c, s := Pipe(l.link, l.link)
+			client, server := muxado.Client(c, nil), muxado.Server(s, nil)
+			defer client.Close()
+			defer server.Close()
+
+			const streams, size = 4, 1 << 20
+			b.SetBytes(streams * size)
+			var total, min float64
+			for i := 0; i < b.N; i++ {
+				goodput, err := MeasureGoodput(client, server, streams, size)
+				if err != nil {
+					b.Fatalf("Failed to measure goodput: %v", err)
+				}
+				for j, g := range goodput {
+					total += g
+					if (i == 0 && j == 0) || g < min {
+						min = g
+					}
+				}
+			}
+			b.ReportMetric(total/float64(b.N*streams), "B/s/stream")
+			b.ReportMetric(min, "min-B/s/stream")
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package muxado
+
+import "context"
+
+// StreamOption configures a stream opened with OpenStream
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	compress    bool
+	codecs      []CodecId
+	metadata    map[string]string
+	proxyHeader []byte
+	label       string
+	slotWait    context.Context
+	pushedBy    uint32 // id of the stream a pushed stream is associated with, see WithPush
+
+	// opens a stream carrying data from this side only, see WithUnidirectional
+	unidirectional bool
+
+	// opens the continuation of a migrated stream, see Migrator
+	continuation bool
+}
+
+// WithCompression compresses the data written to the stream in both
+// directions with the first of codecs that both sides support, see
+// Config.Codecs, or with DEFLATE if no codecs are given. OpenStream waits for
+// the remote side's SETTINGS to learn which codecs it accepts, which sessions
+// advertise when their Config enables Compression. If it accepts none of
+// them the option has no effect.
+func WithCompression(codecs ...CodecId) StreamOption {
+	return func(o *streamOptions) {
+		o.compress = true
+		o.codecs = append([]CodecId(nil), codecs...)
+	}
+}
+
+// WithMetadata attaches a small block of key/value metadata to the stream which
+// the remote side reads with Stream.Metadata when it accepts the stream. The
+// metadata is sent in a HEADERS frame that opens the stream, so the remote side
+// must support HEADERS frames. It is limited to 16KB.
+func WithMetadata(md map[string]string) StreamOption {
+	return func(o *streamOptions) {
+		o.metadata = make(map[string]string, len(md))
+		for k, v := range md {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// WithLabel attaches a human-readable label to the stream, e.g. "db-sync", so
+// that it can be told apart from the session's other streams when debugging.
+// The label is local to this side of the session and is not sent to the
+// remote side. It is returned by Stream.Label and reported by Session.Streams.
+func WithLabel(label string) StreamOption {
+	return func(o *streamOptions) {
+		o.label = label
+	}
+}
+
+// WithWaitForSlot makes OpenStream wait for one of the local side's streams
+// to close when Config.MaxStreams of them are open, instead of failing with
+// ErrStreamsLimited. It fails with the context's error if ctx is done first,
+// and with ErrOpenTimeout when the open deadline passes.
+func WithWaitForSlot(ctx context.Context) StreamOption {
+	return func(o *streamOptions) {
+		o.slotWait = ctx
+	}
+}
+
+// WithUnidirectional opens a stream that carries data from this side of the
+// session only. Reads from it return io.EOF right away and writes to the
+// remote side's end of it fail with ErrUnidirectional. Data the remote side
+// sends on it anyway resets it. No window is spent on the direction that
+// doesn't carry data, which suits protocols that only flow one way, like log
+// shipping or event feeds. Opening the stream waits for the protocol version
+// to be negotiated and fails with ErrUnidirectionalUnsupported if the remote
+// side speaks a version before 4. The stream is opened with a HEADERS frame,
+// see WithMetadata.
+func WithUnidirectional() StreamOption {
+	return func(o *streamOptions) {
+		o.unidirectional = true
+	}
+}
+
+func newStreamOptions(opts []StreamOption) (o streamOptions) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package muxado
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// paddingBuckets returns the sizes of buckets that PADDING frames can pad up
+// to in ascending order
+func paddingBuckets(buckets []int) []int {
+	var ret []int
+	for _, b := range buckets {
+		if b > 0 && b <= frame.MaxLength {
+			ret = append(ret, b)
+		}
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// paddingLength returns the length of the PADDING frame that pads n bytes of
+// frames up to the smallest bucket they fit in along with the PADDING frame's
+// header, or -1 if n fills a bucket already
+func paddingLength(buckets []int, n int) int {
+	for _, b := range buckets {
+		switch {
+		case b == n:
+			return -1
+		case b >= n+frame.HeaderSize:
+			return b - n - frame.HeaderSize
+		}
+	}
+	largest := buckets[len(buckets)-1]
+	if n%largest == 0 {
+		return -1
+	}
+	padded := (n + frame.HeaderSize + largest - 1) / largest * largest
+	return padded - n - frame.HeaderSize
+}
+
+// pad writes a PADDING frame after a DATA frame if the remote side accepts
+// them, see Config.PaddingBuckets
+func (s *session) pad(f frame.Frame) error {
+	if f.Type() != frame.TypeData || len(s.config.PaddingBuckets) == 0 || atomic.LoadUint32(&s.remote.padding) == 0 {
+		return nil
+	}
+	n := frame.HeaderSize + int(f.Length())
+	if f.Flags().IsSet(frame.FlagDataExtended) {
+		n += 8 // the extended length
+	}
+	length := paddingLength(s.config.PaddingBuckets, n)
+	if length < 0 {
+		return nil
+	}
+	if err := s.padding.Pack(length); err != nil {
+		return newErr(InternalError, fmt.Errorf("failed to pack PADDING frame: %v", err))
+	}
+	return fromFrameError(s.framer.WriteFrame(&s.padding))
+}
@@ -0,0 +1,103 @@
+package muxado
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func TestPaddingLength(t *testing.T) {
+	t.Parallel()
+	buckets := paddingBuckets([]int{4096, 512, -1})
+	for _, tc := range []struct {
+		n, want int
+	}{
+		{100, 512 - 100 - frame.HeaderSize},
+		{512, -1},
+		// no room for the PADDING frame's header in the first bucket
+		{510, 4096 - 510 - frame.HeaderSize},
+		{4096, -1},
+		{5000, 8192 - 5000 - frame.HeaderSize},
+		{8192, -1},
+	} {
+		if got := paddingLength(buckets, tc.n); got != tc.want {
+			t.Errorf("Padding for %d bytes is %d, expected %d", tc.n, got, tc.want)
+		}
+	}
+}
+
+// Test that DATA frames are padded up to a bucket when both sides set
+// PaddingBuckets
+func TestPadding(t *testing.T) {
+	t.Parallel()
+	buckets := []int{512, 4096}
+	for _, tc := range []struct {
+		name   string
+		server []int
+		padded bool
+	}{
+		{"negotiated", buckets, true},
+		{"not accepted", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var sizes []int
+			var types []frame.Type
+			record := func(f frame.Frame) (frame.Frame, error) {
+				mu.Lock()
+				types = append(types, f.Type())
+				sizes = append(sizes, frame.HeaderSize+int(f.Length()))
+				mu.Unlock()
+				return f, nil
+			}
+			client, server := newSessionPair(
+				&Config{PaddingBuckets: buckets, OnFrameWrite: record},
+				&Config{PaddingBuckets: tc.server},
+			)
+			defer client.Close()
+			defer server.Close()
+
+			str, err := client.OpenStream()
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			str.Write([]byte("a"))
+			accepted, err := server.AcceptStream()
+			if err != nil {
+				t.Fatalf("Failed to accept stream: %v", err)
+			}
+			readString(t, accepted, "a")
+			// the remote side's SETTINGS arrive before its first frame
+			accepted.Write([]byte("x"))
+			readString(t, str, "x")
+			mu.Lock()
+			types, sizes = nil, nil
+			mu.Unlock()
+
+			data := bytes.Repeat([]byte("a"), 1000)
+			str.Write(data[:10])
+			str.Write(data)
+			readString(t, accepted, string(data[:10])+string(data))
+
+			mu.Lock()
+			defer mu.Unlock()
+			padded := 0
+			for i, ftype := range types {
+				if ftype != frame.TypeData {
+					continue
+				}
+				if i+1 < len(types) && types[i+1] == frame.TypePadding {
+					padded++
+					if total := sizes[i] + sizes[i+1]; total != 512 && total != 4096 {
+						t.Fatalf("DATA frame of %d bytes was padded to %d", sizes[i], total)
+					}
+				}
+			}
+			if tc.padded && padded == 0 || !tc.padded && padded != 0 {
+				t.Fatalf("Padded %d DATA frames, expected padding: %v", padded, tc.padded)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package muxado
+
+import (
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+const (
+	// round trip times beyond this are not trusted, e.g. PING acks carrying
+	// data that is not one of our timestamps
+	maxRTTSample = time.Minute
+
+	// a WNDINC probe that is not answered in this time is replaced, e.g. if
+	// the remote application stopped reading
+	rttProbeTimeout = 10 * time.Second
+
+	// shortest interval over which the delivery rate is measured
+	minRateInterval = 50 * time.Millisecond
+
+	// a gap between window updates this long, or twice the round trip time,
+	// means the application sent nothing and restarts the rate interval
+	minIdleGap = 200 * time.Millisecond
+)
+
+// PathStats are estimates of the properties of the path between the two
+// sides of a session, see Session.PathStats. They are zero until measured.
+type PathStats struct {
+	// Smoothed round trip time, measured from the replies to PINGs and from
+	// how long the remote side takes to return window for the data that is
+	// sent, which includes the time its application took to read it.
+	RTT time.Duration
+
+	// Mean deviation of the round trip time.
+	RTTVar time.Duration
+
+	// Smallest round trip time measured.
+	MinRTT time.Duration
+
+	// Smoothed rate in bytes per second at which the remote side returned
+	// window for stream data. Intervals in which no data was delivered do not
+	// count, so it estimates what the path delivers rather than what the
+	// application sends.
+	DeliveryRate uint64
+}
+
+// pathEstimator estimates a session's PathStats
+type pathEstimator struct {
+	mu    sync.Mutex
+	stats PathStats
+
+	// a DATA frame whose round trip is timed by the WNDINC that covers it
+	probe struct {
+		id     frame.StreamId // zero if there is no probe
+		offset uint64         // stream offset at the end of the frame
+		sent   time.Time
+	}
+
+	delivered     uint64    // bytes delivered since intervalStart
+	intervalStart time.Time // start of the delivery rate interval
+	lastAck       time.Time // when window was last returned
+	rate          float64   // smoothed delivery rate
+}
+
+// sampleRTT adds a round trip time measurement, smoothing it like TCP (RFC 6298)
+func (e *pathEstimator) sampleRTT(rtt time.Duration) {
+	if rtt <= 0 || rtt > maxRTTSample {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sampleRTTLocked(rtt)
+}
+
+func (e *pathEstimator) sampleRTTLocked(rtt time.Duration) {
+	st := &e.stats
+	if st.RTT == 0 {
+		st.RTT, st.RTTVar, st.MinRTT = rtt, rtt/2, rtt
+		return
+	}
+	dev := st.RTT - rtt
+	if dev < 0 {
+		dev = -dev
+	}
+	st.RTTVar = (3*st.RTTVar + dev) / 4
+	st.RTT = (7*st.RTT + rtt) / 8
+	if rtt < st.MinRTT {
+		st.MinRTT = rtt
+	}
+}
+
+// sent is called when stream data up to offset has been written
+func (e *pathEstimator) sent(id frame.StreamId, offset uint64, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.probe.id == 0 || now.Sub(e.probe.sent) > rttProbeTimeout {
+		e.probe.id, e.probe.offset, e.probe.sent = id, offset, now
+	}
+}
+
+// acked is called when the remote side returned n bytes of window, making the
+// total returned for the stream offset
+func (e *pathEstimator) acked(id frame.StreamId, offset uint64, n uint32, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.probe.id == id && offset >= e.probe.offset {
+		if rtt := now.Sub(e.probe.sent); rtt > 0 {
+			e.sampleRTTLocked(rtt)
+		}
+		e.probe.id = 0
+	}
+
+	idleGap := 2 * e.stats.RTT
+	if idleGap < minIdleGap {
+		idleGap = minIdleGap
+	}
+	idle := now.Sub(e.lastAck) > idleGap
+	e.lastAck = now
+	if idle {
+		// the interval starts now, so the bytes returned now do not count
+		e.delivered, e.intervalStart = 0, now
+		return
+	}
+	e.delivered += uint64(n)
+	interval := now.Sub(e.intervalStart)
+	if interval < minRateInterval || interval < e.stats.RTT {
+		return
+	}
+	rate := float64(e.delivered) / interval.Seconds()
+	if e.rate == 0 {
+		e.rate = rate
+	} else {
+		e.rate = (3*e.rate + rate) / 4
+	}
+	e.stats.DeliveryRate = uint64(e.rate)
+	e.delivered, e.intervalStart = 0, now
+}
+
+// forget drops the probe of a stream that was closed
+func (e *pathEstimator) forget(id frame.StreamId) {
+	e.mu.Lock()
+	if e.probe.id == id {
+		e.probe.id = 0
+	}
+	e.mu.Unlock()
+}
+
+func (e *pathEstimator) get() PathStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats
+}
@@ -0,0 +1,87 @@
+package muxado
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestPathEstimatorRTT(t *testing.T) {
+	t.Parallel()
+	var e pathEstimator
+	e.sampleRTT(100 * time.Millisecond)
+	if st := e.get(); st.RTT != 100*time.Millisecond || st.RTTVar != 50*time.Millisecond || st.MinRTT != 100*time.Millisecond {
+		t.Fatalf("Wrong stats after first sample: %+v", st)
+	}
+	e.sampleRTT(20 * time.Millisecond)
+	st := e.get()
+	if st.RTT != 90*time.Millisecond || st.RTTVar != 57500*time.Microsecond || st.MinRTT != 20*time.Millisecond {
+		t.Fatalf("Wrong stats after second sample: %+v", st)
+	}
+	e.sampleRTT(-time.Second)
+	e.sampleRTT(time.Hour)
+	if e.get() != st {
+		t.Fatalf("Implausible samples changed the stats to %+v", e.get())
+	}
+}
+
+func TestPathEstimatorWndInc(t *testing.T) {
+	t.Parallel()
+	var e pathEstimator
+	start := time.Now()
+	e.sent(3, 1000, start)
+	e.sent(5, 500, start.Add(time.Millisecond)) // a probe is outstanding
+	e.acked(5, 500, 500, start.Add(10*time.Millisecond))
+	if rtt := e.get().RTT; rtt != 0 {
+		t.Fatalf("Sampled %v from a stream without a probe", rtt)
+	}
+	e.acked(3, 600, 600, start.Add(20*time.Millisecond))
+	e.acked(3, 1000, 400, start.Add(30*time.Millisecond))
+	if rtt := e.get().RTT; rtt != 30*time.Millisecond {
+		t.Fatalf("Sampled %v, expected 30ms", rtt)
+	}
+
+	// 10KB every 10ms for 100ms
+	now := start.Add(time.Second)
+	for i := 0; i <= 10; i++ {
+		e.acked(3, 0, 10000, now.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	if rate := e.get().DeliveryRate; rate != 1000000 {
+		t.Fatalf("Delivery rate is %d, expected 1000000", rate)
+	}
+}
+
+func TestPathStats(t *testing.T) {
+	t.Parallel()
+	config := &Config{ReadIdleTimeout: 20 * time.Millisecond}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, str)
+	}()
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	buf := make([]byte, 64*1024)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := client.PathStats()
+		if st.RTT > 0 && st.MinRTT > 0 && st.DeliveryRate > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Path was not measured: %+v", st)
+		}
+		if _, err := str.Write(buf); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package muxado
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// ErrPreAuthLimit closes server sessions whose clients exceed
+// Config.PreAuthLimits
+var ErrPreAuthLimit = newErr(EnhanceYourCalm, errors.New("pre-authentication limit exceeded"))
+
+// PreAuthLimits bounds the work the client of a server session can cause
+// before it authenticated, see Config.PreAuthLimits. Zero fields are not
+// limited.
+type PreAuthLimits struct {
+	// Number of streams the client may open.
+	MaxStreams int
+	// Number of bytes of unread data the client may have buffered across
+	// all streams.
+	MaxBuffered int64
+	// Number of frames other than DATA the client may send per second, in
+	// bursts of up to as many.
+	MaxControlFrameRate int
+}
+
+// preAuthState tracks a client against the session's PreAuthLimits
+type preAuthState struct {
+	limits  PreAuthLimits
+	streams int         // streams opened by the client (reader only)
+	control tokenBucket // control frames sent by the client
+}
+
+func (s *session) initPreAuth(config *Config, isClient bool) {
+	if isClient || config.PreAuthLimits == nil {
+		return
+	}
+	s.preAuth = &preAuthState{limits: *config.PreAuthLimits}
+	s.preAuth.control.SetRate(s.preAuth.limits.MaxControlFrameRate)
+}
+
+// enforcesPreAuth reports whether the session enforces its PreAuthLimits:
+// until the client authenticated, or for good without a Config.Verifier
+func (s *session) enforcesPreAuth() bool {
+	if s.preAuth == nil {
+		return false
+	}
+	if s.auth == nil || s.auth.verifier == nil {
+		return true
+	}
+	return !isClosed(s.auth.authenticated)
+}
+
+// checkPreAuth checks the client against the session's PreAuthLimits after
+// handling the frame f it sent
+func (s *session) checkPreAuth(f frame.Frame) error {
+	if !s.enforcesPreAuth() {
+		return nil
+	}
+	p := s.preAuth
+	switch f := f.(type) {
+	case *frame.Data:
+		if f.Syn() {
+			p.streams++
+		}
+	case *frame.Headers:
+		if f.Syn() {
+			p.streams++
+		}
+	}
+	if f.Type() != frame.TypeData && p.control.Take(1) > 0 {
+		return ErrPreAuthLimit
+	}
+	if max := p.limits.MaxStreams; max > 0 && p.streams > max {
+		return ErrPreAuthLimit
+	}
+	if max := p.limits.MaxBuffered; max > 0 && atomic.LoadInt64(&s.buffered) > max {
+		return ErrPreAuthLimit
+	}
+	return nil
+}
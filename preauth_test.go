@@ -0,0 +1,82 @@
+package muxado
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// Test that server sessions disconnect clients that exceed their
+// PreAuthLimits
+func TestPreAuthLimits(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name   string
+		limits PreAuthLimits
+		abuse  func(client Session)
+	}{
+		{"streams", PreAuthLimits{MaxStreams: 2}, func(client Session) {
+			for i := 0; i < 3; i++ {
+				if str, err := client.OpenStream(); err == nil {
+					str.Write([]byte("a"))
+				}
+			}
+		}},
+		{"buffered", PreAuthLimits{MaxBuffered: 100}, func(client Session) {
+			if str, err := client.OpenStream(); err == nil {
+				str.Write(bytes.Repeat([]byte("a"), 1000))
+			}
+		}},
+		{"control frames", PreAuthLimits{MaxControlFrameRate: 5}, func(client Session) {
+			for i := 0; i < 20; i++ {
+				client.WriteUserFrame(frame.TypeUserMin, 0, 0, nil)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := tc.limits
+			client, server := newSessionPair(nil, &Config{PreAuthLimits: &limits})
+			defer client.Close()
+			defer server.Close()
+			go tc.abuse(client)
+			select {
+			case <-server.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Server did not disconnect abusive client")
+			}
+			if err := server.Err(); err != ErrPreAuthLimit {
+				t.Fatalf("Server closed with %v, expected %v", err, ErrPreAuthLimit)
+			}
+			if _, remoteErr, _ := client.Wait(); !errors.Is(remoteErr, EnhanceYourCalm) {
+				t.Fatalf("Client was disconnected with %v, expected %v", remoteErr, EnhanceYourCalm)
+			}
+		})
+	}
+}
+
+// Test that PreAuthLimits no longer apply once the client authenticated
+func TestPreAuthLimitsLifted(t *testing.T) {
+	t.Parallel()
+	verifier := TokenVerifier(func(token []byte) (string, error) { return "alice", nil })
+	client, server := newSessionPair(&Config{Credentials: TokenCredentials(nil)}, &Config{
+		Verifier:      verifier,
+		PreAuthLimits: &PreAuthLimits{MaxStreams: 1},
+	})
+	defer client.Close()
+	defer server.Close()
+	for i := 0; i < 3; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream %d: %v", i, err)
+		}
+		str.Write([]byte("a"))
+		accepted, err := server.AcceptStream()
+		if err != nil {
+			t.Fatalf("Failed to accept stream %d: %v", i, err)
+		}
+		readString(t, accepted, "a")
+	}
+}
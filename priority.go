@@ -0,0 +1,235 @@
+package muxado
+
+import (
+	"sync"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// the weight of streams whose priority has not been set
+const defaultWeight = 16
+
+// Priority describes how the remote side should prioritize sending a stream's
+// data relative to the session's other streams, see Stream.SetPriority. Like
+// in HTTP/2, streams form a dependency tree: a stream is sent data only when
+// the streams it depends on have none to send, and streams with the same
+// dependency share the bandwidth in proportion to their weights.
+type Priority struct {
+	// Id of the stream this stream depends on. Zero, the default, makes the
+	// stream depend on no other stream.
+	Dependency uint32
+
+	// Weight of the stream relative to the other streams with the same
+	// dependency, between 1 and 256. Default 16.
+	Weight int
+
+	// Exclusive makes the stream the only dependent of its dependency. The
+	// streams that depended on it before depend on this stream instead.
+	Exclusive bool
+}
+
+func (p Priority) pack(id frame.StreamId) (*frame.Priority, error) {
+	weight := p.Weight
+	if weight == 0 {
+		weight = defaultWeight
+	}
+	f := new(frame.Priority)
+	return f, f.Pack(id, frame.StreamId(p.Dependency), weight, p.Exclusive)
+}
+
+type priorityNode struct {
+	id       frame.StreamId
+	weight   int
+	parent   *priorityNode
+	children []*priorityNode
+
+	pass    uint64 // virtual time at which the node is next served among its siblings
+	vtime   uint64 // pass of the child served last, idle children catch up to it
+	pending int    // DATA frames queued in the node's subtree (while scheduling)
+	own     int    // DATA frames queued for the node's stream (while scheduling)
+}
+
+func (n *priorityNode) detach() {
+	siblings := n.parent.children
+	for i, c := range siblings {
+		if c == n {
+			n.parent.children = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	n.parent = nil
+}
+
+func (n *priorityNode) attach(parent *priorityNode) {
+	n.parent = parent
+	parent.children = append(parent.children, n)
+}
+
+// priorityTree holds the priorities of the session's streams that the remote
+// side asked for and orders the DATA frames the session writes by them
+type priorityTree struct {
+	mu    sync.Mutex
+	root  priorityNode
+	nodes map[frame.StreamId]*priorityNode
+	live  func(frame.StreamId) bool // reports whether a stream is open
+	used  bool                      // a priority was set, frames are written FIFO until then
+	data  []writeReq                // scratch space for scheduling
+}
+
+func (t *priorityTree) init(live func(frame.StreamId) bool) {
+	t.nodes = make(map[frame.StreamId]*priorityNode)
+	t.live = live
+}
+
+// node returns the stream's node, adding it with the default priority if the
+// stream has none. It must be called with t.mu held.
+func (t *priorityTree) node(id frame.StreamId) *priorityNode {
+	n, ok := t.nodes[id]
+	if !ok {
+		n = &priorityNode{id: id, weight: defaultWeight}
+		n.attach(&t.root)
+		t.nodes[id] = n
+	}
+	return n
+}
+
+// update applies a PRIORITY frame received for an open stream. Like in
+// HTTP/2, a dependency on a stream that is not open gives the stream the
+// default priority.
+func (t *priorityTree) update(id, depId frame.StreamId, weight int, exclusive bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.live(id) {
+		return
+	}
+	t.used = true
+	dep := &t.root
+	if depId != 0 {
+		if t.live(depId) {
+			dep = t.node(depId)
+		} else {
+			weight, exclusive = defaultWeight, false
+		}
+	}
+	n := t.node(id)
+
+	// a stream that depends on one of its own dependents first moves that
+	// dependent up to take its place
+	for a := dep.parent; a != nil; a = a.parent {
+		if a == n {
+			dep.detach()
+			dep.attach(n.parent)
+			break
+		}
+	}
+	n.detach()
+	if exclusive {
+		for _, c := range dep.children {
+			c.attach(n)
+		}
+		dep.children = dep.children[:0]
+	}
+	n.attach(dep)
+	n.weight = weight
+}
+
+// remove drops a closed stream from the tree. Its dependents depend on its
+// own dependency instead.
+func (t *priorityTree) remove(id frame.StreamId) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.nodes[id]
+	if !ok {
+		return
+	}
+	for _, c := range n.children {
+		c.attach(n.parent)
+	}
+	n.children = nil
+	n.detach()
+	delete(t.nodes, id)
+}
+
+// get returns the priority of a stream
+func (t *priorityTree) get(id frame.StreamId) Priority {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.nodes[id]
+	if !ok {
+		return Priority{Weight: defaultWeight}
+	}
+	return Priority{Dependency: uint32(n.parent.id), Weight: n.weight}
+}
+
+// schedule reorders a batch of write requests by priority. Frames other than
+// DATA go first in the order they were queued. DATA frames follow, each one
+// chosen by walking down the tree from the root: a stream with queued frames
+// is served before its dependents, and among siblings the one that is
+// furthest behind its weighted share is served next.
+func (t *priorityTree) schedule(batch []writeReq) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.used {
+		return
+	}
+
+	data, k := t.data[:0], 0
+	for _, req := range batch {
+		if id := req.f.StreamId(); req.f.Type() == frame.TypeData && (t.nodes[id] != nil || t.live(id)) {
+			data = append(data, req)
+		} else {
+			batch[k] = req
+			k++
+		}
+	}
+	for _, req := range data {
+		n := t.node(req.f.StreamId())
+		n.own++
+		for ; n != nil; n = n.parent {
+			n.pending++
+		}
+	}
+
+	for len(data) > 0 {
+		n := &t.root
+		for n == &t.root || n.own == 0 {
+			var next *priorityNode
+			for _, c := range n.children {
+				if c.pending == 0 {
+					continue
+				}
+				if c.pass < n.vtime {
+					c.pass = n.vtime
+				}
+				if next == nil || c.pass < next.pass {
+					next = c
+				}
+			}
+			n.vtime = next.pass
+			n = next
+		}
+
+		i := 0
+		for data[i].f.StreamId() != n.id {
+			i++
+		}
+		req := data[i]
+		batch[k] = req
+		k++
+		data = append(data[:i], data[i+1:]...)
+
+		n.own--
+		size := uint64(frame.HeaderSize) + uint64(req.f.Length())
+		for ; n != nil; n = n.parent {
+			n.pending--
+			if n.parent != nil {
+				n.pass += size * 256 / uint64(n.weight)
+			}
+		}
+	}
+	data = data[:cap(data)]
+	for i := range data {
+		data[i] = writeReq{}
+	}
+	t.data = data[:0]
+}
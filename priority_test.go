@@ -0,0 +1,152 @@
+package muxado
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func newTestPriorityTree() *priorityTree {
+	t := new(priorityTree)
+	t.init(func(frame.StreamId) bool { return true })
+	return t
+}
+
+// scheduleOrder schedules one DATA frame of each given size per stream and
+// returns the stream ids in the order they are written
+func scheduleOrder(t *testing.T, tree *priorityTree, ids []frame.StreamId, size int) []frame.StreamId {
+	var batch []writeReq
+	for _, id := range ids {
+		f := new(frame.Data)
+		if err := f.Pack(id, make([]byte, size), false, false); err != nil {
+			t.Fatalf("Failed to pack DATA frame: %v", err)
+		}
+		batch = append(batch, writeReq{f: f})
+	}
+	tree.schedule(batch)
+	order := make([]frame.StreamId, len(batch))
+	for i, req := range batch {
+		order[i] = req.f.StreamId()
+	}
+	return order
+}
+
+func TestPriorityUnusedIsFIFO(t *testing.T) {
+	t.Parallel()
+	tree := newTestPriorityTree()
+	ids := []frame.StreamId{7, 3, 5}
+	if got := scheduleOrder(t, tree, ids, 10); !reflect.DeepEqual(got, ids) {
+		t.Fatalf("Frames were reordered to %v", got)
+	}
+}
+
+func TestPriorityDependency(t *testing.T) {
+	t.Parallel()
+	tree := newTestPriorityTree()
+	tree.update(5, 3, 16, false)
+	tree.update(3, 7, 16, false)
+	got := scheduleOrder(t, tree, []frame.StreamId{5, 3, 7, 5, 3}, 10)
+	if expected := []frame.StreamId{7, 3, 3, 5, 5}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Wrong order %v, expected %v", got, expected)
+	}
+}
+
+func TestPriorityWeights(t *testing.T) {
+	t.Parallel()
+	tree := newTestPriorityTree()
+	tree.update(3, 0, 192, false)
+	tree.update(5, 0, 64, false)
+	var ids []frame.StreamId
+	for i := 0; i < 16; i++ {
+		ids = append(ids, 5, 3)
+	}
+	got := scheduleOrder(t, tree, ids, 100)
+	// in the first half of the frames, stream 3 gets three times the share
+	counts := map[frame.StreamId]int{}
+	for _, id := range got[:16] {
+		counts[id]++
+	}
+	if counts[3] != 12 || counts[5] != 4 {
+		t.Fatalf("Streams got shares %v, expected 12 frames for 3 and 4 for 5", counts)
+	}
+}
+
+func TestPriorityExclusive(t *testing.T) {
+	t.Parallel()
+	tree := newTestPriorityTree()
+	tree.update(3, 0, 16, false)
+	tree.update(5, 0, 16, false)
+	tree.update(7, 0, 100, true)
+	for _, id := range []frame.StreamId{3, 5} {
+		if p := tree.get(id); p.Dependency != 7 {
+			t.Fatalf("Stream %d depends on %d, expected 7", id, p.Dependency)
+		}
+	}
+	if p := tree.get(7); p != (Priority{Dependency: 0, Weight: 100}) {
+		t.Fatalf("Wrong priority for stream 7: %+v", p)
+	}
+
+	// depending on a dependent moves the dependent up
+	tree.update(7, 3, 16, false)
+	if p := tree.get(3); p.Dependency != 0 {
+		t.Fatalf("Stream 3 depends on %d, expected 0", p.Dependency)
+	}
+	if p := tree.get(7); p.Dependency != 3 {
+		t.Fatalf("Stream 7 depends on %d, expected 3", p.Dependency)
+	}
+
+	// removing a stream moves its dependents to its dependency
+	tree.remove(7)
+	if p := tree.get(5); p.Dependency != 3 {
+		t.Fatalf("Stream 5 depends on %d, expected 3", p.Dependency)
+	}
+}
+
+func TestSetPriority(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	open := func(p Priority) (Stream, Stream) {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if err := str.SetPriority(p); err != nil {
+			t.Fatalf("Failed to set priority: %v", err)
+		}
+		if _, err := str.Write([]byte("x")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		remote, err := server.AcceptStream()
+		if err != nil {
+			t.Fatalf("Failed to accept stream: %v", err)
+		}
+		return str, remote
+	}
+	waitPriority := func(str Stream, expected Priority) {
+		deadline := time.Now().Add(5 * time.Second)
+		for str.Priority() != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Stream has priority %+v, expected %+v", str.Priority(), expected)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	parent, remoteParent := open(Priority{Weight: 200})
+	waitPriority(remoteParent, Priority{Weight: 200})
+	child, remoteChild := open(Priority{Dependency: parent.Id()})
+	waitPriority(remoteChild, Priority{Dependency: parent.Id(), Weight: defaultWeight})
+
+	if err := child.SetPriority(Priority{Weight: 1}); err != nil {
+		t.Fatalf("Failed to set priority: %v", err)
+	}
+	waitPriority(remoteChild, Priority{Weight: 1})
+	if err := child.SetPriority(Priority{Weight: 300}); err == nil {
+		t.Fatalf("Set an invalid weight")
+	}
+}
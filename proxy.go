@@ -0,0 +1,153 @@
+package muxado
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// how long an accepting session waits for a stream's PROXY header
+	proxyHeaderTimeout = 10 * time.Second
+
+	proxyHeaderSize = 16
+
+	proxyCommandLocal = 0x20 // version 2, LOCAL: no addresses
+	proxyCommandProxy = 0x21 // version 2, PROXY: addresses follow
+
+	proxyFamilyUnspec = 0x00
+	proxyFamilyTCP4   = 0x11
+	proxyFamilyUDP4   = 0x12
+	proxyFamilyTCP6   = 0x21
+	proxyFamilyUDP6   = 0x22
+)
+
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// WithProxyHeader writes a PROXY protocol version 2 header carrying the given
+// source and destination addresses as the first bytes of the stream, e.g. to
+// pass the address of the client whose connection is being tunneled over the
+// stream on to a backend. Remote sessions whose Config enables ProxyProtocol
+// read the header when they accept the stream and report the addresses from
+// the stream's RemoteAddr and LocalAddr.
+//
+// Only *net.TCPAddr and *net.UDPAddr addresses are carried. Other addresses
+// produce a header without addresses.
+func WithProxyHeader(src, dst net.Addr) StreamOption {
+	return func(o *streamOptions) {
+		o.proxyHeader = appendProxyHeader(nil, src, dst)
+	}
+}
+
+func proxyAddr(a net.Addr) (ip net.IP, port int, udp bool, ok bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, false, a.IP != nil
+	case *net.UDPAddr:
+		return a.IP, a.Port, true, a.IP != nil
+	}
+	return nil, 0, false, false
+}
+
+func appendProxyHeader(b []byte, src, dst net.Addr) []byte {
+	b = append(b, proxySignature...)
+	b = append(b, proxyCommandProxy)
+
+	srcIP, srcPort, srcUDP, srcOk := proxyAddr(src)
+	dstIP, dstPort, dstUDP, dstOk := proxyAddr(dst)
+	if !srcOk || !dstOk || srcUDP != dstUDP {
+		return append(b, proxyFamilyUnspec, 0, 0)
+	}
+
+	var family byte
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		family, srcIP, dstIP = proxyFamilyTCP4, src4, dst4
+	} else {
+		family, srcIP, dstIP = proxyFamilyTCP6, srcIP.To16(), dstIP.To16()
+	}
+	if srcUDP {
+		family++
+	}
+	b = append(b, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(2*len(srcIP)+4))
+	b = append(b, srcIP...)
+	b = append(b, dstIP...)
+	b = binary.BigEndian.AppendUint16(b, uint16(srcPort))
+	return binary.BigEndian.AppendUint16(b, uint16(dstPort))
+}
+
+// proxiedStream is a stream whose addresses were read from a PROXY header
+type proxiedStream struct {
+	Stream
+	local, remote net.Addr
+}
+
+func (s *proxiedStream) LocalAddr() net.Addr {
+	return s.local
+}
+
+func (s *proxiedStream) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+// readProxyHeader reads the PROXY protocol version 2 header at the start of
+// str and returns a stream reporting its addresses. If the header carries no
+// addresses, str is returned.
+func readProxyHeader(str Stream) (Stream, error) {
+	str.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer str.SetReadDeadline(time.Time{})
+
+	var hdr [proxyHeaderSize]byte
+	if _, err := io.ReadFull(str, hdr[:]); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY header: %v", err)
+	}
+	if !bytes.Equal(hdr[:len(proxySignature)], proxySignature) {
+		return nil, fmt.Errorf("stream does not begin with a PROXY v2 header")
+	}
+	command, family := hdr[12], hdr[13]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(str, body); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY header addresses: %v", err)
+	}
+
+	switch command {
+	case proxyCommandLocal:
+		return str, nil
+	case proxyCommandProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY header version and command: 0x%x", command)
+	}
+
+	var ipLen int
+	switch family {
+	case proxyFamilyTCP4, proxyFamilyUDP4:
+		ipLen = net.IPv4len
+	case proxyFamilyTCP6, proxyFamilyUDP6:
+		ipLen = net.IPv6len
+	default:
+		// unspecified or unix addresses
+		return str, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("PROXY header addresses too short: %d bytes", len(body))
+	}
+	srcIP := net.IP(body[:ipLen])
+	dstIP := net.IP(body[ipLen : 2*ipLen])
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if family&0xF == 0x2 {
+		return &proxiedStream{
+			Stream: str,
+			remote: &net.UDPAddr{IP: srcIP, Port: srcPort},
+			local:  &net.UDPAddr{IP: dstIP, Port: dstPort},
+		}, nil
+	}
+	return &proxiedStream{
+		Stream: str,
+		remote: &net.TCPAddr{IP: srcIP, Port: srcPort},
+		local:  &net.TCPAddr{IP: dstIP, Port: dstPort},
+	}, nil
+}
@@ -0,0 +1,101 @@
+package muxado
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		src, dst             net.Addr
+		expectSrc, expectDst net.Addr
+	}{
+		{
+			&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234},
+			&net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 443},
+			&net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 51234},
+			&net.TCPAddr{IP: net.IP{198, 51, 100, 2}, Port: 443},
+		},
+		{
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353},
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53},
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353},
+			&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53},
+		},
+		// addresses that can't be carried leave the stream's own
+		{&net.UnixAddr{Name: "/tmp/sock"}, &net.UnixAddr{Name: "/tmp/sock"}, nil, nil},
+	}
+	for i, tc := range tcs {
+		hdr := appendProxyHeader(nil, tc.src, tc.dst)
+		str := &fakeStream{}
+		proxied, err := readProxyHeader(&bufferStream{fakeStream: str, Reader: bytes.NewReader(hdr)})
+		if err != nil {
+			t.Fatalf("case %d: failed to read PROXY header: %v", i, err)
+		}
+		if tc.expectSrc == nil {
+			if _, ok := proxied.(*proxiedStream); ok {
+				t.Fatalf("case %d: expected no addresses", i)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(proxied.RemoteAddr(), tc.expectSrc) || !reflect.DeepEqual(proxied.LocalAddr(), tc.expectDst) {
+			t.Fatalf("case %d: wrong addresses. Got %v -> %v, expected %v -> %v",
+				i, proxied.RemoteAddr(), proxied.LocalAddr(), tc.expectSrc, tc.expectDst)
+		}
+	}
+}
+
+// bufferStream is a fake stream reading from a fixed buffer
+type bufferStream struct {
+	*fakeStream
+	io.Reader
+}
+
+func (s *bufferStream) Read(p []byte) (int, error) {
+	return s.Reader.Read(p)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{ProxyProtocol: true})
+	defer client.Close()
+	defer server.Close()
+
+	// a stream without a PROXY header is reset
+	bad, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	bad.Write(bytes.Repeat([]byte("x"), proxyHeaderSize))
+
+	src := &net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 51234}
+	dst := &net.TCPAddr{IP: net.IP{198, 51, 100, 2}, Port: 443}
+	good, err := client.OpenStream(WithProxyHeader(src, dst))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	good.Write([]byte("payload"))
+
+	str, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if str.Id() != good.Id() {
+		t.Fatalf("Accepted wrong stream. Got %d, expected %d", str.Id(), good.Id())
+	}
+	if !reflect.DeepEqual(str.RemoteAddr(), src) || !reflect.DeepEqual(str.LocalAddr(), dst) {
+		t.Fatalf("Wrong addresses. Got %v -> %v, expected %v -> %v", str.RemoteAddr(), str.LocalAddr(), src, dst)
+	}
+	buf := make([]byte, len("payload"))
+	if _, err := io.ReadFull(str, buf); err != nil || string(buf) != "payload" {
+		t.Fatalf("Wrong stream data. Got %q, %v, expected %q", buf, err, "payload")
+	}
+	if _, err := bad.Read(make([]byte, 1)); !errors.Is(err, ProtocolError) {
+		t.Fatalf("Wrong error on stream without PROXY header. Got %v, expected %v", err, ProtocolError)
+	}
+}
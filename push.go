@@ -0,0 +1,73 @@
+package muxado
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// metadata key of a pushed stream carrying the id of the stream it is
+// associated with, see WithPush
+const pushedByKey = "muxado.pushed-by"
+
+var (
+	// ErrPushUnassociated is returned when pushing a stream associated with
+	// a stream that is not an open stream opened by the remote side
+	ErrPushUnassociated = errors.New("pushed stream must be associated with an open stream opened by the remote side")
+
+	// ErrPushDeclined resets pushed streams that the remote side declined
+	ErrPushDeclined = newErr(StreamRefused, errors.New("push declined"))
+)
+
+// WithPush opens the stream as a push associated with the stream with the
+// given id, which the remote side must have opened and which must still be
+// open, e.g. to send a response the remote side will ask for or
+// notifications about a request. The remote side's session hands the pushed
+// stream to its Config.OnPush instead of returning it from AcceptStream, and
+// resets it with StreamRefused if it declines the push. The stream is opened
+// right away, so that it is declined before data is written to it, and
+// carries metadata, so the remote side must support HEADERS frames.
+func WithPush(associated uint32) StreamOption {
+	return func(o *streamOptions) {
+		o.pushedBy = associated
+	}
+}
+
+// promisePush marks a stream about to be opened as a push associated with the
+// stream o.pushedBy
+func (s *session) promisePush(o *streamOptions) error {
+	id := frame.StreamId(o.pushedBy)
+	if s.isLocal(id) || s.getStream(id) == nil {
+		return ErrPushUnassociated
+	}
+	if o.metadata == nil {
+		o.metadata = make(map[string]string, 1)
+	}
+	o.metadata[pushedByKey] = strconv.FormatUint(uint64(o.pushedBy), 10)
+	return nil
+}
+
+// handlePush hands a stream pushed by the remote side to Config.OnPush. The
+// push is declined if the stream it is associated with is not one of the
+// open streams this side opened.
+func (s *session) handlePush(str streamPrivate, md map[string]string) {
+	associated, err := strconv.ParseUint(md[pushedByKey], 10, 32)
+	id := frame.StreamId(associated)
+	if err != nil || s.config.OnPush == nil || !s.isLocal(id) || s.getStream(id) == nil {
+		str.resetWith(StreamRefused, ErrPushDeclined)
+		return
+	}
+	str.setMetadata(withoutKey(md, pushedByKey))
+	s.ackStream(str)
+	go func() {
+		defer s.recoverPanic("push()")
+		pushed := s.prepareAccepted(str)
+		if pushed == nil {
+			return
+		}
+		if !s.config.OnPush(uint32(associated), pushed) {
+			str.resetWith(StreamRefused, ErrPushDeclined)
+		}
+	}()
+}
@@ -0,0 +1,95 @@
+package muxado
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test that a pushed stream is handed to OnPush of the remote side along with
+// the id of the stream it is associated with
+func TestPush(t *testing.T) {
+	t.Parallel()
+	type push struct {
+		associated uint32
+		str        Stream
+	}
+	pushes := make(chan push, 1)
+	client, server := newSessionPair(&Config{OnPush: func(associated uint32, pushed Stream) bool {
+		pushes <- push{associated, pushed}
+		return true
+	}}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+
+	if _, err := server.OpenStream(WithPush(accepted.Id() + 2)); err != ErrPushUnassociated {
+		t.Fatalf("Pushed a stream associated with a stream that isn't open: %v", err)
+	}
+	md := map[string]string{"path": "/style.css"}
+	pushed, err := server.OpenStream(WithPush(accepted.Id()), WithMetadata(md))
+	if err != nil {
+		t.Fatalf("Failed to push stream: %v", err)
+	}
+	pushed.Write([]byte("b"))
+
+	select {
+	case p := <-pushes:
+		if p.associated != str.Id() {
+			t.Fatalf("Push is associated with stream %d, expected %d", p.associated, str.Id())
+		}
+		if got := p.str.Metadata(); !reflect.DeepEqual(got, md) {
+			t.Fatalf("Pushed stream has metadata %v, expected %v", got, md)
+		}
+		readString(t, p.str, "b")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Push was not handed to OnPush")
+	}
+}
+
+// Test that declined pushes are reset with StreamRefused
+func TestPushDeclined(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name   string
+		onPush func(uint32, Stream) bool
+	}{
+		{"declined", func(uint32, Stream) bool { return false }},
+		{"no handler", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := newSessionPair(&Config{OnPush: tc.onPush}, nil)
+			defer client.Close()
+			defer server.Close()
+
+			str, err := client.OpenStream()
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			str.Write([]byte("a"))
+			accepted, err := server.AcceptStream()
+			if err != nil {
+				t.Fatalf("Failed to accept stream: %v", err)
+			}
+			pushed, err := server.OpenStream(WithPush(accepted.Id()))
+			if err != nil {
+				t.Fatalf("Failed to push stream: %v", err)
+			}
+			pushed.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := pushed.Read(make([]byte, 1)); !errors.Is(err, StreamRefused) {
+				t.Fatalf("Read from declined push got %v, expected %v", err, StreamRefused)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package muxado
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits throughput to a rate of bytes per second while allowing
+// bursts of up to one second's worth of bytes. The zero value is unlimited.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64   // tokens added per second, 0 means unlimited
+	tokens float64   // available tokens, negative when callers are waiting
+	last   time.Time // last time tokens were added
+}
+
+// SetRate changes the rate limit. A rate of 0 removes the limit.
+func (b *tokenBucket) SetRate(bytesPerSec int) {
+	b.mu.Lock()
+	b.rate = float64(bytesPerSec)
+	b.tokens = b.rate
+	b.last = time.Now()
+	b.mu.Unlock()
+}
+
+// Burst returns the largest number of tokens that should be requested in a
+// single call to Take, or 0 if there is no limit
+func (b *tokenBucket) Burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.rate)
+}
+
+// Take consumes n tokens and returns how long the caller must wait before
+// the tokens it consumed are considered available.
+func (b *tokenBucket) Take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+const (
+	// smallest burst a pacer allows, so that it never delays small frames
+	pacingQuantum = 0x4000
+
+	// multiple of the measured delivery rate at which sessions pace writes,
+	// above 1 so that the pacing rate grows while the path allows it
+	pacingGain = 1.25
+)
+
+// pacer spreads writes out evenly at a rate that changes as it is measured.
+// Unlike a tokenBucket, it only allows bursts of a millisecond's worth of
+// bytes or pacingQuantum, whichever is larger. It is not safe for concurrent
+// use.
+type pacer struct {
+	tokens float64   // available tokens, negative when the caller must wait
+	last   time.Time // last time tokens were added, zero while not pacing
+}
+
+// take consumes n tokens at the given rate in bytes per second and returns how
+// long the caller must wait before writing them. A rate of 0 does not pace.
+func (p *pacer) take(n int, rate float64) time.Duration {
+	if rate <= 0 {
+		p.last = time.Time{}
+		return 0
+	}
+	now := time.Now()
+	burst := rate / 1000
+	if burst < pacingQuantum {
+		burst = pacingQuantum
+	}
+	if p.last.IsZero() {
+		p.tokens = burst
+	} else if p.tokens += now.Sub(p.last).Seconds() * rate; p.tokens > burst {
+		p.tokens = burst
+	}
+	p.last = now
+	p.tokens -= float64(n)
+	if p.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-p.tokens / rate * float64(time.Second))
+}
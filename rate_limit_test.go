@@ -0,0 +1,44 @@
+package muxado
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+	var b tokenBucket
+	if d := b.Take(1 << 30); d != 0 {
+		t.Fatalf("Unlimited bucket should never wait, got %v", d)
+	}
+
+	b.SetRate(1000)
+	if d := b.Take(1000); d != 0 {
+		t.Fatalf("Expected initial burst to be available, waited %v", d)
+	}
+	if d := b.Take(500); d < 450*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("Wrong wait after burst. Got %v, expected ~500ms", d)
+	}
+	if b.Burst() != 1000 {
+		t.Fatalf("Wrong burst. Got %d, expected 1000", b.Burst())
+	}
+}
+
+func TestPacer(t *testing.T) {
+	t.Parallel()
+	var p pacer
+	if d := p.take(1<<30, 0); d != 0 {
+		t.Fatalf("Pacer without a rate should never wait, got %v", d)
+	}
+	if d := p.take(pacingQuantum, 1000000); d != 0 {
+		t.Fatalf("Expected the first quantum to be sent immediately, waited %v", d)
+	}
+	if d := p.take(10000, 1000000); d < 9*time.Millisecond || d > 10*time.Millisecond {
+		t.Fatalf("Wrong wait after quantum. Got %v, expected ~10ms", d)
+	}
+	// a faster rate allows larger bursts
+	p = pacer{}
+	if d := p.take(100000, 100000000); d != 0 {
+		t.Fatalf("Expected a millisecond's worth to be sent immediately, waited %v", d)
+	}
+}
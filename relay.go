@@ -0,0 +1,74 @@
+package muxado
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Relay copies data between str and conn in both directions, e.g. to tunnel
+// a TCP connection over a stream, until both directions are finished. The
+// end of the data in one direction is passed on as a half-close, so that
+// protocols that rely on it keep working, and an error in either direction
+// closes both. It returns the number of bytes copied to conn and to str and
+// the first error other than the end of the data.
+//
+// Relay is not zero-copy: stream data is framed in user space, so it cannot
+// be spliced between sockets with splice(2) or sendfile(2), and every byte
+// passes through a buffer on its way between conn and the transport. The
+// data is copied into str through the stream's ReadFrom when str is a muxado
+// stream or wraps one that passes it on, which avoids an intermediate
+// allocation, and through pooled buffers otherwise. Relay closes str and conn
+// when it returns.
+func Relay(str Stream, conn net.Conn) (toConn, toStream int64, err error) {
+	defer str.Close()
+	defer conn.Close()
+
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		closed  int32 // set when conn is closed because it cannot be half-closed
+	)
+	fail := func(e error) {
+		if atomic.LoadInt32(&closed) == 1 {
+			// reading from conn failed because it was closed
+			return
+		}
+		errOnce.Do(func() {
+			err = e
+			// unblock the other direction
+			str.Close()
+			conn.Close()
+		})
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var cerr error
+		if toStream, cerr = copyPooled(str, conn); cerr != nil {
+			fail(cerr)
+			return
+		}
+		str.CloseWrite()
+	}()
+	var cerr error
+	if toConn, cerr = copyPooled(conn, str); cerr != nil {
+		fail(cerr)
+	} else if hc, ok := conn.(interface{ CloseWrite() error }); ok {
+		hc.CloseWrite()
+	} else {
+		atomic.StoreInt32(&closed, 1)
+		conn.Close()
+	}
+	wg.Wait()
+	return
+}
+
+// copyPooled copies from src to dst like io.Copy with a buffer from the pool
+// used by streams, if dst and src do not copy between them on their own
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	bufp := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufp)
+	return io.CopyBuffer(dst, src, *bufp)
+}
@@ -0,0 +1,116 @@
+package muxado
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that Relay tunnels a TCP connection over a stream in both directions,
+// passing on half-closes
+func TestRelay(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	// the backend echoes in upper case what it reads until EOF
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		conn.Write(bytes.ToUpper(b))
+	}()
+
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	type result struct {
+		toConn, toStream int64
+		err              error
+	}
+	relayed := make(chan result, 1)
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			relayed <- result{err: err}
+			return
+		}
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			relayed <- result{err: err}
+			return
+		}
+		var r result
+		r.toConn, r.toStream, r.err = Relay(str, conn)
+		relayed <- r
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	msg := bytes.Repeat([]byte("tunnel "), 0x10000)
+	go func() {
+		str.Write(msg)
+		str.CloseWrite()
+	}()
+	str.SetReadDeadline(time.Now().Add(10 * time.Second))
+	got, err := ioutil.ReadAll(str)
+	if err != nil || !bytes.Equal(got, bytes.ToUpper(msg)) {
+		t.Fatalf("Read %d bytes, %v, expected %d", len(got), err, len(msg))
+	}
+
+	select {
+	case r := <-relayed:
+		if r.err != nil || r.toConn != int64(len(msg)) || r.toStream != int64(len(msg)) {
+			t.Fatalf("Relay returned %d, %d, %v, expected %d both ways", r.toConn, r.toStream, r.err, len(msg))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Relay did not return")
+	}
+}
+
+// Test that an error in one direction ends the relay
+func TestRelayError(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	relayed := make(chan error, 1)
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			relayed <- err
+			return
+		}
+		_, _, err = Relay(str, local)
+		relayed <- err
+	}()
+	str, err := client.OpenStreamWithData([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := io.ReadFull(remote, make([]byte, 5)); err != nil {
+		t.Fatalf("Failed to read relayed data: %v", err)
+	}
+	str.(*stream).resetWith(StreamCancelled, nil)
+	select {
+	case err := <-relayed:
+		if err == nil {
+			t.Fatalf("Relay of reset stream returned no error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Relay did not return")
+	}
+}
@@ -0,0 +1,441 @@
+// Package resume provides a transport that lets a muxado session survive the
+// loss of its underlying connection. Both ends keep the data they have
+// written until the other end acknowledges it. When the connection is lost,
+// the client dials a new one and presents the session's token along with how
+// much data it has received; the server attaches the new connection to the
+// session with that token, and both ends replay the data the other has not
+// received. The session and its open streams carry on as if nothing happened:
+//
+//	// client
+//	conn, err := resume.Dial(func() (net.Conn, error) { return net.Dial("tcp", addr) }, nil)
+//	sess := muxado.Client(conn, nil)
+//
+//	// server
+//	srv := resume.NewServer(nil)
+//	for {
+//		raw, _ := l.Accept()
+//		go func() {
+//			conn, resumed, err := srv.Accept(raw)
+//			if err == nil && !resumed {
+//				handle(muxado.Server(conn, nil))
+//			}
+//		}()
+//	}
+//
+// A session's ReadIdleTimeout should be longer than the time it takes to
+// resume, which is bounded by Config.Timeout.
+package resume
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// largest payload carried by a single data segment
+	maxSegmentSize = 1 << 16
+
+	typeData = 0
+	typeAck  = 1
+	typeFin  = 2
+
+	dataHeaderSize = 13 // type, offset and payload length
+	ackSize        = 9  // type and offset
+)
+
+var (
+	// ErrTimeout is returned once a lost connection was not resumed within
+	// Config.Timeout
+	ErrTimeout = errors.New("resume: connection was not resumed in time")
+	// ErrUnknownSession is returned when the server has no session with the
+	// token a client presented, usually because it has timed out
+	ErrUnknownSession = errors.New("resume: unknown session")
+)
+
+// Config configures the resumption of connections.
+type Config struct {
+	// How long a session waits for its connection to be resumed before it
+	// fails. Default 30 seconds.
+	Timeout time.Duration
+
+	// Maximum number of written bytes which have not been acknowledged by the
+	// remote end. Writes block once it is reached. Default 4MB.
+	BufferSize int
+}
+
+func (c *Config) initDefaults() {
+	if c.Timeout == 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 4 * 1024 * 1024
+	}
+}
+
+// link is one of the underlying connections of a Conn
+type link struct {
+	conn net.Conn
+	wmu  sync.Mutex // serializes writes to conn
+	gen  uint64     // generation of the Conn the link belongs to
+}
+
+func (l *link) write(b []byte) error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	_, err := l.conn.Write(b)
+	return err
+}
+
+// Conn is an io.ReadWriteCloser which resumes its underlying connection
+// when it is lost.
+type Conn struct {
+	config Config
+	token  token
+
+	wmu sync.Mutex // serializes calls to Write
+
+	mu       sync.Mutex
+	cond     sync.Cond
+	link     *link  // nil while waiting to resume
+	gen      uint64 // incremented each time the connection is lost
+	unacked  []byte // written data not acknowledged by the remote end
+	ackedOff uint64 // offset of the first unacknowledged byte
+	recvOff  uint64 // number of bytes received
+	buf      []byte // received data not yet read
+	err      error  // set once the Conn can no longer be used
+	readErr  error  // returned by reads once buf is drained
+
+	ackc chan struct{} // signals that recvOff has advanced
+
+	// called when the connection is lost; by the client to dial again and
+	// by the server to time out
+	lost func(gen uint64)
+
+	// called once the Conn fails or is closed
+	onDone func()
+}
+
+func newConn(config *Config, tok token) *Conn {
+	c := &Conn{token: tok, ackc: make(chan struct{}, 1)}
+	if config != nil {
+		c.config = *config
+	}
+	c.config.initDefaults()
+	c.cond.L = &c.mu
+	go c.acker()
+	return c
+}
+
+// detach drops the current connection so that a new one can be attached and
+// returns the number of bytes received over the lost ones.
+func (c *Conn) detach() (recvOff uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.dropLink()
+	return c.recvOff, nil
+}
+
+// dropLink must be called with c.mu held
+func (c *Conn) dropLink() {
+	if c.link != nil {
+		c.link.conn.Close()
+		c.link = nil
+	}
+	c.gen++
+}
+
+// attach makes conn the Conn's connection and replays the written data the
+// remote end has not received, according to peerRecv.
+func (c *Conn) attach(conn net.Conn, peerRecv uint64) error {
+	l := &link{conn: conn}
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		conn.Close()
+		return c.err
+	}
+	if peerRecv < c.ackedOff || peerRecv > c.ackedOff+uint64(len(c.unacked)) {
+		c.mu.Unlock()
+		conn.Close()
+		err := fmt.Errorf("resume: remote end received %d bytes, but %d to %d are buffered", peerRecv, c.ackedOff, c.ackedOff+uint64(len(c.unacked)))
+		c.fail(err)
+		return err
+	}
+	c.dropLink()
+	c.acked(peerRecv)
+	l.gen = c.gen
+	c.link = l
+	replay := append([]byte(nil), c.unacked...)
+	off := c.ackedOff
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	go c.reader(l)
+
+	// writes on the new link wait until the replay is done
+	for len(replay) > 0 {
+		n := len(replay)
+		if n > maxSegmentSize {
+			n = maxSegmentSize
+		}
+		if _, err := conn.Write(dataSegment(off, replay[:n])); err != nil {
+			c.broken(l.gen)
+			return nil
+		}
+		off += uint64(n)
+		replay = replay[n:]
+	}
+	return nil
+}
+
+// broken is called when the connection of generation gen fails
+func (c *Conn) broken(gen uint64) {
+	c.mu.Lock()
+	if c.gen != gen || c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.dropLink()
+	gen = c.gen
+	c.mu.Unlock()
+	c.lost(gen)
+}
+
+// fail permanently fails the Conn with err
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	c.failLocked(err, err)
+	c.mu.Unlock()
+}
+
+// failLocked must be called with c.mu held
+func (c *Conn) failLocked(err, readErr error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	if c.readErr == nil {
+		c.readErr = readErr
+	}
+	if c.link != nil {
+		c.link.conn.Close()
+		c.link = nil
+	}
+	c.gen++
+	c.cond.Broadcast()
+	close(c.ackc)
+	if c.onDone != nil {
+		go c.onDone()
+	}
+}
+
+func dataSegment(off uint64, data []byte) []byte {
+	seg := make([]byte, dataHeaderSize+len(data))
+	seg[0] = typeData
+	binary.BigEndian.PutUint64(seg[1:], off)
+	binary.BigEndian.PutUint32(seg[9:], uint32(len(data)))
+	copy(seg[dataHeaderSize:], data)
+	return seg
+}
+
+// Write buffers p until the remote end acknowledges it and writes it to the
+// current connection. If the connection has been lost, the data is written
+// once it is resumed. Write blocks while Config.BufferSize bytes are waiting
+// to be acknowledged.
+func (c *Conn) Write(p []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for n < len(p) {
+		size := len(p) - n
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+
+		c.mu.Lock()
+		for c.err == nil && len(c.unacked) > 0 && len(c.unacked)+size > c.config.BufferSize {
+			c.cond.Wait()
+		}
+		if c.err != nil {
+			err = c.err
+			c.mu.Unlock()
+			return
+		}
+		off := c.ackedOff + uint64(len(c.unacked))
+		c.unacked = append(c.unacked, p[n:n+size]...)
+		l := c.link
+		c.mu.Unlock()
+
+		// if there is no connection, the data is replayed once there is
+		if l != nil {
+			if werr := l.write(dataSegment(off, p[n:n+size])); werr != nil {
+				c.broken(l.gen)
+			}
+		}
+		n += size
+	}
+	return
+}
+
+// Read reads the data written by the remote end. It returns io.EOF once the
+// remote end has closed the Conn and all of its data has been read.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Close tells the remote end that the Conn is done, so that it will not wait
+// for it to be resumed, and closes the connection.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	l := c.link
+	c.mu.Unlock()
+	if l != nil {
+		l.write([]byte{typeFin})
+	}
+	c.mu.Lock()
+	c.failLocked(io.ErrClosedPipe, io.ErrClosedPipe)
+	c.mu.Unlock()
+	return nil
+}
+
+// LocalAddr returns the local address of the current connection, or nil
+// while waiting to resume.
+func (c *Conn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.link == nil {
+		return nil
+	}
+	return c.link.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the current connection, or nil
+// while waiting to resume.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.link == nil {
+		return nil
+	}
+	return c.link.conn.RemoteAddr()
+}
+
+// reader reads segments from l until it fails
+func (c *Conn) reader(l *link) {
+	rd := bufio.NewReader(l.conn)
+	hdr := make([]byte, dataHeaderSize)
+	for {
+		if _, err := io.ReadFull(rd, hdr[:1]); err != nil {
+			break
+		}
+		switch hdr[0] {
+		case typeData:
+			if _, err := io.ReadFull(rd, hdr[1:]); err != nil {
+				c.broken(l.gen)
+				return
+			}
+			data := make([]byte, binary.BigEndian.Uint32(hdr[9:]))
+			if _, err := io.ReadFull(rd, data); err != nil {
+				c.broken(l.gen)
+				return
+			}
+			if !c.receive(l.gen, binary.BigEndian.Uint64(hdr[1:]), data) {
+				c.broken(l.gen)
+				return
+			}
+		case typeAck:
+			if _, err := io.ReadFull(rd, hdr[1:ackSize]); err != nil {
+				c.broken(l.gen)
+				return
+			}
+			c.mu.Lock()
+			if c.gen == l.gen {
+				c.acked(binary.BigEndian.Uint64(hdr[1:]))
+			}
+			c.mu.Unlock()
+		case typeFin:
+			c.mu.Lock()
+			if c.gen == l.gen {
+				c.failLocked(io.ErrClosedPipe, io.EOF)
+			}
+			c.mu.Unlock()
+			return
+		default:
+			c.broken(l.gen)
+			return
+		}
+	}
+	c.broken(l.gen)
+}
+
+// receive adds the data received at offset off over the connection of
+// generation gen to the read buffer, skipping replayed bytes that were
+// already received. It returns false if data was lost.
+func (c *Conn) receive(gen uint64, off uint64, data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return true
+	}
+	if off > c.recvOff {
+		return false
+	}
+	if skip := c.recvOff - off; skip < uint64(len(data)) {
+		c.buf = append(c.buf, data[skip:]...)
+		c.recvOff += uint64(len(data)) - skip
+		c.cond.Broadcast()
+		select {
+		case c.ackc <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// acked releases the written data the remote end has received. It must be
+// called with c.mu held.
+func (c *Conn) acked(off uint64) {
+	if off <= c.ackedOff || off > c.ackedOff+uint64(len(c.unacked)) {
+		return
+	}
+	c.unacked = c.unacked[off-c.ackedOff:]
+	c.ackedOff = off
+	c.cond.Broadcast()
+}
+
+// acker acknowledges received data. Acknowledgements are written from their
+// own goroutine so that the reader never blocks on writes.
+func (c *Conn) acker() {
+	msg := make([]byte, ackSize)
+	msg[0] = typeAck
+	for range c.ackc {
+		c.mu.Lock()
+		binary.BigEndian.PutUint64(msg[1:], c.recvOff)
+		l := c.link
+		c.mu.Unlock()
+		if l != nil {
+			l.write(msg)
+		}
+	}
+}
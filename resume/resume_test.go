@@ -0,0 +1,163 @@
+package resume
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado"
+)
+
+// testNet connects clients to a Server over pipes and lets tests break the
+// connections
+type testNet struct {
+	t   *testing.T
+	srv *Server
+
+	mu      sync.Mutex
+	clients []net.Conn
+	down    bool // refuse to dial
+	accept  chan *Conn
+}
+
+func newTestNet(t *testing.T, config *Config) *testNet {
+	return &testNet{t: t, srv: NewServer(config), accept: make(chan *Conn, 1)}
+}
+
+func (n *testNet) dial() (net.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return nil, io.ErrClosedPipe
+	}
+	client, server := net.Pipe()
+	n.clients = append(n.clients, client)
+	go func() {
+		c, resumed, err := n.srv.Accept(server)
+		if err == nil && !resumed {
+			n.accept <- c
+		}
+	}()
+	return client, nil
+}
+
+// breakConns closes every connection dialed so far
+func (n *testNet) breakConns() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, c := range n.clients {
+		c.Close()
+	}
+	n.clients = nil
+}
+
+func (n *testNet) setDown(down bool) {
+	n.mu.Lock()
+	n.down = down
+	n.mu.Unlock()
+}
+
+func (n *testNet) sessionPair(config *Config) (client, server muxado.Session) {
+	conn, err := Dial(n.dial, config)
+	if err != nil {
+		n.t.Fatalf("Failed to dial: %v", err)
+	}
+	client = muxado.Client(conn, nil)
+	server = muxado.Server(<-n.accept, nil)
+	n.t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return
+}
+
+func TestResumeStreamsSurviveReconnect(t *testing.T) {
+	t.Parallel()
+	n := newTestNet(t, nil)
+	client, server := n.sessionPair(nil)
+
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.CloseWrite()
+	}()
+
+	msg := bytes.Repeat([]byte("0123456789"), 50000)
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		for i := 0; i < 10; i++ {
+			str.Write(msg[i*len(msg)/10 : (i+1)*len(msg)/10])
+			if i%3 == 0 {
+				n.breakConns()
+			}
+		}
+		str.CloseWrite()
+	}()
+	got, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Wrong echo, read %d bytes, expected %d", len(got), len(msg))
+	}
+}
+
+func TestResumeTimeout(t *testing.T) {
+	t.Parallel()
+	n := newTestNet(t, &Config{Timeout: 200 * time.Millisecond})
+	client, server := n.sessionPair(&Config{Timeout: 200 * time.Millisecond})
+
+	n.setDown(true)
+	n.breakConns()
+	for _, sess := range []muxado.Session{client, server} {
+		select {
+		case <-sess.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Session did not die after failing to resume")
+		}
+	}
+	n.srv.mu.Lock()
+	defer n.srv.mu.Unlock()
+	if len(n.srv.conns) != 0 {
+		t.Fatalf("Server still tracks %d sessions", len(n.srv.conns))
+	}
+}
+
+func TestResumeClose(t *testing.T) {
+	t.Parallel()
+	n := newTestNet(t, nil)
+	client, server := n.sessionPair(nil)
+
+	client.Close()
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session did not die after the remote end closed")
+	}
+	if code, _ := muxado.GetError(server.Err()); code != muxado.PeerEOF {
+		t.Fatalf("Session died with %v, expected %v", code, muxado.PeerEOF)
+	}
+}
+
+func TestResumeUnknownSession(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(nil)
+	client, server := net.Pipe()
+	go srv.Accept(server)
+	tok, _, err := handshake(client, token{1}, 0)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	if tok != (token{}) {
+		t.Fatalf("Server resumed an unknown session")
+	}
+}
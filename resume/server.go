@@ -0,0 +1,210 @@
+package resume
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// bounds each attempt to connect and exchange handshakes
+	handshakeTimeout = 10 * time.Second
+
+	handshakeSize = tokenSize + 8 // token and number of bytes received
+
+	minRedialDelay = 50 * time.Millisecond
+	maxRedialDelay = 5 * time.Second
+)
+
+const tokenSize = 16
+
+// token identifies a session to resume. The zero token asks the server for a
+// new session, and the server replies with it when it cannot resume one.
+type token [tokenSize]byte
+
+// handshake writes our token and received offset to conn and reads the
+// remote end's
+func handshake(conn net.Conn, tok token, recvOff uint64) (token, uint64, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := writeHandshake(conn, tok, recvOff); err != nil {
+		return token{}, 0, err
+	}
+	return readHandshake(conn)
+}
+
+func writeHandshake(conn net.Conn, tok token, recvOff uint64) error {
+	var b [handshakeSize]byte
+	copy(b[:], tok[:])
+	binary.BigEndian.PutUint64(b[tokenSize:], recvOff)
+	_, err := conn.Write(b[:])
+	return err
+}
+
+func readHandshake(conn net.Conn) (tok token, recvOff uint64, err error) {
+	var b [handshakeSize]byte
+	if _, err = io.ReadFull(conn, b[:]); err != nil {
+		return
+	}
+	copy(tok[:], b[:])
+	return tok, binary.BigEndian.Uint64(b[tokenSize:]), nil
+}
+
+// Dial connects to a server with dial and returns a Conn which dials again
+// whenever its connection is lost.
+func Dial(dial func() (net.Conn, error), config *Config) (*Conn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	tok, _, err := handshake(conn, token{}, 0)
+	if err == nil && tok == (token{}) {
+		err = ErrUnknownSession
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := newConn(config, tok)
+	c.lost = func(gen uint64) { go c.redial(dial) }
+	c.attach(conn, 0)
+	return c, nil
+}
+
+// redial dials until the connection is resumed or Config.Timeout expires
+func (c *Conn) redial(dial func() (net.Conn, error)) {
+	deadline := time.Now().Add(c.config.Timeout)
+	delay := minRedialDelay
+	for {
+		if err := c.resume(dial); err != ErrTimeout {
+			if err != nil {
+				c.fail(err)
+			}
+			return
+		}
+		if time.Now().Add(delay).After(deadline) {
+			c.fail(ErrTimeout)
+			return
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRedialDelay {
+			delay = maxRedialDelay
+		}
+	}
+}
+
+// resume makes one attempt to resume the connection. It returns ErrTimeout
+// if the attempt should be retried.
+func (c *Conn) resume(dial func() (net.Conn, error)) error {
+	conn, err := dial()
+	if err != nil {
+		return ErrTimeout
+	}
+	recvOff, err := c.detach()
+	if err != nil {
+		conn.Close()
+		return nil
+	}
+	tok, peerRecv, err := handshake(conn, c.token, recvOff)
+	if err != nil {
+		conn.Close()
+		return ErrTimeout
+	}
+	if tok != c.token {
+		conn.Close()
+		return ErrUnknownSession
+	}
+	return c.attach(conn, peerRecv)
+}
+
+// Server attaches the connections accepted by a server to new or resumed
+// sessions.
+type Server struct {
+	config Config
+
+	mu    sync.Mutex
+	conns map[token]*Conn
+}
+
+// NewServer returns a Server whose Conns are configured by config.
+func NewServer(config *Config) *Server {
+	s := &Server{conns: make(map[token]*Conn)}
+	if config != nil {
+		s.config = *config
+	}
+	s.config.initDefaults()
+	return s
+}
+
+// Accept reads the handshake of a client from conn. If the client asks for a
+// new session, Accept returns a new Conn for it. If the client resumes a
+// session, conn becomes the connection of that session's Conn and Accept
+// returns it with resumed set.
+func (s *Server) Accept(conn net.Conn) (c *Conn, resumed bool, err error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	tok, peerRecv, err := readHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+
+	if tok == (token{}) {
+		if _, err = rand.Read(tok[:]); err != nil {
+			conn.Close()
+			return nil, false, err
+		}
+		c = newConn(&s.config, tok)
+		c.lost = func(gen uint64) { s.awaitResume(c, gen) }
+		c.onDone = func() { s.remove(c) }
+		s.mu.Lock()
+		s.conns[tok] = c
+		s.mu.Unlock()
+		if err = writeHandshake(conn, tok, 0); err != nil {
+			conn.Close()
+			c.fail(err)
+			return nil, false, err
+		}
+		conn.SetDeadline(time.Time{})
+		return c, false, c.attach(conn, peerRecv)
+	}
+
+	s.mu.Lock()
+	c = s.conns[tok]
+	s.mu.Unlock()
+	if c == nil {
+		writeHandshake(conn, token{}, 0)
+		conn.Close()
+		return nil, false, ErrUnknownSession
+	}
+	recvOff, err := c.detach()
+	if err == nil {
+		err = writeHandshake(conn, tok, recvOff)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, true, c.attach(conn, peerRecv)
+}
+
+// awaitResume fails c unless its connection of generation gen is resumed
+// within Config.Timeout
+func (s *Server) awaitResume(c *Conn, gen uint64) {
+	time.AfterFunc(s.config.Timeout, func() {
+		c.mu.Lock()
+		if c.gen == gen {
+			c.failLocked(ErrTimeout, ErrTimeout)
+		}
+		c.mu.Unlock()
+	})
+}
+
+func (s *Server) remove(c *Conn) {
+	s.mu.Lock()
+	delete(s.conns, c.token)
+	s.mu.Unlock()
+}
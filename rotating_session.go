@@ -0,0 +1,350 @@
+package muxado
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// rotatingSession is a client Session that replaces its underlying session
+// with a fresh one when it runs out of stream ids. The exhausted session is
+// sent a GOAWAY and closed once all of its streams have finished.
+type rotatingSession struct {
+	dial   func() (io.ReadWriteCloser, error)
+	config *Config
+
+	mu           sync.Mutex
+	current      *session
+	retired      map[*session]struct{} // sessions rotated out that are still draining
+	openDeadline time.Time             // applied to each new session
+
+	acceptDeadline *deadline
+
+	accept    chan Stream   // streams accepted from every session
+	acceptErr error         // error that terminated the accept stream
+	dead      chan struct{} // closed when the current session's accept fails
+	closeOnce sync.Once
+}
+
+// NewRotatingClient returns a client Session that dials its transport with
+// dial. When the session exhausts its stream ids, a replacement session is
+// transparently dialed and used for all subsequent calls to OpenStream while
+// the exhausted session drains its remaining streams.
+//
+// This allows long-lived clients to open an unbounded number of streams
+// without handling StreamsExhausted errors themselves. If config has a
+// Migrator, the streams still open on the exhausted session are migrated to
+// the replacement instead of keeping it alive until they finish.
+func NewRotatingClient(dial func() (io.ReadWriteCloser, error), config *Config) (Session, error) {
+	s := &rotatingSession{
+		dial:           dial,
+		config:         config,
+		retired:        make(map[*session]struct{}),
+		accept:         make(chan Stream),
+		acceptDeadline: newDeadline(),
+		dead:           make(chan struct{}),
+	}
+	sess, err := s.newSession()
+	if err != nil {
+		return nil, err
+	}
+	s.current = sess
+	return s, nil
+}
+
+func (s *rotatingSession) newSession() (*session, error) {
+	trans, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	sess := Client(trans, s.config).(*session)
+	sess.goLabeled("rotating-accept", func() { s.acceptFrom(sess) })
+	return sess, nil
+}
+
+// acceptFrom forwards the streams accepted by sess until it dies
+func (s *rotatingSession) acceptFrom(sess *session) {
+	for {
+		str, err := sess.AcceptStream()
+		if err != nil {
+			// the death of a rotated session is expected
+			if s.getCurrent() == sess {
+				s.closeOnce.Do(func() {
+					s.acceptErr = err
+					close(s.dead)
+				})
+			}
+			return
+		}
+		select {
+		case s.accept <- str:
+		case <-s.dead:
+			str.Close()
+			return
+		}
+	}
+}
+
+func (s *rotatingSession) getCurrent() *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// rotate replaces old with a newly dialed session unless that has already
+// happened. The session is dialed without holding the lock, so a session
+// dialed by a rotation that lost the race to another one is closed.
+func (s *rotatingSession) rotate(old *session) error {
+	if s.getCurrent() != old {
+		return nil
+	}
+	sess, err := s.newSession()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != old {
+		sess.Close()
+		return nil
+	}
+	s.current = sess
+	sess.SetOpenDeadline(s.openDeadline)
+	s.retired[old] = struct{}{}
+	old.goLabeled("rotating-drain", func() {
+		if s.config != nil && s.config.Migrator != nil {
+			s.config.Migrator.MigrateSession(old, sess)
+		}
+		drain(old)
+		s.mu.Lock()
+		delete(s.retired, old)
+		s.mu.Unlock()
+	})
+	return nil
+}
+
+// closeAll closes the current session and the sessions still draining with
+// close and returns the error of closing the current one
+func (s *rotatingSession) closeAll(close func(*session) error) error {
+	s.mu.Lock()
+	cur := s.current
+	retired := make([]*session, 0, len(s.retired))
+	for sess := range s.retired {
+		retired = append(retired, sess)
+	}
+	s.mu.Unlock()
+	for _, sess := range retired {
+		close(sess)
+	}
+	return close(cur)
+}
+
+// drain tells the remote side of sess to stop opening streams and closes it
+// once its remaining streams have finished
+func drain(sess *session) {
+	sess.GoAway(NoError, []byte("stream ids exhausted"), time.Now().Add(time.Second))
+	select {
+	case <-sess.Drained():
+		sess.Close()
+	case <-sess.dead:
+	}
+}
+
+func (s *rotatingSession) Open() (net.Conn, error) {
+	return s.OpenStream()
+}
+
+func (s *rotatingSession) OpenStream(opts ...StreamOption) (Stream, error) {
+	return s.OpenStreamWithData(nil, opts...)
+}
+
+func (s *rotatingSession) OpenStreamWithData(p []byte, opts ...StreamOption) (Stream, error) {
+	cur := s.getCurrent()
+	str, err := cur.OpenStreamWithData(p, opts...)
+	if err != ErrStreamsExhausted {
+		return str, err
+	}
+	if err := s.rotate(cur); err != nil {
+		return nil, err
+	}
+	return s.getCurrent().OpenStreamWithData(p, opts...)
+}
+
+func (s *rotatingSession) AcceptStream() (Stream, error) {
+	select {
+	case str := <-s.accept:
+		return str, nil
+	case <-s.acceptDeadline.wait():
+		return nil, ErrAcceptTimeout
+	case <-s.dead:
+		return nil, s.acceptErr
+	}
+}
+
+func (s *rotatingSession) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// Close closes the current session along with the sessions rotated out that
+// are still draining
+func (s *rotatingSession) Close() error {
+	return s.closeAll((*session).Close)
+}
+
+func (s *rotatingSession) CloseWithError(errCode ErrorCode, debug []byte) error {
+	return s.closeAll(func(sess *session) error {
+		return sess.CloseWithError(errCode, debug)
+	})
+}
+
+func (s *rotatingSession) GoAway(errCode ErrorCode, debug []byte, dl time.Time) error {
+	return s.getCurrent().GoAway(errCode, debug, dl)
+}
+
+func (s *rotatingSession) Drained() <-chan struct{} {
+	return s.getCurrent().Drained()
+}
+
+func (s *rotatingSession) SetDeadline(t time.Time) error {
+	s.SetAcceptDeadline(t)
+	return s.SetOpenDeadline(t)
+}
+
+func (s *rotatingSession) SetAcceptDeadline(t time.Time) error {
+	s.acceptDeadline.set(t)
+	return nil
+}
+
+func (s *rotatingSession) SetOpenDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.openDeadline = t
+	return s.current.SetOpenDeadline(t)
+}
+
+func (s *rotatingSession) LocalAddr() net.Addr {
+	return s.getCurrent().LocalAddr()
+}
+
+func (s *rotatingSession) RemoteAddr() net.Addr {
+	return s.getCurrent().RemoteAddr()
+}
+
+func (s *rotatingSession) Addr() net.Addr {
+	return s.getCurrent().Addr()
+}
+
+// Wait blocks until the current session dies. Sessions that are rotated out
+// do not cause Wait to return.
+func (s *rotatingSession) Wait() (error, error, []byte) {
+	for {
+		cur := s.getCurrent()
+		localErr, remoteErr, debug := cur.Wait()
+		if s.getCurrent() == cur {
+			return localErr, remoteErr, debug
+		}
+	}
+}
+
+// Streams returns the streams of the current session. Streams still draining
+// on sessions that were rotated out are not included.
+func (s *rotatingSession) Streams() []StreamInfo {
+	return s.getCurrent().Streams()
+}
+
+// NumStreams returns the number of open streams of the current session,
+// like Streams
+func (s *rotatingSession) NumStreams() int {
+	return s.getCurrent().NumStreams()
+}
+
+// NumOpened returns the number of open streams the current session opened
+func (s *rotatingSession) NumOpened() int {
+	return s.getCurrent().NumOpened()
+}
+
+// NumAccepted returns the number of open streams the remote side opened on
+// the current session
+func (s *rotatingSession) NumAccepted() int {
+	return s.getCurrent().NumAccepted()
+}
+
+// TransportOptions returns the options of the current session's transport.
+// Sessions dialed later by a rotation do not inherit options set on it.
+func (s *rotatingSession) TransportOptions() TransportOptions {
+	return s.getCurrent().TransportOptions()
+}
+
+// PathStats returns the estimates of the current session. Sessions dialed
+// later by a rotation measure their paths from scratch.
+func (s *rotatingSession) PathStats() PathStats {
+	return s.getCurrent().PathStats()
+}
+
+// ProtocolVersion returns the protocol version of the current session
+func (s *rotatingSession) ProtocolVersion() uint16 {
+	return s.getCurrent().ProtocolVersion()
+}
+
+func (s *rotatingSession) AuthIdentity() string {
+	return s.getCurrent().AuthIdentity()
+}
+
+func (s *rotatingSession) ConnectionState() (tls.ConnectionState, bool) {
+	return s.getCurrent().ConnectionState()
+}
+
+func (s *rotatingSession) PeerCertificates() []*x509.Certificate {
+	return s.getCurrent().PeerCertificates()
+}
+
+// State returns the state of the current session. A session that is rotated
+// out does not make the rotating session draining.
+func (s *rotatingSession) State() SessionState {
+	return s.getCurrent().State()
+}
+
+func (s *rotatingSession) WriteUserFrame(ftype frame.Type, flags frame.Flags, streamId uint32, data []byte) error {
+	return s.getCurrent().WriteUserFrame(ftype, flags, streamId, data)
+}
+
+func (s *rotatingSession) Tags() map[string]string {
+	return s.getCurrent().Tags()
+}
+
+// SetTag tags the current session. Sessions dialed later by a rotation
+// only have the tags of Config.Tags.
+func (s *rotatingSession) SetTag(key, value string) {
+	s.getCurrent().SetTag(key, value)
+}
+
+// Done returns a channel that is closed when the current session dies.
+// Sessions that are rotated out do not close it.
+func (s *rotatingSession) Done() <-chan struct{} {
+	return s.dead
+}
+
+func (s *rotatingSession) IsClosed() bool {
+	return isClosed(s.dead)
+}
+
+func (s *rotatingSession) CloseReason() (error, error, []byte) {
+	if !isClosed(s.dead) {
+		return nil, nil, nil
+	}
+	return s.getCurrent().CloseReason()
+}
+
+func (s *rotatingSession) Err() error {
+	select {
+	case <-s.dead:
+		return s.getCurrent().Err()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,155 @@
+package muxado
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// Test that a rotating session dials a replacement when stream ids are
+// exhausted and drains the exhausted session
+func TestRotatingSession(t *testing.T) {
+	t.Parallel()
+	servers := make(chan Session, 2)
+	dial := func() (io.ReadWriteCloser, error) {
+		local, remote := newFakeConnPair()
+		servers <- Server(remote, nil)
+		return local, nil
+	}
+	s, err := NewRotatingClient(dial, nil)
+	if err != nil {
+		t.Fatalf("Failed to create rotating session: %v", err)
+	}
+	defer s.Close()
+	first := <-servers
+
+	// the next id will be past the end of the id space
+	s.(*rotatingSession).getCurrent().local.lastId = 1<<31 - 1
+
+	str, err := s.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := str.Write([]byte("hello")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	var second Session
+	select {
+	case second = <-servers:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for replacement session")
+	}
+	defer second.Close()
+	if _, err := second.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream on replacement session: %v", err)
+	}
+
+	// the exhausted session has no streams and is closed after draining
+	done := make(chan struct{})
+	go func() {
+		first.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for exhausted session to close")
+	}
+}
+
+// Test that closing a rotating session also closes the sessions that were
+// rotated out and are still draining
+func TestRotatingSessionCloseDraining(t *testing.T) {
+	t.Parallel()
+	servers := make(chan Session, 2)
+	dial := func() (io.ReadWriteCloser, error) {
+		local, remote := newFakeConnPair()
+		servers <- Server(remote, nil)
+		return local, nil
+	}
+	s, err := NewRotatingClient(dial, nil)
+	if err != nil {
+		t.Fatalf("Failed to create rotating session: %v", err)
+	}
+	first := <-servers
+	defer first.Close()
+
+	// a stream that stays open keeps the first session draining
+	str, err := s.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hello"))
+	if _, err := first.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+
+	s.(*rotatingSession).getCurrent().local.lastId = 1<<31 - 1
+	if _, err := s.OpenStream(); err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	second := <-servers
+	defer second.Close()
+
+	s.Close()
+	done := make(chan struct{})
+	go func() {
+		first.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the draining session to close")
+	}
+}
+
+// Test that a rotation dialing a replacement session does not block calls
+// that use the current session
+func TestRotatingSessionSlowDial(t *testing.T) {
+	t.Parallel()
+	servers := make(chan Session, 2)
+	dialing, release := make(chan struct{}), make(chan struct{})
+	dials := 0
+	dial := func() (io.ReadWriteCloser, error) {
+		if dials++; dials > 1 {
+			close(dialing)
+			<-release
+		}
+		local, remote := newFakeConnPair()
+		servers <- Server(remote, nil)
+		return local, nil
+	}
+	s, err := NewRotatingClient(dial, nil)
+	if err != nil {
+		t.Fatalf("Failed to create rotating session: %v", err)
+	}
+	defer s.Close()
+	first := <-servers
+	defer first.Close()
+
+	s.(*rotatingSession).getCurrent().local.lastId = 1<<31 - 1
+	opened := make(chan error, 1)
+	go func() {
+		_, err := s.OpenStream()
+		opened <- err
+	}()
+	<-dialing
+
+	states := make(chan SessionState, 1)
+	go func() { states <- s.State() }()
+	select {
+	case <-states:
+	case <-time.After(time.Second):
+		close(release)
+		t.Fatalf("State blocked while the replacement session was dialed")
+	}
+
+	close(release)
+	if err := <-opened; err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	second := <-servers
+	second.Close()
+}
@@ -1,11 +1,16 @@
 package muxado
 
 import (
-	"errors"
+	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -18,7 +23,25 @@ type streamPrivate interface {
 	handleStreamData(*frame.Data) error
 	handleStreamRst(*frame.Rst) error
 	handleStreamWndInc(*frame.WndInc) error
+	handleStreamTrailers(*frame.Headers) error
 	closeWith(error)
+	resetWith(ErrorCode, error)
+	closeErr() error
+	info() StreamInfo
+	setStreamType(StreamType)
+	idleSince() time.Time
+	receiveBlocked() bool
+	windowLimit() uint32
+	shrinkWindow(size uint32)
+	shrinkIdleWindow(size uint32)
+	flushWrites() error
+	buffered() int
+	compressed() bool
+	setCompressed()
+	setMetadata(map[string]string)
+	setUnidirectional(local bool)
+	awaitAck(negotiated, cancel <-chan struct{}) (bool, error)
+	open() error
 }
 
 // factory function that creates new streams
@@ -29,8 +52,15 @@ type parityFn func(frame.StreamId) bool
 
 // state for each half of the session (remote and local)
 type halfState struct {
-	goneAway uint32 // true if that half of the stream has gone away
-	lastId   uint32 // last id used/seen from one half of the session
+	goneAway     uint32 // true if that half of the stream has gone away
+	lastId       uint32 // last id used/seen from one half of the session
+	maxFrameSize uint32 // largest DATA payload that half of the session will accept
+	compression  uint32 // ids of the codecs that half of the session accepts, see codecMask
+	checksums    uint32 // true if that half of the session verifies frame checksums
+	acks         uint32 // true if that half of the session asked for its streams to be acknowledged
+	padding      uint32 // true if that half of the session accepts PADDING frames
+	shrink       uint32 // true if that half of the session gives back window when asked to, see frame.FlagWndIncShrink
+	numStreams   int32  // number of open streams initiated by that half of the session
 }
 
 // session implements a simple streaming session manager. It has the following characteristics:
@@ -38,10 +68,11 @@ type halfState struct {
 // - When closing the Session, it does not linger, all pending write operations will fail immediately.
 // - It offers no customization of settings like window size/ping time
 type session struct {
-	dieOnce uint32    // guarantees only one die() call proceeds, first for alignment
-	local   halfState // client state
-	remote  halfState // server state
+	state  uint32    // SessionState, see advance
+	local  halfState // client state
+	remote halfState // server state
 
+	id          uint64             // identifies the session in profiler labels
 	config      Config             // session configuration
 	transport   io.ReadWriteCloser // multiplexing over this transport stream
 	framer      frame.Framer       // framer
@@ -49,38 +80,124 @@ type session struct {
 	accept      chan streamPrivate // new streams opened by the remote
 	isLocal     parityFn           // determines if a stream id is local or remote
 	writeFrames chan writeReq      // write requests for the framer
-
-	dead   chan struct{} // closed when dead
-	dieErr error         // the first error that caused session termination
-
-	// debug information received from the remote end via GOAWAY frame
+	controls    chan writeReq      // write requests for control frames, written ahead of writeFrames
+	writeLock   chan struct{}      // held while writing to the framer, nil unless DirectWrites
+	flushes     chan struct{}      // asks the writer to flush frames written directly, nil unless DirectWrites
+	writers     int32              // goroutines writing directly, see writeFrameDirect
+	loop        *WriteLoop         // writes queued frames in place of the writer goroutine, see Config.WriteLoop
+	wbuf        *writeBuffer       // buffers batches of frames written to the transport
+	batch       []writeReq         // write requests in the current batch (writer goroutine only)
+	egress      tokenBucket        // paces writes to the transport
+	pacer       pacer              // paces DATA frames at the delivery rate (writer goroutine only)
+	padding     frame.Padding      // pads DATA frames, see Config.PaddingBuckets (writer goroutine only)
+	priorities  priorityTree       // priorities of the streams set by the remote side
+	path        pathEstimator      // estimates the round trip time and delivery rate
+
+	queueMu   sync.Mutex
+	queued    []frame.Frame // frames written asynchronously, waiting for the write loop
+	scheduled bool          // true while the session is waiting for the write loop
+
+	buffered int64  // unread bytes buffered across all streams
+	version  uint32 // negotiated protocol version, zero until negotiated
+	lastRead int64  // time in unix nanoseconds that a frame was last read
+
+	negotiated chan struct{} // closed once the protocol version is negotiated
+	settled    chan struct{} // closed once the first frame of the remote side, carrying its SETTINGS, was handled
+
+	heldMu       sync.Mutex
+	held         []heldStream // new streams waiting for room in accept, see holdAccept
+	acceptClosed bool         // true once the reader closed accept
+
+	slotMu    sync.Mutex
+	slotFreed chan struct{} // closed when a local stream closes if callers wait for a slot
+
+	goAwayMu  sync.Mutex    // orders sending GOAWAY with accepting new streams
+	drained   chan struct{} // closed when no streams remain after sending GOAWAY
+	drainOnce sync.Once
+
+	extensions map[uint16]*extensionState // registered extensions by id (const)
+	auth       *authExtension             // nil unless Config.Verifier or Config.Credentials is set (const)
+	preAuth    *preAuthState              // nil unless Config.PreAuthLimits is set on a server (const)
+
+	acceptDeadline *deadline // bounds calls to AcceptStream
+	openDeadline   *deadline // bounds calls to OpenStream
+
+	// stateMu orders the transitions of the session's state with the errors
+	// that describe them
+	stateMu    sync.Mutex
+	dead       chan struct{} // closed when dead
+	dieErr     error         // the first error that caused session termination, set when closing
+	readerDone chan struct{} // closed when the reader exits
+
+	tagsMu sync.Mutex
+	tags   map[string]string // see Config.Tags
+
+	pingsMu sync.Mutex
+	pings   map[uint64]chan struct{} // replies awaited by ping, by PING data
+
+	// debug information received from the remote end via GOAWAY frame,
+	// guarded by stateMu
 	remoteError error
 	remoteDebug []byte
 }
 
 // Client returns a new muxado client-side connection using trans as the transport.
 func Client(trans io.ReadWriteCloser, config *Config) Session {
-	return newSession(trans, config, true)
+	return newSession(trans, config, true, nil)
 }
 
 // Server returns a muxado server session using trans as the transport.
 func Server(trans io.ReadWriteCloser, config *Config) Session {
-	return newSession(trans, config, false)
+	return newSession(trans, config, false, nil)
 }
 
-func newSession(transport io.ReadWriteCloser, config *Config, isClient bool) Session {
+// newSession creates a session over transport. Its framer is created with
+// newFramer in place of config.NewFramer if it is set.
+func newSession(transport io.ReadWriteCloser, config *Config, isClient bool, newFramer func(io.Reader, io.Writer) frame.Framer) Session {
 	if config == nil {
 		config = &zeroConfig
 	}
 	config.initDefaults()
+	if newFramer == nil {
+		newFramer = config.NewFramer
+	}
+	var rd io.Reader = transport
+	if config.ReadBufferSize > 0 {
+		rd = bufio.NewReaderSize(transport, config.ReadBufferSize)
+	}
+	wbuf := newWriteBuffer(transport, config.writeBufferSize)
 	sess := &session{
-		transport:   transport,
-		framer:      config.NewFramer(transport, transport),
-		streams:     newStreamMap(),
-		accept:      make(chan streamPrivate, config.AcceptBacklog),
-		writeFrames: make(chan writeReq, config.writeFrameQueueDepth),
-		dead:        make(chan struct{}),
-		config:      *config,
+		id:             atomic.AddUint64(&sessionIds, 1),
+		transport:      transport,
+		framer:         newHookFramer(newFramer(rd, wbuf), config),
+		streams:        newStreamMap(),
+		accept:         make(chan streamPrivate, config.AcceptBacklog),
+		wbuf:           wbuf,
+		drained:        make(chan struct{}),
+		negotiated:     make(chan struct{}),
+		settled:        make(chan struct{}),
+		acceptDeadline: newDeadline(),
+		openDeadline:   newDeadline(),
+		dead:           make(chan struct{}),
+		readerDone:     make(chan struct{}),
+		config:         *config,
+		tags:           make(map[string]string, len(config.Tags)),
+	}
+	for k, v := range config.Tags {
+		sess.tags[k] = v
+	}
+	if config.WriteLoop != nil {
+		// the loop takes the place of the writer goroutine and its queue
+		sess.loop = config.WriteLoop
+		sess.writeLock = make(chan struct{}, 1)
+	} else {
+		sess.writeFrames = make(chan writeReq, config.WriteQueueDepth)
+		sess.controls = make(chan writeReq, config.WriteQueueDepth)
+		sess.batch = make([]writeReq, 0, maxWriteBatch)
+		if config.DirectWrites {
+			sess.writeLock = make(chan struct{}, 1)
+			sess.flushes = make(chan struct{}, 1)
+		}
 	}
 	if isClient {
 		sess.isLocal = sess.isClient
@@ -89,11 +206,118 @@ func newSession(transport io.ReadWriteCloser, config *Config, isClient bool) Ses
 		sess.isLocal = sess.isServer
 		sess.remote.lastId += 1
 	}
-	go sess.reader()
-	go sess.writer()
+	sess.egress.SetRate(int(config.MaxEgressRate))
+	sess.priorities.init(func(id frame.StreamId) bool { return sess.getStream(id) != nil })
+	sess.local.maxFrameSize = uint32(min(int(config.MaxFrameSize), frame.MaxLength))
+	sess.remote.maxFrameSize = frame.MaxLength
+	if config.Compression {
+		sess.local.compression = codecMask(config)
+	}
+	for _, fr := range frame.Unwrap(sess.framer) {
+		if _, ok := fr.(frame.ChecksumFramer); ok && config.Checksums {
+			sess.local.checksums = 1
+		}
+		// extended lengths are only advertised if the framer reads them
+		if limited, ok := fr.(frame.LimitedFramer); ok {
+			limited.SetMaxLength(config.MaxFrameSize)
+			sess.local.maxFrameSize = config.MaxFrameSize
+		}
+	}
+	sess.initExtensions(config.Extensions)
+	sess.initAuth(config, isClient)
+	sess.initPreAuth(config, isClient)
+	sess.goLabeled("reader", sess.reader)
+	if sess.loop == nil {
+		sess.goLabeled("writer", sess.writer)
+	}
+	if config.ReadIdleTimeout > 0 {
+		atomic.StoreInt64(&sess.lastRead, time.Now().UnixNano())
+		sess.goLabeled("keepalive", sess.keepalive)
+	}
+	if config.HandshakeTimeout > 0 {
+		sess.goLabeled("handshake", sess.awaitHandshake)
+	}
+	if config.StreamIdleTimeout > 0 {
+		sess.goLabeled("reaper", sess.reaper)
+	}
+	if config.SlowConsumerTimeout > 0 {
+		sess.goLabeled("consumers", sess.consumers)
+	}
+	if config.IdleWindowTimeout > 0 {
+		sess.goLabeled("idlewindows", sess.idleWindows)
+	}
+	sess.sendSettings()
 	return sess
 }
 
+// goLabeled runs fn in a new goroutine labeled with the session's id, its
+// remote address and the goroutine's name so that CPU and goroutine profiles
+// of processes running many sessions can be attributed to them. Goroutines
+// that fn starts inherit the labels.
+func (s *session) goLabeled(name string, fn func()) {
+	remote := "unknown"
+	if addr := s.RemoteAddr(); addr != nil {
+		remote = addr.String()
+	}
+	labels := []string{
+		"muxado.session", strconv.FormatUint(s.id, 10),
+		"muxado.remote", remote,
+		"muxado.goroutine", name,
+	}
+	for k, v := range s.Tags() {
+		labels = append(labels, "muxado.tag."+k, v)
+	}
+	go pprof.Do(context.Background(), pprof.Labels(labels...), func(context.Context) { fn() })
+}
+
+// reserveStream counts a new stream against max, the limit on concurrent
+// streams. It returns false if the limit has been reached.
+func (h *halfState) reserveStream(max uint32) bool {
+	n := atomic.AddInt32(&h.numStreams, 1)
+	if max != 0 && n > int32(max) {
+		atomic.AddInt32(&h.numStreams, -1)
+		return false
+	}
+	return true
+}
+
+// waitForSlot reserves a slot under the limit of concurrent local streams,
+// waiting for a stream to close while there is none
+func (s *session) waitForSlot(ctx context.Context) error {
+	for {
+		s.slotMu.Lock()
+		if s.local.reserveStream(s.config.MaxStreams) {
+			s.slotMu.Unlock()
+			return nil
+		}
+		if s.slotFreed == nil {
+			s.slotFreed = make(chan struct{})
+		}
+		freed := s.slotFreed
+		s.slotMu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.openDeadline.wait():
+			return ErrOpenTimeout
+		case <-s.dead:
+			return s.dieErr
+		}
+	}
+}
+
+// freeSlot wakes up the callers waiting for a slot after a local stream closed
+func (s *session) freeSlot() {
+	s.slotMu.Lock()
+	if s.slotFreed != nil {
+		close(s.slotFreed)
+		s.slotFreed = nil
+	}
+	s.slotMu.Unlock()
+}
+
 // check if a stream id is for a client stream. client streams are odd
 func (s *session) isClient(id frame.StreamId) bool {
 	return uint32(id)&1 == 1
@@ -103,69 +327,264 @@ func (s *session) isServer(id frame.StreamId) bool {
 	return !s.isClient(id)
 }
 
-////////////////////////////////
+// //////////////////////////////
 // public interface
-////////////////////////////////
+// //////////////////////////////
 func (s *session) Open() (net.Conn, error) {
 	return s.OpenStream()
 }
 
-func (s *session) OpenStream() (Stream, error) {
+func (s *session) OpenStream(opts ...StreamOption) (Stream, error) {
+	return s.OpenStreamWithData(nil, opts...)
+}
+
+func (s *session) OpenStreamWithData(p []byte, opts ...StreamOption) (Stream, error) {
+	o := newStreamOptions(opts)
+	if s.config.Migrator != nil && !o.continuation {
+		o.metadata = s.config.Migrator.newId(o.metadata)
+	}
+	if o.pushedBy != 0 {
+		if err := s.promisePush(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.unidirectional {
+		if err := s.awaitUnidirectional(); err != nil {
+			return nil, err
+		}
+		if o.metadata == nil {
+			// unidirectional streams are opened by a HEADERS frame
+			o.metadata = map[string]string{}
+		}
+	}
+
+	var codec Codec
+	if o.compress {
+		var id CodecId
+		var err error
+		if id, codec, err = s.chooseCodec(o.codecs); err != nil {
+			return nil, err
+		}
+		if codec != nil && id != CodecDeflate {
+			// codecs other than DEFLATE are named in the metadata
+			if o.metadata == nil {
+				o.metadata = map[string]string{}
+			}
+			o.metadata[codecKey] = strconv.Itoa(int(id))
+		}
+	}
+
+	if isClosed(s.openDeadline.wait()) {
+		return nil, ErrOpenTimeout
+	}
+
+	if s.State() >= StateClosing {
+		return nil, ErrSessionClosed
+	}
+
 	// check if the remote has gone away
 	if atomic.LoadUint32(&s.remote.goneAway) == 1 {
-		return nil, remoteGoneAway
+		return nil, ErrRemoteGoneAway
+	}
+
+	// reserve a slot under the concurrent stream limit
+	if o.slotWait != nil {
+		if err := s.waitForSlot(o.slotWait); err != nil {
+			return nil, err
+		}
+	} else if !s.local.reserveStream(s.config.MaxStreams) {
+		return nil, ErrStreamsLimited
 	}
 
 	// get the next id we can use
 	nextId := frame.StreamId(atomic.AddUint32(&s.local.lastId, 2))
 	if nextId&(1<<31) > 0 {
-		return nil, streamsExhausted
+		atomic.AddInt32(&s.local.numStreams, -1)
+		return nil, ErrStreamsExhausted
 	}
 
 	// make the stream and add it to the stream map
 	str := s.config.newStream(s, nextId, s.config.MaxWindowSize, false, true)
+	if o.metadata != nil {
+		str.setMetadata(o.metadata)
+	}
+	if o.label != "" {
+		str.SetLabel(o.label)
+	}
+	if o.unidirectional {
+		str.setUnidirectional(true)
+	}
 	s.streams.Set(nextId, str)
+	if s.config.OnStreamOpen != nil {
+		s.config.OnStreamOpen(str)
+	}
 
-	return str, nil
+	// only compress if the remote side can decompress
+	var ret Stream = str
+	if codec != nil {
+		str.setCompressed()
+		ret = newCompressedStream(str, codec)
+	}
+	if s.config.Migrator != nil && !o.continuation {
+		ret = s.config.Migrator.track(ret)
+	}
+	if s.config.WrapStream != nil && !o.continuation {
+		ret = s.config.WrapStream(ret, true)
+	}
+
+	// the stream is opened by its first write, so the PROXY header and the
+	// initial data ride on the SYN frame
+	if len(o.proxyHeader)+len(p) > 0 {
+		initial := make([]byte, 0, len(o.proxyHeader)+len(p))
+		initial = append(append(initial, o.proxyHeader...), p...)
+		if _, err := ret.Write(initial); err != nil {
+			str.Close()
+			return nil, err
+		}
+		if err := str.flushWrites(); err != nil {
+			str.Close()
+			return nil, err
+		}
+	} else if o.continuation || o.pushedBy != 0 {
+		// continuations are opened right away so that the remote side moves
+		// its writes to them, pushes so that the remote side can decline them
+		if err := str.open(); err != nil {
+			str.Close()
+			return nil, err
+		}
+	}
+	if s.config.SyncOpen {
+		if err := s.awaitAck(str); err != nil {
+			str.Close()
+			return nil, err
+		}
+	}
+	return ret, nil
 }
 
 func (s *session) AcceptStream() (Stream, error) {
-	select {
-	case str, ok := <-s.accept:
-		if ok {
-			return str, nil
-		} else {
-			<-s.dead
+	if err := s.awaitAuth(); err != nil {
+		return nil, err
+	}
+ACCEPT:
+	for {
+		select {
+		case str, ok := <-s.accept:
+			if ok {
+				s.admitHeld()
+				if ret := s.prepareAccepted(str); ret != nil {
+					return ret, nil
+				}
+				continue ACCEPT
+			} else {
+				<-s.dead
+			}
+		case <-s.acceptDeadline.wait():
+			return nil, ErrAcceptTimeout
+		case <-s.dead:
 		}
-	case <-s.dead:
+		break
 	}
 
 	if s.dieErr == nil {
-		return nil, &muxadoError{NoError, nil}
+		return nil, &Error{NoError, nil}
 	} else {
 		return nil, s.dieErr
 	}
 }
 
+// prepareAccepted wraps an accepted stream according to how it was opened. It
+// returns nil if the stream was reset because it could not be prepared, or
+// handed to the Migrator because it continues a migrated stream.
+func (s *session) prepareAccepted(str streamPrivate) Stream {
+	var ret Stream = str
+	if str.compressed() {
+		codec, err := s.acceptedCodec(str)
+		if err != nil {
+			str.resetWith(ProtocolError, newErr(ProtocolError, err))
+			return nil
+		}
+		ret = newCompressedStream(str, codec)
+	}
+	if s.config.Migrator != nil {
+		if ret = s.config.Migrator.track(ret); ret == nil {
+			return nil
+		}
+	} else if _, ok := str.Metadata()[migratedKey]; ok {
+		str.resetWith(StreamRefused, errNoMigrator)
+		return nil
+	}
+	if s.config.ProxyProtocol {
+		proxied, err := readProxyHeader(ret)
+		if err != nil {
+			str.resetWith(ProtocolError, newErr(ProtocolError, err))
+			return nil
+		}
+		ret = proxied
+	}
+	if s.config.WrapStream != nil {
+		ret = s.config.WrapStream(ret, false)
+	}
+	return ret
+}
+
 func (s *session) Accept() (net.Conn, error) {
 	return s.AcceptStream()
 }
 
 func (s *session) Close() error {
-	return s.die(sessionClosed)
+	return s.die(ErrSessionClosed)
+}
+
+// CloseWithError closes the session like Close but tells the remote side why
+// with the given error code and debug data, which it receives from Wait. The
+// debug data may be structured, see GoAwayDebug.
+func (s *session) CloseWithError(errCode ErrorCode, debug []byte) error {
+	return s.dieWith(newErr(errCode, goAwayError(debug)), errCode, debug)
 }
 
+// GoAway tells the remote side to stop opening new streams. The session
+// continues to service all of the streams that it has already accepted but
+// refuses any new ones. Drained() is closed once all remaining streams have
+// finished.
 func (s *session) GoAway(errCode ErrorCode, debug []byte, dl time.Time) (err error) {
-	// mark that we've told the client to go away
+	// mark that we've told the client to go away. this must be ordered with
+	// handleSyn so that the last stream id reported to the remote is exactly
+	// the last stream we accepted
+	s.goAwayMu.Lock()
 	atomic.StoreUint32(&s.local.goneAway, 1)
-	f := new(frame.GoAway)
 	remoteId := frame.StreamId(atomic.LoadUint32(&s.remote.lastId))
+	s.goAwayMu.Unlock()
+	s.advance(StateDraining)
+	s.maybeDrained()
+
+	f := new(frame.GoAway)
 	if err := f.Pack(remoteId, frame.ErrorCode(errCode), debug); err != nil {
 		return fromFrameError(err)
 	}
 	return s.writeFrame(f, dl)
 }
 
+func (s *session) Drained() <-chan struct{} {
+	return s.drained
+}
+
+func (s *session) SetDeadline(t time.Time) error {
+	s.acceptDeadline.set(t)
+	s.openDeadline.set(t)
+	return nil
+}
+
+func (s *session) SetAcceptDeadline(t time.Time) error {
+	s.acceptDeadline.set(t)
+	return nil
+}
+
+func (s *session) SetOpenDeadline(t time.Time) error {
+	s.openDeadline.set(t)
+	return nil
+}
+
 type addr struct {
 	locality string
 }
@@ -206,33 +625,180 @@ func (s *session) Addr() net.Addr {
 
 func (s *session) Wait() (error, error, []byte) {
 	<-s.dead
+	// a GOAWAY may still be in the hands of the reader, which exits once the
+	// transport is closed
+	<-s.readerDone
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
 	return s.dieErr, s.remoteError, s.remoteDebug
 }
 
+func (s *session) Done() <-chan struct{} {
+	return s.dead
+}
+
+func (s *session) Err() error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.dieErr
+}
+
 ////////////////////////////////
 // private interface for streams
 ////////////////////////////////
 
+func (s *session) TransportOptions() TransportOptions {
+	return transportOptions{s.transport}
+}
+
+func (s *session) Streams() []StreamInfo {
+	var infos []StreamInfo
+	s.streams.Each(func(id frame.StreamId, str streamPrivate) {
+		info := str.info()
+		info.Local = s.isLocal(id)
+		infos = append(infos, info)
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id < infos[j].Id })
+	return infos
+}
+
+func (s *session) NumStreams() int {
+	return s.NumOpened() + s.NumAccepted()
+}
+
+func (s *session) NumOpened() int {
+	return int(atomic.LoadInt32(&s.local.numStreams))
+}
+
+func (s *session) NumAccepted() int {
+	return int(atomic.LoadInt32(&s.remote.numStreams))
+}
+
+func (s *session) PathStats() PathStats {
+	return s.path.get()
+}
+
+func (s *session) dataSent(id frame.StreamId, offset uint64) {
+	s.path.sent(id, offset, time.Now())
+}
+
+func (s *session) dataAcked(id frame.StreamId, offset uint64, n uint32) {
+	s.path.acked(id, offset, n, time.Now())
+}
+
+func (s *session) priority(id frame.StreamId) Priority {
+	return s.priorities.get(id)
+}
+
+func (s *session) writeCoalesceDelay() time.Duration {
+	return s.config.WriteCoalesceDelay
+}
+
+func (s *session) windowUpdateRatio() float64 {
+	return s.config.WindowUpdateThreshold
+}
+
+func (s *session) windowUpdateDelay() time.Duration {
+	return s.config.WindowUpdateDelay
+}
+
+// maxFrameSize returns the largest DATA payload that may be sent to the remote side
+func (s *session) maxFrameSize() int {
+	return min(int(s.config.MaxFrameSize), int(atomic.LoadUint32(&s.remote.maxFrameSize)))
+}
+
+// egressBurst returns the largest DATA payload that the session's egress rate
+// limit lets through without holding up the writer for more than a second,
+// or 0 if there is no limit
+func (s *session) egressBurst() int {
+	if burst := s.egress.Burst(); burst > 0 {
+		return max(burst-frame.HeaderSize, 1)
+	}
+	return 0
+}
+
+// addBuffered adjusts the count of unread bytes buffered across all streams
+func (s *session) addBuffered(delta int) {
+	atomic.AddInt64(&s.buffered, int64(delta))
+}
+
+// streamReset notifies the application that a stream was reset
+func (s *session) streamReset(str Stream, code ErrorCode) {
+	if s.config.OnStreamReset != nil {
+		s.config.OnStreamReset(str, code)
+	}
+}
+
 // removeStream removes a stream from this session's stream registry
 //
 // It does not error if the stream is not present
 func (s *session) removeStream(id frame.StreamId) {
-	s.streams.Delete(id)
+	str, ok := s.streams.Delete(id)
+	if !ok {
+		return
+	}
+	s.priorities.remove(id)
+	s.path.forget(id)
+	if s.config.OnStreamClose != nil {
+		s.config.OnStreamClose(str, str.closeErr())
+	}
+	if s.isLocal(id) {
+		atomic.AddInt32(&s.local.numStreams, -1)
+		s.freeSlot()
+	} else {
+		atomic.AddInt32(&s.remote.numStreams, -1)
+	}
+	s.maybeDrained()
 }
 
+// maybeDrained closes the drained channel if we've sent a GOAWAY and no streams remain
+func (s *session) maybeDrained() {
+	if atomic.LoadUint32(&s.local.goneAway) == 1 && s.streams.Len() == 0 {
+		s.drainOnce.Do(func() { close(s.drained) })
+	}
+}
+
+// maximum number of queued frames the writer will coalesce into a single flush
+const maxWriteBatch = 64
+
 type writeReq struct {
 	f   frame.Frame
 	err chan error
 }
 
+// isControl reports whether f is a control frame that the writer goroutine
+// writes ahead of queued DATA and other frames, so that flow control and
+// teardown aren't held up behind bulk data. Frames that must not overtake
+// the frame opening their stream are written with writeFrameOrdered.
+func isControl(f frame.Frame) bool {
+	switch f.Type() {
+	case frame.TypeRst, frame.TypeWndInc, frame.TypeGoAway, frame.TypePing, frame.TypeSettings:
+		return true
+	}
+	return false
+}
+
+// queueFor returns the queue of the writer goroutine that f is written from
+func (s *session) queueFor(f frame.Frame) chan writeReq {
+	if isControl(f) {
+		return s.controls
+	}
+	return s.writeFrames
+}
+
 var pool = make(chan chan error, 1024)
 
+// source of session ids for profiler labels
+var sessionIds uint64
+
 func poolGet() interface{} {
 	select {
 	case item := <-pool:
 		return item
 	default:
-		return make(chan error)
+		// buffered so that the writer never blocks delivering a result
+		// to a caller that has already given up waiting for it
+		return make(chan error, 1)
 	}
 }
 
@@ -245,59 +811,158 @@ func poolPut(x interface{}) {
 
 // writeFrame writes the given frame to the framer and returns the error from the write operation
 func (s *session) writeFrame(f frame.Frame, dl time.Time) error {
+	return s.writeFrameFrom(context.Background(), s.queueFor(f), f, dl)
+}
+
+// writeFrameContext is like writeFrame but gives up waiting once ctx is done
+func (s *session) writeFrameContext(ctx context.Context, f frame.Frame, dl time.Time) error {
+	return s.writeFrameFrom(ctx, s.queueFor(f), f, dl)
+}
+
+// writeFrameOrdered is like writeFrame but control frames are written after
+// the frames already queued, e.g. an RST after the frame opening its stream
+// that the remote side would otherwise not know the stream from
+func (s *session) writeFrameOrdered(f frame.Frame, dl time.Time) error {
+	return s.writeFrameFrom(context.Background(), s.writeFrames, f, dl)
+}
+
+// writeFrameFrom writes f from the given queue of the writer goroutine
+func (s *session) writeFrameFrom(ctx context.Context, queue chan writeReq, f frame.Frame, dl time.Time) error {
+	if s.writeLock != nil {
+		return s.writeFrameDirect(ctx, f, dl)
+	}
 	var timeout <-chan time.Time
 	if !dl.IsZero() {
-		timeout = time.After(dl.Sub(time.Now()))
+		t := getTimer(dl)
+		defer putTimer(t)
+		timeout = t.C
 	}
 	var req = writeReq{f: f, err: poolGet().(chan error)}
-	select {
-	case s.writeFrames <- req:
-	case <-s.dead:
-		return sessionClosed
-	case <-timeout:
-		return writeTimeout
+	if s.failFast(f) {
+		select {
+		case queue <- req:
+		default:
+			poolPut(req.err)
+			return ErrWriteQueueFull
+		}
+	} else {
+		select {
+		case queue <- req:
+		case <-s.dead:
+			return ErrSessionClosed
+		case <-timeout:
+			return ErrWriteTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	select {
 	case err := <-req.err:
 		poolPut(req.err)
 		return err
 	case <-timeout:
-		return writeTimeout
+		return ErrWriteTimeout
 	case <-s.dead:
-		return sessionClosed
+		return ErrSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// failFast reports whether writing f fails instead of waiting for room in a
+// full write queue. Only DATA frames that don't open a stream fail, so that a
+// failed write leaves the stream usable.
+func (s *session) failFast(f frame.Frame) bool {
+	if s.config.WriteQueuePolicy != WriteQueueFailFast {
+		return false
+	}
+	data, ok := f.(*frame.Data)
+	return ok && !data.Syn()
+}
+
 // like writeFrame but it returns immediately, do not use with any frame/buffer that will be reused
 // or free'd
 func (s *session) writeFrameAsync(f frame.Frame) error {
+	if s.loop != nil {
+		return s.queueFrame(f)
+	}
 	var req = writeReq{f: f}
 	select {
-	case s.writeFrames <- req:
+	case s.queueFor(f) <- req:
 		return nil
 	case <-s.dead:
-		return sessionClosed
+		return ErrSessionClosed
 	}
 }
 
-// die closes the session cleanly with the given error and protocol error code
-func (s *session) die(err error) error {
-	// only one shutdown ever happens
-	if !atomic.CompareAndSwapUint32(&s.dieOnce, 0, 1) {
-		return sessionClosed
+// sendSettings advertises the session's configuration to the remote side. The
+// SETTINGS frame always carries the supported protocol versions; every other
+// setting is only sent if it differs from its protocol default.
+func (s *session) sendSettings() {
+	settings := []frame.Setting{
+		{Id: frame.SettingVersion, Value: packVersions(s.config.MinProtocolVersion, s.config.MaxProtocolVersion)},
+	}
+	if s.local.maxFrameSize < frame.MaxLength {
+		settings = append(settings, frame.Setting{Id: frame.SettingMaxFrameSize, Value: s.local.maxFrameSize})
+	}
+	if s.local.maxFrameSize > frame.MaxLength {
+		// peers that don't support extended lengths ignore the setting
+		settings = append(settings, frame.Setting{Id: frame.SettingExtendedLength, Value: s.local.maxFrameSize})
+	}
+	if s.local.compression != 0 {
+		settings = append(settings, frame.Setting{Id: frame.SettingCompression, Value: s.local.compression})
+	}
+	if s.local.checksums == 1 {
+		settings = append(settings, frame.Setting{Id: frame.SettingChecksums, Value: frame.ChecksumsSupported})
 	}
+	if s.config.SyncOpen {
+		settings = append(settings, frame.Setting{Id: frame.SettingStreamAcks, Value: 1})
+	}
+	if len(s.config.PaddingBuckets) > 0 {
+		settings = append(settings, frame.Setting{Id: frame.SettingPadding, Value: 1})
+	}
+	if s.config.IdleWindowTimeout > 0 {
+		settings = append(settings, frame.Setting{Id: frame.SettingWindowShrink, Value: 1})
+	}
+	settings = append(settings, s.extensionSettings()...)
+	f := new(frame.Settings)
+	if err := f.Pack(settings); err != nil {
+		s.die(newErr(InternalError, fmt.Errorf("failed to pack SETTINGS frame: %v", err)))
+		return
+	}
+	s.writeFrameAsync(f)
+}
 
-	// try to send a GOAWAY frame
+// die closes the session cleanly with the given error and protocol error code
+func (s *session) die(err error) error {
 	errorCode, _ := GetError(err)
 	debug := []byte(err.Error())
-	if err == sessionClosed {
+	if err == ErrSessionClosed {
 		errorCode = NoError
 		debug = []byte("no error")
 	}
+	return s.dieWith(err, errorCode, debug)
+}
+
+// dieWith closes the session with the given error, telling the remote side
+// why with the given error code and debug data
+func (s *session) dieWith(err error, errorCode ErrorCode, debug []byte) error {
+	// only one shutdown ever happens
+	s.stateMu.Lock()
+	closing := s.advanceLocked(StateClosing)
+	if closing {
+		s.dieErr = err
+	}
+	s.stateMu.Unlock()
+	if !closing {
+		return ErrSessionClosed
+	}
+
+	// try to send a GOAWAY frame
 	_ = s.GoAway(errorCode, debug, time.Now().Add(250*time.Millisecond))
 
 	// yay, we're dead
-	s.dieErr = err
+	s.advance(StateDead)
 	close(s.dead)
 
 	// close the transport
@@ -305,7 +970,7 @@ func (s *session) die(err error) error {
 
 	// notify all of the streams that we're closing
 	s.streams.Each(func(id frame.StreamId, str streamPrivate) {
-		str.closeWith(sessionClosed)
+		str.closeWith(ErrSessionClosed)
 	})
 
 	return nil
@@ -318,41 +983,128 @@ func (s *session) die(err error) error {
 func (s *session) writer() {
 	defer s.recoverPanic("writer()")
 	for {
+		// control frames are taken first when both queues have frames
+		select {
+		case req := <-s.controls:
+			s.writeBatch(req)
+			continue
+		default:
+		}
 		select {
+		case req := <-s.controls:
+			s.writeBatch(req)
 		case req := <-s.writeFrames:
-			err := fromFrameError(s.framer.WriteFrame(req.f))
-			if req.err != nil {
-				select {
-				case req.err <- err:
-				case <-s.dead:
-					return
-				}
-			}
-			if err != nil {
-				// any write error kills the session
-				s.die(err)
-			}
+			s.writeBatch(req)
+		case <-s.flushes:
+			s.flushDirect()
 		case <-s.dead:
 			return
 		}
 	}
 }
 
+// writeBatch writes the given request along with any others that are already
+// queued into the session's write buffer and then flushes them to the
+// transport with as few writes as possible. Queued control frames are written
+// before the other queued frames. Each request's result is delivered only
+// after the flush so that transport errors are reported to every writer in
+// the batch.
+func (s *session) writeBatch(req writeReq) {
+	batch := append(s.batch[:0], req)
+	for _, queue := range [...]chan writeReq{s.controls, s.writeFrames} {
+	DRAIN:
+		for len(batch) < maxWriteBatch {
+			select {
+			case req = <-queue:
+				batch = append(batch, req)
+			default:
+				break DRAIN
+			}
+		}
+	}
+	s.priorities.schedule(batch)
+	s.lockWrites()
+	var err error
+	for i := 0; err == nil && i < len(batch); i++ {
+		err = s.pacedWriteFrame(batch[i].f)
+	}
+	if err == nil {
+		err = s.wbuf.Flush()
+	}
+	s.unlockWrites()
+	for i := range batch {
+		if batch[i].err != nil {
+			batch[i].err <- err
+		}
+		batch[i] = writeReq{}
+	}
+	s.batch = batch[:0]
+	if err != nil {
+		// any write error kills the session
+		s.die(err)
+	}
+}
+
+// pacedWriteFrame writes a frame to the framer once the session's egress rate
+// limit and pacing allow it. Frames already buffered are flushed before
+// waiting so that they are not held back by the pacing of the frames behind
+// them.
+func (s *session) pacedWriteFrame(f frame.Frame) error {
+	n := frame.HeaderSize + int(f.Length())
+	d := s.egress.Take(n)
+	if s.config.Pacing && f.Type() == frame.TypeData {
+		rate := pacingGain * float64(s.path.get().DeliveryRate)
+		if pd := s.pacer.take(n, rate); pd > d {
+			d = pd
+		}
+	}
+	if d > 0 {
+		if err := s.wbuf.Flush(); err != nil {
+			return err
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-s.dead:
+			t.Stop()
+			return ErrSessionClosed
+		}
+	}
+	if err := s.framer.WriteFrame(f); err != nil {
+		return fromFrameError(err)
+	}
+	return s.pad(f)
+}
+
 // reader() reads frames from the underlying transport and handles passes them to handleFrame
 func (s *session) reader() {
+	defer close(s.readerDone)
 	defer s.recoverPanic("reader()")
-	defer close(s.accept)
+	defer s.closeAccept()
 	for {
 		f, err := s.framer.ReadFrame()
 		if err != nil {
 			err = fromFrameError(err)
-			if err == io.EOF {
-				s.die(eofPeer)
+			// the transport may also end in the middle of a frame
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				s.die(ErrPeerEOF)
 			} else {
 				s.die(err)
 			}
 			return
 		}
+		atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+		first := atomic.LoadUint32(&s.version) == 0
+		if first {
+			if err := s.negotiateVersion(f); err != nil {
+				s.die(err)
+				return
+			}
+			if err := s.requireAuth(f); err != nil {
+				s.die(err)
+				return
+			}
+		}
 		// any error encountered while handling a frame must
 		// cause the reader to terminate immediately in order
 		// to prevent further data on the transport from being processed
@@ -361,6 +1113,13 @@ func (s *session) reader() {
 			s.die(err)
 			return
 		}
+		if first {
+			close(s.settled)
+		}
+		if err := s.checkPreAuth(f); err != nil {
+			s.die(err)
+			return
+		}
 		select {
 		case <-s.dead:
 			return
@@ -369,15 +1128,113 @@ func (s *session) reader() {
 	}
 }
 
+// reaper periodically resets streams that have been idle for longer than
+// the configured StreamIdleTimeout
+func (s *session) reaper() {
+	defer s.recoverPanic("reaper()")
+	timeout := s.config.StreamIdleTimeout
+	t := time.NewTicker(timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.dead:
+			return
+		}
+		// collect the streams first, resetting them removes them from the map
+		var idle []streamPrivate
+		s.streams.Each(func(id frame.StreamId, str streamPrivate) {
+			if time.Since(str.idleSince()) >= timeout {
+				idle = append(idle, str)
+			}
+		})
+		for _, str := range idle {
+			str.resetWith(StreamIdleTimeout, ErrStreamIdleTimeout)
+		}
+	}
+}
+
+// keepalive closes the session if no frame has been read within the read idle
+// timeout and pings the remote side once half of it has elapsed so that a live
+// peer always has something to reply with
+func (s *session) keepalive() {
+	defer s.recoverPanic("keepalive()")
+	timeout := s.config.ReadIdleTimeout
+	interval := timeout / 2
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.dead:
+			return
+		}
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastRead)))
+		switch {
+		case idle >= timeout:
+			s.die(ErrReadIdleTimeout)
+			return
+		case idle >= interval:
+			f := new(frame.Ping)
+			if err := f.Pack(uint64(time.Now().UnixNano()), false); err != nil {
+				s.die(newErr(InternalError, fmt.Errorf("failed to pack keepalive PING: %v", err)))
+				return
+			}
+			s.writeFrameAsync(f)
+			t.Reset(timeout - idle)
+		default:
+			t.Reset(interval - idle)
+		}
+	}
+}
+
 func (s *session) recoverPanic(prefix string) {
 	if r := recover(); r != nil {
 		s.die(newErr(InternalError, fmt.Errorf("%s panic: %v", prefix, r)))
 	}
 }
 
+// flags defined for each frame type, any others are deviations
+var definedFlags = map[frame.Type]frame.Flags{
+	frame.TypeData:     frame.FlagDataFin | frame.FlagDataSyn | frame.FlagDataCompressed | frame.FlagDataExtended,
+	frame.TypeHeaders:  frame.FlagHeadersFin | frame.FlagHeadersSyn | frame.FlagHeadersCompressed | frame.FlagHeadersUnidirectional,
+	frame.TypePing:     frame.FlagPingAck,
+	frame.TypeWndInc:   frame.FlagWndIncAck | frame.FlagWndIncShrink,
+	frame.TypePriority: frame.FlagPriorityExclusive,
+}
+
+// deviation handles a deviation from the protocol by the remote side that the
+// session can tolerate. It returns the error to close the session with under
+// ValidationStrict and reports the deviation to OnProtocolDeviation otherwise.
+func (s *session) deviation(format string, args ...interface{}) error {
+	err := newErr(ProtocolError, fmt.Errorf(format, args...))
+	if s.config.Validation == ValidationStrict {
+		return err
+	}
+	if s.config.OnProtocolDeviation != nil {
+		s.config.OnProtocolDeviation(err)
+	}
+	return nil
+}
+
 func (s *session) handleFrame(rf frame.Frame) error {
+	// the flags of unknown frames and of application frames are not ours to check
+	switch rf.(type) {
+	case *frame.Unknown, *frame.User:
+	default:
+		if undefined := rf.Flags() &^ definedFlags[rf.Type()]; undefined != 0 {
+			if err := s.deviation("%s frame has undefined flags 0x%x", rf.Type(), uint8(undefined)); err != nil {
+				return err
+			}
+		}
+	}
+
 	switch f := rf.(type) {
 	case *frame.Data:
+		// framers that aren't told the max length read longer frames
+		if f.Length() > s.local.maxFrameSize {
+			return newErr(FrameSizeError, fmt.Errorf("DATA frame length %d exceeds the advertised maximum of %d", f.Length(), s.local.maxFrameSize))
+		}
 		if f.Syn() {
 			// starting a new stream is a sepcial case
 			return s.handleSyn(f)
@@ -411,13 +1268,20 @@ func (s *session) handleFrame(rf frame.Frame) error {
 			s.writeFrameAsync(fRst)
 			return nil
 		}
-		return str.handleStreamData(f)
+		if err := str.handleStreamData(f); err != nil {
+			return err
+		}
+		s.enforceBufferBudget()
 
 	case *frame.Rst:
 		// delegate to the stream to handle these frames
 		if str := s.getStream(f.StreamId()); str != nil {
 			return str.handleStreamRst(f)
 		}
+	case *frame.Priority:
+		// priorities of streams that are not open are ignored
+		s.priorities.update(f.StreamId(), f.Dependency(), f.Weight(), f.Exclusive())
+
 	case *frame.WndInc:
 		// delegate to the stream to handle these frames
 		if str := s.getStream(f.StreamId()); str != nil {
@@ -439,9 +1303,11 @@ func (s *session) handleFrame(rf frame.Frame) error {
 			return err
 		}
 
-		// XXX: this races with shutdown
+		s.stateMu.Lock()
 		s.remoteDebug = debug
-		s.remoteError = &muxadoError{ErrorCode(f.ErrorCode()), errors.New(string(debug))}
+		s.remoteError = &Error{ErrorCode(f.ErrorCode()), goAwayError(debug)}
+		s.stateMu.Unlock()
+		s.advance(StateDraining)
 
 		// close streams unhandled by the remote side
 		lastId := f.LastStreamId()
@@ -449,12 +1315,44 @@ func (s *session) handleFrame(rf frame.Frame) error {
 			// close all streams that we opened above the last handled id
 			sid := frame.StreamId(str.Id())
 			if s.isLocal(sid) && sid > lastId {
-				str.closeWith(remoteGoneAway)
+				str.closeWith(ErrRemoteGoneAway)
 			}
 		})
 
+	case *frame.Settings:
+		return s.handleSettings(f)
+
+	case *frame.Ping:
+		// reply to pings with the same data so the remote side knows we're alive
+		if !f.Ack() {
+			fAck := new(frame.Ping)
+			if err := fAck.Pack(f.Data(), true); err != nil {
+				return newErr(InternalError, fmt.Errorf("failed to pack PING ack: %v", err))
+			}
+			s.writeFrameAsync(fAck)
+		} else {
+			// our PINGs carry the time they were sent
+			s.path.sampleRTT(time.Since(time.Unix(0, int64(f.Data()))))
+			s.pingReplied(f.Data())
+		}
+
+	case *frame.Headers:
+		return s.handleHeaders(f)
+
+	case *frame.Extension:
+		return s.handleExtension(f)
+
+	case *frame.User:
+		return s.handleUserFrame(f)
+
+	case *frame.Padding:
+		// the framer discarded its payload
+
 	case *frame.Unknown:
 		// unknown frame types ignored
+		if err := s.deviation("unknown frame type 0x%x", uint8(f.Type())); err != nil {
+			return err
+		}
 		if _, err := io.CopyN(ioutil.Discard, f.PayloadReader(), int64(f.Length())); err != nil {
 			return err
 		}
@@ -465,63 +1363,373 @@ func (s *session) handleFrame(rf frame.Frame) error {
 	return nil
 }
 
+func (s *session) handleSettings(f *frame.Settings) error {
+	for _, setting := range f.Settings() {
+		switch setting.Id {
+		case frame.SettingMaxFrameSize:
+			if setting.Value == 0 || setting.Value > frame.MaxLength {
+				return newErr(ProtocolError, fmt.Errorf("invalid max frame size setting: %d", setting.Value))
+			}
+			atomic.StoreUint32(&s.remote.maxFrameSize, setting.Value)
+		case frame.SettingExtendedLength:
+			if setting.Value <= frame.MaxLength || setting.Value > frame.MaxExtendedLength {
+				return newErr(ProtocolError, fmt.Errorf("invalid extended length setting: %d", setting.Value))
+			}
+			atomic.StoreUint32(&s.remote.maxFrameSize, setting.Value)
+		case frame.SettingCompression:
+			atomic.StoreUint32(&s.remote.compression, setting.Value)
+		case frame.SettingVersion:
+			// negotiated by the first frame, see negotiateVersion
+		case frame.SettingStreamAcks:
+			var acks uint32
+			if setting.Value != 0 {
+				acks = 1
+			}
+			atomic.StoreUint32(&s.remote.acks, acks)
+		case frame.SettingPadding:
+			var padding uint32
+			if setting.Value != 0 {
+				padding = 1
+			}
+			atomic.StoreUint32(&s.remote.padding, padding)
+		case frame.SettingWindowShrink:
+			var shrink uint32
+			if setting.Value != 0 {
+				shrink = 1
+			}
+			atomic.StoreUint32(&s.remote.shrink, shrink)
+		case frame.SettingChecksums:
+			// the framer verifies checksums once they're turned on. if the
+			// remote side can verify them as well, turn on ours.
+			if setting.Value == frame.ChecksumsSupported && s.local.checksums == 1 &&
+				atomic.CompareAndSwapUint32(&s.remote.checksums, 0, 1) {
+				fOn := new(frame.Settings)
+				if err := fOn.Pack([]frame.Setting{{Id: frame.SettingChecksums, Value: frame.ChecksumsOn}}); err != nil {
+					return newErr(InternalError, fmt.Errorf("failed to pack SETTINGS frame: %v", err))
+				}
+				s.writeFrameAsync(fOn)
+			}
+		default:
+			// extensions we don't run and unknown settings are ignored for
+			// forward compatibility
+			if setting.Id&frame.SettingExtensionBase != 0 && setting.Value != 0 {
+				s.startExtension(uint16(setting.Id &^ frame.SettingExtensionBase))
+			}
+		}
+	}
+	return nil
+}
+
 func (s *session) handleSyn(f *frame.Data) (err error) {
-	// if we're going away, refuse new streams
-	if atomic.LoadUint32(&s.local.goneAway) == 1 {
-		rstF := new(frame.Rst)
-		if err := rstF.Pack(f.StreamId(), frame.ErrorCode(StreamRefused)); err != nil {
-			return newErr(InternalError, fmt.Errorf("failed to pack stream refused RST: %v", err))
+	var stype StreamType
+	if s.config.Authorize != nil || s.config.OnIncomingStream != nil {
+		if stype, err = synStreamType(f); err != nil {
+			return err
 		}
-		s.writeFrameAsync(rstF)
-		return
+	}
+	str, err := s.openRemoteStream(f.StreamId(), f.Fin(), f.Compressed(), stype, nil)
+	if err != nil {
+		return err
+	}
+	if str == nil {
+		// the stream was refused, discard its data
+		_, err := io.CopyN(ioutil.Discard, f.Reader(), int64(f.Length()))
+		return err
 	}
 
-	if s.isLocal(f.StreamId()) {
-		err := fmt.Errorf("initiated stream id has wrong parity for remote endpoint: 0x%x", f.StreamId())
-		return newErr(ProtocolError, err)
+	// put the new stream on the accept channel
+	if s.queueAccept(str) {
+		s.ackStream(str)
 	}
 
-	// update last remote id
-	atomic.StoreUint32(&s.remote.lastId, uint32(f.StreamId()))
+	// handle the stream data
+	if err := str.handleStreamData(f); err != nil {
+		return err
+	}
+	s.enforceBufferBudget()
+	return nil
+}
+
+func (s *session) handleHeaders(f *frame.Headers) error {
+	if f.Syn() == f.Fin() {
+		// headers either open a stream or carry its trailers
+		return newErr(ProtocolError, fmt.Errorf("HEADERS frame must have exactly one of SYN and FIN on stream 0x%x", f.StreamId()))
+	}
+	if f.Fin() {
+		// trailers for streams that don't exist are ignored, like a FIN
+		if str := s.getStream(f.StreamId()); str != nil {
+			return str.handleStreamTrailers(f)
+		}
+		return nil
+	}
+	md := make(map[string]string, len(f.Headers()))
+	for _, h := range f.Headers() {
+		md[h.Key] = h.Value
+	}
+	str, err := s.openRemoteStream(f.StreamId(), false, f.Compressed(), 0, md)
+	if err != nil || str == nil {
+		return err
+	}
+	if f.Unidirectional() {
+		str.setUnidirectional(false)
+	}
+	if _, ok := md[pushedByKey]; ok {
+		s.handlePush(str, md)
+		return nil
+	}
+	if s.queueAccept(str) {
+		s.ackStream(str)
+	}
+	return nil
+}
+
+// openRemoteStream creates a new stream opened by the remote side. If the
+// stream is refused, the remote side is sent a RST and nil is returned.
+func (s *session) openRemoteStream(id frame.StreamId, fin bool, compressed bool, stype StreamType, md map[string]string) (streamPrivate, error) {
+	if s.isLocal(id) {
+		err := fmt.Errorf("initiated stream id has wrong parity for remote endpoint: 0x%x", id)
+		return nil, newErr(ProtocolError, err)
+	}
+	if s.getStream(id) != nil {
+		return nil, newErr(ProtocolError, fmt.Errorf("initiated stream id is already open: 0x%x", id))
+	}
+	if lastId := atomic.LoadUint32(&s.remote.lastId); uint32(id) <= lastId {
+		if err := s.deviation("initiated stream id 0x%x is not greater than the last one 0x%x", id, lastId); err != nil {
+			return nil, err
+		}
+	}
+
+	// let the application refuse the stream
+	if s.config.OnIncomingStream != nil {
+		if accept, code := s.config.OnIncomingStream(uint32(id), stype, md); !accept {
+			return nil, s.refuseStream(id, code)
+		}
+	}
+	if !s.authorize(id, stype, md) {
+		return nil, nil
+	}
+
+	s.goAwayMu.Lock()
+
+	// if we're going away, refuse new streams
+	if atomic.LoadUint32(&s.local.goneAway) == 1 {
+		s.goAwayMu.Unlock()
+		return nil, s.refuseStream(id, StreamRefused)
+	}
+
+	// refuse streams over the concurrent stream limit
+	if !s.remote.reserveStream(s.config.MaxStreams) {
+		s.goAwayMu.Unlock()
+		return nil, s.refuseStream(id, RefusedLimit)
+	}
 
 	// make the new stream
-	str := s.config.newStream(s, f.StreamId(), s.config.MaxWindowSize, f.Fin(), false)
+	str := s.config.newStream(s, id, s.config.MaxWindowSize, fin, false)
+	if compressed {
+		str.setCompressed()
+	}
+	if md != nil {
+		str.setMetadata(md)
+	}
 
 	// add it to the stream map
-	s.streams.Set(f.StreamId(), str)
+	s.streams.Set(id, str)
 
-	// put the new stream on the accept channel
-	var retry bool
-RETRY:
+	// update last remote id
+	if uint32(id) > atomic.LoadUint32(&s.remote.lastId) {
+		atomic.StoreUint32(&s.remote.lastId, uint32(id))
+	}
+	s.goAwayMu.Unlock()
+
+	if s.config.OnStreamOpen != nil {
+		s.config.OnStreamOpen(str)
+	}
+	return str, nil
+}
+
+// queueAccept puts a new stream on the accept channel, applying the configured
+// AcceptQueuePolicy if the channel is full. It returns false if the stream was
+// not queued or will be acknowledged once it is, see holdAccept.
+func (s *session) queueAccept(str streamPrivate) bool {
+	if s.config.AcceptQueuePolicy != AcceptQueueDropOldest {
+		return s.holdAccept(str)
+	}
+	for {
+		select {
+		case s.accept <- str:
+			return true
+		default:
+		}
+		select {
+		case old := <-s.accept:
+			old.resetWith(AcceptQueueFull, ErrAcceptQueueFull)
+		default:
+		}
+	}
+}
+
+// heldStream is a new stream waiting for room on the accept channel
+type heldStream struct {
+	str   streamPrivate
+	timer *time.Timer // resets the stream after AcceptQueueTimeout, nil under AcceptQueueBlock
+}
+
+// holdAccept holds a new stream until there is room for it on the accept
+// channel so that the reader keeps handling frames of the other streams.
+// Under AcceptQueueReset the stream is reset once AcceptQueueTimeout passes.
+// Under AcceptQueueBlock its window updates are withheld until it is queued.
+// Held streams are acknowledged once they are queued, see Config.SyncOpen.
+// Once AcceptBacklog streams are held, new ones are reset with
+// AcceptQueueFull.
+func (s *session) holdAccept(str streamPrivate) bool {
+	s.heldMu.Lock()
+	full := uint32(len(s.held)) >= s.config.AcceptBacklog
+	if !full {
+		h := heldStream{str: str}
+		if s.config.AcceptQueuePolicy == AcceptQueueBlock {
+			str.Pause()
+		} else {
+			h.timer = time.AfterFunc(s.config.AcceptQueueTimeout, func() { s.expireHeld(str) })
+		}
+		s.held = append(s.held, h)
+		s.admitHeldLocked()
+	}
+	s.heldMu.Unlock()
+	if full {
+		str.resetWith(AcceptQueueFull, ErrAcceptQueueFull)
+	}
+	return false
+}
+
+// expireHeld resets a held stream that found no room within AcceptQueueTimeout
+func (s *session) expireHeld(str streamPrivate) {
+	s.heldMu.Lock()
+	found := false
+	for i := range s.held {
+		if s.held[i].str == str {
+			s.held = append(s.held[:i], s.held[i+1:]...)
+			found = true
+			break
+		}
+	}
+	s.heldMu.Unlock()
+	if found {
+		str.resetWith(AcceptQueueFull, ErrAcceptQueueFull)
+	}
+}
+
+// admitHeld moves held streams onto the accept channel as room frees up
+func (s *session) admitHeld() {
+	s.heldMu.Lock()
+	s.admitHeldLocked()
+	s.heldMu.Unlock()
+}
+
+func (s *session) admitHeldLocked() {
+	for len(s.held) > 0 && !s.acceptClosed {
+		h := s.held[0]
+		select {
+		case s.accept <- h.str:
+			if h.timer != nil {
+				h.timer.Stop()
+			} else {
+				h.str.Resume()
+			}
+			s.ackStream(h.str)
+			s.held[0] = heldStream{}
+			s.held = s.held[1:]
+		default:
+			return
+		}
+	}
+}
+
+// closeAccept closes the accept channel once the reader stops. Streams still
+// held are closed with the session.
+func (s *session) closeAccept() {
+	s.heldMu.Lock()
+	s.acceptClosed = true
+	for _, h := range s.held {
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+	}
+	s.held = nil
+	close(s.accept)
+	s.heldMu.Unlock()
+}
+
+// ackStream acknowledges a stream opened by the remote side once it was
+// queued to be accepted if the remote side asked for it, see Config.SyncOpen
+func (s *session) ackStream(str streamPrivate) {
+	if atomic.LoadUint32(&s.remote.acks) == 0 {
+		return
+	}
+	f := new(frame.WndInc)
+	if err := f.PackAck(frame.StreamId(str.Id()), 0); err != nil {
+		s.die(newErr(InternalError, fmt.Errorf("failed to pack WNDINC frame: %v", err)))
+		return
+	}
+	s.writeFrameAsync(f)
+}
+
+// awaitAck waits until the remote side acknowledges a stream opened with
+// Config.SyncOpen. Remote sides that speak protocol versions before 3 don't
+// acknowledge streams, so the wait ends once the negotiated version turns out
+// to be older.
+func (s *session) awaitAck(str streamPrivate) error {
+	cancel := s.openDeadline.wait()
+	acked, err := str.awaitAck(s.negotiated, cancel)
+	if acked || err != nil || s.ProtocolVersion() < streamAcksVersion {
+		return err
+	}
+	_, err = str.awaitAck(nil, cancel)
+	return err
+}
+
+// awaitUnidirectional waits for the protocol version to be negotiated and
+// fails if the remote side can't accept unidirectional streams
+func (s *session) awaitUnidirectional() error {
 	select {
-	case s.accept <- str:
-	default:
-		// The accept channel is full.
-		//
-		// The Go scheduler can put you into a place where there are goroutines
-		// waiting to accept from the full channel but this Goroutine is hogging
-		// the CPU and would continuously read new streams and throw them away
-		// We tell the runtime to sleep this goroutine for a short amount of time
-		// in order to let other goroutines run.
-		//
-		// The use of time.Sleep + goto instead of using time.After() in the select
-		// statement is to avoid a memory alloc in the hot path
-		if !retry {
-			retry = true
-			time.Sleep(time.Millisecond)
-			goto RETRY
-		}
-		// accept queue is full
-		rstF := new(frame.Rst)
-		if err := rstF.Pack(f.StreamId(), frame.ErrorCode(AcceptQueueFull)); err != nil {
-			return newErr(InternalError, fmt.Errorf("failed to pack accept overflow RST: %v", err))
-		}
-		s.writeFrameAsync(rstF)
-		// XXX close the stream!
+	case <-s.negotiated:
+	case <-s.dead:
+		return s.dieErr
+	case <-s.openDeadline.wait():
+		return ErrOpenTimeout
+	}
+	if s.ProtocolVersion() < unidirectionalVersion {
+		return ErrUnidirectionalUnsupported
 	}
+	return nil
+}
 
-	// handle the stream data
-	return str.handleStreamData(f)
+// enforceBufferBudget resets the stream with the most unread data if the total
+// buffered across all streams exceeds the session's budget
+func (s *session) enforceBufferBudget() {
+	max := int64(s.config.MaxSessionBuffer)
+	if max == 0 || atomic.LoadInt64(&s.buffered) <= max {
+		return
+	}
+	var heaviest streamPrivate
+	var most int
+	s.streams.Each(func(id frame.StreamId, str streamPrivate) {
+		if n := str.buffered(); n > most {
+			heaviest, most = str, n
+		}
+	})
+	if heaviest != nil {
+		heaviest.resetWith(EnhanceYourCalm, ErrBufferBudgetExceeded)
+	}
+}
+
+// refuseStream rejects a new stream by resetting it with the given error code
+func (s *session) refuseStream(id frame.StreamId, errCode ErrorCode) error {
+	rstF := new(frame.Rst)
+	if err := rstF.Pack(id, frame.ErrorCode(errCode)); err != nil {
+		return newErr(InternalError, fmt.Errorf("failed to pack stream refused RST: %v", err))
+	}
+	s.writeFrameAsync(rstF)
+	return nil
 }
 
 func (s *session) getStream(id frame.StreamId) streamPrivate {
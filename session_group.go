@@ -0,0 +1,539 @@
+package muxado
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// Balance is the policy by which a SessionGroup chooses the session that
+// opens a stream
+type Balance int
+
+const (
+	// LeastStreams opens each stream on the session with the fewest open
+	// streams
+	LeastStreams Balance = iota
+	// RoundRobin opens streams on each session in turn
+	RoundRobin
+)
+
+// GroupConfig configures a SessionGroup.
+type GroupConfig struct {
+	// How streams are spread across the sessions. Default LeastStreams.
+	Balance Balance
+	// Dials a session to the group's peer to replace each session that
+	// dies or is found unhealthy. Failed dials are retried with the next
+	// health check. Default nil (sessions are not replaced).
+	Dial func() (Session, error)
+	// Interval at which each session is sent a PING to probe its health.
+	// Default 0 (sessions are not probed).
+	HealthCheckInterval time.Duration
+	// Round trip time of a probe above which a session is unhealthy. A probe
+	// that is not answered within it fails. Default 1 second.
+	MaxRTT time.Duration
+	// Number of consecutive failures to open a stream on a session after
+	// which it is unhealthy. Failures because of the open deadline or
+	// because the session has Config.MaxStreams streams open do not count.
+	// Default 0 (failures are not counted).
+	MaxOpenFailures int
+	// Called when a session is found unhealthy, with the reason. Default
+	// nil.
+	OnUnhealthy func(sess Session, reason error)
+	// Opens the group's streams, failing fast with ErrBreakerOpen while the
+	// peer refuses them. Default nil.
+	Breaker *Breaker
+}
+
+// SessionGroup is a Session made of many sessions to the same peer, for
+// applications that need more throughput than a single transport
+// connection delivers. It opens streams on its sessions according to its
+// Balance and accepts the streams opened on any of them. Sessions that die
+// leave the group; the group dies once it has no sessions left.
+//
+// A group can check the health of its sessions, see GroupConfig. Streams
+// are no longer opened on a session found unhealthy; it is sent a GOAWAY,
+// closed once its remaining streams have finished and replaced by a new one
+// if the group can dial them.
+//
+// Methods describing a single session, like LocalAddr, PathStats and
+// ProtocolVersion, describe the group's first session. WriteUserFrame sends
+// the frame on it.
+type SessionGroup struct {
+	config GroupConfig
+
+	mu           sync.Mutex
+	sessions     []Session
+	next         int // next session of RoundRobin
+	openDeadline time.Time
+	tags         map[string]string
+	failures     map[Session]int // consecutive failures to open a stream
+	missing      int             // sessions that left and were not replaced
+	localErr     error           // errors of the last session to die
+	remoteErr    error
+	debug        []byte
+
+	acceptDeadline *deadline
+	accept         chan Stream
+	dead           chan struct{}
+	dieOnce        sync.Once
+}
+
+// NewSessionGroup returns a SessionGroup of the given sessions. More can be
+// added with Add.
+func NewSessionGroup(config *GroupConfig, sessions ...Session) *SessionGroup {
+	g := &SessionGroup{
+		acceptDeadline: newDeadline(),
+		accept:         make(chan Stream),
+		dead:           make(chan struct{}),
+		tags:           make(map[string]string),
+		failures:       make(map[Session]int),
+	}
+	if config != nil {
+		g.config = *config
+	}
+	if g.config.MaxRTT == 0 {
+		g.config.MaxRTT = time.Second
+	}
+	for _, sess := range sessions {
+		g.Add(sess)
+	}
+	if g.config.HealthCheckInterval > 0 {
+		go g.checkHealth()
+	}
+	return g
+}
+
+// Add adds a session to the group, tagging it with the group's tags. It is
+// closed if the group is dead.
+func (g *SessionGroup) Add(sess Session) {
+	g.mu.Lock()
+	if isClosed(g.dead) {
+		g.mu.Unlock()
+		sess.Close()
+		return
+	}
+	g.sessions = append(g.sessions, sess)
+	sess.SetOpenDeadline(g.openDeadline)
+	for k, v := range g.tags {
+		sess.SetTag(k, v)
+	}
+	g.mu.Unlock()
+	go g.acceptFrom(sess)
+}
+
+// Remove stops opening streams on a session of the group without closing
+// it, e.g. to drain it. Streams it accepts are still accepted by the group.
+func (g *SessionGroup) Remove(sess Session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(sess)
+}
+
+// removeLocked must be called with g.mu held. It reports whether sess was
+// in the group.
+func (g *SessionGroup) removeLocked(sess Session) bool {
+	delete(g.failures, sess)
+	for i, s := range g.sessions {
+		if s == sess {
+			g.sessions = append(g.sessions[:i:i], g.sessions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Sessions returns the sessions of the group that streams are opened on
+func (g *SessionGroup) Sessions() []Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Session(nil), g.sessions...)
+}
+
+// acceptFrom forwards the streams accepted by sess until it dies, and then
+// removes it from the group
+func (g *SessionGroup) acceptFrom(sess Session) {
+	for {
+		str, err := sess.AcceptStream()
+		if err != nil {
+			break
+		}
+		select {
+		case g.accept <- str:
+		case <-g.dead:
+			str.Close()
+			return
+		}
+	}
+	localErr, remoteErr, debug := sess.Wait()
+	g.mu.Lock()
+	removed := g.removeLocked(sess)
+	if removed {
+		g.missing++
+	}
+	g.mu.Unlock()
+	if !removed {
+		return
+	}
+	g.replenish()
+	g.mu.Lock()
+	last := len(g.sessions) == 0
+	if last {
+		g.localErr, g.remoteErr, g.debug = localErr, remoteErr, debug
+	}
+	g.mu.Unlock()
+	if last {
+		g.die()
+	}
+}
+
+func (g *SessionGroup) die() {
+	g.dieOnce.Do(func() { close(g.dead) })
+}
+
+// candidates returns the sessions in the order they should be tried for
+// opening a stream
+func (g *SessionGroup) candidates() []Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := len(g.sessions)
+	ret := make([]Session, 0, n)
+	switch g.config.Balance {
+	case RoundRobin:
+		for i := 0; i < n; i++ {
+			ret = append(ret, g.sessions[(g.next+i)%n])
+		}
+		g.next++
+	default:
+		ret = append(ret, g.sessions...)
+		counts := make(map[Session]int, n)
+		for _, sess := range ret {
+			counts[sess] = sess.NumStreams()
+		}
+		// stable, so that ties go to the older session
+		for i := 1; i < n; i++ {
+			for j := i; j > 0 && counts[ret[j]] < counts[ret[j-1]]; j-- {
+				ret[j], ret[j-1] = ret[j-1], ret[j]
+			}
+		}
+	}
+	return ret
+}
+
+// first returns the group's first session, or nil if it has none
+func (g *SessionGroup) first() Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.sessions) == 0 {
+		return nil
+	}
+	return g.sessions[0]
+}
+
+func (g *SessionGroup) Open() (net.Conn, error) {
+	return g.OpenStream()
+}
+
+func (g *SessionGroup) OpenStream(opts ...StreamOption) (Stream, error) {
+	return g.OpenStreamWithData(nil, opts...)
+}
+
+// OpenStreamWithData opens a stream on the session chosen by the group's
+// Balance. If that session cannot open streams, e.g. because it is going
+// away or has reached its stream limit, the others are tried in turn.
+func (g *SessionGroup) OpenStreamWithData(p []byte, opts ...StreamOption) (Stream, error) {
+	if g.config.Breaker != nil {
+		return g.config.Breaker.open(func() (Stream, error) { return g.openStream(p, opts) })
+	}
+	return g.openStream(p, opts)
+}
+
+// openStream opens a stream on the first candidate session that can open it
+func (g *SessionGroup) openStream(p []byte, opts []StreamOption) (Stream, error) {
+	err := ErrSessionClosed
+	for _, sess := range g.candidates() {
+		var str Stream
+		str, err = sess.OpenStreamWithData(p, opts...)
+		g.opened(sess, err)
+		if err == nil {
+			return str, nil
+		}
+		if err == ErrOpenTimeout {
+			break
+		}
+	}
+	return nil, err
+}
+
+func (g *SessionGroup) AcceptStream() (Stream, error) {
+	select {
+	case str := <-g.accept:
+		return str, nil
+	case <-g.acceptDeadline.wait():
+		return nil, ErrAcceptTimeout
+	case <-g.dead:
+		return nil, g.Err()
+	}
+}
+
+func (g *SessionGroup) Accept() (net.Conn, error) {
+	return g.AcceptStream()
+}
+
+// Close closes all sessions of the group
+func (g *SessionGroup) Close() error {
+	for _, sess := range g.Sessions() {
+		sess.Close()
+	}
+	g.closed(ErrSessionClosed)
+	return nil
+}
+
+// CloseWithError closes all sessions of the group with the given error code
+// and debug data
+func (g *SessionGroup) CloseWithError(errCode ErrorCode, debug []byte) error {
+	for _, sess := range g.Sessions() {
+		sess.CloseWithError(errCode, debug)
+	}
+	g.closed(newErr(errCode, goAwayError(debug)))
+	return nil
+}
+
+// closed kills the group unless its last session already did
+func (g *SessionGroup) closed(err error) {
+	g.mu.Lock()
+	if !isClosed(g.dead) && g.localErr == nil {
+		g.localErr = err
+	}
+	g.mu.Unlock()
+	g.die()
+}
+
+// GoAway sends a GOAWAY on all sessions of the group and returns the first
+// error doing so
+func (g *SessionGroup) GoAway(errCode ErrorCode, debug []byte, dl time.Time) (err error) {
+	for _, sess := range g.Sessions() {
+		if gerr := sess.GoAway(errCode, debug, dl); gerr != nil && err == nil {
+			err = gerr
+		}
+	}
+	return
+}
+
+// Drained returns a channel that is closed once all sessions the group had
+// when it was called are drained
+func (g *SessionGroup) Drained() <-chan struct{} {
+	sessions := g.Sessions()
+	drained := make(chan struct{})
+	go func() {
+		for _, sess := range sessions {
+			<-sess.Drained()
+		}
+		close(drained)
+	}()
+	return drained
+}
+
+func (g *SessionGroup) SetDeadline(t time.Time) error {
+	g.SetAcceptDeadline(t)
+	return g.SetOpenDeadline(t)
+}
+
+func (g *SessionGroup) SetAcceptDeadline(t time.Time) error {
+	g.acceptDeadline.set(t)
+	return nil
+}
+
+// SetOpenDeadline sets the open deadline of all sessions of the group,
+// including those added later
+func (g *SessionGroup) SetOpenDeadline(t time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.openDeadline = t
+	for _, sess := range g.sessions {
+		sess.SetOpenDeadline(t)
+	}
+	return nil
+}
+
+func (g *SessionGroup) LocalAddr() net.Addr {
+	if sess := g.first(); sess != nil {
+		return sess.LocalAddr()
+	}
+	return nil
+}
+
+func (g *SessionGroup) RemoteAddr() net.Addr {
+	if sess := g.first(); sess != nil {
+		return sess.RemoteAddr()
+	}
+	return nil
+}
+
+func (g *SessionGroup) Addr() net.Addr {
+	return g.LocalAddr()
+}
+
+// Wait blocks until the group dies. It returns the errors of the group's
+// last session, or the error it was closed with.
+func (g *SessionGroup) Wait() (error, error, []byte) {
+	<-g.dead
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.localErr, g.remoteErr, g.debug
+}
+
+func (g *SessionGroup) Done() <-chan struct{} {
+	return g.dead
+}
+
+func (g *SessionGroup) IsClosed() bool {
+	return isClosed(g.dead)
+}
+
+func (g *SessionGroup) CloseReason() (error, error, []byte) {
+	if !isClosed(g.dead) {
+		return nil, nil, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.localErr, g.remoteErr, g.debug
+}
+
+func (g *SessionGroup) Err() error {
+	select {
+	case <-g.dead:
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.localErr
+	default:
+		return nil
+	}
+}
+
+// Streams returns the streams of all sessions of the group. Their ids are
+// only unique within their session.
+func (g *SessionGroup) Streams() []StreamInfo {
+	var ret []StreamInfo
+	for _, sess := range g.Sessions() {
+		ret = append(ret, sess.Streams()...)
+	}
+	return ret
+}
+
+// NumStreams returns the number of open streams of all sessions of the group
+func (g *SessionGroup) NumStreams() int {
+	n := 0
+	for _, sess := range g.Sessions() {
+		n += sess.NumStreams()
+	}
+	return n
+}
+
+// NumOpened returns the number of open streams the group's sessions opened
+func (g *SessionGroup) NumOpened() int {
+	n := 0
+	for _, sess := range g.Sessions() {
+		n += sess.NumOpened()
+	}
+	return n
+}
+
+// NumAccepted returns the number of open streams the remote sides opened on
+// the group's sessions
+func (g *SessionGroup) NumAccepted() int {
+	n := 0
+	for _, sess := range g.Sessions() {
+		n += sess.NumAccepted()
+	}
+	return n
+}
+
+func (g *SessionGroup) TransportOptions() TransportOptions {
+	if sess := g.first(); sess != nil {
+		return sess.TransportOptions()
+	}
+	return transportOptions{}
+}
+
+func (g *SessionGroup) PathStats() PathStats {
+	if sess := g.first(); sess != nil {
+		return sess.PathStats()
+	}
+	return PathStats{}
+}
+
+func (g *SessionGroup) ProtocolVersion() uint16 {
+	if sess := g.first(); sess != nil {
+		return sess.ProtocolVersion()
+	}
+	return 0
+}
+
+func (g *SessionGroup) AuthIdentity() string {
+	if sess := g.first(); sess != nil {
+		return sess.AuthIdentity()
+	}
+	return ""
+}
+
+func (g *SessionGroup) ConnectionState() (tls.ConnectionState, bool) {
+	if sess := g.first(); sess != nil {
+		return sess.ConnectionState()
+	}
+	return tls.ConnectionState{}, false
+}
+
+func (g *SessionGroup) PeerCertificates() []*x509.Certificate {
+	if sess := g.first(); sess != nil {
+		return sess.PeerCertificates()
+	}
+	return nil
+}
+
+// State returns StateDead once the group has died, otherwise the most open
+// state of its sessions
+func (g *SessionGroup) State() SessionState {
+	state := StateDead
+	if isClosed(g.dead) {
+		return state
+	}
+	for _, sess := range g.Sessions() {
+		if s := sess.State(); s < state {
+			state = s
+		}
+	}
+	return state
+}
+
+func (g *SessionGroup) WriteUserFrame(ftype frame.Type, flags frame.Flags, streamId uint32, data []byte) error {
+	if sess := g.first(); sess != nil {
+		return sess.WriteUserFrame(ftype, flags, streamId, data)
+	}
+	return ErrSessionClosed
+}
+
+// Tags returns the tags set on the group with SetTag
+func (g *SessionGroup) Tags() map[string]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ret := make(map[string]string, len(g.tags))
+	for k, v := range g.tags {
+		ret[k] = v
+	}
+	return ret
+}
+
+// SetTag tags the group and all of its sessions
+func (g *SessionGroup) SetTag(key, value string) {
+	g.mu.Lock()
+	g.tags[key] = value
+	g.mu.Unlock()
+	for _, sess := range g.Sessions() {
+		sess.SetTag(key, value)
+	}
+}
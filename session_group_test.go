@@ -0,0 +1,102 @@
+package muxado
+
+import (
+	"testing"
+	"time"
+)
+
+// newGroupPair returns a group of n client sessions and their servers
+func newGroupPair(t *testing.T, config *GroupConfig, n int) (*SessionGroup, []Session) {
+	t.Helper()
+	g := NewSessionGroup(config)
+	var servers []Session
+	for i := 0; i < n; i++ {
+		client, server := newSessionPair(nil, nil)
+		g.Add(client)
+		servers = append(servers, server)
+		t.Cleanup(func() { server.Close() })
+	}
+	t.Cleanup(func() { g.Close() })
+	return g, servers
+}
+
+func TestSessionGroupRoundRobin(t *testing.T) {
+	t.Parallel()
+	g, _ := newGroupPair(t, &GroupConfig{Balance: RoundRobin}, 2)
+	sessions := g.Sessions()
+	for i := 0; i < 4; i++ {
+		str, err := g.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if str.Session() != sessions[i%2] {
+			t.Fatalf("Stream %d was opened on session %v, expected %v", i, str.Session(), sessions[i%2])
+		}
+	}
+}
+
+func TestSessionGroupLeastStreams(t *testing.T) {
+	t.Parallel()
+	g, _ := newGroupPair(t, nil, 2)
+	sessions := g.Sessions()
+	if _, err := sessions[0].OpenStream(); err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str, err := g.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if str.Session() != sessions[1] {
+		t.Fatalf("Stream was not opened on the session with fewer streams")
+	}
+}
+
+// Test that the group accepts the streams of all of its sessions and
+// survives the death of all but its last session
+func TestSessionGroupAccept(t *testing.T) {
+	t.Parallel()
+	g, servers := newGroupPair(t, nil, 2)
+	for _, server := range servers {
+		str, err := server.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		str.Write([]byte("hello"))
+	}
+	seen := make(map[Session]bool)
+	for range servers {
+		str, err := g.AcceptStream()
+		if err != nil {
+			t.Fatalf("Failed to accept stream: %v", err)
+		}
+		seen[str.Session()] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("Accepted streams from %d sessions, expected 2", len(seen))
+	}
+
+	servers[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(g.Sessions()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Dead session was not removed from the group")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if str, err := g.OpenStream(); err != nil || str.Session() != g.Sessions()[0] {
+		t.Fatalf("Failed to open stream on the remaining session: %v", err)
+	}
+
+	servers[1].Close()
+	select {
+	case <-g.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Group did not die with its last session")
+	}
+	if _, err := g.AcceptStream(); err == nil {
+		t.Fatalf("Accepted stream on dead group")
+	}
+	if g.State() != StateDead {
+		t.Fatalf("Dead group is %v", g.State())
+	}
+}
@@ -1,11 +1,19 @@
 package muxado
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"os"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -21,21 +29,59 @@ type fakeStream struct {
 	streamId frame.StreamId
 }
 
-func (s *fakeStream) Write([]byte) (int, error)              { return 0, nil }
-func (s *fakeStream) Read([]byte) (int, error)               { return 0, nil }
-func (s *fakeStream) Close() error                           { return nil }
-func (s *fakeStream) SetDeadline(time.Time) error            { return nil }
-func (s *fakeStream) SetReadDeadline(time.Time) error        { return nil }
-func (s *fakeStream) SetWriteDeadline(time.Time) error       { return nil }
-func (s *fakeStream) CloseWrite() error                      { return nil }
-func (s *fakeStream) Id() uint32                             { return uint32(s.streamId) }
-func (s *fakeStream) Session() Session                       { return s.sess }
-func (s *fakeStream) RemoteAddr() net.Addr                   { return nil }
-func (s *fakeStream) LocalAddr() net.Addr                    { return nil }
-func (s *fakeStream) handleStreamData(*frame.Data) error     { return nil }
-func (s *fakeStream) handleStreamWndInc(*frame.WndInc) error { return nil }
-func (s *fakeStream) handleStreamRst(*frame.Rst) error       { return nil }
-func (s *fakeStream) closeWith(error)                        {}
+func (s *fakeStream) Write([]byte) (int, error)                      { return 0, nil }
+func (s *fakeStream) Read([]byte) (int, error)                       { return 0, nil }
+func (s *fakeStream) Close() error                                   { return nil }
+func (s *fakeStream) CloseWithError(ErrorCode, []byte) error         { return nil }
+func (s *fakeStream) SetDeadline(time.Time) error                    { return nil }
+func (s *fakeStream) SetReadDeadline(time.Time) error                { return nil }
+func (s *fakeStream) SetWriteDeadline(time.Time) error               { return nil }
+func (s *fakeStream) CloseWrite() error                              { return nil }
+func (s *fakeStream) SetRateLimit(int)                               {}
+func (s *fakeStream) Id() uint32                                     { return uint32(s.streamId) }
+func (s *fakeStream) Session() Session                               { return s.sess }
+func (s *fakeStream) RemoteAddr() net.Addr                           { return nil }
+func (s *fakeStream) LocalAddr() net.Addr                            { return nil }
+func (s *fakeStream) handleStreamData(*frame.Data) error             { return nil }
+func (s *fakeStream) handleStreamWndInc(*frame.WndInc) error         { return nil }
+func (s *fakeStream) handleStreamRst(*frame.Rst) error               { return nil }
+func (s *fakeStream) closeWith(error)                                {}
+func (s *fakeStream) resetWith(ErrorCode, error)                     {}
+func (s *fakeStream) buffered() int                                  { return 0 }
+func (s *fakeStream) compressed() bool                               { return false }
+func (s *fakeStream) setCompressed()                                 {}
+func (s *fakeStream) setMetadata(map[string]string)                  {}
+func (s *fakeStream) Metadata() map[string]string                    { return nil }
+func (s *fakeStream) CloseWriteWithTrailers(map[string]string) error { return nil }
+func (s *fakeStream) Trailers() map[string]string                    { return nil }
+func (s *fakeStream) handleStreamTrailers(*frame.Headers) error      { return nil }
+func (s *fakeStream) closeErr() error                                { return nil }
+func (s *fakeStream) Label() string                                  { return "" }
+func (s *fakeStream) SetLabel(string)                                {}
+func (s *fakeStream) info() StreamInfo                               { return StreamInfo{} }
+func (s *fakeStream) setStreamType(StreamType)                       {}
+func (s *fakeStream) flushWrites() error                             { return nil }
+func (s *fakeStream) idleSince() time.Time                           { return time.Time{} }
+func (s *fakeStream) receiveBlocked() bool                           { return false }
+func (s *fakeStream) windowLimit() uint32                            { return 0 }
+func (s *fakeStream) shrinkWindow(uint32)                            {}
+func (s *fakeStream) shrinkIdleWindow(uint32)                        {}
+func (s *fakeStream) SetNoDelay(bool)                                {}
+func (s *fakeStream) Pause()                                         {}
+func (s *fakeStream) setUnidirectional(bool)                         {}
+func (s *fakeStream) Resume()                                        {}
+func (s *fakeStream) SetPriority(Priority) error                     { return nil }
+func (s *fakeStream) Priority() Priority                             { return Priority{} }
+func (s *fakeStream) awaitAck(_, _ <-chan struct{}) (bool, error)    { return true, nil }
+func (s *fakeStream) open() error                                    { return nil }
+
+func (s *fakeStream) ReadContext(context.Context, []byte) (int, error) {
+	return 0, nil
+}
+
+func (s *fakeStream) WriteContext(context.Context, []byte) (int, error) {
+	return 0, nil
+}
 
 type fakeConn struct {
 	in     *io.PipeReader
@@ -60,6 +106,19 @@ func newFakeConnPair() (local *fakeConn, remote *fakeConn) {
 	return
 }
 
+// skipSettings reads the SETTINGS frame that sessions send first from the
+// remote end of their transport
+func skipSettings(t *testing.T, fr frame.Framer) {
+	t.Helper()
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read SETTINGS: %v", err)
+	}
+	if f.Type() != frame.TypeSettings {
+		t.Fatalf("Wrong first frame. Got %v, expected %v", f.Type(), frame.TypeSettings)
+	}
+}
+
 var debugFramer = func(name string) func(io.Reader, io.Writer) frame.Framer {
 	return func(rd io.Reader, wr io.Writer) frame.Framer {
 		return frame.NewNamedDebugFramer(name, os.Stdout, frame.NewFramer(rd, wr))
@@ -227,3 +286,1544 @@ func TestWriteAfterClose(t *testing.T) {
 		t.Fatalf("remote session closed with error code: %v, expected NoError (debug: %s)", remoteCode, debug)
 	}
 }
+
+// gateConn blocks the first write until gate is closed and counts all writes
+type gateConn struct {
+	fakeConn
+	gate   chan struct{}
+	writes chan int
+}
+
+func (c *gateConn) Write(p []byte) (int, error) {
+	<-c.gate
+	c.writes <- len(p)
+	return len(p), nil
+}
+
+// Test that frames queued while the writer is busy are coalesced into
+// a single write on the transport
+func TestWriteBatching(t *testing.T) {
+	t.Parallel()
+	local, _ := newFakeConnPair()
+	conn := &gateConn{fakeConn: *local, gate: make(chan struct{}), writes: make(chan int, 16)}
+	s := Client(conn, &Config{newStream: newFakeStream}).(*session)
+	defer s.Close()
+
+	mkWndInc := func(id frame.StreamId) frame.Frame {
+		f := new(frame.WndInc)
+		f.Pack(id, 1)
+		return f
+	}
+
+	// the flush of the SETTINGS frame sent first blocks on the gate
+	time.Sleep(50 * time.Millisecond)
+
+	// the rest are queued behind it and should be written together
+	for i := 0; i < 10; i++ {
+		s.writeFrameAsync(mkWndInc(frame.StreamId(i + 3)))
+	}
+	close(conn.gate)
+
+	for _, expected := range []int{14, 120} {
+		select {
+		case n := <-conn.writes:
+			if n != expected {
+				t.Fatalf("Wrong write size. Got %d, expected %d", n, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for write")
+		}
+	}
+}
+
+// Test that a session advertises a non-default max frame size and honors the
+// one advertised by the remote side
+func TestMaxFrameSize(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	s := Server(local, &Config{MaxFrameSize: 1024})
+	defer s.Close()
+	fr := frame.NewFramer(remote, remote)
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	settings, ok := f.(*frame.Settings)
+	if !ok {
+		t.Fatalf("Wrong frame type. Got %v, expected %v", f.Type(), frame.TypeSettings)
+	}
+	if len(settings.Settings()) != 2 || settings.Settings()[1] != (frame.Setting{Id: frame.SettingMaxFrameSize, Value: 1024}) {
+		t.Fatalf("Wrong settings advertised: %v", settings.Settings())
+	}
+
+	fSettings := new(frame.Settings)
+	fSettings.Pack([]frame.Setting{{Id: frame.SettingMaxFrameSize, Value: 4}})
+	if err := fr.WriteFrame(fSettings); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
+	}
+	// give the session time to process the settings
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		str, err := s.OpenStream()
+		if err != nil {
+			t.Errorf("Failed to open stream: %v", err)
+			return
+		}
+		str.Write(make([]byte, 10))
+	}()
+
+	for _, expected := range []uint32{4, 4, 2} {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if f.Type() != frame.TypeData || f.Length() != expected {
+			t.Fatalf("Wrong frame. Got %v of length %d, expected DATA of length %d", f.Type(), f.Length(), expected)
+		}
+		io.Copy(ioutil.Discard, f.(*frame.Data).Reader())
+	}
+}
+
+// Test that DATA frames larger than the advertised MaxFrameSize fail the
+// session, whether or not its framer can be told the max length
+func TestMaxFrameSizeEnforced(t *testing.T) {
+	t.Parallel()
+	for name, newFramer := range map[string]func(io.Reader, io.Writer) frame.Framer{
+		"limited": frame.NewFramer,
+		"unlimited": func(r io.Reader, w io.Writer) frame.Framer {
+			return struct{ frame.Framer }{frame.NewFramer(r, w)}
+		},
+	} {
+		local, remote := newFakeConnPair()
+		remote.Discard()
+		s := Server(local, &Config{MaxFrameSize: 1024, NewFramer: newFramer})
+
+		f := new(frame.Data)
+		f.Pack(301, make([]byte, 1025), false, true)
+		frame.NewFramer(remote, remote).WriteFrame(f)
+
+		err, _, _ := s.Wait()
+		if code, _ := GetError(err); code != FrameSizeError {
+			t.Errorf("%s: session not terminated with frame size error. Got %d, expected %d. Session error: %v", name, code, FrameSizeError, err)
+		}
+	}
+}
+
+// Test that streams beyond the concurrent stream limit are refused
+func TestMaxStreams(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	s := Server(local, &Config{MaxStreams: 1, newStream: newFakeStream})
+	defer s.Close()
+	fr := frame.NewFramer(remote, remote)
+	skipSettings(t, fr)
+
+	// the refused stream's payload must be discarded
+	for _, id := range []frame.StreamId{3, 5} {
+		f := new(frame.Data)
+		f.Pack(id, make([]byte, id-3), false, true)
+		if err := fr.WriteFrame(f); err != nil {
+			t.Fatalf("Failed to write SYN: %v", err)
+		}
+	}
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	rst, ok := f.(*frame.Rst)
+	if !ok {
+		t.Fatalf("Wrong frame type. Got %v, expected %v", f.Type(), frame.TypeRst)
+	}
+	if rst.StreamId() != 5 || ErrorCode(rst.ErrorCode()) != RefusedLimit {
+		t.Fatalf("Wrong RST. Got stream %d code %d, expected stream 5 code %d", rst.StreamId(), rst.ErrorCode(), RefusedLimit)
+	}
+
+	if _, err := s.OpenStream(); err != nil {
+		t.Fatalf("Failed to open local stream: %v", err)
+	}
+	if _, err := s.OpenStream(); err == nil {
+		t.Fatalf("Expected error opening stream over the limit")
+	} else if code, _ := GetError(err); code != RefusedLimit {
+		t.Fatalf("Wrong error opening stream over the limit. Got %d, expected %d", code, RefusedLimit)
+	}
+}
+
+// Test that the session paces its writes to honor MaxEgressRate
+func TestMaxEgressRate(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{MaxEgressRate: 10000}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, str)
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	// the first 10000 bytes are an immediate burst, the next 5000 are paced
+	start := time.Now()
+	if _, err := str.Write(make([]byte, 15000)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("Write was not paced, took %v", elapsed)
+	}
+}
+
+// Test that a large write under MaxEgressRate is split into frames that
+// don't hold up the frames of other streams for more than about a second
+func TestMaxEgressRateFrameSize(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{MaxEgressRate: 10000}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		for {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, str)
+		}
+	}()
+
+	bulk, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go bulk.Write(make([]byte, 100000))
+	time.Sleep(50 * time.Millisecond)
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	start := time.Now()
+	if _, err := str.Write([]byte("hello")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("Write was held up behind the bulk stream for %v", elapsed)
+	}
+}
+
+// Test that the AcceptQueueReset policy resets a stream that found no room
+// in the accept queue within AcceptQueueTimeout without stalling the frames of
+// other streams while it waits
+func TestAcceptQueueReset(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{AcceptBacklog: 1, AcceptQueueTimeout: time.Second})
+	defer client.Close()
+	defer server.Close()
+
+	first, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	first.Write([]byte("hello"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+
+	var strs []Stream
+	for i := 0; i < 2; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		str.Write([]byte("hello"))
+		strs = append(strs, str)
+	}
+
+	start := time.Now()
+	first.Write([]byte("world"))
+	readString(t, accepted, "helloworld")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Data of an accepted stream was held up for %v", elapsed)
+	}
+
+	// the waiting stream is reset once the timeout passes
+	if _, err := strs[1].Read(make([]byte, 1)); !errors.Is(err, AcceptQueueFull) {
+		t.Fatalf("Expected AcceptQueueFull reading the waiting stream, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("Waiting stream was reset after %v", elapsed)
+	}
+	got, err := server.AcceptStream()
+	if err != nil || got.Id() != strs[0].Id() {
+		t.Fatalf("Accepted %v, %v, expected stream %d", got, err, strs[0].Id())
+	}
+}
+
+// Test that the AcceptQueueDropOldest policy resets the oldest queued stream
+// to make room for a new one
+func TestAcceptQueueDropOldest(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{AcceptBacklog: 1, AcceptQueuePolicy: AcceptQueueDropOldest})
+	defer client.Close()
+	defer server.Close()
+
+	var ids []uint32
+	for i := 0; i < 2; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte("hello")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		ids = append(ids, str.Id())
+	}
+
+	// give the session time to queue both streams
+	time.Sleep(50 * time.Millisecond)
+
+	str, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if str.Id() != ids[1] {
+		t.Fatalf("Wrong stream accepted. Got %d, expected %d", str.Id(), ids[1])
+	}
+}
+
+// Test that the AcceptQueueBlock policy holds streams beyond a full accept
+// queue without blocking the frames of accepted streams, and resets streams
+// once the held ones reach AcceptBacklog
+func TestAcceptQueueBlock(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{AcceptBacklog: 1, AcceptQueuePolicy: AcceptQueueBlock})
+	defer client.Close()
+	defer server.Close()
+
+	first, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	first.Write([]byte("hello"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+
+	var strs []Stream
+	for i := 0; i < 3; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte("hello")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		strs = append(strs, str)
+	}
+
+	// the accepted stream's data is still read while new streams wait
+	if _, err := first.Write([]byte("world")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(accepted, buf); err != nil {
+		t.Fatalf("Failed to read from accepted stream: %v", err)
+	}
+
+	// the held stream's window updates are withheld until it is queued
+	held := server.(*session).getStream(frame.StreamId(strs[1].Id())).(*stream)
+	if atomic.LoadUint32(&held.paused) != 1 {
+		t.Fatalf("Held stream is not paused")
+	}
+
+	// the stream beyond the held ones is reset
+	if _, err := strs[2].Read(buf); !errors.Is(err, AcceptQueueFull) {
+		t.Fatalf("Expected AcceptQueueFull reading the third stream, got: %v", err)
+	}
+
+	// the queued and held streams are accepted in order
+	for _, str := range strs[:2] {
+		got, err := server.AcceptStream()
+		if err != nil {
+			t.Fatalf("Failed to accept stream: %v", err)
+		}
+		if got.Id() != str.Id() {
+			t.Fatalf("Wrong stream accepted. Got %d, expected %d", got.Id(), str.Id())
+		}
+	}
+	if atomic.LoadUint32(&held.paused) != 0 {
+		t.Fatalf("Held stream is still paused after it was queued")
+	}
+}
+
+// Test that a session continues servicing accepted streams after GoAway,
+// refuses new ones and reports when it has drained
+func TestGoAwayDrain(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	cStr, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	cStr.Write([]byte("hello"))
+	sStr, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+
+	if err := server.GoAway(NoError, []byte("draining"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to send GOAWAY: %v", err)
+	}
+
+	// give the client time to process the GOAWAY
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.OpenStream(); err != ErrRemoteGoneAway {
+		t.Fatalf("Expected remote gone away error opening stream, got: %v", err)
+	}
+
+	// the accepted stream is still serviced
+	if _, err := sStr.Write([]byte("world")); err != nil {
+		t.Fatalf("Failed to write to accepted stream after GOAWAY: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(cStr, buf); err != nil || string(buf) != "world" {
+		t.Fatalf("Failed to read from stream after GOAWAY: %v", err)
+	}
+
+	select {
+	case <-server.Drained():
+		t.Fatalf("Session drained with a stream still open")
+	default:
+	}
+
+	sStr.Close()
+	select {
+	case <-server.Drained():
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for session to drain")
+	}
+}
+
+// Test that the error code and debug data passed to CloseWithError are
+// delivered to the remote side
+func TestCloseWithError(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+
+	if err := server.CloseWithError(EnhanceYourCalm, []byte("overloaded")); err != nil {
+		t.Fatalf("Failed to close session: %v", err)
+	}
+	if localErr, _, _ := server.Wait(); !errors.Is(localErr, EnhanceYourCalm) {
+		t.Fatalf("Wrong local error. Got %v, expected %v", localErr, EnhanceYourCalm)
+	}
+
+	_, remoteErr, debug := client.Wait()
+	if code, _ := GetError(remoteErr); code != EnhanceYourCalm {
+		t.Fatalf("Wrong remote error code. Got %v, expected %v", code, EnhanceYourCalm)
+	}
+	if string(debug) != "overloaded" {
+		t.Fatalf("Wrong remote debug data. Got %q, expected %q", debug, "overloaded")
+	}
+}
+
+func TestDoneErr(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+
+	if err := server.Err(); err != nil {
+		t.Fatalf("Expected no error from a running session, got %v", err)
+	}
+	select {
+	case <-server.Done():
+		t.Fatalf("Done closed before the session shutdown")
+	default:
+	}
+
+	server.Close()
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Done not closed after the session shutdown")
+	}
+	if err := server.Err(); err != ErrSessionClosed {
+		t.Fatalf("Wrong error. Got %v, expected %v", err, ErrSessionClosed)
+	}
+}
+
+func TestReadIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	local, remote := newFakeConnPair()
+	s := Server(local, &Config{ReadIdleTimeout: 50 * time.Millisecond})
+
+	// the remote side never replies to keepalive pings
+	remote.Discard()
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Session with a dead peer not closed after read idle timeout")
+	}
+	if err := s.Err(); err != ErrReadIdleTimeout {
+		t.Fatalf("Wrong error. Got %v, expected %v", err, ErrReadIdleTimeout)
+	}
+}
+
+func TestReadIdleTimeoutKeepalive(t *testing.T) {
+	t.Parallel()
+	config := &Config{ReadIdleTimeout: 50 * time.Millisecond}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	// an idle session with a live peer is kept open by pings
+	select {
+	case <-client.Done():
+		t.Fatalf("Idle session closed: %v", client.Err())
+	case <-server.Done():
+		t.Fatalf("Idle session closed: %v", server.Err())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSessionDeadlines(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// a blocked accept is woken by the deadline
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.SetAcceptDeadline(time.Now())
+	}()
+	if _, err := server.AcceptStream(); err != ErrAcceptTimeout {
+		t.Fatalf("Wrong accept error. Got %v, expected %v", err, ErrAcceptTimeout)
+	}
+
+	client.SetDeadline(time.Now().Add(-time.Second))
+	if _, err := client.OpenStream(); err != ErrOpenTimeout {
+		t.Fatalf("Wrong open error. Got %v, expected %v", err, ErrOpenTimeout)
+	}
+
+	// clearing the deadlines allows the session to be used again
+	client.SetDeadline(time.Time{})
+	server.SetAcceptDeadline(time.Time{})
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := str.Write([]byte("hi")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	t.Parallel()
+	config := &Config{Checksums: true}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	// wait until both sides have turned on checksums
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadUint32(&client.(*session).remote.checksums) == 0 ||
+		atomic.LoadUint32(&server.(*session).remote.checksums) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Checksums not negotiated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		str.Write([]byte("checked"))
+		str.CloseWrite()
+	}()
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	buf, err := ioutil.ReadAll(accepted)
+	if err != nil || string(buf) != "checked" {
+		t.Fatalf("Wrong data. Got %q, %v", buf, err)
+	}
+}
+
+// wrappedFramer passes frames through to the Framer it wraps
+type wrappedFramer struct {
+	frame.Framer
+}
+
+func (fr *wrappedFramer) Unwrap() frame.Framer { return fr.Framer }
+
+// Test that sessions find the optional interfaces of wrapped framers
+func TestWrappedFramer(t *testing.T) {
+	t.Parallel()
+	config := &Config{
+		Checksums: true,
+		NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+			return &wrappedFramer{frame.NewFramer(r, w)}
+		},
+	}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	if atomic.LoadUint32(&client.(*session).local.checksums) == 0 {
+		t.Fatalf("Checksums not enabled through wrapped framer")
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadUint32(&client.(*session).remote.checksums) == 0 ||
+		atomic.LoadUint32(&server.(*session).remote.checksums) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Checksums not negotiated through wrapped framers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if frs := frame.Unwrap(client.(*session).framer); len(frs) != 2 {
+		t.Fatalf("Unwrapped %d framers, expected 2", len(frs))
+	}
+}
+
+func TestOnIncomingStream(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{
+		OnIncomingStream: func(id uint32, stype StreamType, md map[string]string) (bool, ErrorCode) {
+			if md["user"] != "admin" {
+				return false, StreamRefused
+			}
+			return true, NoError
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	refused, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	refused.Write([]byte("hi"))
+	if _, err := refused.Read(make([]byte, 1)); !errors.Is(err, StreamRefused) {
+		t.Fatalf("Wrong error for refused stream. Got %v, expected %v", err, StreamRefused)
+	}
+
+	allowed, err := client.OpenStream(WithMetadata(map[string]string{"user": "admin"}))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	allowed.Write([]byte("hi"))
+	str, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if str.Id() != allowed.Id() {
+		t.Fatalf("Accepted wrong stream. Got %d, expected %d", str.Id(), allowed.Id())
+	}
+}
+
+// Test that OnIncomingStream can refuse streams by their type
+func TestOnIncomingStreamType(t *testing.T) {
+	t.Parallel()
+	const control, bulk = StreamType(1), StreamType(2)
+	client, server := newSessionPair(nil, &Config{
+		OnIncomingStream: func(id uint32, stype StreamType, md map[string]string) (bool, ErrorCode) {
+			if stype == bulk {
+				return false, StreamRefused
+			}
+			return true, NoError
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+
+	refused, err := typedClient.OpenTypedStream(bulk)
+	if err != nil {
+		t.Fatalf("Failed to open bulk stream: %v", err)
+	}
+	if _, err := refused.Read(make([]byte, 1)); !errors.Is(err, StreamRefused) {
+		t.Fatalf("Wrong error for refused stream. Got %v, expected %v", err, StreamRefused)
+	}
+
+	allowed, err := typedClient.OpenTypedStream(control)
+	if err != nil {
+		t.Fatalf("Failed to open control stream: %v", err)
+	}
+	str, err := typedServer.AcceptTypedStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if str.Id() != allowed.Id() || str.StreamType() != control {
+		t.Fatalf("Accepted stream %d of type %d, expected %d of type %d", str.Id(), str.StreamType(), allowed.Id(), control)
+	}
+}
+
+func TestStreamLifecycleCallbacks(t *testing.T) {
+	t.Parallel()
+	type event struct {
+		kind string
+		id   uint32
+		err  error
+		code ErrorCode
+	}
+	events := make(chan event, 16)
+	client, server := newSessionPair(&Config{
+		OnStreamOpen: func(str Stream) {
+			events <- event{kind: "open", id: str.Id()}
+		},
+		OnStreamClose: func(str Stream, err error) {
+			events <- event{kind: "close", id: str.Id(), err: err}
+		},
+		OnStreamReset: func(str Stream, code ErrorCode) {
+			events <- event{kind: "reset", id: str.Id(), code: code}
+		},
+	}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	next := func(kind string) event {
+		select {
+		case ev := <-events:
+			if ev.kind != kind {
+				t.Fatalf("Wrong event. Got %v, expected %s", ev, kind)
+			}
+			return ev
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s event", kind)
+		}
+		panic("unreachable")
+	}
+
+	// a stream closed cleanly by both sides
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if ev := next("open"); ev.id != str.Id() {
+		t.Fatalf("Wrong stream opened. Got %d, expected %d", ev.id, str.Id())
+	}
+	str.Write([]byte("hi"))
+	remote, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	remote.Close()
+	str.Close()
+	if ev := next("close"); ev.err != nil {
+		t.Fatalf("Expected no error for closed stream, got %v", ev.err)
+	}
+
+	// a stream reset by the remote side
+	str, err = client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	next("open")
+	str.Write([]byte("hi"))
+	remote, err = server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	remote.(streamPrivate).resetWith(StreamCancelled, ErrStreamClosed)
+	if ev := next("reset"); ev.code != StreamCancelled {
+		t.Fatalf("Wrong reset code. Got %v, expected %v", ev.code, StreamCancelled)
+	}
+	var resetErr *StreamResetError
+	if ev := next("close"); !errors.As(ev.err, &resetErr) || resetErr.Code != StreamCancelled {
+		t.Fatalf("Wrong close error. Got %v, expected a reset with code %v", ev.err, StreamCancelled)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+
+	labeled, err := client.OpenStream(WithLabel("db-sync"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	labeled.Write([]byte("hello"))
+	typed, err := typedClient.OpenTypedStream(7)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := typedServer.AcceptTypedStream(); err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	remote, err := typedServer.AcceptTypedStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+
+	infos := client.Streams()
+	if len(infos) != 2 {
+		t.Fatalf("Wrong number of streams. Got %d, expected 2", len(infos))
+	}
+	if info := infos[0]; info.Id != labeled.Id() || info.Label != "db-sync" || !info.Local || info.BytesWritten != 5 {
+		t.Fatalf("Wrong info for labeled stream: %+v", info)
+	}
+	// the window may already have been incremented by the remote side's read
+	if info := infos[0]; info.SendWindow < 0x40000-5 || info.SendWindow > 0x40000 {
+		t.Fatalf("Wrong send window. Got %d, expected at least %d", info.SendWindow, 0x40000-5)
+	}
+	if info := infos[1]; info.Id != typed.Id() || info.Type != 7 {
+		t.Fatalf("Wrong info for typed stream: %+v", info)
+	}
+
+	for _, info := range server.Streams() {
+		if info.Local {
+			t.Fatalf("Remote stream reported as local: %+v", info)
+		}
+		if info.Id == remote.Id() && info.Type != 7 {
+			t.Fatalf("Wrong type for accepted stream: %+v", info)
+		}
+	}
+}
+
+func TestGoroutineProfileLabels(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// the labels are applied once each goroutine starts running
+	id := strconv.FormatUint(client.(*session).id, 10)
+	for _, name := range []string{"reader", "writer"} {
+		want := fmt.Sprintf(`"muxado.goroutine":%q, "muxado.remote":"unknown", "muxado.session":%q`, name, id)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var buf bytes.Buffer
+			if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+				t.Fatalf("Failed to write goroutine profile: %v", err)
+			}
+			if strings.Contains(buf.String(), want) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Goroutine profile has no goroutine labeled %s", want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// Test that OpenStreamWithData sends the initial data in the frame that opens
+// the stream
+func TestOpenStreamWithData(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	s := Client(local, nil)
+	defer s.Close()
+	fr := frame.NewFramer(remote, remote)
+
+	go func() {
+		if _, err := s.OpenStreamWithData([]byte("hello")); err != nil {
+			t.Errorf("Failed to open stream: %v", err)
+		}
+	}()
+
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		data, ok := f.(*frame.Data)
+		if !ok {
+			continue
+		}
+		if !data.Syn() {
+			t.Fatalf("First DATA frame does not open the stream")
+		}
+		buf, err := ioutil.ReadAll(data.Reader())
+		if err != nil {
+			t.Fatalf("Failed to read DATA frame: %v", err)
+		}
+		if string(buf) != "hello" {
+			t.Fatalf("Wrong data on SYN frame. Got %q, expected %q", buf, "hello")
+		}
+		return
+	}
+}
+
+// Test that frames are read through a buffer of the configured size
+func TestReadBufferSize(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		size int
+		want int // size of the buffer, zero if reads are unbuffered
+	}{
+		{0, 0x8000},
+		{1 << 20, 1 << 20},
+		{-1, 0},
+	} {
+		local, _ := newFakeConnPair()
+		var rd io.Reader
+		s := Client(local, &Config{
+			ReadBufferSize: tc.size,
+			NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+				rd = r
+				return frame.NewFramer(r, w)
+			},
+		})
+		s.Close()
+
+		got := 0
+		if buf, ok := rd.(*bufio.Reader); ok {
+			got = buf.Size()
+		} else if rd != local {
+			t.Fatalf("Framer reads from %T, expected the transport", rd)
+		}
+		if got != tc.want {
+			t.Errorf("ReadBufferSize %d: got buffer of %d bytes, expected %d", tc.size, got, tc.want)
+		}
+	}
+}
+
+// maxDataFramer records the length of the largest DATA frame written
+type maxDataFramer struct {
+	frame.Framer
+	max uint32
+}
+
+func (fr *maxDataFramer) WriteFrame(f frame.Frame) error {
+	if f.Type() == frame.TypeData && f.Length() > atomic.LoadUint32(&fr.max) {
+		atomic.StoreUint32(&fr.max, f.Length())
+	}
+	return fr.Framer.WriteFrame(f)
+}
+
+// Test that DATA frames larger than frame.MaxLength are only sent to remote
+// sides which advertise support for extended lengths
+func TestExtendedFrameSize(t *testing.T) {
+	t.Parallel()
+	const size = 20 << 20
+	for _, tc := range []struct {
+		serverMax uint32
+		want      uint32
+	}{
+		{32 << 20, size},
+		{0, frame.MaxLength},
+	} {
+		fr := new(maxDataFramer)
+		client, server := newSessionPair(&Config{
+			MaxWindowSize: 32 << 20,
+			MaxFrameSize:  32 << 20,
+			NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+				fr.Framer = frame.NewFramer(r, w)
+				return fr
+			},
+		}, &Config{MaxWindowSize: 32 << 20, MaxFrameSize: tc.serverMax})
+
+		// wait for the server's settings, if it sends any
+		for tc.serverMax != 0 && atomic.LoadUint32(&client.(*session).remote.maxFrameSize) != tc.serverMax {
+			time.Sleep(time.Millisecond)
+		}
+
+		go func() {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			io.Copy(ioutil.Discard, str)
+		}()
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write(make([]byte, size)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if got := atomic.LoadUint32(&fr.max); got != tc.want {
+			t.Errorf("Server max frame size %d: largest DATA frame was %d bytes, expected %d", tc.serverMax, got, tc.want)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+// Test that Pacing spreads DATA frames out at the measured delivery rate
+func TestPacing(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{Pacing: true}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, str)
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	path := &client.(*session).path
+	path.mu.Lock()
+	path.stats.DeliveryRate = 100000
+	path.mu.Unlock()
+
+	// the first quantum is an immediate burst, the rest is paced at 125KB/s
+	start := time.Now()
+	if _, err := str.Write(make([]byte, pacingQuantum+50000)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Write was not paced, took %v", elapsed)
+	}
+}
+
+// wrappedStream counts the bytes written to the stream it wraps
+type wrappedStream struct {
+	Stream
+	local   bool
+	written int
+}
+
+func (s *wrappedStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.written += n
+	return n, err
+}
+
+func (s *wrappedStream) Unwrap() Stream {
+	return s.Stream
+}
+
+// Test that Config.WrapStream wraps the streams handed to the application
+func TestWrapStream(t *testing.T) {
+	t.Parallel()
+	config := &Config{
+		WrapStream: func(str Stream, local bool) Stream {
+			return &wrappedStream{Stream: str, local: local}
+		},
+	}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStreamWithData([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	wrapped, ok := str.(*wrappedStream)
+	if !ok || !wrapped.local {
+		t.Fatalf("Opened stream was not wrapped as local: %#v", str)
+	}
+	if wrapped.written != 5 {
+		t.Fatalf("Wrapper saw %d bytes of initial data, expected 5", wrapped.written)
+	}
+	if unwrapStream(str) == nil {
+		t.Fatalf("Failed to unwrap the stream")
+	}
+
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if wrapped, ok := accepted.(*wrappedStream); !ok || wrapped.local {
+		t.Fatalf("Accepted stream was not wrapped as remote: %#v", accepted)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(accepted, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Wrong data. Got %q, %v", buf, err)
+	}
+}
+
+// Test that strict sessions die on deviations from the protocol that lenient
+// sessions tolerate and report
+func TestValidationMode(t *testing.T) {
+	t.Parallel()
+	syn := func(id frame.StreamId) []byte {
+		f := new(frame.Data)
+		f.Pack(id, []byte{}, false, true)
+		b, _ := frame.Marshal(f)
+		return b
+	}
+	deviations := map[string][]byte{
+		"misordered ids": append(syn(5), syn(3)...),
+		"unknown type":   {0x0, 0x0, 0x1, 0xA0, 0, 0, 0, 0, 0xAA},
+		"unknown flags":  {0x0, 0x0, 0x8, byte(frame.TypePing<<4) | 0x2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x1},
+	}
+	for name, b := range deviations {
+		local, remote := newFakeConnPair()
+		remote.Discard()
+		s := Server(local, &Config{Validation: ValidationStrict})
+		remote.Write(b)
+		err, _, _ := s.Wait()
+		if code, _ := GetError(err); code != ProtocolError {
+			t.Errorf("Strict session did not die with a protocol error on %s: %v", name, err)
+		}
+
+		local, remote = newFakeConnPair()
+		remote.Discard()
+		reported := make(chan error, 2)
+		s = Server(local, &Config{OnProtocolDeviation: func(err error) { reported <- err }})
+		remote.Write(b)
+		select {
+		case err := <-reported:
+			if code, _ := GetError(err); code != ProtocolError {
+				t.Errorf("Reported %v for %s, expected a protocol error", err, name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Lenient session did not report %s", name)
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("Lenient session died on %s: %v", name, err)
+		}
+		s.Close()
+	}
+}
+
+// Test that streams opened with SyncOpen are returned once the remote side
+// accepted them and that refusals are returned by OpenStream
+func TestSyncOpen(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{SyncOpen: true}, &Config{MaxStreams: 1})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if accepted.Id() != str.Id() {
+		t.Fatalf("Accepted stream 0x%x, expected 0x%x", accepted.Id(), str.Id())
+	}
+
+	_, err = client.OpenStream()
+	var resetErr *StreamResetError
+	if !errors.As(err, &resetErr) || resetErr.Code != RefusedLimit {
+		t.Fatalf("Opened stream over the remote limit, got %v, expected a %v reset", err, RefusedLimit)
+	}
+
+	// the first stream still works
+	str.Write([]byte("hi"))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(accepted, buf); err != nil || string(buf) != "hi" {
+		t.Fatalf("Read %q, %v, expected %q", buf, err, "hi")
+	}
+}
+
+// Test that the refusal of a stream opened with SyncOpen right after the
+// session is created, before the remote side's version is known, is returned
+func TestSyncOpenRefusedBeforeNegotiation(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{SyncOpen: true}, &Config{
+		OnIncomingStream: func(uint32, StreamType, map[string]string) (bool, ErrorCode) {
+			return false, StreamRefused
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.OpenStream()
+	var resetErr *StreamResetError
+	if !errors.As(err, &resetErr) || resetErr.Code != StreamRefused {
+		t.Fatalf("Opening a refused stream returned %v, expected a %v reset", err, StreamRefused)
+	}
+}
+
+// Test that SyncOpen doesn't wait for remote sides that don't acknowledge
+// streams
+func TestSyncOpenLegacyPeer(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{SyncOpen: true}, &Config{MaxProtocolVersion: streamAcksVersion - 1})
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.OpenStream()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OpenStream waited for an acknowledgment from a legacy peer")
+	}
+	if v := client.ProtocolVersion(); v != streamAcksVersion-1 {
+		t.Fatalf("Negotiated version %d, expected %d", v, streamAcksVersion-1)
+	}
+}
+
+// Test that SyncOpen waits for remote sides that never send a frame, like
+// version 1 peers without streams to open, until the open deadline
+func TestSyncOpenSilentPeer(t *testing.T) {
+	t.Parallel()
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	client := Client(local, &Config{SyncOpen: true})
+	defer client.Close()
+	client.SetOpenDeadline(time.Now().Add(100 * time.Millisecond))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.OpenStream()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrOpenTimeout {
+			t.Fatalf("Opening a stream failed with %v, expected %v", err, ErrOpenTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OpenStream waited past the open deadline for a silent peer")
+	}
+}
+
+// Test that WithWaitForSlot waits for a local stream to close when
+// MaxStreams are open
+func TestWaitForSlot(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{MaxStreams: 1}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.OpenStream(WithWaitForSlot(ctx)); err != context.DeadlineExceeded {
+		t.Fatalf("Waiting for a slot failed with %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	opened := make(chan error, 1)
+	go func() {
+		_, err := client.OpenStream(WithWaitForSlot(context.Background()))
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		t.Fatalf("Opened stream over the limit: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	str.Close()
+	select {
+	case err := <-opened:
+		if err != nil {
+			t.Fatalf("Failed to open stream after a slot freed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stream was not opened after a slot freed")
+	}
+}
+
+// Test that the stream counters follow streams opened and closed on either
+// side
+func TestNumStreams(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	check := func(sess Session, opened, accepted int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for sess.NumOpened() != opened || sess.NumAccepted() != accepted {
+			if time.Now().After(deadline) {
+				t.Fatalf("Counted %d opened and %d accepted streams, expected %d and %d",
+					sess.NumOpened(), sess.NumAccepted(), opened, accepted)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := sess.NumStreams(); n != opened+accepted {
+			t.Fatalf("Counted %d streams, expected %d", n, opened+accepted)
+		}
+	}
+	var strs []Stream
+	for i := 0; i < 3; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		str.Write([]byte("a"))
+		strs = append(strs, str)
+	}
+	check(client, 3, 0)
+	check(server, 0, 3)
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	accepted.Close()
+	strs[0].Close()
+	check(client, 2, 0)
+	check(server, 0, 2)
+}
+
+// heldConn is a fakeConn whose writes can be held back until released
+type heldConn struct {
+	*fakeConn
+	holding int32
+	held    chan struct{}
+	release chan struct{}
+}
+
+func (c *heldConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.holding) == 1 {
+		c.held <- struct{}{}
+		<-c.release
+	}
+	return c.fakeConn.Write(p)
+}
+
+// Test that writes fail fast with ErrWriteQueueFull while the write queue is
+// full and that the stream is usable afterwards
+func TestWriteQueueFailFast(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	conn := &heldConn{fakeConn: local, held: make(chan struct{}), release: make(chan struct{})}
+	client := Client(conn, &Config{WriteQueueDepth: 1, WriteQueuePolicy: WriteQueueFailFast})
+	server := Server(remote, nil)
+	defer client.Close()
+	defer server.Close()
+
+	var strs []Stream
+	for i := 0; i < 3; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte("a")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		strs = append(strs, str)
+	}
+
+	// hold the writer goroutine in a write and fill the queue behind it
+	written := make(chan error, 2)
+	atomic.StoreInt32(&conn.holding, 1)
+	go func() { _, err := strs[0].Write([]byte("b")); written <- err }()
+	<-conn.held
+	atomic.StoreInt32(&conn.holding, 0)
+	go func() { _, err := strs[1].Write([]byte("b")); written <- err }()
+	for len(client.(*session).writeFrames) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := strs[2].Write([]byte("b")); err != ErrWriteQueueFull {
+		t.Fatalf("Write to full queue got %v, expected %v", err, ErrWriteQueueFull)
+	}
+
+	close(conn.release)
+	for i := 0; i < 2; i++ {
+		if err := <-written; err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if _, err := strs[2].Write([]byte("c")); err != nil {
+		t.Fatalf("Failed to write after the queue drained: %v", err)
+	}
+	strs[2].CloseWrite()
+	for i := 0; i < 3; i++ {
+		accepted, err := server.AcceptStream()
+		if err != nil {
+			t.Fatalf("Failed to accept stream: %v", err)
+		}
+		if accepted.Id() == strs[2].Id() {
+			got, err := ioutil.ReadAll(accepted)
+			if err != nil || string(got) != "ac" {
+				t.Fatalf("Read %q, %v, expected %q", got, err, "ac")
+			}
+		}
+	}
+}
+
+// Test that control frames are written ahead of DATA frames queued before
+// them
+func TestControlFramesFirst(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	conn := &heldConn{fakeConn: local, held: make(chan struct{}), release: make(chan struct{})}
+	client := Client(conn, nil)
+	defer client.Close()
+	fr := frame.NewFramer(remote, remote)
+	skipSettings(t, fr)
+	types := make(chan frame.Type, 16)
+	go func() {
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if f.Type() == frame.TypeData {
+				io.Copy(ioutil.Discard, f.(*frame.Data).Reader())
+			}
+			types <- f.Type()
+		}
+	}()
+
+	var strs []Stream
+	for i := 0; i < 3; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte("a")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if typ := <-types; typ != frame.TypeData {
+			t.Fatalf("Read %v frame, expected %v", typ, frame.TypeData)
+		}
+		strs = append(strs, str)
+	}
+
+	// queue DATA frames behind a write the writer goroutine is held in, then
+	// a window update
+	atomic.StoreInt32(&conn.holding, 1)
+	go strs[0].Write([]byte("b"))
+	<-conn.held
+	atomic.StoreInt32(&conn.holding, 0)
+	go strs[1].Write([]byte("b"))
+	go strs[2].Write([]byte("b"))
+	sess := client.(*session)
+	for len(sess.writeFrames) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	wndinc := new(frame.WndInc)
+	if err := wndinc.Pack(frame.StreamId(strs[1].Id()), 1); err != nil {
+		t.Fatalf("Failed to pack WNDINC: %v", err)
+	}
+	sess.writeFrameAsync(wndinc)
+	close(conn.release)
+
+	want := []frame.Type{frame.TypeData, frame.TypeWndInc, frame.TypeData, frame.TypeData}
+	for i, typ := range want {
+		select {
+		case got := <-types:
+			if got != typ {
+				t.Fatalf("Frame %d is a %v frame, expected %v", i, got, typ)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for frame %d", i)
+		}
+	}
+}
+
+// Test that an RST for a stream whose opening frame is still queued behind
+// backlogged DATA is written after it
+func TestResetAfterQueuedOpen(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	conn := &heldConn{fakeConn: local, held: make(chan struct{}), release: make(chan struct{})}
+	client := Client(conn, nil)
+	defer client.Close()
+	fr := frame.NewFramer(remote, remote)
+	skipSettings(t, fr)
+	type written struct {
+		typ frame.Type
+		id  frame.StreamId
+	}
+	frames := make(chan written, 16)
+	go func() {
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if f.Type() == frame.TypeData {
+				io.Copy(ioutil.Discard, f.(*frame.Data).Reader())
+			}
+			frames <- written{f.Type(), f.StreamId()}
+		}
+	}()
+
+	var backlogged []Stream
+	for i := 0; i < 2; i++ {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write([]byte("a")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		<-frames
+		backlogged = append(backlogged, str)
+	}
+
+	// hold the writer goroutine in a write with more DATA queued behind it
+	atomic.StoreInt32(&conn.holding, 1)
+	go backlogged[0].Write([]byte("b"))
+	<-conn.held
+	atomic.StoreInt32(&conn.holding, 0)
+	go backlogged[1].Write([]byte("b"))
+	sess := client.(*session)
+	for len(sess.writeFrames) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the frame opening the stream is queued when its write times out
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := str.Write([]byte("c")); err != ErrWriteTimeout {
+		t.Fatalf("Write returned %v, expected %v", err, ErrWriteTimeout)
+	}
+	go str.CloseWithError(StreamCancelled, nil)
+	for len(sess.writeFrames)+len(sess.controls) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(conn.release)
+
+	var opened bool
+	for !opened {
+		select {
+		case f := <-frames:
+			if f.id != frame.StreamId(str.Id()) {
+				continue
+			}
+			if f.typ != frame.TypeData {
+				t.Fatalf("Read %v frame for the stream before the frame opening it", f.typ)
+			}
+			opened = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the frame opening the stream")
+		}
+	}
+	select {
+	case f := <-frames:
+		if f.typ != frame.TypeRst || f.id != frame.StreamId(str.Id()) {
+			t.Fatalf("Read %v frame for stream 0x%x, expected RST for 0x%x", f.typ, f.id, str.Id())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the RST")
+	}
+}
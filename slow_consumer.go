@@ -0,0 +1,128 @@
+package muxado
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// smallest window a stream is shrunk to by SlowConsumerShrink
+const minShrunkWindow = 0x1000
+
+// SlowConsumerPolicy is what a session does with a slow consumer, a stream
+// whose receive window stays full for longer than Config.SlowConsumerTimeout
+// because the application does not read from it
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerNotify only emits a SlowConsumer FlowEvent
+	SlowConsumerNotify SlowConsumerPolicy = iota
+	// SlowConsumerReset resets the stream with FlowControlTimeout
+	SlowConsumerReset
+	// SlowConsumerShrink halves the stream's window each time, down to 4KB,
+	// so that it pins less memory once it has been read
+	SlowConsumerShrink
+)
+
+// consumers watches for slow consumers and applies the session's
+// SlowConsumerPolicy to them
+func (s *session) consumers() {
+	defer s.recoverPanic("consumers()")
+	timeout := s.config.SlowConsumerTimeout
+	t := time.NewTicker(timeout / 2)
+	defer t.Stop()
+	blocked := make(map[frame.StreamId]time.Time) // when each blocked stream was first seen blocked
+	for {
+		select {
+		case <-t.C:
+		case <-s.dead:
+			return
+		}
+		now := time.Now()
+		var slow []streamPrivate
+		s.streams.Each(func(id frame.StreamId, str streamPrivate) {
+			if !str.receiveBlocked() {
+				delete(blocked, id)
+				return
+			}
+			since, ok := blocked[id]
+			if !ok {
+				blocked[id] = now
+			} else if now.Sub(since) >= timeout {
+				slow = append(slow, str)
+			}
+		})
+		for id := range blocked {
+			if s.getStream(id) == nil {
+				delete(blocked, id)
+			}
+		}
+		for _, str := range slow {
+			id := frame.StreamId(str.Id())
+			s.flowEvent(FlowEvent{Type: SlowConsumer, StreamId: uint32(id), Duration: now.Sub(blocked[id])})
+			// apply the policy again once the stream stays blocked for another timeout
+			blocked[id] = now
+			switch s.config.SlowConsumerPolicy {
+			case SlowConsumerReset:
+				str.resetWith(FlowControlTimeout, ErrFlowControlTimeout)
+			case SlowConsumerShrink:
+				str.shrinkWindow(str.windowLimit() / 2)
+			}
+		}
+	}
+}
+
+// receiveBlocked reports whether the remote side's window for the stream
+// is empty because the data it sent has not been read. Paused streams block
+// the remote side on purpose.
+func (s *stream) receiveBlocked() bool {
+	if atomic.LoadUint32(&s.paused) == 1 {
+		return false
+	}
+	outstanding := uint64(s.buf.Buffered()) + uint64(atomic.LoadUint32(&s.pendingInc))
+	return outstanding >= uint64(atomic.LoadUint32(&s.recvLimit))+uint64(atomic.LoadUint32(&s.withheld))
+}
+
+// windowLimit returns the size of the window the remote side may fill
+func (s *stream) windowLimit() uint32 {
+	return atomic.LoadUint32(&s.recvLimit)
+}
+
+// shrinkWindow reduces the window the remote side may fill to size, but no
+// lower than minShrunkWindow. The remote side cannot be made to give back
+// window it has, so the bytes read from the stream are withheld from it
+// instead until the window has shrunk.
+func (s *stream) shrinkWindow(size uint32) {
+	if size < minShrunkWindow {
+		size = minShrunkWindow
+	}
+	for {
+		limit := atomic.LoadUint32(&s.recvLimit)
+		if size >= limit {
+			return
+		}
+		if atomic.CompareAndSwapUint32(&s.recvLimit, limit, size) {
+			atomic.AddUint32(&s.withheld, limit-size)
+			return
+		}
+	}
+}
+
+// withhold takes up to n bytes read from the stream that are owed to a
+// shrinking window. It returns the bytes left to return to the remote side.
+func (s *stream) withhold(n uint32) uint32 {
+	for {
+		w := atomic.LoadUint32(&s.withheld)
+		if w == 0 {
+			return n
+		}
+		take := w
+		if n < take {
+			take = n
+		}
+		if atomic.CompareAndSwapUint32(&s.withheld, w, w-take) {
+			return n - take
+		}
+	}
+}
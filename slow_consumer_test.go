@@ -0,0 +1,79 @@
+package muxado
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Test that a stream that is not read is reported as a slow consumer and
+// reset
+func TestSlowConsumerReset(t *testing.T) {
+	t.Parallel()
+	var events flowRecorder
+	client, server := newSessionPair(&Config{MaxWindowSize: 4096}, &Config{
+		MaxWindowSize:       4096,
+		SlowConsumerTimeout: 50 * time.Millisecond,
+		SlowConsumerPolicy:  SlowConsumerReset,
+		OnFlowEvent:         events.record,
+	})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go str.Write(make([]byte, 8192))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if _, err := str.Read(make([]byte, 1)); !errors.Is(err, FlowControlTimeout) {
+		t.Fatalf("Read from slow consumer got %v, expected %v", err, FlowControlTimeout)
+	}
+	if _, err := io.ReadAll(accepted); !errors.Is(err, ErrFlowControlTimeout) {
+		t.Fatalf("Read from reset stream got %v, expected %v", err, ErrFlowControlTimeout)
+	}
+	if ev, ok := events.find(SlowConsumer); !ok || ev.StreamId != accepted.Id() || ev.Duration < 50*time.Millisecond {
+		t.Fatalf("Reported slow consumer %v, %v", ev, ok)
+	}
+}
+
+// Test that the window of a slow consumer shrinks
+func TestSlowConsumerShrink(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{MaxWindowSize: 65536}, &Config{
+		MaxWindowSize:       65536,
+		SlowConsumerTimeout: 50 * time.Millisecond,
+		SlowConsumerPolicy:  SlowConsumerShrink,
+	})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go str.Write(make([]byte, 65536))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := io.ReadFull(accepted, make([]byte, 65536)); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		window := client.Streams()[0].SendWindow
+		if window > 0 && window <= 32768 {
+			break
+		}
+		if window > 32768 || time.Now().After(deadline) {
+			t.Fatalf("Window of slow consumer is %d, expected it to shrink to at most 32768", window)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
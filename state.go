@@ -0,0 +1,86 @@
+package muxado
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// SessionState is a stage of the lifecycle of a session, see Session.State.
+// Sessions only move forward through the stages, possibly skipping
+// StateDraining:
+//
+//	StateOpen → StateDraining → StateClosing → StateDead
+type SessionState uint32
+
+const (
+	// Open sessions open and accept streams. Err returns nil.
+	StateOpen SessionState = iota
+
+	// Draining sessions have sent or received GOAWAY. Streams that were
+	// already open carry on, but once the remote side went away OpenStream
+	// fails with ErrRemoteGoneAway, and once the local side did the remote
+	// side's new streams are refused. Err returns nil.
+	StateDraining
+
+	// Closing sessions are shutting down: the GOAWAY telling the remote side
+	// why is being sent and the transport is about to be closed. OpenStream
+	// fails with ErrSessionClosed and Err returns the error that closed the
+	// session.
+	StateClosing
+
+	// Dead sessions are done with the GOAWAY and are shutting down the
+	// rest: Err returns the error that closed the session. Done is closed,
+	// then the transport and then all streams right after the session
+	// becomes dead, so they may still be open when State first reports it.
+	StateDead
+)
+
+var sessionStateNames = [...]string{
+	StateOpen:     "open",
+	StateDraining: "draining",
+	StateClosing:  "closing",
+	StateDead:     "dead",
+}
+
+func (s SessionState) String() string {
+	if int(s) < len(sessionStateNames) {
+		return sessionStateNames[s]
+	}
+	return fmt.Sprintf("SessionState(%d)", uint32(s))
+}
+
+func (s *session) State() SessionState {
+	return SessionState(atomic.LoadUint32(&s.state))
+}
+
+func (s *session) IsClosed() bool {
+	return s.State() >= StateClosing
+}
+
+func (s *session) CloseReason() (error, error, []byte) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.State() < StateClosing {
+		return nil, nil, nil
+	}
+	return s.dieErr, s.remoteError, s.remoteDebug
+}
+
+// advance moves the session forward to the given stage. It returns false if
+// the session already reached it or a later one, so that each transition
+// happens exactly once.
+func (s *session) advance(to SessionState) bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.advanceLocked(to)
+}
+
+// advanceLocked is advance for callers holding stateMu, e.g. to set the
+// errors describing the new stage along with it
+func (s *session) advanceLocked(to SessionState) bool {
+	if s.State() >= to {
+		return false
+	}
+	atomic.StoreUint32(&s.state, uint32(to))
+	return true
+}
@@ -0,0 +1,105 @@
+package muxado
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, s Session, want SessionState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session is %s, expected %s", s.State(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	if client.State() != StateOpen || server.State() != StateOpen {
+		t.Fatalf("New sessions are %s and %s, expected %s", client.State(), server.State(), StateOpen)
+	}
+
+	str, err := client.OpenStreamWithData([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if err := server.GoAway(NoError, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to send GOAWAY: %v", err)
+	}
+	if server.State() != StateDraining {
+		t.Fatalf("Session is %s after GoAway, expected %s", server.State(), StateDraining)
+	}
+	waitState(t, client, StateDraining)
+	if _, err := client.OpenStream(); err != ErrRemoteGoneAway {
+		t.Fatalf("Opened stream on draining session: %v", err)
+	}
+	if client.Err() != nil {
+		t.Fatalf("Draining session has error %v", client.Err())
+	}
+	// streams that were open carry on
+	if _, err := str.Write([]byte("world")); err != nil {
+		t.Fatalf("Failed to write to stream of draining session: %v", err)
+	}
+
+	client.Close()
+	if client.State() != StateDead {
+		t.Fatalf("Session is %s after Close, expected %s", client.State(), StateDead)
+	}
+	if err := client.Err(); err != ErrSessionClosed {
+		t.Fatalf("Closed session has error %v, expected %v", err, ErrSessionClosed)
+	}
+	if _, err := client.OpenStream(); err != ErrSessionClosed {
+		t.Fatalf("Opened stream on closed session: %v", err)
+	}
+	waitState(t, server, StateDead)
+}
+
+func TestSessionStateString(t *testing.T) {
+	t.Parallel()
+	if s := StateDraining.String(); s != "draining" {
+		t.Fatalf("StateDraining is %q", s)
+	}
+	if s := SessionState(9).String(); s != "SessionState(9)" {
+		t.Fatalf("Undefined state is %q", s)
+	}
+}
+
+// Test that IsClosed and CloseReason report why a session closed without
+// blocking
+func TestCloseReason(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	if client.IsClosed() {
+		t.Fatalf("New session is closed")
+	}
+	if localErr, remoteErr, debug := client.CloseReason(); localErr != nil || remoteErr != nil || debug != nil {
+		t.Fatalf("Open session has close reason %v, %v, %q", localErr, remoteErr, debug)
+	}
+
+	server.CloseWithError(EnhanceYourCalm, []byte("slow down"))
+	<-client.Done()
+	if !client.IsClosed() {
+		t.Fatalf("Session is not closed after the remote side closed it")
+	}
+	wantLocal, wantRemote, wantDebug := client.Wait()
+	localErr, remoteErr, debug := client.CloseReason()
+	if localErr != wantLocal || remoteErr.Error() != wantRemote.Error() || string(debug) != string(wantDebug) {
+		t.Fatalf("Close reason is %v, %v, %q, expected %v, %v, %q",
+			localErr, remoteErr, debug, wantLocal, wantRemote, wantDebug)
+	}
+	if !errors.Is(remoteErr, EnhanceYourCalm) || string(debug) != "slow down" {
+		t.Fatalf("Remote side closed with %v, %q, expected %v, %q", remoteErr, debug, EnhanceYourCalm, "slow down")
+	}
+}
@@ -1,6 +1,7 @@
 package muxado
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,11 +14,21 @@ import (
 )
 
 var (
+	copyBufPool      = sync.Pool{New: func() interface{} { b := make([]byte, copyBufferSize); return &b }}
 	zeroTime         time.Time
 	resetRemoveDelay = 5 * time.Second
 	closeError       = newErr(StreamClosed, errors.New("stream closed"))
 )
 
+const (
+	// writes smaller than this are coalesced when the session's Config sets
+	// WriteCoalesceDelay, and coalesced writes are sent once they reach it
+	coalesceSize = 0x4000
+
+	// size of the pooled buffers used by ReadFrom and Relay
+	copyBufferSize = 0x8000 // 32KB
+)
+
 const (
 	halfClosedInbound  = 0x1
 	halfClosedOutbound = 0x2
@@ -25,33 +36,87 @@ const (
 )
 
 type stream struct {
+	bytesRead    uint64 // bytes read by the application (atomic, first for alignment)
+	bytesWritten uint64 // bytes written to the remote side (atomic)
+	bytesAcked   uint64 // bytes the remote side returned to the window (atomic)
+	lastActive   int64  // time in unix nanoseconds that data was last read or written (atomic)
+
 	synOnce    uint32    // == 0 only if we should send a syn on the next data frame
 	recvWindow uint32    // remaining space in the recv buffer
+	pendingInc uint32    // bytes read but not yet returned to the remote's window (atomic)
+	incAfter   uint32    // pendingInc at which a window update is sent (atomic)
+	recvLimit  uint32    // size of the window the remote side may fill, see shrinkWindow (atomic)
+	withheld   uint32    // bytes read that are kept from the remote side to shrink its window (atomic)
+	idleShrunk uint32    // == 1 if the window was shrunk while the stream was idle, see shrinkIdleWindow (atomic)
+	paused     uint32    // == 1 if window updates are held back, see Pause (atomic)
 	resetOnce  sync.Once // == 1 only if we sent a reset to close this connection
 
+	incMu      sync.Mutex  // protects incTimer and incStopped
+	incTimer   *time.Timer // sends the window increments held back by Config.WindowUpdateDelay
+	incStopped bool        // true once the stream closed, see stopWindowUpdate
+
 	// just for embedding purposes to avoid heap alloc, use 'window' and 'buf'
 	windowImpl condWindow
 	bufImpl    inboundBuffer
 
-	id             frame.StreamId // stream id (const)
-	session        sessionPrivate // the parent session (const)
-	buf            buffer         // buffer for data coming in from the remote side
-	window         windowManager  // manages the outbound window
-	writer         sync.Mutex     // only one writer at a time
-	writeDeadline  time.Time      // deadline for writes (protected by writer mutex)
-	windowSize     uint32         // max window size
-	frData         frame.Data     // data frame used in writes
-	halfCloseMutex sync.Mutex     // synchornizes access to half-close tracking state
-	closedState    uint8          // used for determining when both in/out streams are closed
+	id             frame.StreamId    // stream id (const)
+	session        sessionPrivate    // the parent session (const)
+	buf            buffer            // buffer for data coming in from the remote side
+	window         windowManager     // manages the outbound window
+	writer         sync.Mutex        // only one writer at a time
+	writeDeadline  time.Time         // deadline for writes (protected by writer mutex)
+	rateLimit      tokenBucket       // limits the rate of writes
+	windowSize     uint32            // max window size, see resizeWindow (atomic)
+	frData         frame.Data        // data frame used in writes
+	halfCloseMutex sync.Mutex        // synchornizes access to half-close tracking state
+	closedState    uint8             // used for determining when both in/out streams are closed
+	compress       bool              // data is compressed, signalled on the SYN frame (const after open)
+	unidirectional bool              // data flows from the opener only, signalled on the SYN frame (const after open)
+	metadata       map[string]string // sent in a HEADERS frame that opens the stream (const after open)
+	trailers       map[string]string // received from the remote side (protected by halfCloseMutex)
+	err            error             // error that terminated the stream (protected by halfCloseMutex)
+	label          atomic.Value      // string label for debugging
+	stype          uint32            // StreamType set by a TypedStreamSession (atomic)
+	opened         time.Time         // when the stream was created (const)
+
+	noDelay       uint32      // == 1 if writes are never coalesced (atomic)
+	coalesceMu    sync.Mutex  // protects the coalescing state, held while flushing
+	coalesced     []byte      // small writes held back to be sent together
+	coalesceTimer *time.Timer // flushes coalesced writes after the delay
+	coalesceErr   error       // error flushing coalesced writes, returned by the next write
+
+	synSent  bool            // the frame opening the stream was written (protected by writer mutex)
+	priority *frame.Priority // sent once the stream is opened (protected by writer mutex)
+	synInc   uint32          // window granted once the stream is opened, see resizeWindow (protected by writer mutex)
+
+	acked   chan struct{} // closed once the remote side acknowledged the stream or it closed
+	ackOnce sync.Once
+
+	closed    chan struct{} // closed once the stream closes, see markClosed
+	closeOnce sync.Once
 }
 
 // private interface for Streams to call Sessions
 type sessionPrivate interface {
 	Session
 	writeFrame(frame.Frame, time.Time) error
+	writeFrameContext(context.Context, frame.Frame, time.Time) error
+	writeFrameOrdered(frame.Frame, time.Time) error
 	writeFrameAsync(frame.Frame) error
 	die(error) error
 	removeStream(frame.StreamId)
+	maxFrameSize() int
+	egressBurst() int
+	windowUpdateRatio() float64
+	windowUpdateDelay() time.Duration
+	windowProfile(StreamType) uint32
+	writeCoalesceDelay() time.Duration
+	priority(frame.StreamId) Priority
+	dataSent(id frame.StreamId, offset uint64)
+	dataAcked(id frame.StreamId, offset uint64, n uint32)
+	addBuffered(int)
+	streamReset(Stream, ErrorCode)
+	flowEvent(FlowEvent)
 }
 
 ////////////////////////////////
@@ -63,29 +128,150 @@ func newStream(sess sessionPrivate, id frame.StreamId, windowSize uint32, fin bo
 		session:    sess,
 		windowSize: windowSize,
 		recvWindow: windowSize,
+		recvLimit:  windowSize,
+		incAfter:   windowUpdateThreshold(windowSize, sess.windowUpdateRatio()),
+		opened:     time.Now(),
+		acked:      make(chan struct{}),
+		closed:     make(chan struct{}),
 	}
+	str.touch()
 	if !init {
 		str.synOnce = 1
+		str.synSent = true
 	}
 	str.windowImpl.Init(int(windowSize))
+	str.windowImpl.onStall, str.windowImpl.onResume = str.stalled, str.resumed
 	str.window = &str.windowImpl
 	str.bufImpl.Init(int(windowSize))
 	str.buf = &str.bufImpl
 
 	if fin {
-		str.window.SetError(streamClosed)
+		str.window.SetError(ErrStreamClosed)
 	}
 	return str
 }
 
 func (s *stream) Write(buf []byte) (n int, err error) {
-	return s.write(buf, false)
+	return s.WriteContext(context.Background(), buf)
+}
+
+func (s *stream) WriteContext(ctx context.Context, buf []byte) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if delay := s.session.writeCoalesceDelay(); delay > 0 && len(buf) < coalesceSize && atomic.LoadUint32(&s.noDelay) == 0 {
+		return s.coalesce(buf, delay)
+	}
+	return s.flushAndWrite(ctx, buf, false, nil)
+}
+
+// coalesce holds back a small write for up to delay so that it is sent in a
+// single frame with the writes that follow it
+func (s *stream) coalesce(buf []byte, delay time.Duration) (int, error) {
+	s.coalesceMu.Lock()
+	defer s.coalesceMu.Unlock()
+	if err := s.coalesceErr; err != nil {
+		return 0, err
+	}
+	s.coalesced = append(s.coalesced, buf...)
+	if len(s.coalesced) >= coalesceSize {
+		if err := s.flushLocked(); err != nil {
+			return 0, err
+		}
+	} else if s.coalesceTimer == nil {
+		s.coalesceTimer = time.AfterFunc(delay, func() {
+			s.coalesceMu.Lock()
+			s.coalesceTimer = nil
+			if err := s.flushLocked(); err != nil && s.coalesceErr == nil {
+				s.coalesceErr = err
+			}
+			s.coalesceMu.Unlock()
+		})
+	}
+	return len(buf), nil
+}
+
+// flushLocked sends the coalesced writes. It must be called with
+// s.coalesceMu held.
+func (s *stream) flushLocked() error {
+	if s.coalesceTimer != nil {
+		s.coalesceTimer.Stop()
+		s.coalesceTimer = nil
+	}
+	if len(s.coalesced) == 0 {
+		return nil
+	}
+	buf := s.coalesced
+	s.coalesced = nil
+	_, err := s.write(context.Background(), buf, false, nil)
+	return err
+}
+
+// flushAndWrite sends buf, and any coalesced writes before it, in as few
+// frames as possible
+func (s *stream) flushAndWrite(ctx context.Context, buf []byte, fin bool, trailers map[string]string) (int, error) {
+	s.coalesceMu.Lock()
+	defer s.coalesceMu.Unlock()
+	if err := s.coalesceErr; err != nil {
+		return 0, err
+	}
+	held := len(s.coalesced)
+	if held == 0 {
+		return s.write(ctx, buf, fin, trailers)
+	}
+	if s.coalesceTimer != nil {
+		s.coalesceTimer.Stop()
+		s.coalesceTimer = nil
+	}
+	data := append(s.coalesced, buf...)
+	s.coalesced = nil
+	n, err := s.write(ctx, data, fin, trailers)
+	if n -= held; n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// flushWrites sends any coalesced writes
+func (s *stream) flushWrites() error {
+	s.coalesceMu.Lock()
+	defer s.coalesceMu.Unlock()
+	if err := s.coalesceErr; err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+func (s *stream) SetNoDelay(noDelay bool) {
+	if noDelay {
+		atomic.StoreUint32(&s.noDelay, 1)
+		s.flushWrites()
+	} else {
+		atomic.StoreUint32(&s.noDelay, 0)
+	}
+}
+
+func (s *stream) Pause() {
+	atomic.StoreUint32(&s.paused, 1)
+}
+
+func (s *stream) Resume() {
+	if atomic.CompareAndSwapUint32(&s.paused, 1, 0) {
+		s.flushWindowUpdate()
+	}
 }
 
 func (s *stream) Read(buf []byte) (int, error) {
+	return s.ReadContext(context.Background(), buf)
+}
+
+func (s *stream) ReadContext(ctx context.Context, buf []byte) (int, error) {
 	// read from the buffer
-	n, err := s.buf.Read(buf)
+	n, err := s.buf.ReadContext(ctx, buf)
 	if n > 0 {
+		s.touch()
+		atomic.AddUint64(&s.bytesRead, uint64(n))
+		s.session.addBuffered(-n)
 		/*
 			maxWinSize := s.windowSize
 			recvWindow := atomic.AddUint32(&s.recvWindow, ^uint32(n-1))
@@ -95,11 +281,107 @@ func (s *stream) Read(buf []byte) (int, error) {
 				}
 			}
 		*/
-		s.sendWindowUpdate(uint32(n))
+		s.replenishWindow(uint32(n))
 	}
 	return n, err
 }
 
+// windowUpdateThreshold returns the number of bytes that must be read from a
+// stream with the given window size before its window is replenished
+func windowUpdateThreshold(windowSize uint32, ratio float64) uint32 {
+	if ratio <= 0 {
+		return 1
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	if t := uint32(float64(windowSize) * ratio); t > 1 {
+		return t
+	}
+	return 1
+}
+
+// replenishWindow returns n bytes read by the application to the remote
+// side's window. Increments are coalesced until the threshold is reached and
+// then held back for up to the session's window update delay.
+func (s *stream) replenishWindow(n uint32) {
+	if n = s.withhold(n); n == 0 {
+		return
+	}
+	pending := atomic.AddUint32(&s.pendingInc, n)
+	if pending < atomic.LoadUint32(&s.incAfter) {
+		return
+	}
+	if delay := s.session.windowUpdateDelay(); delay > 0 && pending < atomic.LoadUint32(&s.windowSize)/2 {
+		s.delayWindowUpdate(delay)
+		return
+	}
+	s.flushWindowUpdate()
+}
+
+// delayWindowUpdate sends the pending window increments once delay passed
+// unless a window update is already scheduled
+func (s *stream) delayWindowUpdate(delay time.Duration) {
+	s.incMu.Lock()
+	defer s.incMu.Unlock()
+	if s.incTimer == nil && !s.incStopped {
+		s.incTimer = time.AfterFunc(delay, func() {
+			s.incMu.Lock()
+			s.incTimer = nil
+			stopped := s.incStopped
+			s.incMu.Unlock()
+			if !stopped {
+				s.flushWindowUpdate()
+			}
+		})
+	}
+}
+
+// stopWindowUpdate cancels a delayed window update once the stream closed
+func (s *stream) stopWindowUpdate() {
+	s.incMu.Lock()
+	s.incStopped = true
+	if s.incTimer != nil {
+		s.incTimer.Stop()
+		s.incTimer = nil
+	}
+	s.incMu.Unlock()
+}
+
+// flushWindowUpdate sends the pending window increments unless the stream is
+// paused
+func (s *stream) flushWindowUpdate() {
+	if atomic.LoadUint32(&s.paused) == 1 {
+		return
+	}
+	if inc := atomic.SwapUint32(&s.pendingInc, 0); inc > 0 {
+		s.sendWindowUpdate(inc)
+	}
+}
+
+// ReadFrom implements io.ReaderFrom so that io.Copy into a stream reads from r
+// directly into pooled buffers which are then framed without further copying.
+func (s *stream) ReadFrom(r io.Reader) (n int64, err error) {
+	bufp := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufp)
+	buf := *bufp
+	for {
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			nw, werr := s.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		} else if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
 // Close closes the stream in a manner that attempts to emulate a net.Conn's Close():
 // - It calls CloseWrite() to half-close the stream on the remote side
 // - It calls closeWith() so that all future Read/Write operations will fail
@@ -108,6 +390,7 @@ func (s *stream) Read(buf []byte) (int, error) {
 func (s *stream) Close() error {
 	s.CloseWrite()
 	s.closeWith(closeError)
+	s.discardBuffered()
 	return nil
 }
 
@@ -127,17 +410,122 @@ func (s *stream) SetReadDeadline(dl time.Time) error {
 }
 
 func (s *stream) SetWriteDeadline(dl time.Time) error {
+	// wakes up a write blocked waiting for window
+	s.window.SetDeadline(dl)
 	s.writer.Lock()
 	s.writeDeadline = dl
 	s.writer.Unlock()
 	return nil
 }
 
+func (s *stream) Metadata() map[string]string {
+	return s.metadata
+}
+
+// touch records that data was read from or written to the stream
+func (s *stream) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *stream) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastActive))
+}
+
+func (s *stream) Label() string {
+	label, _ := s.label.Load().(string)
+	return label
+}
+
+func (s *stream) SetLabel(label string) {
+	s.label.Store(label)
+}
+
+func (s *stream) info() StreamInfo {
+	return StreamInfo{
+		Id:           uint32(s.id),
+		Type:         StreamType(atomic.LoadUint32(&s.stype)),
+		Label:        s.Label(),
+		Age:          time.Since(s.opened),
+		BytesRead:    atomic.LoadUint64(&s.bytesRead),
+		BytesWritten: atomic.LoadUint64(&s.bytesWritten),
+		SendWindow:   s.window.Available(),
+		Buffered:     s.buf.Buffered(),
+
+		Unidirectional: s.unidirectional,
+	}
+}
+
+// setUnidirectional makes the stream carry data from its opener only. The
+// direction that doesn't carry data is half-closed from the start: the
+// opener's reads return io.EOF and the other side's writes fail.
+func (s *stream) setUnidirectional(local bool) {
+	s.unidirectional = true
+	if local {
+		s.buf.SetError(io.EOF)
+		s.maybeRemove(halfClosedInbound)
+	} else {
+		s.window.SetError(ErrUnidirectional)
+		s.maybeRemove(halfClosedOutbound)
+	}
+}
+
+// setStreamType records the type of the stream and gives it the type's
+// window, see Config.WindowProfiles
+func (s *stream) setStreamType(t StreamType) {
+	atomic.StoreUint32(&s.stype, uint32(t))
+	s.resizeWindow(s.session.windowProfile(t))
+}
+
+// String identifies the stream by its id and label in logs
+func (s *stream) String() string {
+	if label := s.Label(); label != "" {
+		return fmt.Sprintf("stream 0x%x (%s)", s.id, label)
+	}
+	return fmt.Sprintf("stream 0x%x", s.id)
+}
+
+func (s *stream) SetRateLimit(bytesPerSec int) {
+	s.rateLimit.SetRate(bytesPerSec)
+}
+
+func (s *stream) SetPriority(p Priority) error {
+	f, err := p.pack(s.id)
+	if err != nil {
+		return err
+	}
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	// the remote side ignores priorities of streams it does not know about
+	if !s.synSent {
+		s.priority = f
+		return nil
+	}
+	return s.session.writeFrame(f, s.writeDeadline)
+}
+
+func (s *stream) Priority() Priority {
+	return s.session.priority(s.id)
+}
+
 func (s *stream) CloseWrite() error {
-	_, err := s.write([]byte{}, true)
+	_, err := s.flushAndWrite(context.Background(), []byte{}, true, nil)
 	return err
 }
 
+func (s *stream) CloseWriteWithTrailers(trailers map[string]string) error {
+	if trailers == nil {
+		trailers = map[string]string{}
+	}
+	_, err := s.flushAndWrite(context.Background(), []byte{}, true, trailers)
+	return err
+}
+
+func (s *stream) Trailers() map[string]string {
+	s.halfCloseMutex.Lock()
+	defer s.halfCloseMutex.Unlock()
+	return s.trailers
+}
+
 func (s *stream) Id() uint32 {
 	return uint32(s.id)
 }
@@ -160,17 +548,22 @@ func (s *stream) RemoteAddr() net.Addr {
 func (s *stream) handleStreamData(f *frame.Data) error {
 	// skip writing for zero-length frames (typically for sending FIN)
 	if f.Length() > 0 {
+		s.touch()
+		s.growIdleWindow()
+
 		// write the data into the buffer
-		if _, err := s.buf.ReadFrom(f.Reader()); err != nil {
+		n, err := s.buf.ReadFrom(f.Reader())
+		s.session.addBuffered(n)
+		if err != nil {
 			if err == bufferFull {
-				s.resetWith(FlowControlError, flowControlViolated)
+				s.resetWith(FlowControlError, ErrFlowControlViolated)
 			} else if err == closeError {
 				// We're trying to emulate net.Conn's Close() behavior where we close our side of the connection,
 				// and if we get any more frames from the other side, we RST it.
-				s.resetWith(StreamClosed, streamClosed)
+				s.resetWith(StreamClosed, ErrStreamClosed)
 			} else if err == bufferClosed {
 				// there was already an error set
-				s.resetWith(StreamClosed, streamClosed)
+				s.resetWith(StreamClosed, ErrStreamClosed)
 			} else {
 				// the transport returned some sort of IO error
 				return err
@@ -186,18 +579,98 @@ func (s *stream) handleStreamData(f *frame.Data) error {
 }
 
 func (s *stream) handleStreamRst(f *frame.Rst) error {
-	s.closeWith(newErr(ErrorCode(f.ErrorCode()), fmt.Errorf("Stream reset by peer with remote error code: %d", f.ErrorCode())))
+	resetErr := &StreamResetError{Code: ErrorCode(f.ErrorCode())}
+	if debug := f.Debug(); len(debug) > 0 {
+		// the frame's buffer is reused for the next frame
+		resetErr.Debug = append([]byte(nil), debug...)
+	}
+	s.session.streamReset(s, resetErr.Code)
+	s.closeWith(resetErr)
+	return nil
+}
+
+func (s *stream) handleStreamTrailers(f *frame.Headers) error {
+	trailers := make(map[string]string, len(f.Headers()))
+	for _, h := range f.Headers() {
+		trailers[h.Key] = h.Value
+	}
+	s.halfCloseMutex.Lock()
+	s.trailers = trailers
+	s.halfCloseMutex.Unlock()
+	s.buf.SetError(io.EOF)
+	s.maybeRemove(halfClosedInbound)
 	return nil
 }
 
 func (s *stream) handleStreamWndInc(f *frame.WndInc) error {
+	if f.Ack() {
+		s.ack()
+		if f.WindowIncrement() == 0 {
+			return nil
+		}
+	}
+	if f.Shrink() {
+		// the window goes negative if the remote side asked for more than
+		// is left of it, writes block until it grows back
+		s.window.Increment(-int(f.WindowIncrement()))
+		return nil
+	}
 	s.window.Increment(int(f.WindowIncrement()))
+	s.session.dataAcked(s.id, atomic.AddUint64(&s.bytesAcked, uint64(f.WindowIncrement())), f.WindowIncrement())
+	s.session.flowEvent(FlowEvent{Type: WindowUpdateReceived, StreamId: uint32(s.id), Increment: f.WindowIncrement()})
 	return nil
 }
 
+// ack wakes up awaitAck
+func (s *stream) ack() {
+	s.ackOnce.Do(func() { close(s.acked) })
+}
+
+// awaitAck opens the stream if its first frame wasn't written yet and waits
+// until the remote side acknowledges it or it closes, see Config.SyncOpen
+func (s *stream) awaitAck(negotiated, cancel <-chan struct{}) (bool, error) {
+	if err := s.open(); err != nil {
+		return false, err
+	}
+	select {
+	case <-s.acked:
+		return true, s.closeErr()
+	case <-negotiated:
+		return false, nil
+	case <-cancel:
+		return false, ErrOpenTimeout
+	}
+}
+
+// open sends the frame opening the stream unless it was already sent
+func (s *stream) open() error {
+	_, err := s.flushAndWrite(context.Background(), nil, false, nil)
+	return err
+}
+
+func (s *stream) buffered() int {
+	return s.buf.Buffered()
+}
+
+func (s *stream) compressed() bool {
+	return s.compress
+}
+
+func (s *stream) setCompressed() {
+	s.compress = true
+}
+
+func (s *stream) setMetadata(md map[string]string) {
+	s.metadata = md
+}
+
 func (s *stream) closeWith(err error) {
+	s.setCloseErr(err)
+	s.ack()
 	s.window.SetError(err)
 	s.buf.SetError(err)
+	s.stopWindowUpdate()
+	s.markClosed()
 	s.removeFromSession()
 }
 
@@ -205,13 +678,39 @@ func (s *stream) closeWith(err error) {
 // internal methods
 ////////////////////////////////
 
+// discardBuffered drops any unread data, releasing it from the session's buffer budget
+func (s *stream) discardBuffered() {
+	if n := s.buf.Discard(); n > 0 {
+		s.session.addBuffered(-n)
+	}
+}
+
+// setCloseErr records the first error that terminated the stream. Closing the
+// stream locally is not an error.
+func (s *stream) setCloseErr(err error) {
+	s.halfCloseMutex.Lock()
+	if s.err == nil && err != closeError {
+		s.err = err
+	}
+	s.halfCloseMutex.Unlock()
+}
+
+func (s *stream) closeErr() error {
+	s.halfCloseMutex.Lock()
+	defer s.halfCloseMutex.Unlock()
+	return s.err
+}
+
 func (s *stream) removeFromSession() {
 	s.session.removeStream(s.id)
 }
 
 func (s *stream) closeWithAndRemoveLater(err error) {
+	s.setCloseErr(err)
 	s.window.SetError(err)
 	s.buf.SetError(err)
+	s.stopWindowUpdate()
+	s.markClosed()
 	time.AfterFunc(resetRemoveDelay, s.removeFromSession)
 }
 
@@ -222,19 +721,34 @@ func (s *stream) maybeRemove(closeFlag uint8) {
 	s.halfCloseMutex.Unlock()
 
 	if remove {
+		s.stopWindowUpdate()
 		s.removeFromSession()
 	}
 }
 
+func (s *stream) CloseWithError(errCode ErrorCode, debug []byte) error {
+	s.resetWithDebug(errCode, debug, ErrStreamClosed)
+	return nil
+}
+
 func (s *stream) resetWith(errorCode ErrorCode, resetErr error) {
+	s.resetWithDebug(errorCode, nil, resetErr)
+}
+
+// resetWithDebug resets the stream, sending the remote side debug data along
+// with the error code, and fails its local operations with resetErr
+func (s *stream) resetWithDebug(errorCode ErrorCode, debug []byte, resetErr error) {
 	// only ever send one reset
 	s.resetOnce.Do(func() {
+		s.session.streamReset(s, errorCode)
+
 		// close the stream
 		s.closeWithAndRemoveLater(resetErr)
+		s.discardBuffered()
 
 		// make the reset frame
 		rst := new(frame.Rst)
-		if err := rst.Pack(s.id, frame.ErrorCode(errorCode)); err != nil {
+		if err := rst.PackWithDebug(s.id, frame.ErrorCode(errorCode), truncateDebug(debug)); err != nil {
 			s.session.die(newErr(InternalError, fmt.Errorf("failed to pack RST frame: %v", err)))
 			return
 		}
@@ -243,12 +757,20 @@ func (s *stream) resetWith(errorCode ErrorCode, resetErr error) {
 		s.writer.Lock()
 		defer s.writer.Unlock()
 
-		// send it
-		s.session.writeFrame(rst, zeroTime)
+		// send it, after the frame opening the stream if that may still be
+		// queued, e.g. because its write timed out
+		if s.synSent {
+			s.session.writeFrame(rst, zeroTime)
+		} else {
+			s.session.writeFrameOrdered(rst, zeroTime)
+		}
 	})
 }
 
-func (s *stream) write(buf []byte, fin bool) (n int, err error) {
+// write sends buf in DATA frames, half-closing the stream after it if fin is
+// set. If trailers is not nil, the stream is half-closed by a HEADERS frame
+// carrying them. It gives up waiting once ctx is done.
+func (s *stream) write(ctx context.Context, buf []byte, fin bool, trailers map[string]string) (n int, err error) {
 	var synFlag bool
 	if atomic.CompareAndSwapUint32(&s.synOnce, 0, 1) {
 		synFlag = true
@@ -257,17 +779,47 @@ func (s *stream) write(buf []byte, fin bool) (n int, err error) {
 	// a write call can pass a buffer larger that we can send in a single frame
 	// only allow one writer at a time to prevent interleaving frames from concurrent writes
 	s.writer.Lock()
+	if err = ctx.Err(); err != nil {
+		s.writer.Unlock()
+		return
+	}
+
+	// streams with metadata are opened by a HEADERS frame instead of the first DATA frame
+	if synFlag && s.metadata != nil {
+		var flags frame.Flags = frame.FlagHeadersSyn
+		if s.compress {
+			flags.Set(frame.FlagHeadersCompressed)
+		}
+		if s.unidirectional {
+			flags.Set(frame.FlagHeadersUnidirectional)
+		}
+		if err = s.sendHeaders(s.metadata, flags); err != nil {
+			s.writer.Unlock()
+			return
+		}
+		if err = s.sentSyn(); err != nil {
+			s.writer.Unlock()
+			return
+		}
+		synFlag = false
+	}
 
 	bufSize := len(buf)
 	bytesRemaining := bufSize
-	for bytesRemaining > 0 || fin {
+	for bytesRemaining > 0 || fin || synFlag {
 		// figure out the most we can write in a single frame
-		writeReqSize := min(0x00FFFFFF, bytesRemaining)
+		writeReqSize := min(s.session.maxFrameSize(), bytesRemaining)
+		if burst := s.rateLimit.Burst(); burst > 0 {
+			writeReqSize = min(burst, writeReqSize)
+		}
+		if burst := s.session.egressBurst(); burst > 0 {
+			writeReqSize = min(burst, writeReqSize)
+		}
 
 		// and then reduce that to however much is available in the window
 		// this blocks until window is available and may not return all that we asked for
 		var writeSize int
-		if writeSize, err = s.window.Decrement(writeReqSize); err != nil {
+		if writeSize, err = s.window.DecrementContext(ctx, writeReqSize); err != nil {
 			s.writer.Unlock()
 			return
 		}
@@ -277,26 +829,72 @@ func (s *stream) write(buf []byte, fin bool) (n int, err error) {
 
 		// only send fin for the last frame
 		finFlag := fin && end == bufSize
+		dataFin := finFlag && trailers == nil
 
-		// make the frame
-		if err = s.frData.Pack(s.id, buf[start:end], finFlag, synFlag); err != nil {
-			err = newErr(InternalError, fmt.Errorf("failed to pack DATA frame: %v", err))
+		// wait until the rate limit allows the frame to be sent
+		if err = s.waitRateLimit(ctx, writeSize); err != nil {
+			// the frame was not sent
+			s.window.Increment(writeSize)
 			s.writer.Unlock()
 			return
 		}
 
-		// write the frame
-		if err = s.session.writeFrame(&s.frData, s.writeDeadline); err != nil {
+		// make the frame
+		var flags frame.Flags
+		if dataFin {
+			flags.Set(frame.FlagDataFin)
+		}
+		if writeSize > frame.MaxLength {
+			flags.Set(frame.FlagDataExtended)
+		}
+		if synFlag {
+			flags.Set(frame.FlagDataSyn)
+			if s.compress {
+				flags.Set(frame.FlagDataCompressed)
+			}
+		}
+		if err = s.frData.PackFlags(s.id, buf[start:end], flags); err != nil {
+			err = newErr(InternalError, fmt.Errorf("failed to pack DATA frame: %v", err))
 			s.writer.Unlock()
 			return
 		}
 
+		// write the frame, an empty one is only needed if it opens or closes the stream
+		if writeSize > 0 || dataFin || synFlag {
+			if err = s.session.writeFrameContext(ctx, &s.frData, s.writeDeadline); err != nil {
+				if err == ErrWriteQueueFull {
+					// the frame was not sent
+					s.window.Increment(writeSize)
+				}
+				s.writer.Unlock()
+				return
+			}
+		}
+		if synFlag {
+			if err = s.sentSyn(); err != nil {
+				s.writer.Unlock()
+				return
+			}
+		}
+
+		// half-close the stream with the trailers
+		if finFlag && trailers != nil {
+			if err = s.sendHeaders(trailers, frame.FlagHeadersFin); err != nil {
+				s.writer.Unlock()
+				return
+			}
+		}
+
 		// update our counts
+		if offset := atomic.AddUint64(&s.bytesWritten, uint64(writeSize)); writeSize > 0 {
+			s.session.dataSent(s.id, offset)
+		}
+		s.touch()
 		n += writeSize
 		bytesRemaining -= writeSize
 
 		if finFlag {
-			s.window.SetError(streamClosed)
+			s.window.SetError(ErrStreamClosed)
 			s.maybeRemove(halfClosedOutbound)
 
 			// handles the empty buffer with fin case
@@ -309,6 +907,76 @@ func (s *stream) write(buf []byte, fin bool) (n int, err error) {
 	return
 }
 
+// waitRateLimit waits until the stream's rate limit lets n more bytes through.
+// It gives up once ctx is done, the write deadline passes or the stream or the
+// session closes. It is called with the writer mutex held.
+func (s *stream) waitRateLimit(ctx context.Context, n int) error {
+	d := s.rateLimit.Take(n)
+	if d <= 0 {
+		return nil
+	}
+	t := getTimer(time.Now().Add(d))
+	defer putTimer(t)
+	var timeout <-chan time.Time
+	if !s.writeDeadline.IsZero() {
+		dl := getTimer(s.writeDeadline)
+		defer putTimer(dl)
+		timeout = dl.C
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-timeout:
+		return ErrWriteTimeout
+	case <-s.closed:
+		if err := s.closeErr(); err != nil {
+			return err
+		}
+		return ErrStreamClosed
+	case <-s.session.Done():
+		return ErrSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// markClosed wakes the writes waiting for the stream's rate limit once the
+// stream closes
+func (s *stream) markClosed() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// sentSyn is called with the writer mutex held once the frame opening the
+// stream was written. It sends the priority and window set before the stream
+// was opened.
+func (s *stream) sentSyn() error {
+	s.synSent = true
+	// grants window added before the stream was opened, see resizeWindow
+	if s.synInc > 0 {
+		atomic.AddUint32(&s.pendingInc, s.synInc)
+		s.synInc = 0
+		s.flushWindowUpdate()
+	}
+	if f := s.priority; f != nil {
+		s.priority = nil
+		return s.session.writeFrame(f, s.writeDeadline)
+	}
+	return nil
+}
+
+// sendHeaders sends a HEADERS frame with the given flags carrying md
+func (s *stream) sendHeaders(md map[string]string, flags frame.Flags) error {
+	headers := make([]frame.Header, 0, len(md))
+	for k, v := range md {
+		headers = append(headers, frame.Header{Key: k, Value: v})
+	}
+	f := new(frame.Headers)
+	if err := f.Pack(s.id, headers, flags); err != nil {
+		return err
+	}
+	return s.session.writeFrame(f, s.writeDeadline)
+}
+
 // sendWindowUpdate sends a window increment frame
 // with the given increment
 func (s *stream) sendWindowUpdate(inc uint32) {
@@ -319,6 +987,7 @@ func (s *stream) sendWindowUpdate(inc uint32) {
 		return
 	}
 	s.session.writeFrameAsync(&wndinc)
+	s.session.flowEvent(FlowEvent{Type: WindowUpdateSent, StreamId: uint32(s.id), Increment: inc})
 }
 
 func min(n1, n2 int) int {
@@ -29,10 +29,20 @@ func (m *streamMap) Set(id frame.StreamId, str streamPrivate) {
 	m.Unlock()
 }
 
-func (m *streamMap) Delete(id frame.StreamId) {
+// Delete removes the stream with the given id, returning it if it was present
+func (m *streamMap) Delete(id frame.StreamId) (s streamPrivate, ok bool) {
 	m.Lock()
+	s, ok = m.table[id]
 	delete(m.table, id)
 	m.Unlock()
+	return
+}
+
+func (m *streamMap) Len() (n int) {
+	m.RLock()
+	n = len(m.table)
+	m.RUnlock()
+	return
 }
 
 func (m *streamMap) Each(fn func(frame.StreamId, streamPrivate)) {
@@ -1,9 +1,20 @@
 package muxado
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/inconshreveable/muxado/frame"
 )
@@ -14,6 +25,7 @@ func TestCloseWrite(t *testing.T) {
 	local, remote := newFakeConnPair()
 
 	s := Client(local, &Config{NewFramer: debugFramer("CLIENT")})
+	defer remote.in.Close()
 
 	done := make(chan int)
 	go func() {
@@ -31,6 +43,7 @@ func TestCloseWrite(t *testing.T) {
 			return f
 		}
 
+		readFrame(frame.TypeSettings)
 		f := readFrame(frame.TypeData)
 		if f.Length() != 10 {
 			t.Errorf("Wrong data length. Got %d, expected %d", f.Length(), 10)
@@ -52,11 +65,11 @@ func TestCloseWrite(t *testing.T) {
 			return
 		}
 
+		close(done)
+
 		// io.Pipe is weird and apparently sometimes doesn't acknowledge a write completion
 		// until the next read
 		remote.Read([]byte{})
-
-		close(done)
 	}()
 
 	str, err := s.OpenStream()
@@ -77,6 +90,888 @@ func TestCloseWrite(t *testing.T) {
 	<-done
 }
 
+// newSessionPair returns a client and server session connected to each other
+func newSessionPair(clientConfig, serverConfig *Config) (client Session, server Session) {
+	local, remote := newFakeConnPair()
+	return Client(local, clientConfig), Server(remote, serverConfig)
+}
+
+// Test that io.Copy into a stream through its ReadFrom and back out of it
+// transfers all of the data, including payloads larger than the stream window
+func TestStreamCopy(t *testing.T) {
+	t.Parallel()
+
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	payload := bytes.Repeat([]byte("muxado"), 0x20000)
+	go func() {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Errorf("Failed to open stream: %v", err)
+			return
+		}
+		if _, ok := str.(io.ReaderFrom); !ok {
+			t.Errorf("Stream does not implement io.ReaderFrom")
+		}
+		if _, err := io.Copy(str, bytes.NewReader(payload)); err != nil {
+			t.Errorf("Failed to copy into stream: %v", err)
+		}
+		str.CloseWrite()
+	}()
+
+	str, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, str)
+	if err != nil {
+		t.Fatalf("Failed to copy out of stream: %v", err)
+	}
+	if n != int64(len(payload)) || !bytes.Equal(buf.Bytes(), payload) {
+		t.Fatalf("Copied data does not match. Got %d bytes, expected %d", n, len(payload))
+	}
+}
+
+// Test that the stream with the most unread data is reset when the session's
+// buffer budget is exceeded
+func TestSessionBufferBudget(t *testing.T) {
+	t.Parallel()
+
+	client, server := newSessionPair(nil, &Config{MaxSessionBuffer: 100})
+	defer client.Close()
+	defer server.Close()
+
+	for _, size := range []int{60, 50} {
+		str, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := str.Write(make([]byte, size)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	heavy, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	light, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+
+	// give the session time to buffer the second stream's data
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := heavy.Read(make([]byte, 60)); err == nil {
+		t.Fatalf("Expected heaviest stream to be reset")
+	} else if code, _ := GetError(err); code != EnhanceYourCalm {
+		t.Fatalf("Wrong error code. Got %d, expected %d", code, EnhanceYourCalm)
+	}
+	if n, err := io.ReadFull(light, make([]byte, 50)); err != nil {
+		t.Fatalf("Failed to read %d bytes from light stream: %v", n, err)
+	}
+}
+
+// Test that a reset from the remote side is surfaced as a *StreamResetError
+// carrying the remote error code and debug data
+func TestStreamResetError(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	s := Client(local, nil)
+	defer s.Close()
+	fr := frame.NewFramer(remote, remote)
+	skipSettings(t, fr)
+
+	str, err := s.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go str.Write([]byte("hello"))
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	io.Copy(ioutil.Discard, f.(*frame.Data).Reader())
+
+	rst := new(frame.Rst)
+	rst.PackWithDebug(f.StreamId(), frame.ErrorCode(StreamCancelled), []byte("bye"))
+	if err := fr.WriteFrame(rst); err != nil {
+		t.Fatalf("Failed to write RST: %v", err)
+	}
+
+	_, err = str.Read(make([]byte, 1))
+	resetErr, ok := err.(*StreamResetError)
+	if !ok {
+		t.Fatalf("Expected *StreamResetError, got %T: %v", err, err)
+	}
+	if resetErr.Code != StreamCancelled || string(resetErr.Debug) != "bye" {
+		t.Fatalf("Wrong reset error. Got code %d debug %q", resetErr.Code, resetErr.Debug)
+	}
+}
+
+// Test that CloseWithError resets the stream with the given error code and
+// debug data and fails the local side's operations
+func TestStreamCloseWithError(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+
+	if err := str.CloseWithError(StreamCancelled, []byte("quota exceeded")); err != nil {
+		t.Fatalf("Failed to close stream: %v", err)
+	}
+	_, err = accepted.Read(make([]byte, 1))
+	resetErr, ok := err.(*StreamResetError)
+	if !ok {
+		t.Fatalf("Expected *StreamResetError, got %T: %v", err, err)
+	}
+	if resetErr.Code != StreamCancelled || string(resetErr.Debug) != "quota exceeded" {
+		t.Fatalf("Wrong reset error. Got code %d debug %q", resetErr.Code, resetErr.Debug)
+	}
+	if _, err := str.Write([]byte("b")); err != ErrStreamClosed {
+		t.Fatalf("Wrote to a closed stream, got %v", err)
+	}
+	if _, err := str.Read(make([]byte, 1)); err != ErrStreamClosed {
+		t.Fatalf("Read from a closed stream, got %v", err)
+	}
+}
+
+// Test that the session of an accepted stream opens sibling streams back to
+// the peer
+func TestStreamSession(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if str.Session() != client {
+		t.Fatalf("Stream is on %v, expected %v", str.Session(), client)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if accepted.Session() != server {
+		t.Fatalf("Accepted stream is on %v, expected %v", accepted.Session(), server)
+	}
+	sibling, err := accepted.Session().OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open sibling stream: %v", err)
+	}
+	sibling.Write([]byte("b"))
+	back, err := client.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept sibling stream: %v", err)
+	}
+	readString(t, back, "b")
+}
+
+// Test that reads and writes blocked on a stream give up once their context
+// is done and leave the stream usable
+func TestStreamContext(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		opts []StreamOption
+	}{
+		{"plain", nil},
+		{"compressed", []StreamOption{WithCompression()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := newSessionPair(&Config{MaxWindowSize: 0x1000}, &Config{MaxWindowSize: 0x1000})
+			defer client.Close()
+			defer server.Close()
+			str, err := client.OpenStream(tc.opts...)
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			str.Write([]byte("a"))
+			accepted, err := server.AcceptStream()
+			if err != nil {
+				t.Fatalf("Failed to accept stream: %v", err)
+			}
+			readString(t, accepted, "a")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			if _, err := str.ReadContext(ctx, make([]byte, 1)); err != context.Canceled {
+				t.Fatalf("Read got %v, expected %v", err, context.Canceled)
+			}
+
+			// the server's window fills up, even with data that does not
+			// compress
+			data := make([]byte, 0x10000)
+			rand.Read(data)
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if _, err := str.WriteContext(ctx, data); err != context.DeadlineExceeded {
+				t.Fatalf("Write got %v, expected %v", err, context.DeadlineExceeded)
+			}
+
+			accepted.Write([]byte("x"))
+			readString(t, str, "x")
+		})
+	}
+}
+
+// Test that expired deadlines fail reads and writes blocked on window with
+// net.Error timeouts
+func TestStreamDeadlines(t *testing.T) {
+	t.Parallel()
+	config := &Config{MaxWindowSize: 16}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	checkTimeout := func(op string, err error) {
+		if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+			t.Fatalf("Expected %s timeout net.Error, got %T: %v", op, err, err)
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Expected %s error to match os.ErrDeadlineExceeded: %v", op, err)
+		}
+	}
+
+	str.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = str.Read(make([]byte, 1))
+	checkTimeout("read", err)
+
+	// the remote side never reads, so the write blocks once the window is used up
+	str.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	n, err := str.Write(make([]byte, 64))
+	checkTimeout("write", err)
+	if n != 16 {
+		t.Fatalf("Wrong number of bytes written. Got %d, expected %d", n, 16)
+	}
+
+	// clearing the deadline lets reads block again
+	str.SetReadDeadline(time.Time{})
+	go str.Close()
+	if _, err := str.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Expected read from closed stream to fail")
+	} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Fatalf("Unexpected timeout after clearing the deadline: %v", err)
+	}
+}
+
+// Test that a stream opened with compression is accepted as a compressed
+// stream and round-trips data in both directions
+func TestStreamCompression(t *testing.T) {
+	t.Parallel()
+	config := &Config{Compression: true}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	// opening waits for the server's SETTINGS to advertise compression
+	str, err := client.OpenStream(WithCompression())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	msg := bytes.Repeat([]byte("muxado compresses repetitive text "), 1000)
+	go func() {
+		str.Write(msg)
+		str.CloseWrite()
+	}()
+
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if _, ok := accepted.(*compressedStream); !ok {
+		t.Fatalf("Accepted stream is not compressed: %T", accepted)
+	}
+	buf, err := ioutil.ReadAll(accepted)
+	if err != nil {
+		t.Fatalf("Failed to read compressed stream: %v", err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("Wrong data read from compressed stream")
+	}
+
+	if _, err := accepted.Write([]byte("ok")); err != nil {
+		t.Fatalf("Failed to write reply: %v", err)
+	}
+	accepted.CloseWrite()
+	reply, err := ioutil.ReadAll(str)
+	if err != nil || string(reply) != "ok" {
+		t.Fatalf("Wrong reply. Got %q, %v", reply, err)
+	}
+}
+
+type countingCodec struct {
+	deflateCodec
+	writers int32
+}
+
+func (c *countingCodec) NewWriter(w io.Writer) CodecWriter {
+	atomic.AddInt32(&c.writers, 1)
+	return c.deflateCodec.NewWriter(w)
+}
+
+// Test that streams are compressed with the first requested codec that both
+// sides registered and fall back to DEFLATE otherwise
+func TestStreamCodecs(t *testing.T) {
+	t.Parallel()
+	const custom, unknown = CodecId(3), CodecId(4)
+	clientCodec, serverCodec := new(countingCodec), new(countingCodec)
+	client, server := newSessionPair(
+		&Config{Compression: true, Codecs: map[CodecId]Codec{custom: clientCodec, unknown: clientCodec}},
+		&Config{Compression: true, Codecs: map[CodecId]Codec{custom: serverCodec}},
+	)
+	defer client.Close()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		codecs []CodecId
+		md     string
+	}{
+		{[]CodecId{unknown, custom}, "3"},
+		{[]CodecId{unknown, CodecDeflate}, ""},
+	} {
+		str, err := client.OpenStream(WithCompression(tc.codecs...))
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		go str.Write([]byte("hello"))
+		accepted, err := server.AcceptStream()
+		if err != nil {
+			t.Fatalf("Failed to accept stream: %v", err)
+		}
+		if _, ok := accepted.(*compressedStream); !ok {
+			t.Fatalf("Accepted stream is not compressed: %T", accepted)
+		}
+		if md := accepted.Metadata()[codecKey]; md != tc.md {
+			t.Fatalf("Stream opened with codecs %v named codec %q, expected %q", tc.codecs, md, tc.md)
+		}
+		readString(t, accepted, "hello")
+	}
+	if atomic.LoadInt32(&clientCodec.writers) != 1 || atomic.LoadInt32(&serverCodec.writers) != 1 {
+		t.Fatalf("Custom codec used for %d client and %d server streams, expected 1",
+			atomic.LoadInt32(&clientCodec.writers), atomic.LoadInt32(&serverCodec.writers))
+	}
+}
+
+// Test that metadata attached when opening a stream is available to the
+// remote side when it accepts the stream
+func TestStreamMetadata(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	md := map[string]string{"method": "GET", "path": "/index.html"}
+	str, err := client.OpenStream(WithMetadata(md))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		str.Write([]byte("body"))
+		str.CloseWrite()
+	}()
+
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if !reflect.DeepEqual(accepted.Metadata(), md) {
+		t.Fatalf("Wrong metadata. Got %v, expected %v", accepted.Metadata(), md)
+	}
+	buf, err := ioutil.ReadAll(accepted)
+	if err != nil || string(buf) != "body" {
+		t.Fatalf("Wrong data. Got %q, %v", buf, err)
+	}
+}
+
+// Test that trailers sent when half-closing a stream are available to the
+// remote side after EOF
+func TestStreamTrailers(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	trailers := map[string]string{"status": "200", "length": "4"}
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		str.Write([]byte("body"))
+		str.CloseWriteWithTrailers(trailers)
+	}()
+
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	buf, err := ioutil.ReadAll(accepted)
+	if err != nil || string(buf) != "body" {
+		t.Fatalf("Wrong data. Got %q, %v", buf, err)
+	}
+	if !reflect.DeepEqual(accepted.Trailers(), trailers) {
+		t.Fatalf("Wrong trailers. Got %v, expected %v", accepted.Trailers(), trailers)
+	}
+
+	// the server can still reply after the client half-closed
+	if _, err := accepted.Write([]byte("reply")); err != nil {
+		t.Fatalf("Failed to write reply: %v", err)
+	}
+	accepted.CloseWrite()
+	if buf, err := ioutil.ReadAll(str); err != nil || string(buf) != "reply" {
+		t.Fatalf("Wrong reply. Got %q, %v", buf, err)
+	}
+	if str.Trailers() != nil {
+		t.Fatalf("Unexpected trailers: %v", str.Trailers())
+	}
+}
+
+func TestStreamLabel(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream(WithLabel("db-sync"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if str.Label() != "db-sync" {
+		t.Fatalf("Wrong label. Got %q, expected %q", str.Label(), "db-sync")
+	}
+	if s := fmt.Sprint(str); s != fmt.Sprintf("stream 0x%x (db-sync)", str.Id()) {
+		t.Fatalf("Wrong string for labeled stream: %q", s)
+	}
+	str.Write([]byte("hi"))
+
+	// labels are not sent to the remote side
+	remote, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if remote.Label() != "" {
+		t.Fatalf("Label was sent to the remote side: %q", remote.Label())
+	}
+	remote.SetLabel("agent")
+	if remote.Label() != "agent" {
+		t.Fatalf("Wrong label. Got %q, expected %q", remote.Label(), "agent")
+	}
+}
+
+// countingFramer counts the frames of each type written
+type countingFramer struct {
+	frame.Framer
+	mu     sync.Mutex
+	counts map[frame.Type]int
+}
+
+func (fr *countingFramer) WriteFrame(f frame.Frame) error {
+	fr.mu.Lock()
+	fr.counts[f.Type()]++
+	fr.mu.Unlock()
+	return fr.Framer.WriteFrame(f)
+}
+
+func (fr *countingFramer) count(t frame.Type) int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.counts[t]
+}
+
+// Test that window updates are coalesced until the configured fraction of
+// the window has been read
+func TestWindowUpdateThreshold(t *testing.T) {
+	t.Parallel()
+	const window, size = 0x10000, 0x40000
+	fr := &countingFramer{counts: make(map[frame.Type]int)}
+	client, server := newSessionPair(&Config{MaxWindowSize: window}, &Config{
+		MaxWindowSize:         window,
+		WindowUpdateThreshold: 0.5,
+		NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+			fr.Framer = frame.NewFramer(r, w)
+			return fr
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		str, err := client.OpenStream()
+		if err != nil {
+			return
+		}
+		str.Write(make([]byte, size))
+		str.CloseWrite()
+	}()
+	str, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	var n int
+	buf := make([]byte, 1024)
+	for {
+		nr, err := str.Read(buf)
+		n += nr
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+	}
+	if n != size {
+		t.Fatalf("Read %d bytes, expected %d", n, size)
+	}
+	if got, max := fr.count(frame.TypeWndInc), size/(window/2); got == 0 || got > max {
+		t.Fatalf("Sent %d WNDINC frames, expected between 1 and %d", got, max)
+	}
+}
+
+// Test that window increments are held back for the window update delay and
+// sent early before the remote side stalls
+func TestWindowUpdateDelay(t *testing.T) {
+	t.Parallel()
+	const window, writes = 0x10000, 200
+	fr := &countingFramer{counts: make(map[frame.Type]int)}
+	client, server := newSessionPair(&Config{MaxWindowSize: window}, &Config{
+		MaxWindowSize:     window,
+		WindowUpdateDelay: time.Hour,
+		NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+			fr.Framer = frame.NewFramer(r, w)
+			return fr
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	go func() {
+		for i := 0; i < writes; i++ {
+			str.Write(make([]byte, 1024))
+		}
+		str.CloseWrite()
+	}()
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	n, err := io.Copy(ioutil.Discard, accepted)
+	if err != nil || n != writes*1024 {
+		t.Fatalf("Read %d bytes, %v, expected %d", n, err, writes*1024)
+	}
+	// an increment is sent for every half window read
+	if got, max := fr.count(frame.TypeWndInc), writes*1024/(window/2); got == 0 || got > max {
+		t.Fatalf("Sent %d WNDINC frames, expected between 1 and %d", got, max)
+	}
+}
+
+// Test that the window increments of reads within the window update delay
+// are sent in a single WNDINC frame once it passed
+func TestWindowUpdateDelayed(t *testing.T) {
+	t.Parallel()
+	fr := &countingFramer{counts: make(map[frame.Type]int)}
+	client, server := newSessionPair(nil, &Config{
+		WindowUpdateDelay: 100 * time.Millisecond,
+		NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+			fr.Framer = frame.NewFramer(r, w)
+			return fr
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+	str.Write([]byte("b"))
+	readString(t, accepted, "b")
+	if n := fr.count(frame.TypeWndInc); n != 0 {
+		t.Fatalf("Sent %d WNDINC frames before the delay passed", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fr.count(frame.TypeWndInc) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("No WNDINC frame sent after the delay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n := fr.count(frame.TypeWndInc); n != 1 {
+		t.Fatalf("Sent %d WNDINC frames, expected 1", n)
+	}
+}
+
+// Test that a window update held back by the window update delay is not
+// sent once the stream is closed
+func TestWindowUpdateDelayedClosed(t *testing.T) {
+	t.Parallel()
+	fr := &countingFramer{counts: make(map[frame.Type]int)}
+	client, server := newSessionPair(nil, &Config{
+		WindowUpdateDelay: 100 * time.Millisecond,
+		NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+			fr.Framer = frame.NewFramer(r, w)
+			return fr
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+	accepted.CloseWithError(StreamCancelled, nil)
+	time.Sleep(250 * time.Millisecond)
+	if n := fr.count(frame.TypeWndInc); n != 0 {
+		t.Fatalf("Sent %d WNDINC frames after the stream closed", n)
+	}
+}
+
+// Test that a paused stream stops the remote side once its window is used
+// up and that resuming it lets the remaining data through
+func TestStreamPause(t *testing.T) {
+	t.Parallel()
+	const window, size = 0x1000, 0x4000
+	config := &Config{MaxWindowSize: window}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("a"))
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	readString(t, accepted, "a")
+	accepted.Pause()
+	go func() {
+		str.Write(make([]byte, size))
+		str.CloseWrite()
+	}()
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(accepted, buf[:window]); err != nil {
+		t.Fatalf("Failed to read the data sent before the window was used up: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if n, err := accepted.ReadContext(ctx, buf); err != context.DeadlineExceeded {
+		t.Fatalf("Read %d bytes, %v from a paused stream whose window is used up", n, err)
+	}
+
+	accepted.Resume()
+	n, err := io.Copy(ioutil.Discard, accepted)
+	if err != nil || n != size-window {
+		t.Fatalf("Read %d bytes, %v after resuming, expected %d", n, err, size-window)
+	}
+}
+
+// Test that unidirectional streams carry data from their opener only
+func TestUnidirectional(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream(WithUnidirectional())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if n, err := str.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("Read %d bytes, %v from a write-only stream, expected EOF", n, err)
+	}
+	str.Write([]byte("a"))
+	str.CloseWrite()
+
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if _, err := accepted.Write([]byte("b")); err != ErrUnidirectional {
+		t.Fatalf("Write to a read-only stream got %v, expected %v", err, ErrUnidirectional)
+	}
+	if got, err := ioutil.ReadAll(accepted); err != nil || string(got) != "a" {
+		t.Fatalf("Read %q, %v, expected %q", got, err, "a")
+	}
+
+	// remote sides speaking older versions can't tell that the stream is
+	// unidirectional
+	old, oldServer := newSessionPair(nil, &Config{MaxProtocolVersion: unidirectionalVersion - 1})
+	defer old.Close()
+	defer oldServer.Close()
+	if _, err := old.OpenStream(WithUnidirectional()); err != ErrUnidirectionalUnsupported {
+		t.Fatalf("Opened unidirectional stream to an older remote side: %v", err)
+	}
+}
+
+// Test that small writes are coalesced into a single DATA frame unless the
+// stream opts out with SetNoDelay
+func TestWriteCoalescing(t *testing.T) {
+	t.Parallel()
+	fr := &countingFramer{counts: make(map[frame.Type]int)}
+	client, server := newSessionPair(&Config{
+		WriteCoalesceDelay: time.Hour,
+		NewFramer: func(r io.Reader, w io.Writer) frame.Framer {
+			fr.Framer = frame.NewFramer(r, w)
+			return fr
+		},
+	}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := str.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if n := fr.count(frame.TypeData); n != 0 {
+		t.Fatalf("Sent %d DATA frames before the writes were flushed", n)
+	}
+
+	// opting out flushes the held back writes
+	str.SetNoDelay(true)
+	if n := fr.count(frame.TypeData); n != 1 {
+		t.Fatalf("Sent %d DATA frames for coalesced writes, expected 1", n)
+	}
+	for i := 0; i < 10; i++ {
+		str.Write([]byte("0123456789"))
+	}
+	if n := fr.count(frame.TypeData); n != 11 {
+		t.Fatalf("Sent %d DATA frames, expected a frame per write", n)
+	}
+	str.CloseWrite()
+
+	rstr, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	buf, err := ioutil.ReadAll(rstr)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if len(buf) != 1100 {
+		t.Fatalf("Read %d bytes, expected %d", len(buf), 1100)
+	}
+}
+
+// Test that coalesced writes are sent once the delay expires
+func TestWriteCoalesceDelay(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{WriteCoalesceDelay: 10 * time.Millisecond}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hello "))
+	str.Write([]byte("world"))
+
+	rstr, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(rstr, buf); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(buf) != "hello world" {
+		t.Fatalf("Read %q, expected %q", buf, "hello world")
+	}
+}
+
+// Test that streams without activity are reset once the idle timeout
+// expires while active streams are left alone
+func TestStreamIdleTimeout(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{StreamIdleTimeout: 100 * time.Millisecond}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		for {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go io.Copy(str, str)
+		}
+	}()
+
+	idle, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	active, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	idle.Write([]byte("x"))
+	deadline := time.Now().Add(400 * time.Millisecond)
+	buf := make([]byte, 1)
+	for time.Now().Before(deadline) {
+		if _, err := active.Write([]byte("x")); err != nil {
+			t.Fatalf("Active stream failed to write: %v", err)
+		}
+		if _, err := io.ReadFull(active, buf); err != nil {
+			t.Fatalf("Active stream failed to read: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	io.ReadFull(idle, buf)
+	if _, err := idle.Read(buf); !errors.Is(err, StreamIdleTimeout) {
+		t.Fatalf("Idle stream read returned %v, expected %v", err, ErrStreamIdleTimeout)
+	}
+}
+
 /*
 func TestDataAfterRst(t *testing.T) {
 	local, remote := newFakeConnPair()
@@ -270,3 +1165,76 @@ func TestRemoveAfterHalfClose(t *testing.T) {
 func TestDataAfterFin(t *testing.T) {
 }
 */
+
+// Test that a write waiting for the stream's rate limit gives up once its
+// deadline passes or the stream is reset
+func TestStreamRateLimitWait(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		for {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, str)
+		}
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.SetRateLimit(1000)
+	str.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := str.Write(make([]byte, 5000)); err != ErrWriteTimeout {
+		t.Fatalf("Rate limited write returned %v, expected %v", err, ErrWriteTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Rate limited write ignored its deadline for %v", elapsed)
+	}
+
+	str.SetWriteDeadline(time.Time{})
+	time.AfterFunc(100*time.Millisecond, func() { str.CloseWithError(StreamCancelled, nil) })
+	start = time.Now()
+	if _, err := str.Write(make([]byte, 5000)); err == nil {
+		t.Fatalf("Rate limited write succeeded after the stream was reset")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Rate limited write ignored the reset for %v", elapsed)
+	}
+}
+
+func TestStreamRateLimitWriteContext(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		for {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, str)
+		}
+	}()
+
+	str, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.SetRateLimit(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := str.WriteContext(ctx, make([]byte, 5000)); err != context.DeadlineExceeded {
+		t.Fatalf("Rate limited write returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Rate limited write ignored its context for %v", elapsed)
+	}
+}
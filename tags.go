@@ -0,0 +1,17 @@
+package muxado
+
+func (s *session) Tags() map[string]string {
+	s.tagsMu.Lock()
+	defer s.tagsMu.Unlock()
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func (s *session) SetTag(key, value string) {
+	s.tagsMu.Lock()
+	s.tags[key] = value
+	s.tagsMu.Unlock()
+}
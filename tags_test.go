@@ -0,0 +1,40 @@
+package muxado
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+	tags := map[string]string{"customer": "acme"}
+	client, server := newSessionPair(&Config{Tags: tags}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// the session keeps its own copy of the configured tags
+	tags["customer"] = "other"
+	client.SetTag("region", "eu")
+	got := client.Tags()
+	if len(got) != 2 || got["customer"] != "acme" || got["region"] != "eu" {
+		t.Fatalf("Session has tags %v, expected customer=acme and region=eu", got)
+	}
+	got["region"] = "us"
+	if client.Tags()["region"] != "eu" {
+		t.Fatalf("Tags returned the session's own map")
+	}
+	if len(server.Tags()) != 0 {
+		t.Fatalf("Untagged session has tags %v", server.Tags())
+	}
+
+	rec := httptest.NewRecorder()
+	DebugHandler(client).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/muxado?format=json", nil))
+	var state []debugSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("Failed to decode JSON: %v, %s", err, rec.Body)
+	}
+	if len(state) != 1 || state[0].Tags["customer"] != "acme" {
+		t.Fatalf("Debug state does not contain the session's tags: %+v", state)
+	}
+}
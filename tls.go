@@ -0,0 +1,164 @@
+package muxado
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ALPNProtocol is the protocol name negotiated via TLS ALPN by DialTLS and
+	// TLSListener
+	ALPNProtocol = "muxado/2"
+
+	// how long DialTLS and TLSListener wait for the TLS handshake to complete
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// DialTLS connects to addr over TCP, performs a TLS handshake negotiating
+// ALPNProtocol and returns a client Session running over the connection.
+func DialTLS(addr string, tlsConfig *tls.Config, config *Config) (Session, error) {
+	dialer := &net.Dialer{Timeout: tlsHandshakeTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, withALPN(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkALPN(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return Client(conn, config), nil
+}
+
+// TLSListener accepts TLS connections that negotiate ALPNProtocol and returns
+// server Sessions running over them.
+type TLSListener struct {
+	l         net.Listener
+	tlsConfig *tls.Config
+	config    *Config
+
+	startOnce sync.Once
+	conns     chan *tls.Conn // connections that completed the handshake
+	done      chan struct{}  // closed when the underlying listener stops accepting
+	err       error          // error that stopped the underlying listener, set before done is closed
+	closed    chan struct{}  // closed by Close
+	closeOnce sync.Once
+}
+
+// NewTLSListener returns a TLSListener that accepts connections from l
+func NewTLSListener(l net.Listener, tlsConfig *tls.Config, config *Config) *TLSListener {
+	return &TLSListener{
+		l:         l,
+		tlsConfig: withALPN(tlsConfig),
+		config:    config,
+		conns:     make(chan *tls.Conn),
+		done:      make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+// AcceptSession waits for the next connection that completes a TLS handshake
+// negotiating ALPNProtocol and returns a server Session running over it.
+// Handshakes run concurrently so that slow clients don't hold up the others.
+// Connections that fail the handshake are closed and skipped.
+func (l *TLSListener) AcceptSession() (Session, error) {
+	l.startOnce.Do(func() { go l.accept() })
+	select {
+	case conn := <-l.conns:
+		return Server(conn, l.config), nil
+	case <-l.done:
+		return nil, l.err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// accept starts a handshake on each connection from the underlying listener
+// until it fails
+func (l *TLSListener) accept() {
+	for {
+		conn, err := l.l.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+// handshake hands conn to AcceptSession once it completes the TLS handshake
+func (l *TLSListener) handshake(conn net.Conn) {
+	tlsConn := tls.Server(conn, l.tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		return
+	}
+	if err := checkALPN(tlsConn); err != nil {
+		tlsConn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	select {
+	case l.conns <- tlsConn:
+	case <-l.closed:
+		tlsConn.Close()
+	}
+}
+
+// Close closes the underlying listener. Connections that completed their
+// handshake but weren't accepted yet are closed.
+func (l *TLSListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.l.Close()
+}
+
+// Addr returns the underlying listener's address
+func (l *TLSListener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
+// connectionStater is implemented by TLS transports like *tls.Conn
+type connectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+func (s *session) ConnectionState() (tls.ConnectionState, bool) {
+	t, ok := transportOptions{s.transport}.find(func(t interface{}) bool { _, ok := t.(connectionStater); return ok }).(connectionStater)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return t.ConnectionState(), true
+}
+
+func (s *session) PeerCertificates() []*x509.Certificate {
+	state, _ := s.ConnectionState()
+	return state.PeerCertificates
+}
+
+// withALPN returns a copy of tlsConfig that offers ALPNProtocol
+func withALPN(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = new(tls.Config)
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	for _, proto := range tlsConfig.NextProtos {
+		if proto == ALPNProtocol {
+			return tlsConfig
+		}
+	}
+	tlsConfig.NextProtos = append([]string{ALPNProtocol}, tlsConfig.NextProtos...)
+	return tlsConfig
+}
+
+func checkALPN(conn *tls.Conn) error {
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != ALPNProtocol {
+		return fmt.Errorf("peer did not negotiate ALPN protocol %q, got %q", ALPNProtocol, proto)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package muxado
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedConfig returns TLS configs for a server with a self-signed
+// certificate for 127.0.0.1 and a client that trusts it
+func selfSignedConfig(t *testing.T) (server *tls.Config, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "muxado test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool}
+	return
+}
+
+func TestTLS(t *testing.T) {
+	t.Parallel()
+	serverConfig, clientConfig := selfSignedConfig(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	tl := NewTLSListener(l, serverConfig, nil)
+	defer tl.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		sess, err := tl.AcceptSession()
+		if err != nil {
+			done <- err
+			return
+		}
+		// the session is closed by the client
+		str, err := sess.AcceptStream()
+		if err != nil {
+			done <- err
+			return
+		}
+		if _, err = io.ReadFull(str, make([]byte, 2)); err != nil {
+			done <- err
+			return
+		}
+		_, err = str.Write([]byte("hello"))
+		str.Close()
+		done <- err
+	}()
+
+	sess, err := DialTLS(l.Addr().String(), clientConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer sess.Close()
+	str, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := str.Write([]byte("hi")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	buf, err := ioutil.ReadAll(str)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("Wrong data. Got %q, expected %q", buf, "hello")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Server failed: %v", err)
+	}
+}
+
+// Test that a client which does not negotiate the muxado ALPN protocol is
+// rejected without failing the listener
+func TestTLSWrongALPN(t *testing.T) {
+	t.Parallel()
+	serverConfig, clientConfig := selfSignedConfig(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	tl := NewTLSListener(l, serverConfig, nil)
+	defer tl.Close()
+	go tl.AcceptSession()
+
+	clientConfig.NextProtos = []string{"h2"}
+	if conn, err := tls.Dial("tcp", l.Addr().String(), clientConfig); err == nil {
+		defer conn.Close()
+		if err := checkALPN(conn); err == nil {
+			t.Fatalf("Expected ALPN check to fail")
+		}
+	}
+}
+
+// Test that both sides of a session over TLS see the certificates of the
+// other and that sessions over other transports see none
+func TestTLSPeerCertificates(t *testing.T) {
+	t.Parallel()
+	serverConfig, clientConfig := selfSignedConfig(t)
+	serverConfig.ClientAuth = tls.RequireAnyClientCert
+	clientConfig.Certificates = serverConfig.Certificates
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	tl := NewTLSListener(l, serverConfig, nil)
+	defer tl.Close()
+	accepted := make(chan Stream, 1)
+	go func() {
+		sess, err := tl.AcceptSession()
+		if err != nil {
+			return
+		}
+		str, err := sess.AcceptStream()
+		if err != nil {
+			sess.Close()
+			return
+		}
+		accepted <- str
+	}()
+
+	sess, err := DialTLS(l.Addr().String(), clientConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer sess.Close()
+	state, ok := sess.ConnectionState()
+	if !ok || state.NegotiatedProtocol != ALPNProtocol {
+		t.Fatalf("Client connection state %v, %v", state.NegotiatedProtocol, ok)
+	}
+	if certs := sess.PeerCertificates(); len(certs) != 1 || certs[0].Subject.CommonName != "muxado test" {
+		t.Fatalf("Client saw peer certificates %v", certs)
+	}
+	str, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hi"))
+	select {
+	case str := <-accepted:
+		defer str.Session().Close()
+		if certs := str.Session().PeerCertificates(); len(certs) != 1 || certs[0].Subject.CommonName != "muxado test" {
+			t.Fatalf("Server saw peer certificates %v", certs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for stream")
+	}
+
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	if _, ok := client.ConnectionState(); ok {
+		t.Fatalf("Session over a plain transport reported a TLS connection state")
+	}
+	if certs := client.PeerCertificates(); certs != nil {
+		t.Fatalf("Session over a plain transport saw peer certificates %v", certs)
+	}
+}
+
+// Test that a client which never completes the TLS handshake doesn't hold up
+// the sessions of other clients
+func TestTLSSlowHandshake(t *testing.T) {
+	t.Parallel()
+	serverConfig, clientConfig := selfSignedConfig(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	tl := NewTLSListener(l, serverConfig, nil)
+	defer tl.Close()
+
+	slow, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer slow.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		sess, err := tl.AcceptSession()
+		if err == nil {
+			sess.Close()
+		}
+		accepted <- err
+	}()
+	sess, err := DialTLS(l.Addr().String(), clientConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer sess.Close()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("Failed to accept session: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session was not accepted while another client was in its handshake")
+	}
+}
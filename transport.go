@@ -0,0 +1,112 @@
+package muxado
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrTransportOptionUnsupported is returned when setting an option that the
+// session's transport does not support
+var ErrTransportOptionUnsupported = errors.New("transport does not support option")
+
+// TransportOptions sets the options of the transport a session runs over,
+// such as the socket options of a *net.TCPConn, without the caller keeping a
+// reference to it. TLS connections are unwrapped to set the options of the
+// connection beneath them. Options the transport does not support return
+// ErrTransportOptionUnsupported.
+type TransportOptions interface {
+	// SetNoDelay controls whether the operating system delays sending
+	// packets in the hope of sending fewer of them (Nagle's algorithm).
+	SetNoDelay(noDelay bool) error
+
+	// SetKeepAlive sets whether the operating system sends keep-alive
+	// messages on the connection.
+	SetKeepAlive(keepalive bool) error
+
+	// SetKeepAlivePeriod sets the period between keep-alive messages.
+	SetKeepAlivePeriod(d time.Duration) error
+
+	// SetReadBuffer sets the size of the operating system's receive buffer
+	// for the connection.
+	SetReadBuffer(bytes int) error
+
+	// SetWriteBuffer sets the size of the operating system's transmit
+	// buffer for the connection.
+	SetWriteBuffer(bytes int) error
+}
+
+// transportOptions sets options on the innermost transport that supports them
+type transportOptions struct {
+	transport interface{}
+}
+
+// unwrap returns the transports beneath t, outermost first
+func (o transportOptions) unwrap() []interface{} {
+	type netConner interface {
+		NetConn() net.Conn
+	}
+	ts := []interface{}{o.transport}
+	for {
+		nc, ok := ts[len(ts)-1].(netConner)
+		if !ok {
+			return ts
+		}
+		ts = append(ts, nc.NetConn())
+	}
+}
+
+// find returns the first transport that implements the option
+func (o transportOptions) find(supports func(interface{}) bool) interface{} {
+	for _, t := range o.unwrap() {
+		if supports(t) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (o transportOptions) SetNoDelay(noDelay bool) error {
+	type setter interface{ SetNoDelay(bool) error }
+	t, ok := o.find(func(t interface{}) bool { _, ok := t.(setter); return ok }).(setter)
+	if !ok {
+		return ErrTransportOptionUnsupported
+	}
+	return t.SetNoDelay(noDelay)
+}
+
+func (o transportOptions) SetKeepAlive(keepalive bool) error {
+	type setter interface{ SetKeepAlive(bool) error }
+	t, ok := o.find(func(t interface{}) bool { _, ok := t.(setter); return ok }).(setter)
+	if !ok {
+		return ErrTransportOptionUnsupported
+	}
+	return t.SetKeepAlive(keepalive)
+}
+
+func (o transportOptions) SetKeepAlivePeriod(d time.Duration) error {
+	type setter interface{ SetKeepAlivePeriod(time.Duration) error }
+	t, ok := o.find(func(t interface{}) bool { _, ok := t.(setter); return ok }).(setter)
+	if !ok {
+		return ErrTransportOptionUnsupported
+	}
+	return t.SetKeepAlivePeriod(d)
+}
+
+func (o transportOptions) SetReadBuffer(bytes int) error {
+	type setter interface{ SetReadBuffer(int) error }
+	t, ok := o.find(func(t interface{}) bool { _, ok := t.(setter); return ok }).(setter)
+	if !ok {
+		return ErrTransportOptionUnsupported
+	}
+	return t.SetReadBuffer(bytes)
+}
+
+func (o transportOptions) SetWriteBuffer(bytes int) error {
+	type setter interface{ SetWriteBuffer(int) error }
+	t, ok := o.find(func(t interface{}) bool { _, ok := t.(setter); return ok }).(setter)
+	if !ok {
+		return ErrTransportOptionUnsupported
+	}
+	return t.SetWriteBuffer(bytes)
+}
@@ -0,0 +1,64 @@
+package muxado
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialTCP returns a TCP connection to a listener which holds the connection
+// open until the test ends
+func dialTCP(t *testing.T) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			io.Copy(ioutil.Discard, conn)
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	return conn
+}
+
+func TestTransportOptions(t *testing.T) {
+	t.Parallel()
+	for name, wrap := range map[string]func(net.Conn) net.Conn{
+		"tcp": func(c net.Conn) net.Conn { return c },
+		"tls": func(c net.Conn) net.Conn { return tls.Client(c, &tls.Config{InsecureSkipVerify: true}) },
+	} {
+		sess := Client(wrap(dialTCP(t)), nil)
+		opts := sess.TransportOptions()
+		for option, err := range map[string]error{
+			"NoDelay":         opts.SetNoDelay(false),
+			"KeepAlive":       opts.SetKeepAlive(true),
+			"KeepAlivePeriod": opts.SetKeepAlivePeriod(time.Minute),
+			"ReadBuffer":      opts.SetReadBuffer(1 << 16),
+			"WriteBuffer":     opts.SetWriteBuffer(1 << 16),
+		} {
+			if err != nil {
+				t.Errorf("%s: failed to set %s: %v", name, option, err)
+			}
+		}
+		sess.Close()
+	}
+}
+
+func TestTransportOptionsUnsupported(t *testing.T) {
+	t.Parallel()
+	local, _ := newFakeConnPair()
+	sess := Client(local, nil)
+	defer sess.Close()
+	if err := sess.TransportOptions().SetNoDelay(true); err != ErrTransportOptionUnsupported {
+		t.Fatalf("Got error %v, expected %v", err, ErrTransportOptionUnsupported)
+	}
+}
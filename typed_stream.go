@@ -2,7 +2,10 @@ package muxado
 
 import (
 	"encoding/binary"
+	"fmt"
+	"io"
 	"net"
+	"sync"
 )
 
 var order = binary.BigEndian
@@ -18,14 +21,48 @@ type TypedStreamSession interface {
 	Session
 	OpenTypedStream(stype StreamType) (Stream, error)
 	AcceptTypedStream() (TypedStream, error)
+
+	// RegisterAcceptQueue gives streams of the given type their own accept
+	// queue holding up to depth streams, so that a flood of streams of
+	// another type, e.g. bulk transfers, cannot starve them. Streams of the
+	// type are accepted with AcceptTypedStreamOf; AcceptTypedStream accepts
+	// streams of every other type. Streams that arrive while their queue is
+	// full are reset with AcceptQueueFull. Queues must be registered before
+	// streams are accepted.
+	RegisterAcceptQueue(stype StreamType, depth int)
+
+	// AcceptTypedStreamOf returns the next stream of a type that has its
+	// own accept queue
+	AcceptTypedStreamOf(stype StreamType) (TypedStream, error)
+
+	// Handle registers the handler that Serve calls with streams of the
+	// given type, replacing any previous one. A nil handler unregisters it.
+	Handle(stype StreamType, handler func(TypedStream))
+
+	// Serve accepts streams with AcceptTypedStream and calls the handler
+	// registered for each stream's type in a new goroutine, until accepting
+	// fails, and returns the error. Streams of a type without a handler are
+	// reset with StreamRefused. A handler that panics has its stream reset
+	// with InternalError; the session and the other streams carry on.
+	Serve() error
 }
 
+// depth of the queue of streams whose type has no queue of its own, once
+// any queues are registered
+const defaultTypedAcceptBacklog = 128
+
 func NewTypedStreamSession(s Session) TypedStreamSession {
-	return &typedStreamSession{s}
+	return &typedStreamSession{Session: s}
 }
 
 type typedStreamSession struct {
 	Session
+
+	mu       sync.Mutex
+	queues   map[StreamType]chan TypedStream // registered accept queues
+	others   chan TypedStream                // streams of types without a queue
+	dispatch sync.Once
+	handlers map[StreamType]func(TypedStream) // handlers called by Serve
 }
 
 func (s *typedStreamSession) Accept() (net.Conn, error) {
@@ -37,17 +74,131 @@ func (s *typedStreamSession) AcceptStream() (Stream, error) {
 }
 
 func (s *typedStreamSession) AcceptTypedStream() (TypedStream, error) {
+	s.mu.Lock()
+	others := s.others
+	s.mu.Unlock()
+	if others != nil {
+		return s.acceptFrom(others)
+	}
+
 	str, err := s.Session.AcceptStream()
 	if err != nil {
 		return nil, err
 	}
+	return readStreamType(str)
+}
+
+func (s *typedStreamSession) RegisterAcceptQueue(st StreamType, depth int) {
+	s.mu.Lock()
+	if s.queues == nil {
+		s.queues = make(map[StreamType]chan TypedStream)
+		s.others = make(chan TypedStream, defaultTypedAcceptBacklog)
+	}
+	s.queues[st] = make(chan TypedStream, depth)
+	s.mu.Unlock()
+	s.dispatch.Do(func() { go s.dispatcher() })
+}
+
+func (s *typedStreamSession) AcceptTypedStreamOf(st StreamType) (TypedStream, error) {
+	s.mu.Lock()
+	q, ok := s.queues[st]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no accept queue registered for stream type %d", st)
+	}
+	return s.acceptFrom(q)
+}
+
+func (s *typedStreamSession) acceptFrom(q chan TypedStream) (TypedStream, error) {
+	select {
+	case str := <-q:
+		return str, nil
+	case <-s.Session.Done():
+		if err := s.Session.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionClosed
+	}
+}
+
+func (s *typedStreamSession) Handle(st StreamType, handler func(TypedStream)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if handler == nil {
+		delete(s.handlers, st)
+		return
+	}
+	if s.handlers == nil {
+		s.handlers = make(map[StreamType]func(TypedStream))
+	}
+	s.handlers[st] = handler
+}
+
+func (s *typedStreamSession) Serve() error {
+	for {
+		str, err := s.AcceptTypedStream()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		handler, ok := s.handlers[str.StreamType()]
+		s.mu.Unlock()
+		if !ok {
+			resetStream(str, StreamRefused, fmt.Errorf("no handler for stream type %d", str.StreamType()))
+			continue
+		}
+		go serveStream(str, handler)
+	}
+}
+
+// serveStream calls handler with str, resetting str if handler panics
+func serveStream(str TypedStream, handler func(TypedStream)) {
+	defer func() {
+		if r := recover(); r != nil {
+			resetStream(str, InternalError, fmt.Errorf("stream handler panic: %v", r))
+		}
+	}()
+	handler(str)
+}
+
+// dispatcher accepts streams and queues them by type
+func (s *typedStreamSession) dispatcher() {
+	for {
+		str, err := s.Session.AcceptStream()
+		if err != nil {
+			return
+		}
+		// a stream that is slow to send its type must not hold up the others
+		go func() {
+			typed, err := readStreamType(str)
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			q, ok := s.queues[typed.StreamType()]
+			if !ok {
+				q = s.others
+			}
+			s.mu.Unlock()
+			select {
+			case q <- typed:
+			default:
+				resetStream(str, AcceptQueueFull, ErrAcceptQueueFull)
+			}
+		}()
+	}
+}
+
+// readStreamType reads the type that opens a typed stream
+func readStreamType(str Stream) (TypedStream, error) {
 	var stype [4]byte
-	_, err = str.Read(stype[:])
-	if err != nil {
+	if _, err := io.ReadFull(str, stype[:]); err != nil {
 		str.Close()
 		return nil, err
 	}
-	return &typedStream{str, StreamType(order.Uint32(stype[:]))}, nil
+	st := StreamType(order.Uint32(stype[:]))
+	setStreamType(str, st)
+	return &typedStream{str, st}, nil
 }
 
 func (s *typedStreamSession) OpenTypedStream(st StreamType) (Stream, error) {
@@ -55,6 +206,7 @@ func (s *typedStreamSession) OpenTypedStream(st StreamType) (Stream, error) {
 	if err != nil {
 		return nil, err
 	}
+	setStreamType(str, st)
 	var stype [4]byte
 	order.PutUint32(stype[:], uint32(st))
 	_, err = str.Write(stype[:])
@@ -72,3 +224,41 @@ type typedStream struct {
 func (s *typedStream) StreamType() StreamType {
 	return s.streamType
 }
+
+// setStreamType records the type of str so that it is reported by
+// Session.Streams
+func setStreamType(str Stream, st StreamType) {
+	if s := unwrapStream(str); s != nil {
+		s.setStreamType(st)
+	}
+}
+
+// resetStream resets str, or closes it if it is not a muxado stream
+func resetStream(str Stream, code ErrorCode, err error) {
+	if s := unwrapStream(str); s != nil {
+		s.resetWith(code, err)
+	} else {
+		str.Close()
+	}
+}
+
+// unwrapStream returns the muxado stream beneath the wrappers added by
+// stream options and Config.WrapStream, or nil if str is not a muxado stream
+func unwrapStream(str Stream) streamPrivate {
+	for {
+		switch s := str.(type) {
+		case *compressedStream:
+			str = s.Stream
+		case *proxiedStream:
+			str = s.Stream
+		case *typedStream:
+			str = s.Stream
+		case WrappingStream:
+			str = s.Unwrap()
+		case streamPrivate:
+			return s
+		default:
+			return nil
+		}
+	}
+}
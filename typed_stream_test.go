@@ -0,0 +1,191 @@
+package muxado
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Test that a flood of streams of one type cannot starve the accept queue
+// of another type
+func TestTypedAcceptQueues(t *testing.T) {
+	t.Parallel()
+	const control, bulk = StreamType(1), StreamType(2)
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+	typedServer.RegisterAcceptQueue(control, 1)
+	typedServer.RegisterAcceptQueue(bulk, 2)
+
+	var bulkStreams []Stream
+	for i := 0; i < 5; i++ {
+		str, err := typedClient.OpenTypedStream(bulk)
+		if err != nil {
+			t.Fatalf("Failed to open bulk stream: %v", err)
+		}
+		bulkStreams = append(bulkStreams, str)
+	}
+	if _, err := typedClient.OpenTypedStream(control); err != nil {
+		t.Fatalf("Failed to open control stream: %v", err)
+	}
+
+	str, err := typedServer.AcceptTypedStreamOf(control)
+	if err != nil {
+		t.Fatalf("Failed to accept control stream: %v", err)
+	}
+	if str.StreamType() != control {
+		t.Fatalf("Accepted stream of type %d, expected %d", str.StreamType(), control)
+	}
+
+	// the bulk streams that did not fit in their queue are reset
+	reset := 0
+	for _, str := range bulkStreams {
+		str.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		if _, err := str.Read(make([]byte, 1)); errors.Is(err, AcceptQueueFull) {
+			reset++
+		}
+	}
+	if reset != 3 {
+		t.Fatalf("%d bulk streams were reset, expected 3", reset)
+	}
+	for i := 0; i < 2; i++ {
+		if str, err := typedServer.AcceptTypedStreamOf(bulk); err != nil || str.StreamType() != bulk {
+			t.Fatalf("Failed to accept bulk stream: %v", err)
+		}
+	}
+}
+
+// Test that streams of types without their own queue are accepted by
+// AcceptTypedStream once queues are registered
+func TestTypedAcceptQueuesOtherTypes(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+	typedServer.RegisterAcceptQueue(1, 1)
+
+	if _, err := typedClient.OpenTypedStream(9); err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str, err := typedServer.AcceptTypedStream()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	if str.StreamType() != 9 {
+		t.Fatalf("Accepted stream of type %d, expected %d", str.StreamType(), 9)
+	}
+
+	server.Close()
+	if _, err := typedServer.AcceptTypedStreamOf(1); err == nil || err == io.EOF {
+		t.Fatalf("Accept on closed session returned %v", err)
+	}
+}
+
+// Test that Serve dispatches streams to the handler of their type and
+// resets streams without a handler or whose handler panics
+func TestTypedServe(t *testing.T) {
+	t.Parallel()
+	const echo, unhandled, panics = StreamType(1), StreamType(2), StreamType(3)
+	client, server := newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+	typedServer.Handle(echo, func(str TypedStream) {
+		io.Copy(str, str)
+		str.Close()
+	})
+	typedServer.Handle(panics, func(str TypedStream) {
+		panic("handler failed")
+	})
+	served := make(chan error, 1)
+	go func() { served <- typedServer.Serve() }()
+
+	for _, tc := range []struct {
+		stype StreamType
+		code  ErrorCode
+	}{
+		{unhandled, StreamRefused},
+		{panics, InternalError},
+	} {
+		str, err := typedClient.OpenTypedStream(tc.stype)
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		str.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := str.Read(make([]byte, 1)); !errors.Is(err, tc.code) {
+			t.Fatalf("Read stream of type %d returned %v, expected %v", tc.stype, err, tc.code)
+		}
+	}
+
+	str, err := typedClient.OpenTypedStream(echo)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	str.Write([]byte("hello"))
+	str.CloseWrite()
+	if got, err := io.ReadAll(str); err != nil || string(got) != "hello" {
+		t.Fatalf("Read echo %q, %v, expected %q", got, err, "hello")
+	}
+
+	server.Close()
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Fatalf("Serve returned nil after the session closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve did not return after the session closed")
+	}
+}
+
+// Test that streams get the window of their type's profile
+func TestWindowProfiles(t *testing.T) {
+	t.Parallel()
+	const control, bulk = StreamType(1), StreamType(2)
+	config := &Config{WindowProfiles: map[StreamType]uint32{
+		control: 16 * 1024,
+		bulk:    1024 * 1024,
+	}}
+	client, server := newSessionPair(config, config)
+	defer client.Close()
+	defer server.Close()
+	typedClient, typedServer := NewTypedStreamSession(client), NewTypedStreamSession(server)
+
+	// writes larger than MaxWindowSize fit in the window of a bulk stream
+	// that is never read
+	str, err := typedClient.OpenTypedStream(bulk)
+	if err != nil {
+		t.Fatalf("Failed to open bulk stream: %v", err)
+	}
+	if _, err := typedServer.AcceptTypedStream(); err != nil {
+		t.Fatalf("Failed to accept bulk stream: %v", err)
+	}
+	str.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := str.Write(make([]byte, 512*1024)); err != nil {
+		t.Fatalf("Failed to write to bulk stream: %v", err)
+	}
+
+	// the window of a control stream is not replenished as its data is read
+	str, err = typedClient.OpenTypedStream(control)
+	if err != nil {
+		t.Fatalf("Failed to open control stream: %v", err)
+	}
+	accepted, err := typedServer.AcceptTypedStream()
+	if err != nil {
+		t.Fatalf("Failed to accept control stream: %v", err)
+	}
+	const sent, window = 64 * 1024, 256 * 1024
+	go str.Write(make([]byte, sent))
+	if _, err := io.ReadFull(accepted, make([]byte, sent)); err != nil {
+		t.Fatalf("Failed to read control stream: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, info := range client.Streams() {
+		if info.Id == str.Id() && info.SendWindow > window-sent {
+			t.Fatalf("Send window of control stream is %d after reading, expected at most %d", info.SendWindow, window-sent)
+		}
+	}
+}
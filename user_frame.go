@@ -0,0 +1,31 @@
+package muxado
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func (s *session) WriteUserFrame(ftype frame.Type, flags frame.Flags, streamId uint32, data []byte) error {
+	f := new(frame.User)
+	if err := f.Pack(ftype, frame.StreamId(streamId), flags, data); err != nil {
+		return err
+	}
+	return s.writeFrame(f, zeroTime)
+}
+
+func (s *session) handleUserFrame(f *frame.User) error {
+	if s.config.OnUserFrame == nil {
+		// the types are reserved, so frames nobody handles are not deviations
+		return frame.DiscardPayload(f)
+	}
+	data, err := ioutil.ReadAll(f.Reader())
+	if err != nil {
+		return err
+	}
+	if err := s.config.OnUserFrame(f.Type(), f.Flags(), uint32(f.StreamId()), data); err != nil {
+		return newErr(ProtocolError, fmt.Errorf("failed to handle %s frame: %v", f.Type(), err))
+	}
+	return nil
+}
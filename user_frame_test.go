@@ -0,0 +1,91 @@
+package muxado
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+type userFrame struct {
+	ftype    frame.Type
+	flags    frame.Flags
+	streamId uint32
+	data     string
+}
+
+func TestUserFrame(t *testing.T) {
+	t.Parallel()
+	received := make(chan userFrame, 1)
+	client, server := newSessionPair(nil, &Config{
+		OnUserFrame: func(ftype frame.Type, flags frame.Flags, streamId uint32, data []byte) error {
+			received <- userFrame{ftype, flags, streamId, string(data)}
+			return nil
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	if err := client.WriteUserFrame(frame.TypeExtension, 0, 0, nil); err == nil {
+		t.Fatalf("Wrote user frame of a type reserved for the protocol")
+	}
+	want := userFrame{frame.TypeUserMin + 1, 0xF, 7, "reload config"}
+	if err := client.WriteUserFrame(want.ftype, want.flags, want.streamId, []byte(want.data)); err != nil {
+		t.Fatalf("Failed to write user frame: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != want {
+			t.Fatalf("Received %+v, expected %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("User frame was not received")
+	}
+
+	// user frames do not get in the way of streams
+	str, err := client.OpenStreamWithData([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer str.Close()
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+}
+
+// Test that user frames are discarded without OnUserFrame and that an error
+// returned by OnUserFrame closes the session
+func TestUserFrameUnhandled(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{Validation: ValidationStrict})
+	defer client.Close()
+	defer server.Close()
+	if err := client.WriteUserFrame(frame.TypeUserMax, 0x1, 0, []byte("ignored")); err != nil {
+		t.Fatalf("Failed to write user frame: %v", err)
+	}
+	if _, err := client.OpenStreamWithData([]byte("hello")); err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatalf("Failed to accept stream after user frame: %v", err)
+	}
+
+	client2, server2 := newSessionPair(nil, &Config{
+		OnUserFrame: func(frame.Type, frame.Flags, uint32, []byte) error {
+			return errors.New("unexpected message")
+		},
+	})
+	defer client2.Close()
+	if err := client2.WriteUserFrame(frame.TypeUserMin, 0, 0, nil); err != nil {
+		t.Fatalf("Failed to write user frame: %v", err)
+	}
+	select {
+	case <-server2.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session was not closed by OnUserFrame's error")
+	}
+	if code, _ := GetError(server2.Err()); code != ProtocolError {
+		t.Fatalf("Session died with %v, expected %v", code, ProtocolError)
+	}
+}
@@ -0,0 +1,86 @@
+package muxado
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+const (
+	// ProtocolVersion1 is the protocol spoken by sessions that predate
+	// version negotiation
+	ProtocolVersion1 = 1
+
+	// ProtocolVersion is the newest version of the protocol. Sessions
+	// advertise the versions they speak in the SETTINGS frame they send
+	// first and speak the highest version both sides support. Version 2
+	// starts sessions with that SETTINGS frame, version 3 adds stream
+	// acknowledgments, see Config.SyncOpen, and version 4 unidirectional
+	// streams, see WithUnidirectional.
+	ProtocolVersion = 4
+
+	// first version in which sessions acknowledge streams when asked
+	streamAcksVersion = 3
+
+	// first version in which sessions accept unidirectional streams
+	unidirectionalVersion = 4
+)
+
+func packVersions(min, max uint16) uint32 {
+	return uint32(min)<<16 | uint32(max)
+}
+
+func unpackVersions(v uint32) (min, max uint16) {
+	return uint16(v >> 16), uint16(v)
+}
+
+// negotiateVersion picks the protocol version of the session when the first
+// frame from the remote side arrives. Remote sides that don't advertise the
+// versions they support in it only speak version 1.
+func (s *session) negotiateVersion(f frame.Frame) error {
+	remoteMin, remoteMax := uint16(ProtocolVersion1), uint16(ProtocolVersion1)
+	if settings, ok := f.(*frame.Settings); ok {
+		for _, setting := range settings.Settings() {
+			if setting.Id == frame.SettingVersion {
+				remoteMin, remoteMax = unpackVersions(setting.Value)
+			}
+		}
+	}
+	if remoteMin == 0 || remoteMin > remoteMax {
+		return newErr(ProtocolError, fmt.Errorf("invalid version setting: %d to %d", remoteMin, remoteMax))
+	}
+	version, lowest := s.config.MaxProtocolVersion, s.config.MinProtocolVersion
+	if remoteMax < version {
+		version = remoteMax
+	}
+	if remoteMin > lowest {
+		lowest = remoteMin
+	}
+	if version < lowest {
+		return newErr(VersionMismatch, fmt.Errorf("version mismatch: local side speaks versions %d to %d, remote side %d to %d",
+			s.config.MinProtocolVersion, s.config.MaxProtocolVersion, remoteMin, remoteMax))
+	}
+	atomic.StoreUint32(&s.version, uint32(version))
+	close(s.negotiated)
+	return nil
+}
+
+// awaitHandshake closes the session if the remote side does not send a valid
+// first frame within Config.HandshakeTimeout
+func (s *session) awaitHandshake() {
+	defer s.recoverPanic("awaitHandshake()")
+	t := time.NewTimer(s.config.HandshakeTimeout)
+	defer t.Stop()
+	select {
+	case <-s.negotiated:
+	case <-s.dead:
+	case <-t.C:
+		s.die(ErrHandshakeTimeout)
+	}
+}
+
+func (s *session) ProtocolVersion() uint16 {
+	return uint16(atomic.LoadUint32(&s.version))
+}
@@ -0,0 +1,92 @@
+package muxado
+
+import (
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+func waitVersion(t *testing.T, s Session, expected uint16) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.ProtocolVersion() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Negotiated version %d, expected %d", s.ProtocolVersion(), expected)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(nil, &Config{MaxProtocolVersion: ProtocolVersion1})
+	defer client.Close()
+	defer server.Close()
+	waitVersion(t, client, ProtocolVersion1)
+	waitVersion(t, server, ProtocolVersion1)
+
+	client, server = newSessionPair(nil, nil)
+	defer client.Close()
+	defer server.Close()
+	waitVersion(t, client, ProtocolVersion)
+	waitVersion(t, server, ProtocolVersion)
+}
+
+func TestVersionMismatch(t *testing.T) {
+	t.Parallel()
+	client, server := newSessionPair(&Config{MinProtocolVersion: ProtocolVersion + 1, MaxProtocolVersion: ProtocolVersion + 1}, nil)
+	defer client.Close()
+	defer server.Close()
+	for _, s := range []Session{client, server} {
+		err, _, _ := s.Wait()
+		if code, _ := GetError(err); code != VersionMismatch {
+			t.Fatalf("Session died with %v, expected %v", err, VersionMismatch)
+		}
+	}
+}
+
+// Test that peers which predate version negotiation speak version 1
+func TestVersionLegacyPeer(t *testing.T) {
+	t.Parallel()
+	for _, minVersion := range []uint16{0, ProtocolVersion} {
+		local, remote := newFakeConnPair()
+		remote.Discard()
+		s := Server(local, &Config{MinProtocolVersion: minVersion})
+		f := new(frame.Data)
+		f.Pack(3, []byte("hi"), false, true)
+		frame.NewFramer(remote, remote).WriteFrame(f)
+
+		if minVersion == 0 {
+			waitVersion(t, s, ProtocolVersion1)
+			s.Close()
+			continue
+		}
+		err, _, _ := s.Wait()
+		if code, _ := GetError(err); code != VersionMismatch {
+			t.Fatalf("Session died with %v, expected %v", err, VersionMismatch)
+		}
+	}
+}
+
+// Test that a server closes sessions whose remote side sends nothing within
+// the handshake timeout, but not those that do
+func TestHandshakeTimeout(t *testing.T) {
+	t.Parallel()
+	local, remote := newFakeConnPair()
+	remote.Discard()
+	s := Server(local, &Config{HandshakeTimeout: 50 * time.Millisecond})
+	err, _, _ := s.Wait()
+	if err != ErrHandshakeTimeout {
+		t.Fatalf("Session died with %v, expected %v", err, ErrHandshakeTimeout)
+	}
+
+	client, server := newSessionPair(nil, &Config{HandshakeTimeout: 50 * time.Millisecond})
+	defer client.Close()
+	defer server.Close()
+	waitVersion(t, server, ProtocolVersion)
+	time.Sleep(100 * time.Millisecond)
+	if err := server.Err(); err != nil {
+		t.Fatalf("Session died after the handshake: %v", err)
+	}
+}
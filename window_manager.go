@@ -1,19 +1,28 @@
 package muxado
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
 type windowManager interface {
 	Increment(int)
 	Decrement(int) (int, error)
+	DecrementContext(context.Context, int) (int, error)
 	SetError(error)
+	SetDeadline(time.Time)
+	Available() int
 }
 
 type condWindow struct {
-	val     int
-	maxSize int
-	err     error
+	val      int
+	maxSize  int
+	err      error
+	deadline time.Time           // time after which decrements fail with ErrWriteTimeout
+	timer    *time.Timer         // wakes blocked decrements at the deadline
+	onStall  func()              // called when a decrement blocks, may be nil (const)
+	onResume func(time.Duration) // called with how long a blocked decrement waited (const)
 	sync.Cond
 	sync.Mutex
 }
@@ -37,6 +46,13 @@ func (w *condWindow) Increment(inc int) {
 	w.L.Unlock()
 }
 
+// Available returns the size of the window
+func (w *condWindow) Available() int {
+	w.L.Lock()
+	defer w.L.Unlock()
+	return w.val
+}
+
 func (w *condWindow) SetError(err error) {
 	w.L.Lock()
 	w.err = err
@@ -44,11 +60,46 @@ func (w *condWindow) SetError(err error) {
 	w.L.Unlock()
 }
 
+func (w *condWindow) SetDeadline(t time.Time) {
+	w.L.Lock()
+	w.deadline = t
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if !t.IsZero() {
+		// wake up blocked writers once the deadline passes
+		w.timer = time.AfterFunc(time.Until(t), func() {
+			w.L.Lock()
+			w.Broadcast()
+			w.L.Unlock()
+		})
+	}
+	w.Broadcast()
+	w.L.Unlock()
+}
+
 func (w *condWindow) Decrement(dec int) (ret int, err error) {
+	return w.DecrementContext(context.Background(), dec)
+}
+
+// DecrementContext is like Decrement, but gives up waiting for the window
+// with ctx.Err() once ctx is done
+func (w *condWindow) DecrementContext(ctx context.Context, dec int) (ret int, err error) {
 	if dec == 0 {
 		return
 	}
+	if ctx.Done() != nil {
+		// wake up blocked decrements once ctx is done
+		stop := context.AfterFunc(ctx, func() {
+			w.L.Lock()
+			w.Broadcast()
+			w.L.Unlock()
+		})
+		defer stop()
+	}
 
+	var stalled time.Time
 	w.L.Lock()
 	for {
 		if w.err != nil {
@@ -66,10 +117,24 @@ func (w *condWindow) Decrement(dec int) (ret int, err error) {
 				w.val -= dec
 				break
 			}
+		} else if !w.deadline.IsZero() && !time.Now().Before(w.deadline) {
+			err = ErrWriteTimeout
+			break
+		} else if err = ctx.Err(); err != nil {
+			break
+		} else if w.onStall != nil && stalled.IsZero() {
+			// report the stall without holding the lock, then look again
+			stalled = time.Now()
+			w.L.Unlock()
+			w.onStall()
+			w.L.Lock()
 		} else {
 			w.Wait()
 		}
 	}
 	w.L.Unlock()
+	if !stalled.IsZero() {
+		w.onResume(time.Since(stalled))
+	}
 	return
 }
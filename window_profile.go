@@ -0,0 +1,49 @@
+package muxado
+
+import (
+	"sync/atomic"
+)
+
+// windowProfile returns the receive window size of streams of the given
+// type, or zero if they have the session's MaxWindowSize
+func (s *session) windowProfile(t StreamType) uint32 {
+	return s.config.WindowProfiles[t]
+}
+
+// resizeWindow changes the size of the window the remote side may fill to
+// size. Larger windows are granted to the remote side with a window update
+// once the stream is opened, smaller ones are reached by withholding window
+// updates, see shrinkWindow.
+func (s *stream) resizeWindow(size uint32) {
+	windowSize := atomic.LoadUint32(&s.windowSize)
+	if size == 0 || size == windowSize {
+		return
+	}
+	if size < windowSize {
+		s.shrinkWindow(size)
+		size = s.windowLimit()
+		atomic.StoreUint32(&s.windowSize, size)
+		atomic.StoreUint32(&s.incAfter, windowUpdateThreshold(size, s.session.windowUpdateRatio()))
+		return
+	}
+
+	grow := size - windowSize
+	s.buf.SetMaxSize(int(size))
+	atomic.AddUint32(&s.recvLimit, grow)
+	atomic.StoreUint32(&s.windowSize, size)
+	atomic.StoreUint32(&s.incAfter, windowUpdateThreshold(size, s.session.windowUpdateRatio()))
+
+	// window updates for streams the remote side doesn't know about yet are
+	// ignored, so streams that are not open grant the window once they are,
+	// see sentSyn
+	s.writer.Lock()
+	opened := s.synSent
+	if !opened {
+		s.synInc += grow
+	}
+	s.writer.Unlock()
+	if opened {
+		atomic.AddUint32(&s.pendingInc, grow)
+		s.flushWindowUpdate()
+	}
+}
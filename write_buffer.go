@@ -0,0 +1,46 @@
+package muxado
+
+import (
+	"bufio"
+	"io"
+	"net"
+)
+
+// writeBuffer batches the frames a session writes to its transport. Frames
+// too large to benefit from batching bypass the buffer once the frames
+// buffered ahead of them are flushed, so that their header and payload are
+// written with a single net.Buffers.WriteTo call, i.e. writev(2) on
+// transports that support it. See frame.BuffersWriter.
+type writeBuffer struct {
+	*bufio.Writer
+	transport io.Writer
+}
+
+func newWriteBuffer(transport io.Writer, size int) *writeBuffer {
+	return &writeBuffer{bufio.NewWriterSize(transport, size), transport}
+}
+
+func (w *writeBuffer) WriteBuffers(bufs *net.Buffers) (int64, error) {
+	n := 0
+	for _, b := range *bufs {
+		n += len(b)
+	}
+	if n > w.Available() {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+		if n >= w.Size() {
+			return bufs.WriteTo(w.transport)
+		}
+	}
+	var written int64
+	for _, b := range *bufs {
+		nw, err := w.Write(b)
+		written += int64(nw)
+		if err != nil {
+			return written, err
+		}
+	}
+	*bufs = nil
+	return written, nil
+}
@@ -0,0 +1,47 @@
+package muxado
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+type recordingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// Test that small frames are batched in the write buffer while large ones
+// bypass it after the buffered frames are flushed
+func TestWriteBufferBuffers(t *testing.T) {
+	t.Parallel()
+	transport := new(recordingWriter)
+	w := newWriteBuffer(transport, 64)
+
+	small := net.Buffers{[]byte("head"), []byte("small")}
+	if _, err := w.WriteBuffers(&small); err != nil {
+		t.Fatalf("Failed to write small frame: %v", err)
+	}
+	if transport.writes != 0 || w.Buffered() != 9 {
+		t.Fatalf("Small frame was not buffered: %d writes, %d bytes buffered", transport.writes, w.Buffered())
+	}
+
+	payload := bytes.Repeat([]byte("x"), 128)
+	large := net.Buffers{[]byte("head"), payload}
+	n, err := w.WriteBuffers(&large)
+	if err != nil || n != int64(4+len(payload)) {
+		t.Fatalf("Wrote %d bytes of large frame, %v", n, err)
+	}
+	if w.Buffered() != 0 {
+		t.Fatalf("Large frame left %d bytes buffered", w.Buffered())
+	}
+	expected := "headsmallhead" + string(payload)
+	if transport.String() != expected {
+		t.Fatalf("Wrong data written to transport: %q", transport.String())
+	}
+}
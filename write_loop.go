@@ -0,0 +1,121 @@
+package muxado
+
+import (
+	"sync"
+
+	"github.com/inconshreveable/muxado/frame"
+)
+
+// A WriteLoop writes the frames of many sessions from a fixed number of
+// goroutines, see Config.WriteLoop. Each session otherwise runs a writer
+// goroutine of its own, so sharing a loop lets servers holding hundreds of
+// thousands of mostly idle sessions keep just their reader goroutines.
+//
+// A goroutine of the loop is tied up while it writes to a session's
+// transport, e.g. when the session's egress rate limit or pacing holds its
+// frames back, or the transport blocks, so loops should have enough
+// goroutines to cover the sessions that may be stalled at once.
+type WriteLoop struct {
+	mu      sync.Mutex
+	ready   *sync.Cond
+	queue   []*session // sessions with frames to write
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// NewWriteLoop returns a WriteLoop running the given number of goroutines
+func NewWriteLoop(goroutines int) *WriteLoop {
+	if goroutines < 1 {
+		goroutines = 1
+	}
+	l := new(WriteLoop)
+	l.ready = sync.NewCond(&l.mu)
+	l.workers.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go l.run()
+	}
+	return l
+}
+
+// Close stops the loop's goroutines once they have written the frames that
+// are queued. Sessions using the loop should be closed first.
+func (l *WriteLoop) Close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.ready.Broadcast()
+	l.workers.Wait()
+}
+
+func (l *WriteLoop) run() {
+	defer l.workers.Done()
+	for {
+		l.mu.Lock()
+		for len(l.queue) == 0 && !l.closed {
+			l.ready.Wait()
+		}
+		if len(l.queue) == 0 {
+			l.mu.Unlock()
+			return
+		}
+		s := l.queue[0]
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+		s.writeQueued()
+	}
+}
+
+func (l *WriteLoop) schedule(s *session) {
+	l.mu.Lock()
+	l.queue = append(l.queue, s)
+	l.mu.Unlock()
+	l.ready.Signal()
+}
+
+// queueFrame queues f for the session's write loop. A nil frame only asks
+// for a flush.
+func (s *session) queueFrame(f frame.Frame) error {
+	if isClosed(s.dead) {
+		return ErrSessionClosed
+	}
+	s.queueMu.Lock()
+	if f != nil {
+		s.queued = append(s.queued, f)
+	}
+	schedule := !s.scheduled
+	s.scheduled = true
+	s.queueMu.Unlock()
+	if schedule {
+		s.loop.schedule(s)
+	}
+	return nil
+}
+
+// writeQueued writes the session's queued frames from a goroutine of its
+// write loop
+func (s *session) writeQueued() {
+	defer s.recoverPanic("writeQueued()")
+	s.queueMu.Lock()
+	frames := s.queued
+	s.queued = nil
+	s.scheduled = false
+	s.queueMu.Unlock()
+	if isClosed(s.dead) {
+		return
+	}
+
+	s.lockWrites()
+	var err error
+	for i := 0; err == nil && i < len(frames); i++ {
+		err = s.pacedWriteFrame(frames[i])
+	}
+	if err == nil {
+		err = s.wbuf.Flush()
+	}
+	s.unlockWrites()
+	if err != nil {
+		// any write error kills the session
+		s.die(err)
+	}
+}
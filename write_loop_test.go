@@ -0,0 +1,72 @@
+package muxado
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that sessions sharing a write loop transfer more data than fits in
+// their windows, which needs the window updates written by the loop
+func TestWriteLoop(t *testing.T) {
+	t.Parallel()
+	loop := NewWriteLoop(2)
+	config := &Config{WriteLoop: loop}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		client, server := newSessionPair(config, config)
+		defer client.Close()
+		defer server.Close()
+		go func() {
+			str, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			io.Copy(str, str)
+			str.Close()
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			str, err := client.OpenStream()
+			if err != nil {
+				t.Errorf("Failed to open stream: %v", err)
+				return
+			}
+			payload := bytes.Repeat([]byte("muxado"), 0x20000)
+			go func() {
+				str.Write(payload)
+				str.CloseWrite()
+			}()
+			str.SetReadDeadline(time.Now().Add(10 * time.Second))
+			if got, err := ioutil.ReadAll(str); err != nil || !bytes.Equal(got, payload) {
+				t.Errorf("Echoed %d bytes, %v, expected %d", len(got), err, len(payload))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWriteLoopClose(t *testing.T) {
+	t.Parallel()
+	loop := NewWriteLoop(1)
+	client, server := newSessionPair(&Config{WriteLoop: loop}, nil)
+	client.Close()
+	server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		loop.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write loop did not stop")
+	}
+}